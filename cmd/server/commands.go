@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hangbin2008/sanjicms/internal/middleware"
+	"github.com/hangbin2008/sanjicms/internal/service"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"golang.org/x/term"
+)
+
+// runCommand 执行运维子命令，只能在能访问数据库的服务器上运行
+func runCommand(cfg *config.Config, name string, args []string) error {
+	userService := service.NewUserService(cfg, middleware.NewJWTConfig(cfg))
+
+	switch name {
+	case "create-admin":
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		username := fs.String("username", "admin", "站长用户名")
+		realName := fs.String("name", "系统管理员", "站长姓名")
+		fs.Parse(args)
+
+		password, err := readNewPassword()
+		if err != nil {
+			return err
+		}
+		if err := userService.CreateFirstAdmin(*username, *realName, password); err != nil {
+			return err
+		}
+		fmt.Printf("站长账号 %s 创建成功\n", *username)
+		return nil
+
+	case "reset-admin-password":
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		username := fs.String("username", "admin", "站长用户名")
+		fs.Parse(args)
+
+		password, err := readNewPassword()
+		if err != nil {
+			return err
+		}
+		if err := userService.RecoverAdminPassword(*username, password); err != nil {
+			return err
+		}
+		fmt.Printf("站长账号 %s 的密码已重置并重新启用\n", *username)
+		return nil
+
+	default:
+		return fmt.Errorf("未知命令 %q，可用命令：create-admin、reset-admin-password", name)
+	}
+}
+
+// readNewPassword 读取新密码：优先使用环境变量ADMIN_PASSWORD，否则在终端输入两次
+func readNewPassword() (string, error) {
+	if password := os.Getenv("ADMIN_PASSWORD"); password != "" {
+		return password, nil
+	}
+
+	password, err := readPassword("请输入密码: ")
+	if err != nil {
+		return "", err
+	}
+	confirm, err := readPassword("请再次输入密码: ")
+	if err != nil {
+		return "", err
+	}
+	if password != confirm {
+		return "", errors.New("两次输入的密码不一致")
+	}
+	return password, nil
+}
+
+// readPassword 从终端读取密码，输入内容不回显；标准输入不是终端时按行读取
+func readPassword(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(b), err
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("未读取到密码")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+var stdin = bufio.NewReader(os.Stdin)
@@ -4,10 +4,14 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/hangbin2008/sanjicms/internal/api"
 	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/middleware"
+	"github.com/hangbin2008/sanjicms/internal/service"
 	"github.com/hangbin2008/sanjicms/pkg/config"
 )
 
@@ -32,6 +36,19 @@ func main() {
 		log.Fatalf("数据库迁移失败: %v", err)
 	}
 
+	// 加密尚未加密或使用旧密钥加密的手机号和身份证号
+	if err := encryptPersonalInfo(cfg); err != nil {
+		log.Fatalf("加密个人信息失败: %v", err)
+	}
+
+	// 运维子命令：创建站长账号、找回站长密码
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, os.Args[1], os.Args[2:]); err != nil {
+			log.Fatalf("%s 执行失败: %v", os.Args[1], err)
+		}
+		return
+	}
+
 	// 设置路由
 	router := api.SetupRouter(cfg)
 
@@ -91,71 +108,32 @@ func migrateDatabase() error {
 	// 2. 确保数据库存在 - 关键修复：先创建数据库（如果不存在）
 	log.Println("检查并确保数据库存在...")
 
-	// 3. 读取迁移脚本文件
-	content, err := os.ReadFile("migrations/001_init_schema.sql")
+	// 3. 按文件名顺序执行尚未执行过的迁移脚本，每个脚本只执行一次，
+	// 避免重启时重新执行预置数据，覆盖管理员修改过的角色权限和科室
+	applied, err := appliedMigrations()
 	if err != nil {
-		return fmt.Errorf("读取迁移脚本失败: %w", err)
+		return err
 	}
-	log.Printf("成功读取迁移脚本，大小: %d 字节\n", len(content))
-
-	// 4. 执行迁移脚本 - 增强版本：确保脚本被正确执行
-	log.Println("开始执行迁移脚本...")
-
-	// 尝试1: 直接执行整个脚本
-	_, err = db.DB.Exec(string(content))
-	if err == nil {
-		log.Println("直接执行脚本成功")
-	} else {
-		log.Printf("直接执行脚本失败: %v，尝试按语句执行...\n", err)
-
-		// 尝试2: 按语句执行，正确处理跨多行的SQL语句
-		// 将脚本按";"分割成多个语句
-		statements := strings.Split(string(content), ";")
-		stmtErrors := 0
-
-		for i, stmt := range statements {
-			// 清理语句：移除注释和空白字符
-			lines := strings.Split(stmt, "\n")
-			var cleanedStmt strings.Builder
-
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				// 跳过空行和注释
-				if line == "" || strings.HasPrefix(line, "--") {
-					continue
-				}
-				cleanedStmt.WriteString(line)
-				cleanedStmt.WriteString(" ")
-			}
-
-			// 再次清理，确保语句不为空
-			finalStmt := strings.TrimSpace(cleanedStmt.String())
-			if finalStmt == "" {
-				continue
-			}
-
-			// 添加分号
-			finalStmt += ";"
+	files, err := filepath.Glob("migrations/*.sql")
+	if err != nil {
+		return fmt.Errorf("查找迁移脚本失败: %w", err)
+	}
+	sort.Strings(files)
 
-			// 执行语句
-			if _, err := db.DB.Exec(finalStmt); err != nil {
-				log.Printf("执行语句 %d 失败: %v\n语句: %s\n", i+1, err, finalStmt)
-				stmtErrors++
-			} else {
-				log.Printf("执行语句 %d 成功\n", i+1)
-			}
+	for _, file := range files {
+		version := filepath.Base(file)
+		if applied[version] {
+			continue
 		}
-
-		// 只有当没有语句错误时，才认为迁移成功
-		if stmtErrors == 0 {
-			log.Println("按语句执行脚本成功")
-		} else {
-			log.Printf("按语句执行脚本失败，共 %d 个错误\n", stmtErrors)
-			// 不要返回错误，继续执行，因为users表可能已经创建成功
+		if err := executeMigrationFile(file); err != nil {
+			return err
+		}
+		if _, err := db.DB.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
+			return fmt.Errorf("记录迁移脚本 %s 失败: %w", version, err)
 		}
 	}
 
-	// 5. 验证迁移结果 - 增强版本：必须确保users表存在
+	// 4. 验证迁移结果 - 增强版本：必须确保users表存在
 	log.Println("验证数据库迁移结果...")
 
 	// 检查users表是否存在
@@ -175,31 +153,149 @@ func migrateDatabase() error {
 
 	log.Println("✅ users表已成功创建")
 
-	// 6. 检查并创建admin用户 - 确保admin用户存在
-	var adminExists bool
+	// 5. 检查是否已有启用的站长账号；预置的 admin 账号在设置密码前是停用的
+	var admins, activeAdmins int
 	err = db.DB.QueryRow(
-		"SELECT EXISTS (SELECT 1 FROM users WHERE username = 'admin')",
-	).Scan(&adminExists)
+		"SELECT COUNT(*), COALESCE(SUM(status = 1), 0) FROM users WHERE role = 'admin'",
+	).Scan(&admins, &activeAdmins)
 
 	if err != nil {
-		return fmt.Errorf("检查admin用户失败: %w", err)
+		return fmt.Errorf("检查站长账号失败: %w", err)
 	}
 
-	if adminExists {
-		log.Println("✅ admin用户已存在")
-	} else {
-		log.Println("创建admin用户...")
-		// 直接创建admin用户
-		_, err := db.DB.Exec(
-			`INSERT INTO users (username, password_hash, name, role, status) VALUES (?, ?, ?, ?, ?)`,
-			"admin", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", "系统管理员", "admin", 1,
-		)
-		if err != nil {
-			return fmt.Errorf("创建admin用户失败: %w", err)
-		}
-		log.Println("✅ admin用户创建成功")
+	switch {
+	case activeAdmins > 0:
+		log.Println("✅ 站长账号已存在")
+	case admins > 0:
+		log.Println("⚠️ 站长账号尚未设置密码或已停用，请执行: server reset-admin-password -username <用户名>")
+	default:
+		log.Println("⚠️ 尚未创建站长账号，请执行: server create-admin -username <用户名> -name <姓名>")
 	}
 
 	log.Println("数据库迁移完成")
 	return nil
 }
+
+// encryptPersonalInfo 用当前密钥加密手机号、身份证号和两步验证密钥，并计算盲索引。
+// 轮换密钥时把新密钥放在 FIELD_ENCRYPTION_KEYS 最前面并重启，重新加密完成后即可删除旧密钥
+func encryptPersonalInfo(cfg *config.Config) error {
+	if len(cfg.Crypto.Keys) == 0 {
+		log.Println("⚠️ 未配置 FIELD_ENCRYPTION_KEYS，手机号、身份证号和两步验证密钥以明文保存")
+	}
+
+	userService := service.NewUserService(cfg, middleware.NewJWTConfig(cfg))
+	updated, err := userService.EncryptPersonalInfo()
+	if err != nil {
+		return err
+	}
+	if updated > 0 {
+		log.Printf("✅ 已更新 %d 个账号的手机号和身份证号加密", updated)
+	}
+
+	updated, err = service.NewTwoFactorService(cfg).EncryptSecrets()
+	if err != nil {
+		return err
+	}
+	if updated > 0 {
+		log.Printf("✅ 已更新 %d 个账号的两步验证密钥加密", updated)
+	}
+	return nil
+}
+
+// appliedMigrations 创建迁移记录表并返回已执行的迁移脚本。
+// 引入迁移记录之前的数据库没有任何记录，升级后首次启动会把全部脚本再执行一次，脚本需要保证重复执行无害
+func appliedMigrations() (map[string]bool, error) {
+	_, err := db.DB.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version VARCHAR(100) PRIMARY KEY,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("创建迁移记录表失败: %w", err)
+	}
+
+	rows, err := db.DB.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("读取迁移记录失败: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+// executeMigrationFile 执行单个迁移脚本
+func executeMigrationFile(file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("读取迁移脚本失败: %w", err)
+	}
+	log.Printf("成功读取迁移脚本 %s，大小: %d 字节\n", file, len(content))
+
+	// 执行迁移脚本 - 增强版本：确保脚本被正确执行
+	log.Println("开始执行迁移脚本...")
+
+	// 尝试1: 直接执行整个脚本
+	_, err = db.DB.Exec(string(content))
+	if err == nil {
+		log.Println("直接执行脚本成功")
+		return nil
+	}
+	log.Printf("直接执行脚本失败: %v，尝试按语句执行...\n", err)
+
+	// 尝试2: 按语句执行，正确处理跨多行的SQL语句
+	// 将脚本按";"分割成多个语句
+	statements := strings.Split(string(content), ";")
+	stmtErrors := 0
+
+	for i, stmt := range statements {
+		// 清理语句：移除注释和空白字符
+		lines := strings.Split(stmt, "\n")
+		var cleanedStmt strings.Builder
+
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			// 跳过空行和注释
+			if line == "" || strings.HasPrefix(line, "--") {
+				continue
+			}
+			cleanedStmt.WriteString(line)
+			cleanedStmt.WriteString(" ")
+		}
+
+		// 再次清理，确保语句不为空
+		finalStmt := strings.TrimSpace(cleanedStmt.String())
+		if finalStmt == "" {
+			continue
+		}
+
+		// 添加分号
+		finalStmt += ";"
+
+		// 执行语句
+		if _, err := db.DB.Exec(finalStmt); err != nil {
+			log.Printf("执行语句 %d 失败: %v\n语句: %s\n", i+1, err, finalStmt)
+			stmtErrors++
+		} else {
+			log.Printf("执行语句 %d 成功\n", i+1)
+		}
+	}
+
+	// 只有当没有语句错误时，才认为迁移成功
+	if stmtErrors == 0 {
+		log.Println("按语句执行脚本成功")
+	} else {
+		// 不要返回错误，继续执行：引入迁移记录前的数据库重新执行脚本时，已存在的列和索引会导致ALTER语句失败
+		log.Printf("按语句执行脚本 %s 失败，共 %d 个错误\n", file, stmtErrors)
+	}
+
+	return nil
+}
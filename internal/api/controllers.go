@@ -1,20 +1,44 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hangbin2008/sanjicms/internal/middleware"
 	"github.com/hangbin2008/sanjicms/internal/models"
 	"github.com/hangbin2008/sanjicms/internal/service"
 )
 
 // Controllers API控制器
 type Controllers struct {
-	userService     *service.UserService
-	questionService *service.QuestionService
-	examService     *service.ExamService
-	captchaService  *service.CaptchaService
+	userService       *service.UserService
+	questionService   *service.QuestionService
+	examService       *service.ExamService
+	captchaService    *service.CaptchaService
+	reportService     *service.ReportService
+	certService       *service.CertificateService
+	cmeService        *service.CMEService
+	departmentService *service.DepartmentService
+	rosterService     *service.RosterService
+	securityService   *service.SecurityService
+	sessionService    *service.SessionService
+	permissionService *service.PermissionService
+	scopeService      *service.ScopeService
+	twoFactorService  *service.TwoFactorService
+	oidcService       *service.OIDCService
+	avatarService     *service.AvatarService
+	resetService      *service.PasswordResetService
+	auditService      *service.AuditService
+	proctorService    *service.ProctoringService
+	jwtConfig         *middleware.JWTConfig
 }
 
 // NewControllers 创建API控制器
@@ -23,12 +47,44 @@ func NewControllers(
 	questionService *service.QuestionService,
 	examService *service.ExamService,
 	captchaService *service.CaptchaService,
+	reportService *service.ReportService,
+	certService *service.CertificateService,
+	cmeService *service.CMEService,
+	departmentService *service.DepartmentService,
+	rosterService *service.RosterService,
+	securityService *service.SecurityService,
+	sessionService *service.SessionService,
+	permissionService *service.PermissionService,
+	scopeService *service.ScopeService,
+	twoFactorService *service.TwoFactorService,
+	oidcService *service.OIDCService,
+	avatarService *service.AvatarService,
+	resetService *service.PasswordResetService,
+	auditService *service.AuditService,
+	proctorService *service.ProctoringService,
+	jwtConfig *middleware.JWTConfig,
 ) *Controllers {
 	return &Controllers{
-		userService:     userService,
-		questionService: questionService,
-		examService:     examService,
-		captchaService:  captchaService,
+		userService:       userService,
+		questionService:   questionService,
+		examService:       examService,
+		captchaService:    captchaService,
+		reportService:     reportService,
+		certService:       certService,
+		cmeService:        cmeService,
+		departmentService: departmentService,
+		rosterService:     rosterService,
+		securityService:   securityService,
+		sessionService:    sessionService,
+		permissionService: permissionService,
+		scopeService:      scopeService,
+		twoFactorService:  twoFactorService,
+		oidcService:       oidcService,
+		avatarService:     avatarService,
+		resetService:      resetService,
+		auditService:      auditService,
+		proctorService:    proctorService,
+		jwtConfig:         jwtConfig,
 	}
 }
 
@@ -46,7 +102,7 @@ func (c *Controllers) Register(ctx *gin.Context) {
 		return
 	}
 
-	user, err := c.userService.RegisterUser(&req)
+	user, err := c.userService.RegisterUser(&req, ctx.ClientIP())
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -54,18 +110,22 @@ func (c *Controllers) Register(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "用户注册成功",
-		"user": models.UserResponse{
-			ID:         user.ID,
-			Username:   user.Username,
-			Name:       user.Name,
-			Role:       user.Role,
-			Phone:      user.Phone,
-			IDCard:     user.IDCard,
-			Department: user.Department,
-			JobTitle:   user.JobTitle,
-			Status:     user.Status,
-			CreatedAt:  user.CreatedAt,
-		},
+		"user": maskedUserResponse(ctx, models.UserResponse{
+			ID:           user.ID,
+			Username:     user.Username,
+			Name:         user.Name,
+			Role:         user.Role,
+			Phone:        user.Phone,
+			IDCard:       user.IDCard,
+			BirthDate:    user.BirthDate,
+			DepartmentID: user.DepartmentID,
+			Department:   user.Department,
+			JobTitle:     user.JobTitle,
+			Status:       user.Status,
+			CreatedAt:    user.CreatedAt,
+		}),
+		// 前端根据权限显示或隐藏功能入口
+		"permissions": ctx.GetStringSlice("permissions"),
 	})
 }
 
@@ -82,6 +142,7 @@ func (c *Controllers) GenerateCaptcha(ctx *gin.Context) {
 		"data": gin.H{
 			"captcha_id":    id,
 			"captcha_image": b64s,
+			"captcha_type":  c.captchaService.Type(),
 		},
 	})
 }
@@ -94,28 +155,75 @@ func (c *Controllers) Login(ctx *gin.Context) {
 		return
 	}
 
-	// 验证验证码
-	if !c.captchaService.VerifyCaptcha(req.CaptchaID, req.Captcha) {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "验证码错误"})
+	ip := ctx.ClientIP()
+	userAgent := ctx.Request.UserAgent()
+
+	// 先按用户名或手机号、身份证号找到账号，失败次数和锁定按账号统计
+	accountID, account, err := c.userService.ResolveLoginAccount(req.Username)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 账号锁定、IP失败过多或连续失败后的等待时间内拒绝登录
+	if err := c.securityService.CheckLogin(accountID, ip); err != nil {
+		var blocked *service.LoginBlockedError
+		if !errors.As(err, &blocked) {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.recordLogin(accountID, account, ip, userAgent, blocked.Result)
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(blocked.RetryAfter.Seconds()))))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": blocked.Error()})
+		return
+	}
+
+	// 账号和IP没有失败记录时免输验证码，出现失败后才需要
+	if c.captchaRequired(accountID, ip) && !c.captchaService.VerifyCaptcha(req.CaptchaID, req.Captcha) {
+		c.recordLogin(accountID, account, ip, userAgent, models.LoginResultCaptcha)
+		message := "验证码错误"
+		if req.CaptchaID == "" {
+			message = "请输入验证码"
+		}
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": message, "captcha_required": true})
 		return
 	}
 
-	response, err := c.userService.LoginUser(&req)
+	response, err := c.userService.LoginUser(&req, ip, userAgent)
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		// 目录服务故障不是用户的过错，不计入登录失败
+		var unavailable *service.AuthUnavailableError
+		if errors.As(err, &unavailable) {
+			log.Printf("外部认证服务不可用: %v", unavailable.Err)
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{"error": unavailable.Error()})
+			return
+		}
+
+		result := models.LoginResultBadCredentials
+		if errors.Is(err, service.ErrUserDisabled) {
+			result = models.LoginResultDisabled
+		}
+		c.recordLogin(accountID, account, ip, userAgent, result)
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error":            err.Error(),
+			"captcha_required": c.captchaRequired(accountID, ip),
+		})
+		return
+	}
+
+	// 已启用两步验证时密码正确还不算登录成功，等待提交验证码
+	if response.TwoFactorRequired {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "请输入两步验证码",
+			"data":    response,
+		})
 		return
 	}
+	// 外部认证首次登录时账号才刚创建
+	c.recordLogin(response.User.ID, response.User.Username, ip, userAgent, models.LoginResultSuccess)
 
 	// 设置JWT令牌到Cookie，用于前端页面访问控制
-	ctx.SetCookie(
-		"token",
-		response.Token,
-		3600,  // 过期时间（秒）
-		"/",   // 路径
-		"",    // 域名
-		false, // 仅HTTPS
-		true,  // HttpOnly
-	)
+	c.jwtConfig.SetAuthCookies(ctx, response.Token, response.RefreshToken)
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": "登录成功",
@@ -123,368 +231,747 @@ func (c *Controllers) Login(ctx *gin.Context) {
 	})
 }
 
-// GetCurrentUser 获取当前用户信息
-func (c *Controllers) GetCurrentUser(ctx *gin.Context) {
-	userID, exists := ctx.Get("user_id")
-	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+// LoginTwoFactor 登录第二步：提交两步验证码或恢复码
+func (c *Controllers) LoginTwoFactor(ctx *gin.Context) {
+	var req models.TwoFactorLoginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, err := c.userService.GetUserByID(userID.(int))
+	claims, err := c.jwtConfig.ParseToken(req.Token)
+	if err != nil || claims.Scope != middleware.ScopeTwoFactor {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "验证已过期，请重新登录"})
+		return
+	}
+
+	ip := ctx.ClientIP()
+	userAgent := ctx.Request.UserAgent()
+
+	// 验证码错误与密码错误一样计入失败次数，防止暴力猜测
+	if err := c.securityService.CheckLogin(claims.UserID, ip); err != nil {
+		var blocked *service.LoginBlockedError
+		if !errors.As(err, &blocked) {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.recordLogin(claims.UserID, claims.Username, ip, userAgent, blocked.Result)
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(blocked.RetryAfter.Seconds()))))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": blocked.Error()})
+		return
+	}
+
+	response, err := c.userService.LoginTwoFactor(claims.UserID, req.Code, ip, userAgent)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取用户信息失败"})
+		result := models.LoginResultTwoFactor
+		if errors.Is(err, service.ErrUserDisabled) {
+			result = models.LoginResultDisabled
+		}
+		c.recordLogin(claims.UserID, claims.Username, ip, userAgent, result)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
+	c.recordLogin(claims.UserID, claims.Username, ip, userAgent, models.LoginResultSuccess)
+
+	c.jwtConfig.SetAuthCookies(ctx, response.Token, response.RefreshToken)
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "获取用户信息成功",
-		"user": models.UserResponse{
-			ID:         user.ID,
-			Username:   user.Username,
-			Name:       user.Name,
-			Role:       user.Role,
-			Phone:      user.Phone,
-			IDCard:     user.IDCard,
-			Department: user.Department,
-			JobTitle:   user.JobTitle,
-			Avatar:     user.Avatar,
-			Status:     user.Status,
-			CreatedAt:  user.CreatedAt,
-		},
+		"message": "登录成功",
+		"data":    response,
 	})
 }
 
-// UpdateUser 更新用户信息
-func (c *Controllers) UpdateUser(ctx *gin.Context) {
-	userID, exists := ctx.Get("user_id")
-	if !exists {
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+// GetTwoFactorStatus 获取当前用户的两步验证状态
+func (c *Controllers) GetTwoFactorStatus(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	status, err := c.twoFactorService.GetStatus(userID.(int))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var req models.UserUpdateRequest
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取两步验证状态成功",
+		"data":    status,
+	})
+}
+
+// SetupTwoFactor 生成两步验证密钥和配置地址
+func (c *Controllers) SetupTwoFactor(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	setup, err := c.twoFactorService.BeginSetup(userID.(int))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "请使用验证器应用扫描二维码或手动输入密钥",
+		"data":    setup,
+	})
+}
+
+// EnableTwoFactor 提交验证码确认绑定，返回恢复码；当前会话解除绑定限制，其他会话全部注销
+func (c *Controllers) EnableTwoFactor(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var req models.TwoFactorCodeRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, err := c.userService.UpdateUser(userID.(int), &req)
+	codes, err := c.twoFactorService.Enable(userID.(int), req.Code)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "更新用户信息失败"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, refreshToken, err := c.sessionService.ReissueSession(ctx.GetString("session_id"), userID.(int), "")
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.jwtConfig.SetAuthCookies(ctx, token, refreshToken)
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "更新用户信息成功",
-		"user": models.UserResponse{
-			ID:         user.ID,
-			Username:   user.Username,
-			Name:       user.Name,
-			Role:       user.Role,
-			Phone:      user.Phone,
-			IDCard:     user.IDCard,
-			Department: user.Department,
-			JobTitle:   user.JobTitle,
-			Avatar:     user.Avatar,
-			Status:     user.Status,
-			CreatedAt:  user.CreatedAt,
+		"message": "两步验证已启用，请妥善保存恢复码",
+		"data": gin.H{
+			"recovery_codes": codes,
+			"token":          token,
+			"refresh_token":  refreshToken,
 		},
 	})
 }
 
-// CreateQuestionBank 创建题库
-func (c *Controllers) CreateQuestionBank(ctx *gin.Context) {
+// DisableTwoFactor 用验证码或恢复码停用两步验证
+func (c *Controllers) DisableTwoFactor(ctx *gin.Context) {
 	userID, _ := ctx.Get("user_id")
 
-	var req models.QuestionBankCreateRequest
+	var req models.TwoFactorCodeRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	bank, err := c.questionService.CreateQuestionBank(&req, userID.(int))
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := c.twoFactorService.Disable(userID.(int), req.Code); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "题库创建成功",
-		"bank":    bank,
+		"message": "两步验证已停用",
 	})
 }
 
-// GetQuestionBankByID 根据ID获取题库
-func (c *Controllers) GetQuestionBankByID(ctx *gin.Context) {
-	bankID, err := strconv.Atoi(ctx.Param("id"))
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的题库ID"})
+// RegenerateRecoveryCodes 重新生成恢复码，旧恢复码全部作废
+func (c *Controllers) RegenerateRecoveryCodes(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var req models.TwoFactorCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	bank, err := c.questionService.GetQuestionBankByID(bankID)
+	codes, err := c.twoFactorService.RegenerateRecoveryCodes(userID.(int), req.Code)
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "题库不存在"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "获取题库成功",
-		"bank":    bank,
+		"message": "已重新生成恢复码，请妥善保存",
+		"data":    gin.H{"recovery_codes": codes},
 	})
 }
 
-// ListQuestionBanks 获取题库列表
-func (c *Controllers) ListQuestionBanks(ctx *gin.Context) {
-	subject := ctx.Query("subject")
-	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
+// oidcStateCookie 保存OIDC登录的state，回调时与参数比对，确保回调来自发起登录的浏览器
+const oidcStateCookie = "oidc_state"
+
+// OIDCLogin 跳转到统一身份认证服务登录
+func (c *Controllers) OIDCLogin(ctx *gin.Context) {
+	c.beginOIDC(ctx, 0)
+}
+
+// LinkOIDC 已登录用户跳转到统一身份认证服务，回调后绑定外部身份
+func (c *Controllers) LinkOIDC(ctx *gin.Context) {
+	c.beginOIDC(ctx, ctx.GetInt("user_id"))
+}
 
-	banks, total, err := c.questionService.ListQuestionBanks(subject, page, pageSize)
+// beginOIDC 创建登录状态并重定向到认证服务
+func (c *Controllers) beginOIDC(ctx *gin.Context, userID int) {
+	authURL, state, err := c.oidcService.Begin(ctx.Request.Context(), userID)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		var unavailable *service.AuthUnavailableError
+		if errors.As(err, &unavailable) {
+			log.Printf("统一身份认证服务不可用: %v", unavailable.Err)
+		}
+		if userID > 0 {
+			ctx.Redirect(http.StatusFound, "/profile?identity=failed")
+			return
+		}
+		c.renderLogin(ctx, http.StatusServiceUnavailable, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"message": "获取题库列表成功",
-		"data": gin.H{
-			"banks":     banks,
-			"total":     total,
-			"page":      page,
-			"page_size": pageSize,
-		},
-	})
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oidcStateCookie, state, int(10*time.Minute/time.Second), "/api/auth/oidc", "", false, true)
+	ctx.Redirect(http.StatusFound, authURL)
 }
 
-// CreateQuestion 创建题目
-func (c *Controllers) CreateQuestion(ctx *gin.Context) {
-	userID, _ := ctx.Get("user_id")
+// OIDCCallback 统一身份认证服务登录后的回调：绑定流程完成绑定后返回个人中心，
+// 登录流程创建会话后由登录页保存令牌并跳转，已启用两步验证时由登录页继续输入验证码
+func (c *Controllers) OIDCCallback(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	state := ctx.Query("state")
+	cookieState, _ := ctx.Cookie(oidcStateCookie)
+	ctx.SetCookie(oidcStateCookie, "", -1, "/api/auth/oidc", "", false, true)
+
+	var callback *service.OIDCCallback
+	err := service.ErrOIDCState
+	// 用户在认证服务取消登录时回调只带error参数
+	if ctx.Query("error") == "" && state != "" && state == cookieState {
+		callback, err = c.oidcService.Complete(ctx.Request.Context(), state, ctx.Query("code"))
+	}
+	if err != nil {
+		var unavailable *service.AuthUnavailableError
+		if errors.As(err, &unavailable) {
+			log.Printf("统一身份认证登录失败: %v", unavailable.Err)
+			err = errors.New("统一身份认证登录失败，请重试或使用账号密码登录")
+		}
+		if ctx.GetInt("user_id") > 0 {
+			ctx.Redirect(http.StatusFound, "/profile?identity=failed")
+			return
+		}
+		c.renderLogin(ctx, http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
 
-	var req models.QuestionCreateRequest
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if callback.LinkUserID > 0 {
+		result := "linked"
+		if callback.LinkUserID != ctx.GetInt("user_id") {
+			result = "failed"
+		} else if err := c.oidcService.Link(callback.LinkUserID, callback); err != nil {
+			result = "failed"
+			if errors.Is(err, service.ErrIdentityTaken) {
+				result = "taken"
+			}
+		}
+		ctx.Redirect(http.StatusFound, "/profile?identity="+result)
+		return
+	}
+
+	ip := ctx.ClientIP()
+	userAgent := ctx.Request.UserAgent()
+
+	userID, err := c.oidcService.ResolveUser(callback)
+	if err != nil {
+		c.renderLogin(ctx, http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	response, err := c.userService.LoginExternalUser(userID, ip, userAgent)
+	if err != nil {
+		if errors.Is(err, service.ErrUserDisabled) {
+			c.recordLogin(userID, callback.Identity.Username, ip, userAgent, models.LoginResultDisabled)
+		}
+		c.renderLogin(ctx, http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
-	question, err := c.questionService.CreateQuestion(&req, userID.(int))
+	if !response.TwoFactorRequired {
+		c.recordLogin(response.User.ID, response.User.Username, ip, userAgent, models.LoginResultSuccess)
+		c.jwtConfig.SetAuthCookies(ctx, response.Token, response.RefreshToken)
+	}
+	c.renderLogin(ctx, http.StatusOK, gin.H{"ssoLogin": response})
+}
+
+// ListMyIdentities 获取当前用户绑定的统一身份认证账号
+func (c *Controllers) ListMyIdentities(ctx *gin.Context) {
+	identities, err := c.oidcService.ListIdentities(ctx.GetInt("user_id"))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message":  "题目创建成功",
-		"question": question,
+		"message": "获取绑定列表成功",
+		"data":    identities,
 	})
 }
 
-// GetQuestionByID 根据ID获取题目
-func (c *Controllers) GetQuestionByID(ctx *gin.Context) {
-	questionID, err := strconv.Atoi(ctx.Param("id"))
+// UnlinkMyIdentity 解除当前用户绑定的统一身份认证账号
+func (c *Controllers) UnlinkMyIdentity(ctx *gin.Context) {
+	identityID, err := strconv.Atoi(ctx.Param("id"))
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的题目ID"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的绑定ID"})
 		return
 	}
 
-	question, err := c.questionService.GetQuestionByID(questionID)
-	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "题目不存在"})
+	if err := c.oidcService.Unlink(ctx.GetInt("user_id"), identityID); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message":  "获取题目成功",
-		"question": question,
+		"message": "已解除绑定",
 	})
 }
 
-// ListQuestionsByBank 获取题库下的题目列表
-func (c *Controllers) ListQuestionsByBank(ctx *gin.Context) {
-	bankID, err := strconv.Atoi(ctx.Param("bank_id"))
+// renderLogin 渲染登录页，统一身份认证的回调也通过登录页显示结果
+func (c *Controllers) renderLogin(ctx *gin.Context, status int, data gin.H) {
+	data["title"] = "登录 - 基层三基考试系统"
+	data["oidcEnabled"] = c.oidcService.Enabled()
+	data["oidcName"] = c.oidcService.DisplayName()
+	data["captchaRequired"] = c.captchaRequired(0, ctx.ClientIP())
+	data["captchaType"] = c.captchaService.Type()
+	data["passwordResetEnabled"] = len(c.resetService.Channels()) > 0
+	ctx.HTML(status, "login.html", data)
+}
+
+// captchaRequired 登录是否需要验证码，无法判断时按需要处理
+func (c *Controllers) captchaRequired(userID int, ip string) bool {
+	required, err := c.securityService.CaptchaRequired(userID, ip)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的题库ID"})
+		log.Printf("查询登录失败次数失败: %v", err)
+		return true
+	}
+	return required
+}
+
+// recordLogin 记录登录尝试，记录失败不影响登录结果
+func (c *Controllers) recordLogin(userID int, username, ip, userAgent, result string) {
+	if err := c.securityService.RecordLogin(userID, username, ip, userAgent, result); err != nil {
+		log.Printf("记录登录尝试失败: %v", err)
+	}
+}
+
+// ChangePassword 修改当前用户密码，成功后签发新的不受限令牌
+func (c *Controllers) ChangePassword(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var req models.ChangePasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
+	if err := c.userService.ChangePassword(operator(ctx), req.CurrentPassword, req.NewPassword); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	questions, total, err := c.questionService.ListQuestionsByBank(bankID, page, pageSize)
+	// 当前会话解除修改密码的限制（必须启用两步验证但尚未绑定时转为绑定两步验证的限制），其他会话全部注销
+	scope, err := c.twoFactorService.SetupScope(userID.(int))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	token, refreshToken, err := c.sessionService.ReissueSession(ctx.GetString("session_id"), userID.(int), scope)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.jwtConfig.SetAuthCookies(ctx, token, refreshToken)
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "获取题目列表成功",
+		"message": "密码修改成功",
 		"data": gin.H{
-			"questions": questions,
-			"total":     total,
-			"page":      page,
-			"page_size": pageSize,
+			"token":                     token,
+			"refresh_token":             refreshToken,
+			"two_factor_setup_required": scope == middleware.ScopeTwoFactorSetup,
 		},
 	})
 }
 
-// GenerateExam 生成试卷
-func (c *Controllers) GenerateExam(ctx *gin.Context) {
-	userID, _ := ctx.Get("user_id")
-
-	var req models.ExamGenerateRequest
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// RefreshToken 用刷新令牌换取新的访问令牌，刷新令牌同时轮换
+func (c *Controllers) RefreshToken(ctx *gin.Context) {
+	var req models.RefreshTokenRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.RefreshToken == "" {
+		req.RefreshToken, _ = ctx.Cookie(middleware.RefreshTokenCookie)
+	}
+	if req.RefreshToken == "" {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "缺少刷新令牌"})
 		return
 	}
 
-	exam, err := c.examService.GenerateExam(&req, userID.(int))
+	token, refreshToken, err := c.sessionService.RefreshSession(req.RefreshToken, ctx.ClientIP(), ctx.Request.UserAgent())
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.jwtConfig.ClearAuthCookies(ctx)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
+	c.jwtConfig.SetAuthCookies(ctx, token, refreshToken)
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "试卷生成成功",
-		"exam":    exam,
+		"message": "刷新令牌成功",
+		"data": gin.H{
+			"token":         token,
+			"refresh_token": refreshToken,
+			"expires_in":    c.jwtConfig.ExpiresIn,
+		},
 	})
 }
 
-// GetExamByID 根据ID获取试卷
-func (c *Controllers) GetExamByID(ctx *gin.Context) {
-	examID, err := strconv.Atoi(ctx.Param("id"))
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的试卷ID"})
+// RequestPasswordReset 找回密码第一步：向账号绑定的邮箱或手机号发送验证码。
+// 无论账号是否存在都返回相同的提示，不向请求方透露账号信息
+func (c *Controllers) RequestPasswordReset(ctx *gin.Context) {
+	var req models.PasswordResetCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	exam, err := c.examService.GetExamByID(examID)
-	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "试卷不存在"})
+	if !c.captchaService.VerifyCaptcha(req.CaptchaID, req.Captcha) {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "验证码错误"})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"message": "获取试卷成功",
-		"exam":    exam,
-	})
+	if err := c.resetService.RequestCode(req.Account, req.Channel, ctx.ClientIP()); err != nil {
+		c.passwordResetError(ctx, err)
+		return
+	}
+
+	message := "如果账号存在且已绑定邮箱，验证码已发送到该邮箱"
+	if req.Channel == models.ResetChannelSMS {
+		message = "如果账号存在且已绑定手机号，验证码已通过短信发送"
+	}
+	ctx.JSON(http.StatusOK, gin.H{"message": message})
 }
 
-// StartExam 开始考试
-func (c *Controllers) StartExam(ctx *gin.Context) {
-	userID, _ := ctx.Get("user_id")
-	examID, err := strconv.Atoi(ctx.Param("id"))
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的试卷ID"})
+// VerifyPasswordReset 找回密码第二步：校验验证码，返回设置新密码用的reset_token
+func (c *Controllers) VerifyPasswordReset(ctx *gin.Context) {
+	var req models.PasswordResetVerifyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	record, err := c.examService.StartExam(examID, userID.(int))
+	token, err := c.resetService.VerifyCode(req.Account, req.Code)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.passwordResetError(ctx, err)
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "开始考试成功",
-		"record":  record,
+		"message": "验证成功，请设置新密码",
+		"data": gin.H{
+			"reset_token": token,
+		},
 	})
 }
 
-// SubmitExam 提交试卷
-func (c *Controllers) SubmitExam(ctx *gin.Context) {
-	var req models.ExamSubmitRequest
+// ConfirmPasswordReset 找回密码第三步：设置新密码，成功后需要重新登录
+func (c *Controllers) ConfirmPasswordReset(ctx *gin.Context) {
+	var req models.PasswordResetConfirmRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	record, err := c.examService.SubmitExam(&req)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := c.resetService.ResetPassword(req.ResetToken, req.NewPassword, ctx.ClientIP()); err != nil {
+		c.passwordResetError(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"message": "提交试卷成功",
-		"record":  record,
-	})
+	ctx.JSON(http.StatusOK, gin.H{"message": "密码已重置，请使用新密码登录"})
 }
 
-// GetExamRecord 获取考试记录
-func (c *Controllers) GetExamRecord(ctx *gin.Context) {
-	recordID, err := strconv.Atoi(ctx.Param("id"))
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的记录ID"})
+// passwordResetError 找回密码的请求错误返回400，请求过于频繁返回429，其他错误返回500
+func (c *Controllers) passwordResetError(ctx *gin.Context, err error) {
+	var resetErr *service.PasswordResetError
+	if !errors.As(err, &resetErr) {
+		log.Printf("找回密码失败: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "服务暂时不可用，请稍后再试"})
+		return
+	}
+	if errors.Is(err, service.ErrResetLimited) {
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": resetErr.Error()})
 		return
 	}
+	ctx.JSON(http.StatusBadRequest, gin.H{"error": resetErr.Error()})
+}
 
-	record, err := c.examService.GetExamRecord(recordID)
-	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "记录不存在"})
+// Logout 注销当前会话
+func (c *Controllers) Logout(ctx *gin.Context) {
+	if err := c.sessionService.RevokeSessionByID(ctx.GetString("session_id")); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.jwtConfig.ClearAuthCookies(ctx)
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "获取考试记录成功",
-		"record":  record,
+		"message": "已退出登录",
 	})
 }
 
-// ListExamRecords 获取用户考试记录
-func (c *Controllers) ListExamRecords(ctx *gin.Context) {
+// ListMySessions 获取当前用户的登录会话
+func (c *Controllers) ListMySessions(ctx *gin.Context) {
 	userID, _ := ctx.Get("user_id")
-	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
 
-	records, total, err := c.examService.ListExamRecords(userID.(int), page, pageSize)
+	sessions, err := c.sessionService.ListSessions(userID.(int), ctx.GetString("session_id"))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "获取考试记录成功",
-		"data": gin.H{
-			"records":   records,
-			"total":     total,
-			"page":      page,
-			"page_size": pageSize,
-		},
+		"message": "获取会话列表成功",
+		"data":    sessions,
 	})
 }
 
-// GetExamStats 获取考试统计数据
-func (c *Controllers) GetExamStats(ctx *gin.Context) {
+// RevokeMySession 注销当前用户的某个会话，例如在其他设备上的登录
+func (c *Controllers) RevokeMySession(ctx *gin.Context) {
 	userID, _ := ctx.Get("user_id")
 
-	stats, err := c.examService.GetExamStats(userID.(int))
+	if err := c.sessionService.RevokeSession(userID.(int), ctx.Param("id")); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "注销会话成功",
+	})
+}
+
+// GetCurrentUser 获取当前用户信息
+func (c *Controllers) GetCurrentUser(ctx *gin.Context) {
+	userID, exists := ctx.Get("user_id")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	user, err := c.userService.GetUserByID(userID.(int))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "获取用户信息失败"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取用户信息成功",
+		"user": maskedUserResponse(ctx, models.UserResponse{
+			ID:           user.ID,
+			Username:     user.Username,
+			Name:         user.Name,
+			Role:         user.Role,
+			Phone:        user.Phone,
+			IDCard:       user.IDCard,
+			BirthDate:    user.BirthDate,
+			DepartmentID: user.DepartmentID,
+			Department:   user.Department,
+			JobTitle:     user.JobTitle,
+			Avatar:       user.Avatar,
+			Status:       user.Status,
+			CreatedAt:    user.CreatedAt,
+		}),
+	})
+}
+
+// UpdateUser 更新用户信息
+func (c *Controllers) UpdateUser(ctx *gin.Context) {
+	if _, exists := ctx.Get("user_id"); !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		return
+	}
+
+	var req models.UserUpdateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := c.userService.UpdateUser(operator(ctx), &req)
+	if err != nil {
+		var invalid *service.PersonalInfoError
+		if errors.As(err, &invalid) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": invalid.Message})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "更新用户信息失败"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "更新用户信息成功",
+		"user": maskedUserResponse(ctx, models.UserResponse{
+			ID:           user.ID,
+			Username:     user.Username,
+			Name:         user.Name,
+			Role:         user.Role,
+			Phone:        user.Phone,
+			IDCard:       user.IDCard,
+			BirthDate:    user.BirthDate,
+			DepartmentID: user.DepartmentID,
+			Department:   user.Department,
+			JobTitle:     user.JobTitle,
+			Avatar:       user.Avatar,
+			Status:       user.Status,
+			CreatedAt:    user.CreatedAt,
+		}),
+	})
+}
+
+// UploadAvatar 上传头像：multipart字段file为图片，可选crop_x、crop_y、crop_size指定正方形裁剪区域（像素）
+func (c *Controllers) UploadAvatar(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请选择头像图片"})
+		return
+	}
+	crop, err := parseAvatarCrop(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	// 多读一个字节，超过大小上限的文件由头像服务拒绝
+	data, err := io.ReadAll(io.LimitReader(file, int64(c.avatarService.MaxSize())+1))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	avatar, err := c.avatarService.UploadAvatar(userID.(int), data, crop)
+	if err != nil {
+		var invalid *service.AvatarError
+		if errors.As(err, &invalid) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": invalid.Message})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "保存头像失败"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "头像上传成功",
+		"avatar":  avatar,
+	})
+}
+
+// parseAvatarCrop 解析头像裁剪区域，未指定crop_size时返回nil
+func parseAvatarCrop(ctx *gin.Context) (*models.AvatarCrop, error) {
+	if ctx.PostForm("crop_size") == "" {
+		return nil, nil
+	}
+	var values [3]int
+	for i, name := range []string{"crop_x", "crop_y", "crop_size"} {
+		value, err := strconv.Atoi(ctx.PostForm(name))
+		if err != nil || value < 0 {
+			return nil, errors.New("无效的裁剪区域")
+		}
+		values[i] = value
+	}
+	return &models.AvatarCrop{X: values[0], Y: values[1], Size: values[2]}, nil
+}
+
+// ResetAvatar 恢复默认头像
+func (c *Controllers) ResetAvatar(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	if err := c.avatarService.ResetAvatar(userID.(int)); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "恢复默认头像失败"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "已恢复默认头像",
+	})
+}
+
+// CreateQuestionBank 创建题库
+func (c *Controllers) CreateQuestionBank(ctx *gin.Context) {
+	var req models.QuestionBankCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scope, ok := c.dataScope(ctx)
+	if !ok {
+		return
+	}
+	departmentID, err := c.scopeService.OwnerDepartment(scope, req.DepartmentID)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	req.DepartmentID = departmentID
+
+	bank, err := c.questionService.CreateQuestionBank(&req, operator(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "获取考试统计成功",
-		"stats":   stats,
+		"message": "题库创建成功",
+		"bank":    bank,
 	})
 }
 
-// ListExams 获取试卷列表
-func (c *Controllers) ListExams(ctx *gin.Context) {
+// GetQuestionBankByID 根据ID获取题库
+func (c *Controllers) GetQuestionBankByID(ctx *gin.Context) {
+	bankID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的题库ID"})
+		return
+	}
+	if !c.checkBank(ctx, bankID, false) {
+		return
+	}
+
+	bank, err := c.questionService.GetQuestionBankByID(bankID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "题库不存在"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取题库成功",
+		"bank":    bank,
+	})
+}
+
+// ListQuestionBanks 获取题库列表
+func (c *Controllers) ListQuestionBanks(ctx *gin.Context) {
+	subject := ctx.Query("subject")
 	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
 
-	exams, total, err := c.examService.ListExams(page, pageSize)
+	scope, ok := c.dataScope(ctx)
+	if !ok {
+		return
+	}
+
+	banks, total, err := c.questionService.ListQuestionBanks(scope, subject, page, pageSize)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "获取试卷列表成功",
+		"message": "获取题库列表成功",
 		"data": gin.H{
-			"exams":     exams,
+			"banks":     banks,
 			"total":     total,
 			"page":      page,
 			"page_size": pageSize,
@@ -492,47 +979,1903 @@ func (c *Controllers) ListExams(ctx *gin.Context) {
 	})
 }
 
-// GetPracticeQuestions 获取练习题目
-func (c *Controllers) GetPracticeQuestions(ctx *gin.Context) {
-	// 这里需要实现GetPracticeQuestions方法
+// CreateQuestion 创建题目
+func (c *Controllers) CreateQuestion(ctx *gin.Context) {
+	var req models.QuestionCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !c.checkBank(ctx, req.BankID, true) {
+		return
+	}
+
+	question, err := c.questionService.CreateQuestion(&req, operator(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
-		"message":   "获取练习题目成功",
-		"questions": []models.Question{},
+		"message":  "题目创建成功",
+		"question": question,
 	})
 }
 
-// SubmitPractice 提交练习答案
-func (c *Controllers) SubmitPractice(ctx *gin.Context) {
-	// 这里需要实现SubmitPractice方法
+// GetQuestionByID 根据ID获取题目
+func (c *Controllers) GetQuestionByID(ctx *gin.Context) {
+	questionID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的题目ID"})
+		return
+	}
+
+	question, err := c.questionService.GetQuestionByID(questionID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "题目不存在"})
+		return
+	}
+	if !c.checkBank(ctx, question.BankID, false) {
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "提交练习答案成功",
-		"score":   0,
-		"correct": 0,
-		"total":   0,
+		"message":  "获取题目成功",
+		"question": question,
 	})
 }
 
-// ListWrongQuestions 获取错题列表
-func (c *Controllers) ListWrongQuestions(ctx *gin.Context) {
+// ListQuestionsByBank 获取题库下的题目列表
+func (c *Controllers) ListQuestionsByBank(ctx *gin.Context) {
+	bankID, err := strconv.Atoi(ctx.Param("bank_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的题库ID"})
+		return
+	}
+
+	if !c.checkBank(ctx, bankID, false) {
+		return
+	}
+
 	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
 
-	// 这里需要实现ListWrongQuestions方法
+	questions, total, err := c.questionService.ListQuestionsByBank(bankID, page, pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "获取错题列表成功",
+		"message": "获取题目列表成功",
 		"data": gin.H{
-			"questions": []models.Question{},
-			"total":     0,
+			"questions": questions,
+			"total":     total,
 			"page":      page,
 			"page_size": pageSize,
 		},
 	})
 }
 
-// RemoveWrongQuestion 移除错题
-func (c *Controllers) RemoveWrongQuestion(ctx *gin.Context) {
-	// 这里需要实现RemoveWrongQuestion方法
+// GenerateExam 生成试卷
+func (c *Controllers) GenerateExam(ctx *gin.Context) {
+	var req models.ExamGenerateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scope, ok := c.dataScope(ctx)
+	if !ok {
+		return
+	}
+	departmentID, err := c.scopeService.OwnerDepartment(scope, req.DepartmentID)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	req.DepartmentID = departmentID
+
+	exam, err := c.examService.GenerateExam(scope, &req, operator(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "移除错题成功",
+		"message": "试卷生成成功",
+		"exam":    exam,
 	})
 }
+
+// GetExamByID 根据ID获取试卷
+func (c *Controllers) GetExamByID(ctx *gin.Context) {
+	examID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的试卷ID"})
+		return
+	}
+
+	if !c.checkExam(ctx, examID, false) {
+		return
+	}
+
+	exam, err := c.examService.GetExamByID(examID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "试卷不存在"})
+		return
+	}
+
+	// 考生只能看到题目，标准答案和解析只返回给组卷和阅卷人员
+	if !middleware.HasPermission(ctx, models.PermExamPublish) && !middleware.HasPermission(ctx, models.PermGradeReview) {
+		for i := range exam.Questions {
+			exam.Questions[i].Answer = ""
+			exam.Questions[i].Analysis = ""
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取试卷成功",
+		"exam":    exam,
+	})
+}
+
+// StartExam 开始考试
+func (c *Controllers) StartExam(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	examID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的试卷ID"})
+		return
+	}
+	if !c.checkExam(ctx, examID, false) {
+		return
+	}
+
+	record, err := c.examService.StartExam(examID, userID.(int), ctx.GetString("session_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "开始考试成功",
+		"record":  record,
+	})
+}
+
+// SubmitExam 提交试卷
+func (c *Controllers) SubmitExam(ctx *gin.Context) {
+	var req models.ExamSubmitRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	record, err := c.examService.SubmitExam(&req, operator(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 及格后自动签发合格证书并计入学分，失败不影响交卷结果
+	if record.Status == "graded" {
+		if _, err := c.certService.IssueForRecord(record.ID); err != nil && !errors.Is(err, service.ErrNotPassed) {
+			log.Printf("签发证书失败，记录ID %d: %v", record.ID, err)
+		}
+		if err := c.cmeService.AccrueExamCredits(record.ID); err != nil {
+			log.Printf("计入学分失败，记录ID %d: %v", record.ID, err)
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "提交试卷成功",
+		"record":  record,
+	})
+}
+
+// ReportProctorEvent 考生端上报切屏、复制粘贴等防作弊事件，返回需要执行的提醒或交卷动作
+func (c *Controllers) ReportProctorEvent(ctx *gin.Context) {
+	recordID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的记录ID"})
+		return
+	}
+
+	var req models.ProctorEventRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := c.proctorService.ReportEvent(operator(ctx), ctx.GetString("session_id"), recordID, &req)
+	if err != nil {
+		c.proctorError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "上报事件成功",
+		"data":    result,
+	})
+}
+
+// GetProctorPolicy 获取试卷的防作弊违规次数阈值
+func (c *Controllers) GetProctorPolicy(ctx *gin.Context) {
+	examID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的试卷ID"})
+		return
+	}
+	if !c.checkExam(ctx, examID, false) {
+		return
+	}
+
+	policy, err := c.proctorService.GetPolicy(examID)
+	if err != nil {
+		c.proctorError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取防作弊设置成功",
+		"data":    policy,
+	})
+}
+
+// SetProctorPolicy 设置试卷的防作弊违规次数阈值
+func (c *Controllers) SetProctorPolicy(ctx *gin.Context) {
+	examID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的试卷ID"})
+		return
+	}
+
+	var req models.ProctorPolicyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !c.checkExam(ctx, examID, true) {
+		return
+	}
+
+	policy, err := c.proctorService.SetPolicy(operator(ctx), examID, &req)
+	if err != nil {
+		c.proctorError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "设置防作弊阈值成功",
+		"data":    policy,
+	})
+}
+
+// ListFlaggedRecords 查询被标记为可疑的考试记录
+func (c *Controllers) ListFlaggedRecords(ctx *gin.Context) {
+	filter := &models.FlaggedRecordFilter{Status: ctx.Query("status")}
+	switch filter.Status {
+	case "", models.ProctorStatusWarned, models.ProctorStatusFlagged, models.ProctorStatusForceSubmitted:
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的监考状态"})
+		return
+	}
+	if examID := ctx.Query("exam_id"); examID != "" {
+		id, err := strconv.Atoi(examID)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的试卷ID"})
+			return
+		}
+		filter.ExamID = id
+	}
+	departmentID, _ := strconv.Atoi(ctx.Query("department_id"))
+	departmentID, err := c.scopeDepartment(ctx, departmentID)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	filter.DepartmentID = departmentID
+	filter.Page, _ = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	filter.PageSize, _ = strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
+
+	records, total, err := c.proctorService.ListFlaggedRecords(filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取可疑考试记录成功",
+		"data": gin.H{
+			"records":   records,
+			"total":     total,
+			"page":      filter.Page,
+			"page_size": filter.PageSize,
+		},
+	})
+}
+
+// ListProctorEvents 获取考试记录的防作弊事件，科室管理员只能查看所属科室子树内人员的记录
+func (c *Controllers) ListProctorEvents(ctx *gin.Context) {
+	recordID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的记录ID"})
+		return
+	}
+
+	userID, err := c.proctorService.RecordUserID(recordID)
+	if err != nil {
+		c.proctorError(ctx, err)
+		return
+	}
+	if !c.canManageUser(ctx, userID) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": service.ErrOutOfScope.Error()})
+		return
+	}
+
+	events, err := c.proctorService.ListEvents(recordID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取防作弊事件成功",
+		"data":    events,
+	})
+}
+
+// proctorError 输出防作弊接口的错误：请求不符合要求时返回400，其他错误返回500
+func (c *Controllers) proctorError(ctx *gin.Context, err error) {
+	var proctorErr *service.ProctorError
+	if errors.As(err, &proctorErr) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": proctorErr.Error()})
+		return
+	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// GetExamRecord 获取考试记录
+func (c *Controllers) GetExamRecord(ctx *gin.Context) {
+	recordID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的记录ID"})
+		return
+	}
+
+	record, err := c.examService.GetExamRecord(recordID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "记录不存在"})
+		return
+	}
+	if !c.canAccessUser(ctx, record.UserID) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": service.ErrOutOfScope.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取考试记录成功",
+		"record":  record,
+	})
+}
+
+// ListExamRecords 获取用户考试记录
+func (c *Controllers) ListExamRecords(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
+
+	records, total, err := c.examService.ListExamRecords(userID.(int), page, pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取考试记录成功",
+		"data": gin.H{
+			"records":   records,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// GetExamStats 获取考试统计数据
+func (c *Controllers) GetExamStats(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	stats, err := c.examService.GetExamStats(userID.(int))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取考试统计成功",
+		"stats":   stats,
+	})
+}
+
+// ListExams 获取试卷列表
+func (c *Controllers) ListExams(ctx *gin.Context) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
+
+	scope, ok := c.dataScope(ctx)
+	if !ok {
+		return
+	}
+
+	exams, total, err := c.examService.ListExams(scope, page, pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取试卷列表成功",
+		"data": gin.H{
+			"exams":     exams,
+			"total":     total,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// GetPracticeQuestions 获取练习题目
+func (c *Controllers) GetPracticeQuestions(ctx *gin.Context) {
+	// 这里需要实现GetPracticeQuestions方法
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":   "获取练习题目成功",
+		"questions": []models.Question{},
+	})
+}
+
+// SubmitPractice 提交练习答案
+func (c *Controllers) SubmitPractice(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var req models.PracticeSubmitRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scope, ok := c.dataScope(ctx)
+	if !ok {
+		return
+	}
+	record, err := c.examService.SubmitPractice(userID.(int), scope, &req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 练习量达到练习目标后计入学分
+	if err := c.cmeService.AccruePracticeGoals(userID.(int), record.CreatedAt.Year()); err != nil {
+		log.Printf("计入练习学分失败，用户ID %d: %v", userID, err)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "提交练习答案成功",
+		"score":   record.Score,
+		"correct": record.CorrectCount,
+		"total":   record.QuestionCount,
+	})
+}
+
+// ListWrongQuestions 获取错题列表
+func (c *Controllers) ListWrongQuestions(ctx *gin.Context) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
+
+	// 这里需要实现ListWrongQuestions方法
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取错题列表成功",
+		"data": gin.H{
+			"questions": []models.Question{},
+			"total":     0,
+			"page":      page,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// RemoveWrongQuestion 移除错题
+func (c *Controllers) RemoveWrongQuestion(ctx *gin.Context) {
+	// 这里需要实现RemoveWrongQuestion方法
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "移除错题成功",
+	})
+}
+
+// ExportScoreSheet 导出XLSX成绩单
+func (c *Controllers) ExportScoreSheet(ctx *gin.Context) {
+	filter, err := c.parseScoreSheetFilter(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := c.reportService.ListScoreSheet(filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setAttachment(ctx, fmt.Sprintf("成绩单_%s.xlsx", time.Now().Format("20060102150405")),
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	if err := c.reportService.WriteScoreSheetXLSX(ctx.Writer, rows); err != nil {
+		ctx.Error(err)
+	}
+}
+
+// ExportExamSummaryPDF 导出单场考试的PDF成绩汇总表
+func (c *Controllers) ExportExamSummaryPDF(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	examID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的试卷ID"})
+		return
+	}
+	if !c.checkExam(ctx, examID, false) {
+		return
+	}
+
+	filter, err := c.parseScoreSheetFilter(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	summary, err := c.reportService.GetExamSummary(examID, filter)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "试卷不存在"})
+		return
+	}
+
+	preparedBy := ""
+	if user, err := c.userService.GetUserByID(userID.(int)); err == nil {
+		preparedBy = user.Name
+	}
+
+	setAttachment(ctx, fmt.Sprintf("%s_成绩汇总表.pdf", summary.Exam.Title), "application/pdf")
+	if err := c.reportService.WriteExamSummaryPDF(ctx.Writer, summary, filter, preparedBy, time.Now()); err != nil {
+		ctx.Error(err)
+	}
+}
+
+// parseScoreSheetFilter 从查询参数解析成绩单筛选条件
+func (c *Controllers) parseScoreSheetFilter(ctx *gin.Context) (*models.ScoreSheetFilter, error) {
+	filter := &models.ScoreSheetFilter{}
+
+	if departmentID := ctx.Query("department_id"); departmentID != "" {
+		id, err := strconv.Atoi(departmentID)
+		if err != nil {
+			return nil, errors.New("无效的科室ID")
+		}
+		dept, err := c.departmentService.GetDepartment(id)
+		if err != nil {
+			return nil, errors.New("科室不存在")
+		}
+		filter.DepartmentID = dept.ID
+		filter.Department = dept.Name
+	}
+
+	departmentID, err := c.scopeDepartment(ctx, filter.DepartmentID)
+	if err != nil {
+		return nil, err
+	}
+	if departmentID != filter.DepartmentID {
+		dept, err := c.departmentService.GetDepartment(departmentID)
+		if err != nil {
+			return nil, errors.New("科室不存在")
+		}
+		filter.DepartmentID = dept.ID
+		filter.Department = dept.Name
+	}
+
+	if examID := ctx.Query("exam_id"); examID != "" {
+		id, err := strconv.Atoi(examID)
+		if err != nil {
+			return nil, errors.New("无效的试卷ID")
+		}
+		filter.ExamID = id
+	}
+
+	if startDate := ctx.Query("start_date"); startDate != "" {
+		t, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+		if err != nil {
+			return nil, errors.New("开始日期格式错误")
+		}
+		filter.StartDate = t
+	}
+
+	if endDate := ctx.Query("end_date"); endDate != "" {
+		t, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+		if err != nil {
+			return nil, errors.New("结束日期格式错误")
+		}
+		filter.EndDate = t
+	}
+
+	if !filter.StartDate.IsZero() && !filter.EndDate.IsZero() && filter.EndDate.Before(filter.StartDate) {
+		return nil, errors.New("结束日期必须大于开始日期")
+	}
+
+	return filter, nil
+}
+
+// setAttachment 设置文件下载响应头
+func setAttachment(ctx *gin.Context, filename, contentType string) {
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(filename)))
+	ctx.Status(http.StatusOK)
+}
+
+// GetMyTranscript 获取当前用户的成绩档案
+func (c *Controllers) GetMyTranscript(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	c.respondTranscript(ctx, userID.(int))
+}
+
+// DownloadMyTranscriptPDF 下载当前用户的PDF成绩档案
+func (c *Controllers) DownloadMyTranscriptPDF(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	c.downloadTranscriptPDF(ctx, userID.(int))
+}
+
+// GetUserTranscript 获取指定用户的成绩档案
+func (c *Controllers) GetUserTranscript(ctx *gin.Context) {
+	userID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+	if !c.canManageUser(ctx, userID) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "无权查看该用户的数据"})
+		return
+	}
+	c.respondTranscript(ctx, userID)
+}
+
+// DownloadUserTranscriptPDF 下载指定用户的PDF成绩档案
+func (c *Controllers) DownloadUserTranscriptPDF(ctx *gin.Context) {
+	userID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+	if !c.canManageUser(ctx, userID) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "无权查看该用户的数据"})
+		return
+	}
+	c.downloadTranscriptPDF(ctx, userID)
+}
+
+func (c *Controllers) respondTranscript(ctx *gin.Context, userID int) {
+	transcript, err := c.buildTranscript(userID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    "获取成绩档案成功",
+		"transcript": transcript,
+	})
+}
+
+func (c *Controllers) downloadTranscriptPDF(ctx *gin.Context, userID int) {
+	transcript, err := c.buildTranscript(userID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	setAttachment(ctx, fmt.Sprintf("%s_三基成绩档案.pdf", transcript.User.Name), "application/pdf")
+	if err := c.reportService.WriteTranscriptPDF(ctx.Writer, transcript, time.Now()); err != nil {
+		ctx.Error(err)
+	}
+}
+
+// buildTranscript 组装用户信息和年度成绩
+func (c *Controllers) buildTranscript(userID int) (*models.Transcript, error) {
+	user, err := c.userService.GetUserByID(userID)
+	if err != nil {
+		return nil, errors.New("用户不存在")
+	}
+
+	years, err := c.examService.GetTranscript(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Transcript{
+		User: models.UserResponse{
+			ID:           user.ID,
+			Username:     user.Username,
+			Name:         user.Name,
+			Role:         user.Role,
+			DepartmentID: user.DepartmentID,
+			Department:   user.Department,
+			JobTitle:     user.JobTitle,
+			Avatar:       user.Avatar,
+			Status:       user.Status,
+			CreatedAt:    user.CreatedAt,
+		},
+		Years: years,
+	}, nil
+}
+
+// ListMyCertificates 获取当前用户的合格证书
+func (c *Controllers) ListMyCertificates(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	certs, err := c.certService.ListCertificates(userID.(int))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":      "获取证书列表成功",
+		"certificates": certs,
+	})
+}
+
+// IssueCertificate 为考试记录签发合格证书
+func (c *Controllers) IssueCertificate(ctx *gin.Context) {
+	recordID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的记录ID"})
+		return
+	}
+
+	record, err := c.examService.GetExamRecord(recordID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "记录不存在"})
+		return
+	}
+	if !c.canAccessUser(ctx, record.UserID) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	cert, err := c.certService.IssueForRecord(recordID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":     "签发证书成功",
+		"certificate": cert,
+		"verify_url":  c.certService.VerifyURL(cert.Code),
+	})
+}
+
+// DownloadCertificatePDF 下载PDF合格证书
+func (c *Controllers) DownloadCertificatePDF(ctx *gin.Context) {
+	certID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的证书ID"})
+		return
+	}
+
+	cert, err := c.certService.GetCertificateByID(certID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "证书不存在"})
+		return
+	}
+	if !c.canAccessUser(ctx, cert.UserID) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	setAttachment(ctx, fmt.Sprintf("合格证书_%s.pdf", cert.Code), "application/pdf")
+	if err := c.certService.WriteCertificatePDF(ctx.Writer, cert); err != nil {
+		ctx.Error(err)
+	}
+}
+
+// VerifyCertificate 公开验证证书真伪
+func (c *Controllers) VerifyCertificate(ctx *gin.Context) {
+	cert, err := c.certService.GetCertificateByCode(ctx.Param("code"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "证书不存在或验证码错误",
+			"valid":   false,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "证书验证通过",
+		"valid":   true,
+		"certificate": gin.H{
+			"code":        cert.Code,
+			"user_name":   cert.UserName,
+			"department":  cert.Department,
+			"exam_title":  cert.ExamTitle,
+			"score":       cert.Score,
+			"total_score": cert.TotalScore,
+			"issued_at":   cert.IssuedAt,
+		},
+	})
+}
+
+// canAccessUser 判断当前用户能否访问指定用户的数据：本人，或拥有成绩查看权限且该用户在数据范围内
+func (c *Controllers) canAccessUser(ctx *gin.Context, ownerID int) bool {
+	userID, _ := ctx.Get("user_id")
+	if id, ok := userID.(int); ok && id == ownerID {
+		return true
+	}
+	return middleware.HasPermission(ctx, models.PermGradeReview) && c.canManageUser(ctx, ownerID)
+}
+
+// GetMyCreditLedger 获取当前用户的年度学分台账
+func (c *Controllers) GetMyCreditLedger(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+	c.respondCreditLedger(ctx, userID.(int))
+}
+
+// GetUserCreditLedger 获取指定用户的年度学分台账
+func (c *Controllers) GetUserCreditLedger(ctx *gin.Context) {
+	userID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return
+	}
+	if !c.canManageUser(ctx, userID) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "无权查看该用户的数据"})
+		return
+	}
+	c.respondCreditLedger(ctx, userID)
+}
+
+func (c *Controllers) respondCreditLedger(ctx *gin.Context, userID int) {
+	year, err := queryYear(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ledger, err := c.cmeService.GetLedger(userID, year)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取学分台账成功",
+		"ledger":  ledger,
+	})
+}
+
+// SetExamCredits 设置试卷学分
+func (c *Controllers) SetExamCredits(ctx *gin.Context) {
+	examID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的试卷ID"})
+		return
+	}
+
+	var req models.ExamCreditsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !c.checkExam(ctx, examID, true) {
+		return
+	}
+
+	if err := c.cmeService.SetExamCredits(operator(ctx), examID, req.CMECredits); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "设置试卷学分成功",
+	})
+}
+
+// CreatePracticeGoal 创建练习目标
+func (c *Controllers) CreatePracticeGoal(ctx *gin.Context) {
+	userID, _ := ctx.Get("user_id")
+
+	var req models.PracticeGoalCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	goal, err := c.cmeService.CreatePracticeGoal(&req, userID.(int))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "练习目标创建成功",
+		"goal":    goal,
+	})
+}
+
+// ListPracticeGoals 获取年度练习目标
+func (c *Controllers) ListPracticeGoals(ctx *gin.Context) {
+	year, err := queryYear(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	goals, err := c.cmeService.ListPracticeGoals(year)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取练习目标成功",
+		"goals":   goals,
+	})
+}
+
+// SetCreditTarget 设置年度学分要求
+func (c *Controllers) SetCreditTarget(ctx *gin.Context) {
+	var req models.CreditTargetRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.cmeService.SetCreditTarget(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "设置学分要求成功",
+	})
+}
+
+// ListCreditTargets 获取年度学分要求
+func (c *Controllers) ListCreditTargets(ctx *gin.Context) {
+	year, err := queryYear(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	targets, err := c.cmeService.ListCreditTargets(year)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取学分要求成功",
+		"targets": targets,
+	})
+}
+
+// ListCreditShortfalls 获取年度学分未达标人员
+func (c *Controllers) ListCreditShortfalls(ctx *gin.Context) {
+	year, err := queryYear(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	departmentID, _ := strconv.Atoi(ctx.Query("department_id"))
+	departmentID, err = c.scopeDepartment(ctx, departmentID)
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	shortfalls, err := c.cmeService.ListCreditShortfalls(year, departmentID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取学分未达标人员成功",
+		"data": gin.H{
+			"year":       year,
+			"shortfalls": shortfalls,
+			"total":      len(shortfalls),
+		},
+	})
+}
+
+// queryYear 从查询参数获取年度，默认为当前年度
+func queryYear(ctx *gin.Context) (int, error) {
+	yearStr := ctx.Query("year")
+	if yearStr == "" {
+		return time.Now().Year(), nil
+	}
+
+	year, err := strconv.Atoi(yearStr)
+	if err != nil || year < 2000 || year > 9999 {
+		return 0, errors.New("无效的年度")
+	}
+	return year, nil
+}
+
+// CreateDepartment 创建科室
+func (c *Controllers) CreateDepartment(ctx *gin.Context) {
+	var req models.DepartmentCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dept, err := c.departmentService.CreateDepartment(operator(ctx), &req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    "创建科室成功",
+		"department": dept,
+	})
+}
+
+// UpdateDepartment 更新科室
+func (c *Controllers) UpdateDepartment(ctx *gin.Context) {
+	departmentID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的科室ID"})
+		return
+	}
+
+	var req models.DepartmentUpdateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	dept, err := c.departmentService.UpdateDepartment(operator(ctx), departmentID, &req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    "更新科室成功",
+		"department": dept,
+	})
+}
+
+// DeleteDepartment 删除科室
+func (c *Controllers) DeleteDepartment(ctx *gin.Context) {
+	departmentID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的科室ID"})
+		return
+	}
+
+	if err := c.departmentService.DeleteDepartment(operator(ctx), departmentID); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "删除科室成功",
+	})
+}
+
+// GetDepartment 获取科室详情
+func (c *Controllers) GetDepartment(ctx *gin.Context) {
+	departmentID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的科室ID"})
+		return
+	}
+
+	dept, err := c.departmentService.GetDepartment(departmentID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "科室不存在"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":    "获取科室成功",
+		"department": dept,
+	})
+}
+
+// ListDepartments 获取科室列表
+func (c *Controllers) ListDepartments(ctx *gin.Context) {
+	departments, err := c.departmentService.ListDepartments()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if departments == nil {
+		departments = []models.Department{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":     "获取科室列表成功",
+		"departments": departments,
+	})
+}
+
+// GetDepartmentTree 获取科室树
+func (c *Controllers) GetDepartmentTree(ctx *gin.Context) {
+	tree, err := c.departmentService.GetDepartmentTree()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取科室树成功",
+		"tree":    tree,
+	})
+}
+
+// GetDepartmentStats 获取科室及其下级科室的统计数据
+func (c *Controllers) GetDepartmentStats(ctx *gin.Context) {
+	departmentID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的科室ID"})
+		return
+	}
+
+	if _, err := c.scopeDepartment(ctx, departmentID); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	stats, err := c.departmentService.GetDepartmentStats(departmentID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取科室统计成功",
+		"stats":   stats,
+	})
+}
+
+// dataScope 解析当前用户的数据范围：站长为全院，其他管理角色（含自定义角色）限制在所属科室子树，失败时已写入响应
+func (c *Controllers) dataScope(ctx *gin.Context) (service.DataScope, bool) {
+	scope, err := c.scopeService.Resolve(operator(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return service.DataScope{}, false
+	}
+	return scope, true
+}
+
+// scopeDepartment 将查询科室限制在当前用户的数据范围内，未指定科室时默认为所属科室
+func (c *Controllers) scopeDepartment(ctx *gin.Context, departmentID int) (int, error) {
+	scope, err := c.scopeService.Resolve(operator(ctx))
+	if err != nil {
+		return 0, err
+	}
+	return c.scopeService.RestrictDepartment(scope, departmentID)
+}
+
+// canManageUser 判断当前管理员能否查看指定用户：科室管理员只能查看所属科室子树内的人员
+func (c *Controllers) canManageUser(ctx *gin.Context, userID int) bool {
+	scope, err := c.scopeService.Resolve(operator(ctx))
+	if err != nil {
+		return false
+	}
+	ok, err := c.scopeService.CanAccessUser(scope, userID)
+	return err == nil && ok
+}
+
+// checkBank 检查当前用户能否查看或修改题库，失败时已写入响应
+func (c *Controllers) checkBank(ctx *gin.Context, bankID int, write bool) bool {
+	scope, ok := c.dataScope(ctx)
+	if !ok {
+		return false
+	}
+	return respondScopeError(ctx, c.scopeService.CheckBank(scope, bankID, write))
+}
+
+// checkExam 检查当前用户能否查看或修改试卷，失败时已写入响应
+func (c *Controllers) checkExam(ctx *gin.Context, examID int, write bool) bool {
+	scope, ok := c.dataScope(ctx)
+	if !ok {
+		return false
+	}
+	return respondScopeError(ctx, c.scopeService.CheckExam(scope, examID, write))
+}
+
+// respondScopeError 将数据范围检查的错误写入响应，没有错误时返回true
+func respondScopeError(ctx *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, service.ErrOutOfScope) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	} else {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	}
+	return false
+}
+
+// operator 获取当前执行操作的用户及其IP
+func operator(ctx *gin.Context) service.Operator {
+	userID, _ := ctx.Get("user_id")
+	role, _ := ctx.Get("role")
+	id, _ := userID.(int)
+	r, _ := role.(string)
+	return service.Operator{
+		UserID:      id,
+		Username:    ctx.GetString("username"),
+		Role:        r,
+		Permissions: ctx.GetStringSlice("permissions"),
+		IP:          ctx.ClientIP(),
+	}
+}
+
+// canViewPersonalInfo 是否可以查看完整的手机号和身份证号
+func canViewPersonalInfo(ctx *gin.Context) bool {
+	return middleware.HasPermission(ctx, models.PermPersonalInfoView)
+}
+
+// maskedUser 没有查看个人信息权限时脱敏手机号和身份证号，不修改原用户
+func maskedUser(ctx *gin.Context, user *models.User) *models.User {
+	if user == nil || canViewPersonalInfo(ctx) {
+		return user
+	}
+	masked := *user
+	masked.MaskPersonalInfo()
+	return &masked
+}
+
+// maskedUsers 没有查看个人信息权限时脱敏用户列表中的手机号和身份证号
+func maskedUsers(ctx *gin.Context, users []models.User) []models.User {
+	if canViewPersonalInfo(ctx) {
+		return users
+	}
+	for i := range users {
+		users[i].MaskPersonalInfo()
+	}
+	return users
+}
+
+// maskedUserResponse 没有查看个人信息权限时脱敏手机号和身份证号
+func maskedUserResponse(ctx *gin.Context, resp models.UserResponse) models.UserResponse {
+	if !canViewPersonalInfo(ctx) {
+		resp.MaskPersonalInfo()
+	}
+	return resp
+}
+
+// ListUsers 分页获取用户列表
+func (c *Controllers) ListUsers(ctx *gin.Context) {
+	filter, err := c.parseUserListFilter(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	users, total, err := c.userService.ListUsers(filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取用户列表成功",
+		"data": gin.H{
+			"users":     maskedUsers(ctx, users),
+			"total":     total,
+			"page":      filter.Page,
+			"page_size": filter.PageSize,
+		},
+	})
+}
+
+// parseUserListFilter 从查询参数解析用户列表筛选条件，科室管理员只能查看所属科室子树
+func (c *Controllers) parseUserListFilter(ctx *gin.Context) (*models.UserListFilter, error) {
+	page, _ := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
+	filter := &models.UserListFilter{
+		Keyword:  ctx.Query("keyword"),
+		Role:     ctx.Query("role"),
+		Page:     page,
+		PageSize: pageSize,
+	}
+
+	if departmentID := ctx.Query("department_id"); departmentID != "" {
+		id, err := strconv.Atoi(departmentID)
+		if err != nil {
+			return nil, errors.New("无效的科室ID")
+		}
+		filter.DepartmentID = id
+	}
+
+	if status := ctx.Query("status"); status != "" {
+		s, err := strconv.Atoi(status)
+		if err != nil || (s != 0 && s != 1) {
+			return nil, errors.New("无效的用户状态")
+		}
+		filter.Status = &s
+	}
+
+	departmentID, err := c.scopeDepartment(ctx, filter.DepartmentID)
+	if err != nil {
+		return nil, err
+	}
+	filter.DepartmentID = departmentID
+
+	return filter, nil
+}
+
+// GetUser 获取指定用户信息
+func (c *Controllers) GetUser(ctx *gin.Context) {
+	userID, ok := c.managedUserID(ctx)
+	if !ok {
+		return
+	}
+
+	user, err := c.userService.GetUserByID(userID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "用户不存在"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取用户信息成功",
+		"user":    maskedUser(ctx, user),
+	})
+}
+
+// CreateUser 管理员代员工创建账号
+func (c *Controllers) CreateUser(ctx *gin.Context) {
+	var req models.AdminUserCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 科室管理员只能在所属科室子树内创建账号，未指定科室时默认为所属科室
+	scope, ok := c.dataScope(ctx)
+	if !ok {
+		return
+	}
+	if !scope.Global {
+		if req.DepartmentID == 0 && req.Department != "" {
+			dept, err := c.departmentService.ResolveDepartment(0, req.Department)
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			req.DepartmentID = dept.ID
+		}
+		departmentID, err := c.scopeService.RestrictDepartment(scope, req.DepartmentID)
+		if err != nil {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		req.DepartmentID = departmentID
+	}
+
+	user, err := c.userService.CreateUser(operator(ctx), &req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "创建用户成功",
+		"user":    maskedUser(ctx, user),
+	})
+}
+
+// AdminUpdateUser 管理员编辑用户资料
+func (c *Controllers) AdminUpdateUser(ctx *gin.Context) {
+	userID, ok := c.managedUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var req models.UserUpdateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 科室管理员不能把人员调出所属科室子树
+	scope, ok := c.dataScope(ctx)
+	if !ok {
+		return
+	}
+	if !scope.Global && (req.DepartmentID > 0 || req.Department != "") {
+		dept, err := c.departmentService.ResolveDepartment(req.DepartmentID, req.Department)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := c.scopeService.RestrictDepartment(scope, dept.ID); err != nil {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	user, err := c.userService.AdminUpdateUser(operator(ctx), userID, &req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "更新用户信息成功",
+		"user":    maskedUser(ctx, user),
+	})
+}
+
+// SetUserStatus 启用或禁用用户
+func (c *Controllers) SetUserStatus(ctx *gin.Context) {
+	userID, ok := c.managedUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var req models.UserStatusRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.userService.SetUserStatus(operator(ctx), userID, *req.Status); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "修改用户状态成功",
+	})
+}
+
+// UnlockUser 解除用户账号锁定
+func (c *Controllers) UnlockUser(ctx *gin.Context) {
+	userID, ok := c.managedUserID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.userService.UnlockUser(operator(ctx), userID); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "解除锁定成功",
+	})
+}
+
+// ListUserSessions 管理员查看用户的登录会话
+func (c *Controllers) ListUserSessions(ctx *gin.Context) {
+	userID, ok := c.managedUserID(ctx)
+	if !ok {
+		return
+	}
+
+	sessions, err := c.sessionService.ListSessions(userID, "")
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取会话列表成功",
+		"data":    sessions,
+	})
+}
+
+// ResetUserTwoFactor 重置用户的两步验证，用户下次登录时重新绑定
+func (c *Controllers) ResetUserTwoFactor(ctx *gin.Context) {
+	userID, ok := c.managedUserID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.userService.ResetTwoFactor(operator(ctx), userID); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "已重置两步验证",
+	})
+}
+
+// RevokeUserSessions 管理员注销用户的全部会话，强制其重新登录
+func (c *Controllers) RevokeUserSessions(ctx *gin.Context) {
+	userID, ok := c.managedUserID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.userService.RevokeUserSessions(operator(ctx), userID); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "注销用户会话成功",
+	})
+}
+
+// ResetUserPassword 管理员重置用户密码
+func (c *Controllers) ResetUserPassword(ctx *gin.Context) {
+	userID, ok := c.managedUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var req models.ResetPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.userService.ResetPassword(operator(ctx), userID, req.Password); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "重置密码成功",
+	})
+}
+
+// ChangeUserRole 修改用户角色
+func (c *Controllers) ChangeUserRole(ctx *gin.Context) {
+	userID, ok := c.managedUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var req models.UserRoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.userService.ChangeUserRole(operator(ctx), userID, req.Role); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "修改用户角色成功",
+	})
+}
+
+// managedUserID 解析路径中的用户ID并检查当前管理员能否管理该用户，失败时已写入响应
+func (c *Controllers) managedUserID(ctx *gin.Context) (int, bool) {
+	userID, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		return 0, false
+	}
+	if !c.canManageUser(ctx, userID) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "无权管理该用户"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// ListLoginAttempts 查询登录尝试记录，用于安全审查
+func (c *Controllers) ListLoginAttempts(ctx *gin.Context) {
+	filter := &models.LoginAttemptFilter{
+		Username: ctx.Query("username"),
+		IP:       ctx.Query("ip"),
+		Result:   ctx.Query("result"),
+	}
+	filter.Page, _ = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	filter.PageSize, _ = strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
+
+	if startDate := ctx.Query("start_date"); startDate != "" {
+		t, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "开始日期格式错误"})
+			return
+		}
+		filter.StartDate = t
+	}
+	if endDate := ctx.Query("end_date"); endDate != "" {
+		t, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "结束日期格式错误"})
+			return
+		}
+		filter.EndDate = t
+	}
+
+	attempts, total, err := c.securityService.ListLoginAttempts(filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取登录记录成功",
+		"data": gin.H{
+			"attempts":  attempts,
+			"total":     total,
+			"page":      filter.Page,
+			"page_size": filter.PageSize,
+		},
+	})
+}
+
+// ListLockedAccounts 获取当前被锁定的账号
+func (c *Controllers) ListLockedAccounts(ctx *gin.Context) {
+	accounts, err := c.securityService.ListLockedAccounts()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取锁定账号成功",
+		"data":    accounts,
+	})
+}
+
+// ListPermissions 获取全部权限
+func (c *Controllers) ListPermissions(ctx *gin.Context) {
+	permissions, err := c.permissionService.ListPermissions()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取权限列表成功",
+		"data":    permissions,
+	})
+}
+
+// ListRoles 获取角色列表
+func (c *Controllers) ListRoles(ctx *gin.Context) {
+	roles, err := c.permissionService.ListRoles()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取角色列表成功",
+		"data":    roles,
+	})
+}
+
+// GetRole 获取角色详情
+func (c *Controllers) GetRole(ctx *gin.Context) {
+	role, err := c.permissionService.GetRole(ctx.Param("code"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取角色成功",
+		"data":    role,
+	})
+}
+
+// CreateRole 创建自定义角色
+func (c *Controllers) CreateRole(ctx *gin.Context) {
+	var req models.RoleCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := c.permissionService.CreateRole(operator(ctx), &req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "创建角色成功",
+		"data":    role,
+	})
+}
+
+// UpdateRole 修改角色名称、说明和权限
+func (c *Controllers) UpdateRole(ctx *gin.Context) {
+	var req models.RoleUpdateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role, err := c.permissionService.UpdateRole(operator(ctx), ctx.Param("code"), &req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "修改角色成功",
+		"data":    role,
+	})
+}
+
+// DeleteRole 删除自定义角色
+func (c *Controllers) DeleteRole(ctx *gin.Context) {
+	if err := c.permissionService.DeleteRole(operator(ctx), ctx.Param("code")); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "删除角色成功",
+	})
+}
+
+// maxRosterFileSize 花名册文件大小上限
+const maxRosterFileSize = 5 << 20
+
+// ImportRoster 从CSV/XLSX花名册批量创建或更新人员
+func (c *Controllers) ImportRoster(ctx *gin.Context) {
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "请上传花名册文件"})
+		return
+	}
+	if fileHeader.Size > maxRosterFileSize {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "花名册文件不能超过5MB"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxRosterFileSize))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := c.rosterService.ParseRoster(fileHeader.Filename, data)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 科室管理员只能导入所属科室子树内的人员，站长不限科室
+	scope, ok := c.dataScope(ctx)
+	if !ok {
+		return
+	}
+	if !scope.Global && scope.DepartmentID == 0 {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": service.ErrOutOfScope.Error()})
+		return
+	}
+
+	result, err := c.rosterService.ImportRoster(operator(ctx), scope.DepartmentID, rows)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": fmt.Sprintf("导入完成：新建%d人，更新%d人，未变化%d人，失败%d人",
+			result.Created, result.Updated, result.Unchanged, result.Failed),
+		"data": result,
+	})
+}
+
+// DownloadRosterTemplate 下载花名册XLSX模板
+func (c *Controllers) DownloadRosterTemplate(ctx *gin.Context) {
+	setAttachment(ctx, "人员花名册模板.xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	if err := c.rosterService.WriteRosterTemplate(ctx.Writer); err != nil {
+		ctx.Error(err)
+	}
+}
+
+// ListAuditLogs 分页查询审计日志
+func (c *Controllers) ListAuditLogs(ctx *gin.Context) {
+	filter, err := parseAuditLogFilter(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.Page, _ = strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	filter.PageSize, _ = strconv.Atoi(ctx.DefaultQuery("page_size", "20"))
+
+	logs, total, err := c.auditService.ListAuditLogs(filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "获取审计日志成功",
+		"data": gin.H{
+			"logs":      logs,
+			"total":     total,
+			"page":      filter.Page,
+			"page_size": filter.PageSize,
+		},
+	})
+}
+
+// ExportAuditLogs 按查询条件导出CSV审计日志
+func (c *Controllers) ExportAuditLogs(ctx *gin.Context) {
+	filter, err := parseAuditLogFilter(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	logs, err := c.auditService.ExportAuditLogs(filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	setAttachment(ctx, fmt.Sprintf("审计日志_%s.csv", time.Now().Format("20060102150405")), "text/csv; charset=utf-8")
+	if err := c.auditService.WriteAuditLogsCSV(ctx.Writer, logs); err != nil {
+		ctx.Error(err)
+	}
+}
+
+// parseAuditLogFilter 解析审计日志的查询条件
+func parseAuditLogFilter(ctx *gin.Context) (*models.AuditLogFilter, error) {
+	filter := &models.AuditLogFilter{
+		ActorName:  ctx.Query("actor"),
+		IP:         ctx.Query("ip"),
+		Action:     ctx.Query("action"),
+		EntityType: ctx.Query("entity_type"),
+	}
+
+	if actorID := ctx.Query("actor_id"); actorID != "" {
+		id, err := strconv.Atoi(actorID)
+		if err != nil {
+			return nil, errors.New("无效的操作人ID")
+		}
+		filter.ActorID = id
+	}
+	if entityID := ctx.Query("entity_id"); entityID != "" {
+		id, err := strconv.Atoi(entityID)
+		if err != nil {
+			return nil, errors.New("无效的对象ID")
+		}
+		filter.EntityID = id
+	}
+
+	if startDate := ctx.Query("start_date"); startDate != "" {
+		t, err := time.ParseInLocation("2006-01-02", startDate, time.Local)
+		if err != nil {
+			return nil, errors.New("开始日期格式错误")
+		}
+		filter.StartDate = t
+	}
+	if endDate := ctx.Query("end_date"); endDate != "" {
+		t, err := time.ParseInLocation("2006-01-02", endDate, time.Local)
+		if err != nil {
+			return nil, errors.New("结束日期格式错误")
+		}
+		filter.EndDate = t
+	}
+	return filter, nil
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/internal/service"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+)
+
+var (
+	managerDeptQuery = regexp.QuoteMeta("SELECT COALESCE(department_id, 0) FROM users WHERE id = ?")
+	examDeptQuery    = regexp.QuoteMeta("SELECT COALESCE(department_id, 0) FROM exams WHERE id = ?")
+	subtreeQuery     = regexp.QuoteMeta("SELECT COUNT(*) FROM departments WHERE id = ?")
+	userInDeptQuery  = regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE id = ?")
+	recordQuery      = regexp.QuoteMeta("FROM exam_records WHERE id = ?")
+	answersQuery     = regexp.QuoteMeta("FROM exam_answers WHERE record_id = ?")
+	certificateQuery = regexp.QuoteMeta("FROM certificates WHERE id = ?")
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// mockDB 用sqlmock替换全局数据库连接，测试结束时检查预期的SQL是否全部执行
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := db.DB
+	db.DB = conn
+	t.Cleanup(func() {
+		db.DB = old
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+	return mock
+}
+
+func newScopeControllers() *Controllers {
+	departments := service.NewDepartmentService()
+	return &Controllers{
+		examService:       service.NewExamService(service.NewQuestionService()),
+		certService:       service.NewCertificateService(&config.Config{}),
+		departmentService: departments,
+		scopeService:      service.NewScopeService(departments),
+		proctorService:    service.NewProctoringService(),
+	}
+}
+
+// serve 以指定身份调用处理函数，permissions为经角色授予的权限
+func serve(handler gin.HandlerFunc, userID int, role string, permissions []string, id string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx.Params = gin.Params{{Key: "id", Value: id}}
+	granted := make(map[string]bool)
+	for _, p := range permissions {
+		granted[p] = true
+	}
+	ctx.Set("user_id", userID)
+	ctx.Set("role", role)
+	ctx.Set("permissions", permissions)
+	ctx.Set("granted_permissions", granted)
+	handler(ctx)
+	return w
+}
+
+func recordRows(id, userID int) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{"id", "exam_id", "user_id", "start_time", "end_time", "duration", "total_score", "status", "created_at", "updated_at"}).
+		AddRow(id, 9, userID, now, now, 600, 90, "graded", now, now)
+}
+
+func emptyAnswers() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "record_id", "question_id", "user_answer", "score", "is_correct", "created_at"})
+}
+
+func TestGetExamByIDOtherDepartment(t *testing.T) {
+	mock := mockDB(t)
+	c := newScopeControllers()
+
+	mock.ExpectQuery(managerDeptQuery).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(2))
+	mock.ExpectQuery(examDeptQuery).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(5))
+	mock.ExpectQuery(subtreeQuery).WithArgs(5, 2).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	mock.ExpectQuery(examDeptQuery).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(5))
+	mock.ExpectQuery(subtreeQuery).WithArgs(2, 5).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+
+	w := serve(c.GetExamByID, 7, models.RoleEmployee, nil, "9")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("查看其他科室的试卷应返回403, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestGetProctorPolicyOtherDepartment(t *testing.T) {
+	mock := mockDB(t)
+	c := newScopeControllers()
+
+	mock.ExpectQuery(managerDeptQuery).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(2))
+	mock.ExpectQuery(examDeptQuery).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(5))
+	mock.ExpectQuery(subtreeQuery).WithArgs(5, 2).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	mock.ExpectQuery(examDeptQuery).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(5))
+	mock.ExpectQuery(subtreeQuery).WithArgs(2, 5).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	if w := serve(c.GetProctorPolicy, 7, models.RoleEmployee, nil, "9"); w.Code != http.StatusForbidden {
+		t.Fatalf("查看其他科室试卷的防作弊设置应返回403, got %d %s", w.Code, w.Body.String())
+	}
+
+	// 所属科室的试卷可以查看
+	mock.ExpectQuery(managerDeptQuery).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(2))
+	mock.ExpectQuery(examDeptQuery).WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(2))
+	mock.ExpectQuery(subtreeQuery).WithArgs(2, 2).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT proctor_warn_threshold, proctor_flag_threshold, proctor_submit_threshold")).
+		WithArgs(9).WillReturnRows(sqlmock.NewRows([]string{"warn", "flag", "submit"}).AddRow(1, 3, 5))
+	if w := serve(c.GetProctorPolicy, 7, models.RoleEmployee, nil, "9"); w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestListExamsScoped(t *testing.T) {
+	mock := mockDB(t)
+	c := newScopeControllers()
+
+	mock.ExpectQuery(managerDeptQuery).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM exams WHERE status = 'published' AND ((department_id IS NULL OR")).
+		WithArgs(2, 2).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM exams WHERE status = 'published' AND ((department_id IS NULL OR")).
+		WithArgs(2, 2, 20, 0).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	w := serve(c.ListExams, 7, models.RoleEmployee, nil, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestGetExamRecordOtherUser(t *testing.T) {
+	mock := mockDB(t)
+	c := newScopeControllers()
+
+	// 普通考生查看他人的考试记录
+	mock.ExpectQuery(recordQuery).WithArgs(3).WillReturnRows(recordRows(3, 8))
+	mock.ExpectQuery(answersQuery).WithArgs(3).WillReturnRows(emptyAnswers())
+	if w := serve(c.GetExamRecord, 7, models.RoleEmployee, nil, "3"); w.Code != http.StatusForbidden {
+		t.Fatalf("查看他人的考试记录应返回403, got %d", w.Code)
+	}
+
+	// 有阅卷权限但考生不在所属科室
+	mock.ExpectQuery(recordQuery).WithArgs(3).WillReturnRows(recordRows(3, 8))
+	mock.ExpectQuery(answersQuery).WithArgs(3).WillReturnRows(emptyAnswers())
+	mock.ExpectQuery(managerDeptQuery).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(2))
+	mock.ExpectQuery(userInDeptQuery).WithArgs(8, 2).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	if w := serve(c.GetExamRecord, 7, models.RoleManager, []string{models.PermGradeReview}, "3"); w.Code != http.StatusForbidden {
+		t.Fatalf("查看其他科室考生的考试记录应返回403, got %d", w.Code)
+	}
+
+	// 本人可以查看
+	mock.ExpectQuery(recordQuery).WithArgs(3).WillReturnRows(recordRows(3, 7))
+	mock.ExpectQuery(answersQuery).WithArgs(3).WillReturnRows(emptyAnswers())
+	if w := serve(c.GetExamRecord, 7, models.RoleEmployee, nil, "3"); w.Code != http.StatusOK {
+		t.Fatalf("本人应可查看考试记录, got %d", w.Code)
+	}
+}
+
+func TestIssueCertificateOtherDepartment(t *testing.T) {
+	mock := mockDB(t)
+	c := newScopeControllers()
+
+	mock.ExpectQuery(recordQuery).WithArgs(3).WillReturnRows(recordRows(3, 8))
+	mock.ExpectQuery(answersQuery).WithArgs(3).WillReturnRows(emptyAnswers())
+	mock.ExpectQuery(managerDeptQuery).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(2))
+	mock.ExpectQuery(userInDeptQuery).WithArgs(8, 2).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+
+	w := serve(c.IssueCertificate, 7, models.RoleManager, []string{models.PermGradeReview}, "3")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("为其他科室考生签发证书应返回403, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestDownloadCertificateOtherDepartment(t *testing.T) {
+	mock := mockDB(t)
+	c := newScopeControllers()
+
+	certRows := func() *sqlmock.Rows {
+		now := time.Now()
+		return sqlmock.NewRows([]string{"id", "record_id", "user_id", "exam_id", "code", "user_name", "department", "exam_title", "score", "total_score", "issued_at", "created_at"}).
+			AddRow(4, 3, 8, 9, "ABCD1234", "张三", "外科", "院感考核", 90, 100, now, now)
+	}
+
+	mock.ExpectQuery(certificateQuery).WithArgs(4).WillReturnRows(certRows())
+	mock.ExpectQuery(managerDeptQuery).WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"d"}).AddRow(2))
+	mock.ExpectQuery(userInDeptQuery).WithArgs(8, 2).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	if w := serve(c.DownloadCertificatePDF, 7, models.RoleManager, []string{models.PermGradeReview}, "4"); w.Code != http.StatusForbidden {
+		t.Fatalf("下载其他科室考生的证书应返回403, got %d", w.Code)
+	}
+
+	// 没有阅卷权限时不查询数据范围
+	mock.ExpectQuery(certificateQuery).WithArgs(4).WillReturnRows(certRows())
+	if w := serve(c.DownloadCertificatePDF, 7, models.RoleEmployee, nil, "4"); w.Code != http.StatusForbidden {
+		t.Fatalf("下载他人的证书应返回403, got %d", w.Code)
+	}
+
+	mock.ExpectQuery(certificateQuery).WithArgs(4).WillReturnError(sql.ErrNoRows)
+	if w := serve(c.DownloadCertificatePDF, 7, models.RoleEmployee, nil, "4"); w.Code != http.StatusNotFound {
+		t.Fatalf("got %d", w.Code)
+	}
+}
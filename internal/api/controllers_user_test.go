@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/internal/service"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+)
+
+// serveJSON 以指定身份提交JSON请求体调用处理函数
+func serveJSON(handler gin.HandlerFunc, userID int, role string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(http.MethodPut, "/", strings.NewReader(body))
+	ctx.Request.Header.Set("Content-Type", "application/json")
+	ctx.Set("user_id", userID)
+	ctx.Set("role", role)
+	ctx.Set("granted_permissions", map[string]bool{})
+	handler(ctx)
+	return w
+}
+
+func userByIDRows(id, departmentID int, department, name string) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{"id", "username", "name", "gender", "email", "role", "phone", "id_card", "birth_date",
+		"department_id", "department", "job_title", "avatar", "status", "auth_source", "created_at", "updated_at"}).
+		AddRow(id, "zhangsan", name, "男", "", models.RoleManager, "", "", "", departmentID, department, "",
+			"", 1, models.AuthSourceLocal, now, now)
+}
+
+// 本人修改资料时忽略科室，科室决定数据范围，只能由管理员调整
+func TestUpdateUserCannotChangeDepartment(t *testing.T) {
+	mock := mockDB(t)
+	c := &Controllers{userService: service.NewUserService(&config.Config{}, nil)}
+	byID := regexp.QuoteMeta("FROM users WHERE id = ?")
+
+	mock.ExpectQuery(byID).WithArgs(7).WillReturnRows(userByIDRows(7, 2, "外科", "张三"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET")).
+		WithArgs("张医生", nil, "", "", true, nil, "", true, nil, true, nil, nil, nil, "", 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(byID).WithArgs(7).WillReturnRows(userByIDRows(7, 2, "外科", "张医生"))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs")).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	w := serveJSON(c.UpdateUser, 7, models.RoleManager, `{"name":"张医生","department_id":1,"department":"医院"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"department":"外科"`) {
+		t.Errorf("department changed: %s", w.Body.String())
+	}
+}
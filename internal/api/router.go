@@ -1,6 +1,8 @@
 package api
 
 import (
+	"html/template"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -15,24 +17,19 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 	// 创建Gin引擎
 	router := gin.Default()
 
+	// 只信任配置的反向代理转发的客户端IP，防止伪造X-Forwarded-For绕过按IP的登录限制
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Printf("可信代理配置无效: %v", err)
+	}
+
 	// 设置静态文件服务
 	router.Static("/static", "./static")
+	// 上传的头像等文件
+	router.Static(cfg.Storage.PublicURL, cfg.Storage.LocalDir)
 
 	// 设置模板引擎
 	router.LoadHTMLGlob("./templates/*")
 
-	// 调试：检查模板文件是否存在
-	// 注意：这行代码会在生产环境中产生日志，可以根据需要保留或删除
-	router.GET("/debug/templates", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"message": "模板文件加载配置",
-			"pattern": "./templates/*",
-		})
-	})
-
-	// 添加认证检查中间件，用于前端页面访问控制
-	router.Use(middleware.AuthCheck())
-
 	// 创建JWT中间件
 	jwtConfig := middleware.NewJWTConfig(cfg)
 
@@ -40,13 +37,42 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 	userService := service.NewUserService(cfg, jwtConfig)
 	questionService := service.NewQuestionService()
 	examService := service.NewExamService(questionService)
-	captchaService := service.NewCaptchaService()
+	captchaService := service.NewCaptchaService(cfg)
+	reportService := service.NewReportService()
+	certService := service.NewCertificateService(cfg)
+	cmeService := service.NewCMEService()
+	departmentService := service.NewDepartmentService()
+	rosterService := service.NewRosterService(userService, departmentService)
+	securityService := service.NewSecurityService(cfg)
+	sessionService := service.NewSessionService(cfg, jwtConfig)
+	permissionService := service.NewPermissionService()
+	twoFactorService := service.NewTwoFactorService(cfg)
+	scopeService := service.NewScopeService(departmentService)
+	oidcService := service.NewOIDCService(cfg, userService)
+	avatarService := service.NewAvatarService(cfg)
+	resetService := service.NewPasswordResetService(cfg, userService)
+	auditService := service.NewAuditService()
+	proctorService := service.NewProctoringService()
+
+	// 令牌必须属于未注销的会话
+	jwtConfig.Sessions = sessionService
+
+	// 统一认证中间件：页面和API都可以使用Cookie或Authorization头中的令牌
+	router.Use(middleware.Auth(jwtConfig, userService, permissionService))
+
+	// 调试：检查模板文件是否存在，需要系统调试权限
+	router.GET("/debug/templates", middleware.RequirePermission(models.PermSystemDebug), func(c *gin.Context) {
+		c.JSON(200, gin.H{
+			"message": "模板文件加载配置",
+			"pattern": "./templates/*",
+		})
+	})
 
 	// 创建控制器实例
-	controllers := NewControllers(userService, questionService, examService, captchaService)
+	controllers := NewControllers(userService, questionService, examService, captchaService, reportService, certService, cmeService, departmentService, rosterService, securityService, sessionService, permissionService, scopeService, twoFactorService, oidcService, avatarService, resetService, auditService, proctorService, jwtConfig)
 
-	// 健康检查路由 - 只有站长可以访问
-	router.GET("/health", middleware.RoleAuth("admin"), func(c *gin.Context) {
+	// 健康检查路由 - 需要系统调试权限
+	router.GET("/health", middleware.RequirePermission(models.PermSystemDebug), func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"status":  "ok",
 			"message": "基层三基考试系统 API 运行正常",
@@ -61,19 +87,44 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 		// 用户认证路由
 		public.POST("/register", controllers.Register)
 		public.POST("/login", controllers.Login)
+		public.POST("/login/2fa", controllers.LoginTwoFactor)
+		public.POST("/auth/refresh", controllers.RefreshToken)
+		// 自助找回密码：申请验证码、校验验证码、设置新密码
+		public.POST("/auth/password-reset/request", controllers.RequestPasswordReset)
+		public.POST("/auth/password-reset/verify", controllers.VerifyPasswordReset)
+		public.POST("/auth/password-reset/confirm", controllers.ConfirmPasswordReset)
+		// 统一身份认证（OIDC）登录，回调同时处理登录和绑定
+		public.GET("/auth/oidc/login", controllers.OIDCLogin)
+		public.GET("/auth/oidc/callback", controllers.OIDCCallback)
+		// 证书公开验证
+		public.GET("/certificates/verify/:code", controllers.VerifyCertificate)
 	}
 
 	// 受保护路由组
 	protected := router.Group("/api")
-	protected.Use(middleware.JWTAuth(jwtConfig))
+	protected.Use(middleware.RequireAuth())
 	{
 		// 用户相关路由
 		protected.GET("/user/me", controllers.GetCurrentUser)
 		protected.PUT("/user/me", controllers.UpdateUser)
-
-		// 调试路由组 - 只有站长可以访问
+		protected.PUT("/user/password", controllers.ChangePassword)
+		protected.POST("/user/avatar", controllers.UploadAvatar)
+		protected.DELETE("/user/avatar", controllers.ResetAvatar)
+		protected.POST("/logout", controllers.Logout)
+		protected.GET("/user/sessions", controllers.ListMySessions)
+		protected.DELETE("/user/sessions/:id", controllers.RevokeMySession)
+		protected.GET("/user/2fa", controllers.GetTwoFactorStatus)
+		protected.POST("/user/2fa/setup", controllers.SetupTwoFactor)
+		protected.POST("/user/2fa/enable", controllers.EnableTwoFactor)
+		protected.POST("/user/2fa/disable", controllers.DisableTwoFactor)
+		protected.POST("/user/2fa/recovery-codes", controllers.RegenerateRecoveryCodes)
+		protected.GET("/user/oidc/link", controllers.LinkOIDC)
+		protected.GET("/user/identities", controllers.ListMyIdentities)
+		protected.DELETE("/user/identities/:id", controllers.UnlinkMyIdentity)
+
+		// 调试路由组 - 需要系统调试权限
 		debug := protected.Group("/debug")
-		debug.Use(middleware.RoleAuth("admin"))
+		debug.Use(middleware.RequirePermission(models.PermSystemDebug))
 		{
 			debug.GET("/templates", func(c *gin.Context) {
 				c.JSON(200, gin.H{
@@ -83,18 +134,18 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 			})
 		}
 
-		// 题库相关路由（需要管理员权限）
+		// 题库相关路由（需要题库管理权限）
 		bank := protected.Group("/banks")
-		bank.Use(middleware.RoleAuth("admin", "manager"))
+		bank.Use(middleware.RequirePermission(models.PermQuestionEdit))
 		{
 			bank.POST("/", controllers.CreateQuestionBank)
 			bank.GET("/", controllers.ListQuestionBanks)
 			bank.GET("/:id", controllers.GetQuestionBankByID)
 		}
 
-		// 题目相关路由（需要管理员权限）
+		// 题目相关路由（需要题库管理权限）
 		question := protected.Group("/questions")
-		question.Use(middleware.RoleAuth("admin", "manager"))
+		question.Use(middleware.RequirePermission(models.PermQuestionEdit))
 		{
 			question.POST("/", controllers.CreateQuestion)
 			question.GET("/bank/:bank_id", controllers.ListQuestionsByBank)
@@ -104,8 +155,8 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 		// 试卷相关路由
 		exam := protected.Group("/exams")
 		{
-			// 生成试卷（需要管理员权限）
-			exam.POST("/generate", middleware.RoleAuth("admin", "manager"), controllers.GenerateExam)
+			// 生成试卷（需要组卷发布权限）
+			exam.POST("/generate", middleware.RequirePermission(models.PermExamPublish), controllers.GenerateExam)
 			// 获取试卷列表
 			exam.GET("/", controllers.ListExams)
 			// 获取试卷详情
@@ -114,6 +165,9 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 			exam.POST("/:id/start", controllers.StartExam)
 			// 提交试卷
 			exam.POST("/submit", controllers.SubmitExam)
+			// 防作弊违规次数阈值
+			exam.GET("/:id/proctoring", controllers.GetProctorPolicy)
+			exam.PUT("/:id/proctoring", middleware.RequirePermission(models.PermExamPublish), controllers.SetProctorPolicy)
 		}
 
 		// 考试记录相关路由
@@ -122,6 +176,16 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 			record.GET("/", controllers.ListExamRecords)
 			record.GET("/:id", controllers.GetExamRecord)
 			record.GET("/stats", controllers.GetExamStats)
+			record.POST("/:id/certificate", controllers.IssueCertificate)
+			// 考试中上报防作弊事件
+			record.POST("/:id/events", controllers.ReportProctorEvent)
+		}
+
+		// 合格证书相关路由
+		certificate := protected.Group("/certificates")
+		{
+			certificate.GET("/", controllers.ListMyCertificates)
+			certificate.GET("/:id/pdf", controllers.DownloadCertificatePDF)
 		}
 
 		// 模拟练习相关路由
@@ -133,6 +197,20 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 			practice.POST("/submit", controllers.SubmitPractice)
 		}
 
+		// 成绩档案相关路由
+		transcript := protected.Group("/transcript")
+		{
+			transcript.GET("/", controllers.GetMyTranscript)
+			transcript.GET("/pdf", controllers.DownloadMyTranscriptPDF)
+		}
+
+		// 继续医学教育学分相关路由
+		cme := protected.Group("/cme")
+		{
+			cme.GET("/ledger", controllers.GetMyCreditLedger)
+			cme.GET("/practice-goals", controllers.ListPracticeGoals)
+		}
+
 		// 错题本相关路由
 		wrong := protected.Group("/wrong-questions")
 		{
@@ -142,56 +220,82 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 			wrong.DELETE("/:id", controllers.RemoveWrongQuestion)
 		}
 
-		// 管理员功能路由组 - 只有管理员可以访问
+		// 管理员功能路由组 - 按权限访问
 		admin := protected.Group("/admin")
-		admin.Use(middleware.RoleAuth("admin", "manager"))
 		{
-			// 这里可以添加更多管理员功能
+			// 成绩导出
+			grade := admin.Group("", middleware.RequirePermission(models.PermGradeReview))
+			grade.GET("/exports/scores", controllers.ExportScoreSheet)
+			grade.GET("/exports/exams/:id/summary", controllers.ExportExamSummaryPDF)
+
+			// 员工成绩档案
+			grade.GET("/users/:id/transcript", controllers.GetUserTranscript)
+			grade.GET("/users/:id/transcript/pdf", controllers.DownloadUserTranscriptPDF)
+			grade.GET("/departments/:id/stats", controllers.GetDepartmentStats)
+
+			// 防作弊：可疑考试记录和违规事件
+			grade.GET("/proctoring/flagged", controllers.ListFlaggedRecords)
+			grade.GET("/records/:id/proctoring-events", controllers.ListProctorEvents)
+
+			// 用户管理
+			users := admin.Group("/users", middleware.RequirePermission(models.PermUserManage))
+			users.GET("", controllers.ListUsers)
+			users.POST("", controllers.CreateUser)
+			users.POST("/import", controllers.ImportRoster)
+			users.GET("/import/template", controllers.DownloadRosterTemplate)
+			users.GET("/:id", controllers.GetUser)
+			users.PUT("/:id", controllers.AdminUpdateUser)
+			users.PUT("/:id/status", controllers.SetUserStatus)
+			users.PUT("/:id/password", controllers.ResetUserPassword)
+			users.POST("/:id/unlock", controllers.UnlockUser)
+			users.GET("/:id/sessions", controllers.ListUserSessions)
+			users.DELETE("/:id/sessions", controllers.RevokeUserSessions)
+			users.DELETE("/:id/2fa", controllers.ResetUserTwoFactor)
+			admin.PUT("/users/:id/role", middleware.RequirePermission(models.PermRoleAssign), controllers.ChangeUserRole)
+
+			// 继续医学教育学分
+			cme := admin.Group("", middleware.RequirePermission(models.PermCMEManage))
+			cme.PUT("/exams/:id/cme-credits", controllers.SetExamCredits)
+			cme.POST("/cme/practice-goals", controllers.CreatePracticeGoal)
+			cme.GET("/cme/practice-goals", controllers.ListPracticeGoals)
+			cme.PUT("/cme/targets", controllers.SetCreditTarget)
+			cme.GET("/cme/targets", controllers.ListCreditTargets)
+			cme.GET("/cme/shortfalls", controllers.ListCreditShortfalls)
+			cme.GET("/users/:id/cme/ledger", controllers.GetUserCreditLedger)
+
+			// 科室管理
+			admin.GET("/departments", middleware.RequirePermission(models.PermDepartmentView), controllers.ListDepartments)
+			admin.GET("/departments/tree", middleware.RequirePermission(models.PermDepartmentView), controllers.GetDepartmentTree)
+			admin.GET("/departments/:id", middleware.RequirePermission(models.PermDepartmentView), controllers.GetDepartment)
+			admin.POST("/departments", middleware.RequirePermission(models.PermDepartmentManage), controllers.CreateDepartment)
+			admin.PUT("/departments/:id", middleware.RequirePermission(models.PermDepartmentManage), controllers.UpdateDepartment)
+			admin.DELETE("/departments/:id", middleware.RequirePermission(models.PermDepartmentManage), controllers.DeleteDepartment)
+
+			// 登录安全审查
+			security := admin.Group("/security", middleware.RequirePermission(models.PermSecurityAudit))
+			security.GET("/login-attempts", controllers.ListLoginAttempts)
+			security.GET("/locked-accounts", controllers.ListLockedAccounts)
+
+			// 审计日志
+			audit := admin.Group("/audit-logs", middleware.RequirePermission(models.PermAuditView))
+			audit.GET("", controllers.ListAuditLogs)
+			audit.GET("/export", controllers.ExportAuditLogs)
+
+			// 角色和权限
+			roles := admin.Group("", middleware.RequirePermission(models.PermRoleManage))
+			roles.GET("/permissions", controllers.ListPermissions)
+			roles.GET("/roles", controllers.ListRoles)
+			roles.POST("/roles", controllers.CreateRole)
+			roles.GET("/roles/:code", controllers.GetRole)
+			roles.PUT("/roles/:code", controllers.UpdateRole)
+			roles.DELETE("/roles/:code", controllers.DeleteRole)
 		}
 	}
 
 	// 前端页面路由
-	// 首页 - 登录成功后显示
-	router.GET("/", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err == nil && token != "" {
-			// 解析token获取用户ID
-			claims, err := jwtConfig.ParseToken(token)
-			if err == nil {
-				// 获取当前用户信息
-				user, err := userService.GetUserByID(claims.UserID)
-				if err == nil {
-					if user.Name != "" {
-						userName = user.Name
-					} else {
-						userName = user.Username
-					}
-					userAvatar = user.Avatar
-				}
-			}
-		}
-		c.HTML(200, "index.html", gin.H{
-			"title":      "基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": userAvatar,
-			"stats": gin.H{
-				"totalExams":    0,
-				"avgScore":      0,
-				"passedExams":   0,
-				"upcomingExams": 0,
-			},
-			"recentExams": []interface{}{},
-		})
-	})
-
 	// 登录页面
 	router.GET("/login", func(c *gin.Context) {
-		c.HTML(200, "login.html", gin.H{
-			"title": "登录 - 基层三基考试系统",
-		})
+		controllers.renderLogin(c, http.StatusOK, gin.H{})
 	})
 
 	// 注册页面
@@ -201,141 +305,98 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 		})
 	})
 
+	// 找回密码页面，未开启邮件和短信发送时跳转到登录页
+	router.GET("/forgot-password", func(c *gin.Context) {
+		channels := resetService.Channels()
+		if len(channels) == 0 {
+			c.Redirect(http.StatusFound, "/login")
+			return
+		}
+		c.HTML(200, "forgot-password.html", gin.H{
+			"title":    "找回密码 - 基层三基考试系统",
+			"channels": channels,
+		})
+	})
+
+	// 证书验证页面（公开）
+	router.GET("/certificates/verify/:code", func(c *gin.Context) {
+		code := c.Param("code")
+		cert, err := certService.GetCertificateByCode(code)
+		c.HTML(200, "certificate_verify.html", gin.H{
+			"title":       "证书验证 - 基层三基考试系统",
+			"code":        code,
+			"valid":       err == nil,
+			"certificate": cert,
+		})
+	})
+
 	// 登出路由
 	router.GET("/logout", func(c *gin.Context) {
+		// 注销服务端会话，访问令牌已过期时通过刷新令牌找到会话
+		if sessionID := c.GetString("session_id"); sessionID != "" {
+			sessionService.RevokeSessionByID(sessionID)
+		}
+		if refreshToken, err := c.Cookie(middleware.RefreshTokenCookie); err == nil && refreshToken != "" {
+			sessionService.RevokeRefreshToken(refreshToken)
+		}
 		// 清除Cookie
-		c.SetCookie("token", "", -1, "/", "", false, true)
+		jwtConfig.ClearAuthCookies(c)
 		// 重定向到登录页面
 		c.Redirect(http.StatusFound, "/login")
 	})
 
+	// 需要登录的页面
+	pages := router.Group("/")
+	pages.Use(middleware.RequireAuth())
+
+	// 首页 - 登录成功后显示
+	pages.GET("/", func(c *gin.Context) {
+		data := pageData(c, "基层三基考试系统")
+		data["stats"] = gin.H{
+			"totalExams":    0,
+			"avgScore":      0,
+			"passedExams":   0,
+			"upcomingExams": 0,
+		}
+		data["recentExams"] = []interface{}{}
+		c.HTML(200, "index.html", data)
+	})
+
 	// 考试记录页面
-	router.GET("/records", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err == nil && token != "" {
-			// 解析token获取用户ID
-			claims, err := jwtConfig.ParseToken(token)
-			if err == nil {
-				// 获取当前用户信息
-				user, err := userService.GetUserByID(claims.UserID)
-				if err == nil {
-					if user.Name != "" {
-						userName = user.Name
-					} else {
-						userName = user.Username
-					}
-					userAvatar = user.Avatar
-				}
-			}
-		}
-		c.HTML(200, "index.html", gin.H{
-			"title":      "考试记录 - 基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": userAvatar,
-		})
+	pages.GET("/records", func(c *gin.Context) {
+		c.HTML(200, "index.html", pageData(c, "考试记录 - 基层三基考试系统"))
 	})
 
 	// 个人中心页面
-	router.GET("/profile", func(c *gin.Context) {
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err != nil || token == "" {
-			c.Redirect(http.StatusFound, "/login")
-			return
-		}
-
-		// 解析token获取用户ID
-		claims, err := jwtConfig.ParseToken(token)
-		if err != nil {
-			c.Redirect(http.StatusFound, "/login")
-			return
-		}
-
-		// 获取当前用户信息
-		user, err := userService.GetUserByID(claims.UserID)
-		if err != nil {
-			c.HTML(200, "profile.html", gin.H{
-				"title":    "个人中心 - 基层三基考试系统",
-				"error":    "获取用户信息失败",
-				"user":     models.User{},
-				"userName": "",
-			})
-			return
-		}
-
-		userName := ""
-		if user.Name != "" {
-			userName = user.Name
-		} else {
-			userName = user.Username
-		}
-
-		c.HTML(200, "profile.html", gin.H{
-			"title":      "个人中心 - 基层三基考试系统",
-			"user":       user,
-			"userName":   userName,
-			"userAvatar": user.Avatar,
-		})
+	pages.GET("/profile", func(c *gin.Context) {
+		data := pageData(c, "个人中心 - 基层三基考试系统")
+		data["user"] = maskedUser(c, currentUser(c))
+		data["oidcEnabled"] = oidcService.Enabled()
+		data["oidcName"] = oidcService.DisplayName()
+		switch c.Query("identity") {
+		case "linked":
+			data["success"] = "统一身份认证账号绑定成功"
+		case "taken":
+			data["error"] = "该统一身份认证账号已绑定其他用户"
+		case "failed":
+			data["error"] = "统一身份认证账号绑定失败，请重试"
+		}
+		if oidcService.Enabled() {
+			identities, _ := oidcService.ListIdentities(c.GetInt("user_id"))
+			data["identities"] = identities
+		}
+		c.HTML(200, "profile.html", data)
 	})
 
 	// 修改密码页面
-	router.GET("/change-password", func(c *gin.Context) {
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err != nil || token == "" {
-			c.Redirect(http.StatusFound, "/login")
-			return
-		}
-
-		// 解析token获取用户ID
-		claims, err := jwtConfig.ParseToken(token)
-		if err != nil {
-			c.Redirect(http.StatusFound, "/login")
-			return
-		}
-
-		// 获取当前用户信息
-		user, err := userService.GetUserByID(claims.UserID)
-		if err != nil {
-			c.HTML(200, "change-password.html", gin.H{
-				"title":    "修改密码 - 基层三基考试系统",
-				"userName": "",
-			})
-			return
-		}
-
-		userName := ""
-		if user.Name != "" {
-			userName = user.Name
-		} else {
-			userName = user.Username
-		}
-
-		c.HTML(200, "change-password.html", gin.H{
-			"title":      "修改密码 - 基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": user.Avatar,
-		})
+	pages.GET("/change-password", func(c *gin.Context) {
+		c.HTML(200, "change-password.html", pageData(c, "修改密码 - 基层三基考试系统"))
 	})
 
 	// 处理修改密码请求
-	router.POST("/change-password", func(c *gin.Context) {
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err != nil || token == "" {
-			c.Redirect(http.StatusFound, "/login")
-			return
-		}
-
-		// 解析token获取用户ID
-		claims, err := jwtConfig.ParseToken(token)
-		if err != nil {
-			c.Redirect(http.StatusFound, "/login")
-			return
-		}
+	pages.POST("/change-password", func(c *gin.Context) {
+		data := pageData(c, "修改密码 - 基层三基考试系统")
+		userID := c.GetInt("user_id")
 
 		// 获取表单数据
 		currentPassword := c.PostForm("currentPassword")
@@ -344,215 +405,132 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 
 		// 验证新密码和确认密码是否一致
 		if newPassword != confirmPassword {
-			// 获取当前用户信息
-			user, _ := userService.GetUserByID(claims.UserID)
-			userName := ""
-			if user != nil && user.Name != "" {
-				userName = user.Name
-			} else if user != nil {
-				userName = user.Username
-			}
-
-			c.HTML(200, "change-password.html", gin.H{
-				"title":      "修改密码 - 基层三基考试系统",
-				"userName":   userName,
-				"userAvatar": user.Avatar,
-				"message":    "新密码和确认密码不一致",
-				"isSuccess":  false,
-			})
+			data["message"] = "新密码和确认密码不一致"
+			data["isSuccess"] = false
+			c.HTML(200, "change-password.html", data)
 			return
 		}
 
 		// 修改密码
-		err = userService.ChangePassword(claims.UserID, currentPassword, newPassword)
-		if err != nil {
-			// 获取当前用户信息
-			user, _ := userService.GetUserByID(claims.UserID)
-			userName := ""
-			if user != nil && user.Name != "" {
-				userName = user.Name
-			} else if user != nil {
-				userName = user.Username
-			}
-
-			c.HTML(200, "change-password.html", gin.H{
-				"title":      "修改密码 - 基层三基考试系统",
-				"userName":   userName,
-				"userAvatar": user.Avatar,
-				"message":    err.Error(),
-				"isSuccess":  false,
-			})
+		if err := userService.ChangePassword(operator(c), currentPassword, newPassword); err != nil {
+			data["message"] = err.Error()
+			data["isSuccess"] = false
+			c.HTML(200, "change-password.html", data)
 			return
 		}
 
-		// 获取当前用户信息
-		user, _ := userService.GetUserByID(claims.UserID)
-		userName := ""
-		if user != nil && user.Name != "" {
-			userName = user.Name
-		} else if user != nil {
-			userName = user.Username
+		// 修改密码后替换受限令牌，并注销其他会话；必须启用两步验证但尚未绑定时转到绑定页面
+		var newToken, refreshToken string
+		scope, err := twoFactorService.SetupScope(userID)
+		if err == nil {
+			newToken, refreshToken, err = sessionService.ReissueSession(c.GetString("session_id"), userID, scope)
+		}
+		if err == nil {
+			jwtConfig.SetAuthCookies(c, newToken, refreshToken)
+		}
+		if err == nil && scope == middleware.ScopeTwoFactorSetup {
+			c.Redirect(http.StatusFound, "/two-factor")
+			return
 		}
 
-		c.HTML(200, "change-password.html", gin.H{
-			"title":      "修改密码 - 基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": user.Avatar,
-			"message":    "密码修改成功",
-			"isSuccess":  true,
-		})
+		data["message"] = "密码修改成功"
+		data["isSuccess"] = true
+		data["token"] = newToken
+		c.HTML(200, "change-password.html", data)
+	})
+
+	// 两步验证页面
+	pages.GET("/two-factor", func(c *gin.Context) {
+		c.HTML(200, "two_factor.html", pageData(c, "两步验证 - 基层三基考试系统"))
 	})
 
 	// 模拟练习页面
-	router.GET("/practice", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err == nil && token != "" {
-			// 解析token获取用户ID
-			claims, err := jwtConfig.ParseToken(token)
-			if err == nil {
-				// 获取当前用户信息
-				user, err := userService.GetUserByID(claims.UserID)
-				if err == nil {
-					if user.Name != "" {
-						userName = user.Name
-					} else {
-						userName = user.Username
-					}
-					userAvatar = user.Avatar
-				}
-			}
+	pages.GET("/practice", func(c *gin.Context) {
+		c.HTML(200, "index.html", pageData(c, "模拟练习 - 基层三基考试系统"))
+	})
+
+	// 成绩档案页面
+	pages.GET("/transcript", func(c *gin.Context) {
+		years, err := examService.GetTranscript(c.GetInt("user_id"))
+		if err != nil {
+			years = []models.TranscriptYear{}
 		}
-		c.HTML(200, "index.html", gin.H{
-			"title":      "模拟练习 - 基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": userAvatar,
-		})
+
+		data := pageData(c, "成绩档案 - 基层三基考试系统")
+		data["user"] = maskedUser(c, currentUser(c))
+		data["years"] = years
+		c.HTML(200, "transcript.html", data)
 	})
 
 	// 错题本页面
-	router.GET("/wrong-questions", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err == nil && token != "" {
-			// 解析token获取用户ID
-			claims, err := jwtConfig.ParseToken(token)
-			if err == nil {
-				// 获取当前用户信息
-				user, err := userService.GetUserByID(claims.UserID)
-				if err == nil {
-					if user.Name != "" {
-						userName = user.Name
-					} else {
-						userName = user.Username
-					}
-					userAvatar = user.Avatar
-				}
-			}
-		}
-		c.HTML(200, "index.html", gin.H{
-			"title":      "错题本 - 基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": userAvatar,
-		})
+	pages.GET("/wrong-questions", func(c *gin.Context) {
+		c.HTML(200, "index.html", pageData(c, "错题本 - 基层三基考试系统"))
 	})
 
 	// 管理员后台页面
-	router.GET("/admin", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err == nil && token != "" {
-			// 解析token获取用户ID
-			claims, err := jwtConfig.ParseToken(token)
-			if err == nil {
-				// 获取当前用户信息
-				user, err := userService.GetUserByID(claims.UserID)
-				if err == nil {
-					if user.Name != "" {
-						userName = user.Name
-					} else {
-						userName = user.Username
-					}
-					userAvatar = user.Avatar
-				}
-			}
-		}
-		c.HTML(200, "index.html", gin.H{
-			"title":      "管理员后台 - 基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": userAvatar,
-		})
+	pages.GET("/admin", middleware.RequirePermission(models.PermUserManage, models.PermGradeReview,
+		models.PermQuestionEdit, models.PermExamPublish), func(c *gin.Context) {
+		c.HTML(200, "index.html", pageData(c, "管理员后台 - 基层三基考试系统"))
 	})
 
-	// 管理员用户列表页面
-	router.GET("/admin/users", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err == nil && token != "" {
-			// 解析token获取用户ID
-			claims, err := jwtConfig.ParseToken(token)
-			if err == nil {
-				// 获取当前用户信息
-				user, err := userService.GetUserByID(claims.UserID)
-				if err == nil {
-					if user.Name != "" {
-						userName = user.Name
-					} else {
-						userName = user.Username
-					}
-					userAvatar = user.Avatar
-				}
-			}
-		}
+	// 管理员用户列表页面，需要人员管理权限
+	pages.GET("/admin/users", middleware.RequirePermission(models.PermUserManage), func(c *gin.Context) {
 		// 获取用户列表数据
-		users := []models.User{}
-		// 模拟分页数据
-		currentPage := 1
-		totalPages := 1
-		pages := []int{1}
-		c.HTML(200, "admin_users.html", gin.H{
-			"title":       "用户管理 - 管理员后台",
-			"userName":    userName,
-			"userAvatar":  userAvatar,
-			"users":       users,
-			"currentPage": currentPage,
-			"totalPages":  totalPages,
-			"pages":       pages,
-		})
+		filter, err := controllers.parseUserListFilter(c)
+		if err != nil {
+			filter = &models.UserListFilter{Page: 1, PageSize: 20}
+		}
+		users, total, err := userService.ListUsers(filter)
+		if err != nil {
+			users = []models.User{}
+		}
+		departments, _ := departmentService.ListDepartments()
+		roles, err := permissionService.ListRoles()
+		if err != nil {
+			roles = []models.Role{}
+		}
+		roleNames := make(map[string]string, len(roles))
+		for _, role := range roles {
+			roleNames[role.Code] = role.Name
+		}
+
+		// 分页数据
+		totalPages := (total + filter.PageSize - 1) / filter.PageSize
+		if totalPages < 1 {
+			totalPages = 1
+		}
+		pages := make([]int, totalPages)
+		for i := range pages {
+			pages[i] = i + 1
+		}
+
+		query := c.Request.URL.Query()
+		query.Del("page")
+		data := pageData(c, "用户管理 - 管理员后台")
+		data["isAdmin"] = c.GetString("role") == models.RoleAdmin
+		data["roles"] = roles
+		data["roleNames"] = roleNames
+		data["users"] = maskedUsers(c, users)
+		data["total"] = total
+		data["departments"] = departments
+		data["keyword"] = filter.Keyword
+		data["role"] = filter.Role
+		data["status"] = c.Query("status")
+		data["departmentID"] = filter.DepartmentID
+		data["query"] = template.URL(query.Encode())
+		data["currentPage"] = filter.Page
+		data["prevPage"] = filter.Page - 1
+		data["nextPage"] = filter.Page + 1
+		data["totalPages"] = totalPages
+		data["pages"] = pages
+		c.HTML(200, "admin_users.html", data)
 	})
 
-	// 考试统计页面
-	router.GET("/stats", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err == nil && token != "" {
-			// 解析token获取用户ID
-			claims, err := jwtConfig.ParseToken(token)
-			if err == nil {
-				// 获取当前用户信息
-				user, err := userService.GetUserByID(claims.UserID)
-				if err == nil {
-					if user.Name != "" {
-						userName = user.Name
-					} else {
-						userName = user.Username
-					}
-					userAvatar = user.Avatar
-				}
-			}
-		}
+	// 考试统计页面，需要成绩查看权限
+	pages.GET("/stats", middleware.RequirePermission(models.PermGradeReview), func(c *gin.Context) {
+		data := pageData(c, "考试统计 - 基层三基考试系统")
 		// 获取考试统计数据
-		stats := gin.H{
+		data["stats"] = gin.H{
 			"totalParticipants": 100,
 			"avgScore":          75.5,
 			"maxScore":          98,
@@ -560,191 +538,77 @@ func SetupRouter(cfg *config.Config) *gin.Engine {
 			"passRate":          85,
 			"excellentRate":     25,
 		}
-		c.HTML(200, "stats.html", gin.H{
-			"title":      "考试统计 - 基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": userAvatar,
-			"stats":      stats,
-		})
+		c.HTML(200, "stats.html", data)
 	})
 
 	// 我的考试页面
-	router.GET("/exams", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err != nil || token == "" {
-			c.Redirect(http.StatusFound, "/login")
-			return
-		}
-
-		// 解析token获取用户ID
-		claims, err := jwtConfig.ParseToken(token)
-		if err != nil {
-			c.Redirect(http.StatusFound, "/login")
-			return
-		}
-
-		// 获取当前用户信息
-		user, err := userService.GetUserByID(claims.UserID)
-		if err != nil {
-			c.HTML(200, "exams.html", gin.H{
-				"title":         "我的考试 - 基层三基考试系统",
-				"userName":      "",
-				"userAvatar":    "",
-				"upcomingExams": []models.Exam{},
-				"pastExams":     []models.ExamRecord{},
-			})
-			return
-		}
-
-		if user.Name != "" {
-			userName = user.Name
-		} else {
-			userName = user.Username
-		}
-		userAvatar = user.Avatar
-
+	pages.GET("/exams", func(c *gin.Context) {
 		// 获取待参加考试 - 实际项目中，这里会调用examService获取即将开始的考试
-		// 现在获取所有已发布的考试
+		// 现在获取数据范围内所有已发布的考试
 		upcomingExams := []models.Exam{}
-		exams, _, err := examService.ListExams(1, 100)
-		if err == nil {
-			upcomingExams = exams
+		if scope, err := scopeService.Resolve(operator(c)); err == nil {
+			if exams, _, err := examService.ListExams(scope, 1, 100); err == nil {
+				upcomingExams = exams
+			}
 		}
 
 		// 获取已参加考试
 		pastExams := []models.ExamRecord{}
-		records, _, err := examService.ListExamRecords(claims.UserID, 1, 100)
+		records, _, err := examService.ListExamRecords(c.GetInt("user_id"), 1, 100)
 		if err == nil {
 			pastExams = records
 		}
 
-		c.HTML(200, "exams.html", gin.H{
-			"title":         "我的考试 - 基层三基考试系统",
-			"userName":      userName,
-			"userAvatar":    userAvatar,
-			"upcomingExams": upcomingExams,
-			"pastExams":     pastExams,
-		})
+		data := pageData(c, "我的考试 - 基层三基考试系统")
+		data["upcomingExams"] = upcomingExams
+		data["pastExams"] = pastExams
+		c.HTML(200, "exams.html", data)
 	})
 
 	// 考试详情页面
-	router.GET("/exam/:id/details", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err == nil && token != "" {
-			// 解析token获取用户ID
-			claims, err := jwtConfig.ParseToken(token)
-			if err == nil {
-				// 获取当前用户信息
-				user, err := userService.GetUserByID(claims.UserID)
-				if err == nil {
-					if user.Name != "" {
-						userName = user.Name
-					} else {
-						userName = user.Username
-					}
-					userAvatar = user.Avatar
-				}
-			}
-		}
-		c.HTML(200, "index.html", gin.H{
-			"title":      "考试详情 - 基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": userAvatar,
-		})
+	pages.GET("/exam/:id/details", func(c *gin.Context) {
+		c.HTML(200, "index.html", pageData(c, "考试详情 - 基层三基考试系统"))
 	})
 
 	// 开始考试页面
-	router.GET("/exam/:id/start", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err == nil && token != "" {
-			// 解析token获取用户ID
-			claims, err := jwtConfig.ParseToken(token)
-			if err == nil {
-				// 获取当前用户信息
-				user, err := userService.GetUserByID(claims.UserID)
-				if err == nil {
-					if user.Name != "" {
-						userName = user.Name
-					} else {
-						userName = user.Username
-					}
-					userAvatar = user.Avatar
-				}
-			}
-		}
-		c.HTML(200, "index.html", gin.H{
-			"title":      "开始考试 - 基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": userAvatar,
-		})
+	pages.GET("/exam/:id/start", func(c *gin.Context) {
+		c.HTML(200, "index.html", pageData(c, "开始考试 - 基层三基考试系统"))
 	})
 
 	// 考试结果页面
-	router.GET("/record/:id", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err == nil && token != "" {
-			// 解析token获取用户ID
-			claims, err := jwtConfig.ParseToken(token)
-			if err == nil {
-				// 获取当前用户信息
-				user, err := userService.GetUserByID(claims.UserID)
-				if err == nil {
-					if user.Name != "" {
-						userName = user.Name
-					} else {
-						userName = user.Username
-					}
-					userAvatar = user.Avatar
-				}
-			}
-		}
-		c.HTML(200, "index.html", gin.H{
-			"title":      "考试结果 - 基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": userAvatar,
-		})
+	pages.GET("/record/:id", func(c *gin.Context) {
+		c.HTML(200, "index.html", pageData(c, "考试结果 - 基层三基考试系统"))
 	})
 
 	// 试卷查看页面
-	router.GET("/exam/:id/paper", func(c *gin.Context) {
-		userName := ""
-		userAvatar := ""
-		// 从cookie中获取token
-		token, err := c.Cookie("token")
-		if err == nil && token != "" {
-			// 解析token获取用户ID
-			claims, err := jwtConfig.ParseToken(token)
-			if err == nil {
-				// 获取当前用户信息
-				user, err := userService.GetUserByID(claims.UserID)
-				if err == nil {
-					if user.Name != "" {
-						userName = user.Name
-					} else {
-						userName = user.Username
-					}
-					userAvatar = user.Avatar
-				}
-			}
-		}
-		c.HTML(200, "index.html", gin.H{
-			"title":      "查看试卷 - 基层三基考试系统",
-			"userName":   userName,
-			"userAvatar": userAvatar,
-		})
+	pages.GET("/exam/:id/paper", func(c *gin.Context) {
+		c.HTML(200, "index.html", pageData(c, "查看试卷 - 基层三基考试系统"))
 	})
 
 	return router
 }
+
+// currentUser 获取认证中间件加载的当前用户
+func currentUser(c *gin.Context) *models.User {
+	user, _ := c.Get("user")
+	u, _ := user.(*models.User)
+	return u
+}
+
+// pageData 页面公共数据：标题、当前用户姓名和头像
+func pageData(c *gin.Context, title string) gin.H {
+	data := gin.H{
+		"title":      title,
+		"userName":   "",
+		"userAvatar": "",
+	}
+	if user := currentUser(c); user != nil {
+		if user.Name != "" {
+			data["userName"] = user.Name
+		} else {
+			data["userName"] = user.Username
+		}
+		data["userAvatar"] = service.AvatarThumbnail(user.Avatar)
+	}
+	return data
+}
@@ -5,60 +5,253 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/hangbin2008/sanjicms/internal/models"
 )
 
-// AuthCheck 检查用户是否已登录，用于前端页面访问控制
-func AuthCheck() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// 允许访问的公共页面
-		publicPages := map[string]bool{
-			"/login":    true,
-			"/register": true,
-		}
+// UserLoader 按ID加载用户，由服务层实现
+type UserLoader interface {
+	GetUserByID(userID int) (*models.User, error)
+}
 
-		// 允许访问的调试页面，只有站长可以访问
-		debugPages := map[string]bool{
-			"/debug/templates": true,
-			"/health":          true,
-		}
+// PermissionLoader 按角色加载权限编码，由服务层实现
+type PermissionLoader interface {
+	RolePermissions(role string) ([]string, error)
+}
 
+// Auth 统一认证中间件：从Authorization头或Cookie读取访问令牌，校验签名、有效期和会话，
+// 加载用户、检查状态并加载角色权限后写入上下文。未携带令牌的请求直接放行，由RequireAuth决定是否必须登录
+func Auth(jwtConfig *JWTConfig, users UserLoader, permissions PermissionLoader) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// 静态资源无需认证
 		path := c.Request.URL.Path
-
-		// 检查是否是API请求，API请求由专门的JWT中间件处理
-		if strings.HasPrefix(path, "/api/") {
+		if strings.HasPrefix(path, "/static/") || path == "/favicon.ico" {
 			c.Next()
 			return
 		}
 
-		// 检查是否是静态资源请求
-		if strings.HasPrefix(path, "/static/") || strings.HasPrefix(path, "/favicon.ico") {
+		claims, err := authenticateRequest(c, jwtConfig)
+		if err != "" {
+			unauthorized(c, err)
+			return
+		}
+		if claims == nil {
 			c.Next()
 			return
 		}
 
-		// 检查是否是公共页面
-		if publicPages[path] {
-			c.Next()
+		// 以数据库中的用户状态和角色为准
+		user, loadErr := users.GetUserByID(claims.UserID)
+		if loadErr != nil {
+			jwtConfig.ClearAuthCookies(c)
+			unauthorized(c, "用户不存在")
+			return
+		}
+		if user.Status == 0 {
+			jwtConfig.ClearAuthCookies(c)
+			unauthorized(c, "用户已被禁用")
 			return
 		}
 
-		// 非公共页面，检查登录状态
-		token, err := c.Cookie("token")
-		if err != nil || token == "" {
-			// 未登录，重定向到登录页面
-			c.Redirect(http.StatusFound, "/login")
+		// 受限令牌只能修改密码或绑定两步验证
+		if rule, ok := restrictedScopes[claims.Scope]; ok && !rule.allows(c) {
+			if isPageRequest(c) {
+				c.Redirect(http.StatusFound, rule.page)
+				c.Abort()
+				return
+			}
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": rule.message,
+				"code":  rule.code,
+			})
 			c.Abort()
 			return
 		}
 
-		// 检查是否是调试页面
-		if debugPages[path] {
-			// 这里需要更严格的验证，确保只有站长可以访问调试页面
-			// 简化处理，实际应该解析JWT并验证角色
-			// c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
-			// return
+		codes, loadErr := permissions.RolePermissions(user.Role)
+		if loadErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": loadErr.Error()})
+			c.Abort()
+			return
 		}
+		granted := make(map[string]bool, len(codes))
+		for _, code := range codes {
+			granted[code] = true
+		}
+
+		// 将用户信息存储到上下文
+		c.Set("user", user)
+		c.Set("user_id", user.ID)
+		c.Set("username", user.Username)
+		c.Set("role", user.Role)
+		c.Set("scope", claims.Scope)
+		c.Set("session_id", claims.SessionID)
+		c.Set("permissions", codes)
+		c.Set(grantedKey, granted)
+
+		c.Next()
+	}
+}
 
+// RequireAuth 要求已登录，需在Auth之后使用；页面请求未登录时跳转登录页
+func RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get("user_id"); !exists {
+			unauthorized(c, "未登录")
+			return
+		}
 		c.Next()
 	}
 }
+
+// grantedKey 上下文中保存权限集合的键
+const grantedKey = "granted_permissions"
+
+// HasPermission 判断当前用户是否拥有指定权限
+func HasPermission(c *gin.Context, permission string) bool {
+	granted, _ := c.Get(grantedKey)
+	set, _ := granted.(map[string]bool)
+	return set[permission]
+}
+
+// RequirePermission 权限中间件，需在Auth之后使用，拥有其中任一权限即可访问；页面请求无权限时跳转首页
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get("user_id"); !exists {
+			unauthorized(c, "未登录")
+			return
+		}
+
+		for _, permission := range permissions {
+			if HasPermission(c, permission) {
+				c.Next()
+				return
+			}
+		}
+
+		if isPageRequest(c) {
+			c.Redirect(http.StatusFound, "/")
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Permission denied",
+		})
+		c.Abort()
+	}
+}
+
+// authenticateRequest 读取并校验访问令牌。Authorization头优先，其次是Cookie；
+// Cookie中的令牌失效时尝试用刷新令牌续期。未携带令牌时返回nil，令牌无效时返回错误信息
+func authenticateRequest(c *gin.Context, jwtConfig *JWTConfig) (*Claims, string) {
+	if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+		// 检查令牌格式
+		parts := strings.SplitN(authHeader, " ", 2)
+		if !(len(parts) == 2 && parts[0] == "Bearer") {
+			return nil, "Authorization header format must be Bearer {token}"
+		}
+
+		claims, err := jwtConfig.Authenticate(parts[1])
+		if err != nil {
+			return nil, "Invalid or expired token"
+		}
+		return claims, ""
+	}
+
+	token, _ := c.Cookie(TokenCookie)
+	if token != "" {
+		if claims, err := jwtConfig.Authenticate(token); err == nil {
+			return claims, ""
+		}
+	}
+
+	// 访问令牌过期或缺失时用刷新令牌续期，没有刷新令牌视为未登录
+	claims := refreshCookieSession(c, jwtConfig)
+	if claims == nil && token != "" {
+		jwtConfig.ClearAuthCookies(c)
+	}
+	return claims, ""
+}
+
+// refreshCookieSession 用刷新令牌Cookie续期会话，成功时写入新Cookie并替换本次请求中的令牌
+func refreshCookieSession(c *gin.Context, jwtConfig *JWTConfig) *Claims {
+	refreshToken, err := c.Cookie(RefreshTokenCookie)
+	if err != nil || refreshToken == "" || jwtConfig.Sessions == nil {
+		return nil
+	}
+
+	accessToken, newRefreshToken, err := jwtConfig.Sessions.RefreshSession(refreshToken, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		jwtConfig.ClearAuthCookies(c)
+		return nil
+	}
+	jwtConfig.SetAuthCookies(c, accessToken, newRefreshToken)
+
+	// 后续处理函数从请求Cookie中读取令牌
+	cookies := c.Request.Cookies()
+	c.Request.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name == TokenCookie || cookie.Name == RefreshTokenCookie {
+			continue
+		}
+		c.Request.AddCookie(cookie)
+	}
+	c.Request.AddCookie(&http.Cookie{Name: TokenCookie, Value: accessToken})
+	c.Request.AddCookie(&http.Cookie{Name: RefreshTokenCookie, Value: newRefreshToken})
+
+	claims, err := jwtConfig.ParseToken(accessToken)
+	if err != nil {
+		return nil
+	}
+	return claims
+}
+
+// scopeRule 受限令牌可以访问的页面和接口
+type scopeRule struct {
+	page    string          // 唯一可访问的页面，其他页面请求跳转到这里
+	routes  map[string]bool // 可访问的接口
+	message string
+	code    string
+}
+
+// restrictedScopes 受限令牌的访问规则
+var restrictedScopes = map[string]scopeRule{
+	ScopePasswordChange: {
+		page:    "/change-password",
+		routes:  passwordChangeRoutes,
+		message: "请先修改密码",
+		code:    "password_change_required",
+	},
+	ScopeTwoFactorSetup: {
+		page:    "/two-factor",
+		routes:  twoFactorSetupRoutes,
+		message: "请先启用两步验证",
+		code:    "two_factor_setup_required",
+	},
+}
+
+// allows 判断受限令牌能否访问当前请求的页面或接口
+func (r scopeRule) allows(c *gin.Context) bool {
+	if isPageRequest(c) {
+		path := c.Request.URL.Path
+		return path == r.page || path == "/logout"
+	}
+	return r.routes[c.Request.Method+" "+c.FullPath()]
+}
+
+// isPageRequest 是否为页面请求，API请求以/api/开头
+func isPageRequest(c *gin.Context) bool {
+	return !strings.HasPrefix(c.Request.URL.Path, "/api/")
+}
+
+// unauthorized 未登录或令牌无效：页面跳转登录页，API返回401
+func unauthorized(c *gin.Context, message string) {
+	if isPageRequest(c) {
+		c.Redirect(http.StatusFound, "/login")
+		c.Abort()
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"error": message,
+	})
+	c.Abort()
+}
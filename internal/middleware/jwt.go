@@ -3,7 +3,6 @@ package middleware
 import (
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -13,35 +12,103 @@ import (
 
 // JWT中间件配置
 type JWTConfig struct {
-	Secret    string
-	ExpiresIn int
+	Secret           string
+	ExpiresIn        int
+	RefreshExpiresIn int
+	// Sessions 服务端会话，设置后令牌必须属于未注销的会话
+	Sessions SessionManager
 }
 
+// SessionManager 服务端会话管理，由服务层实现
+type SessionManager interface {
+	// ValidateSession 检查会话是否属于该用户且未注销、未过期
+	ValidateSession(sessionID string, userID int) error
+	// RefreshSession 用刷新令牌签发新的访问令牌，并轮换刷新令牌
+	RefreshSession(refreshToken, ip, userAgent string) (accessToken, newRefreshToken string, err error)
+}
+
+// 保存令牌的Cookie名称
+const (
+	TokenCookie        = "token"
+	RefreshTokenCookie = "refresh_token"
+)
+
 // Claims 自定义JWT声明
 type Claims struct {
 	UserID   int    `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	Scope    string `json:"scope,omitempty"`
+	// SessionID 令牌所属的服务端会话
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// ScopePasswordChange 受限令牌：只能修改密码，用于首次登录或密码过期的用户
+const ScopePasswordChange = "password_change"
+
+// ScopeTwoFactor 两步验证凭证：密码已验证，只能用于提交两步验证码，不属于任何会话
+const ScopeTwoFactor = "two_factor"
+
+// ScopeTwoFactorSetup 受限令牌：必须启用两步验证的角色尚未绑定时，只能绑定两步验证
+const ScopeTwoFactorSetup = "two_factor_setup"
+
+// passwordChangeRoutes 受限令牌允许访问的接口
+var passwordChangeRoutes = map[string]bool{
+	"GET /api/user/me":       true,
+	"PUT /api/user/password": true,
+	"POST /api/logout":       true,
+}
+
+// twoFactorSetupRoutes 绑定两步验证的受限令牌允许访问的接口
+var twoFactorSetupRoutes = map[string]bool{
+	"GET /api/user/me":          true,
+	"GET /api/user/2fa":         true,
+	"POST /api/user/2fa/setup":  true,
+	"POST /api/user/2fa/enable": true,
+	"POST /api/logout":          true,
+}
+
 // NewJWTConfig 创建JWT配置
 func NewJWTConfig(cfg *config.Config) *JWTConfig {
 	return &JWTConfig{
-		Secret:    cfg.JWT.Secret,
-		ExpiresIn: cfg.JWT.ExpiresIn,
+		Secret:           cfg.JWT.Secret,
+		ExpiresIn:        cfg.JWT.ExpiresIn,
+		RefreshExpiresIn: cfg.JWT.RefreshExpiresIn,
 	}
 }
 
 // GenerateToken 生成JWT令牌
 func (j *JWTConfig) GenerateToken(userID int, username, role string) (string, error) {
+	return j.GenerateScopedToken(userID, username, role, "")
+}
+
+// GenerateScopedToken 生成限定用途的JWT令牌，scope为空时不限用途
+func (j *JWTConfig) GenerateScopedToken(userID int, username, role, scope string) (string, error) {
+	return j.GenerateSessionToken(userID, username, role, scope, "")
+}
+
+// GenerateSessionToken 为服务端会话生成访问令牌
+func (j *JWTConfig) GenerateSessionToken(userID int, username, role, scope, sessionID string) (string, error) {
+	return j.generate(userID, username, role, scope, sessionID, time.Duration(j.ExpiresIn)*time.Second)
+}
+
+// GenerateChallengeToken 生成不属于会话的短期凭证，例如登录第二步使用的两步验证凭证
+func (j *JWTConfig) GenerateChallengeToken(userID int, username, role, scope string, expiresIn time.Duration) (string, error) {
+	return j.generate(userID, username, role, scope, "", expiresIn)
+}
+
+// generate 生成并签名JWT令牌
+func (j *JWTConfig) generate(userID int, username, role, scope, sessionID string, expiresIn time.Duration) (string, error) {
 	// 创建声明
 	claims := Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+		UserID:    userID,
+		Username:  username,
+		Role:      role,
+		Scope:     scope,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(j.ExpiresIn) * time.Second)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Subject:   fmt.Sprintf("%d", userID),
 		},
@@ -82,79 +149,35 @@ func (j *JWTConfig) ParseToken(tokenString string) (*Claims, error) {
 	return nil, fmt.Errorf("invalid token")
 }
 
-// JWTAuth JWT认证中间件
-func JWTAuth(jwtConfig *JWTConfig) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// 从Authorization头获取令牌
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Authorization header is required",
-			})
-			c.Abort()
-			return
-		}
-
-		// 检查令牌格式
-		parts := strings.SplitN(authHeader, " ", 2)
-		if !(len(parts) == 2 && parts[0] == "Bearer") {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Authorization header format must be Bearer {token}",
-			})
-			c.Abort()
-			return
+// Authenticate 解析访问令牌并检查所属会话是否仍然有效
+func (j *JWTConfig) Authenticate(tokenString string) (*Claims, error) {
+	claims, err := j.ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if j.Sessions != nil {
+		if claims.SessionID == "" {
+			return nil, fmt.Errorf("token has no session")
 		}
-
-		// 解析令牌
-		claims, err := jwtConfig.ParseToken(parts[1])
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Invalid or expired token",
-			})
-			c.Abort()
-			return
+		if err := j.Sessions.ValidateSession(claims.SessionID, claims.UserID); err != nil {
+			return nil, err
 		}
-
-		// 将用户信息存储到上下文
-		c.Set("user_id", claims.UserID)
-		c.Set("username", claims.Username)
-		c.Set("role", claims.Role)
-
-		c.Next()
 	}
+	return claims, nil
 }
 
-// RoleAuth 角色权限中间件
-func RoleAuth(roles ...string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// 从上下文获取用户角色
-		role, exists := c.Get("role")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Role not found in context",
-			})
-			c.Abort()
-			return
-		}
-
-		// 检查角色是否在允许列表中
-		userRole := role.(string)
-		allowed := false
-		for _, r := range roles {
-			if r == userRole {
-				allowed = true
-				break
-			}
-		}
-
-		if !allowed {
-			c.JSON(http.StatusForbidden, gin.H{
-				"error": "Permission denied",
-			})
-			c.Abort()
-			return
-		}
-
-		c.Next()
+// SetAuthCookies 将访问令牌和刷新令牌写入HttpOnly Cookie
+func (j *JWTConfig) SetAuthCookies(c *gin.Context, accessToken, refreshToken string) {
+	// API也接受Cookie中的令牌，SameSite=Lax阻止跨站提交的请求携带Cookie
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(TokenCookie, accessToken, j.ExpiresIn, "/", "", false, true)
+	if refreshToken != "" {
+		c.SetCookie(RefreshTokenCookie, refreshToken, j.RefreshExpiresIn, "/", "", false, true)
 	}
 }
+
+// ClearAuthCookies 清除登录Cookie
+func (j *JWTConfig) ClearAuthCookies(c *gin.Context) {
+	c.SetCookie(TokenCookie, "", -1, "/", "", false, true)
+	c.SetCookie(RefreshTokenCookie, "", -1, "/", "", false, true)
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 审计操作
+const (
+	AuditUserRegister        = "user.register"         // 自助注册
+	AuditUserCreate          = "user.create"           // 管理员创建、花名册导入或命令行创建站长
+	AuditUserUpdate          = "user.update"           // 修改资料
+	AuditUserStatus          = "user.status"           // 启用或禁用
+	AuditUserRole            = "user.role"             // 修改角色
+	AuditUserPasswordChange  = "user.password_change"  // 本人修改密码
+	AuditUserPasswordReset   = "user.password_reset"   // 管理员或命令行重置密码
+	AuditUserPasswordRecover = "user.password_recover" // 本人通过邮箱或短信验证码找回密码
+	AuditUserUnlock          = "user.unlock"           // 解除登录锁定
+	AuditUserTwoFactorReset  = "user.two_factor_reset"
+	AuditUserSessionsRevoke  = "user.sessions_revoke"
+	AuditRoleCreate          = "role.create"
+	AuditRoleUpdate          = "role.update" // 修改名称、说明或权限
+	AuditRoleDelete          = "role.delete"
+	AuditDepartmentCreate    = "department.create"
+	AuditDepartmentUpdate    = "department.update" // 改名、调整层级或移动到其他上级科室
+	AuditDepartmentDelete    = "department.delete"
+	AuditQuestionBankCreate  = "question_bank.create"
+	AuditQuestionCreate      = "question.create" // 包含标准答案和分值
+	AuditExamPublish         = "exam.publish"
+	AuditExamProctoring      = "exam.proctoring"          // 修改防作弊违规次数阈值
+	AuditExamCredits         = "exam.credits"             // 修改试卷的继续医学教育学分
+	AuditExamSubmit          = "exam_record.submit"       // 交卷并自动评分
+	AuditExamForceSubmit     = "exam_record.force_submit" // 违规次数达到阈值被强制交卷
+)
+
+// 审计对象类型
+const (
+	AuditEntityUser         = "user"
+	AuditEntityRole         = "role" // 角色以编码为主键，entity_id为0，编码记录在变化内容的code字段
+	AuditEntityDepartment   = "department"
+	AuditEntityQuestionBank = "question_bank"
+	AuditEntityQuestion     = "question"
+	AuditEntityExam         = "exam"
+	AuditEntityExamRecord   = "exam_record"
+)
+
+// AuditLog 审计日志
+type AuditLog struct {
+	ID         int64           `json:"id"`
+	ActorID    int             `json:"actor_id"` // 0表示系统操作
+	ActorName  string          `json:"actor_name"`
+	IP         string          `json:"ip"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int             `json:"entity_id"`
+	Changes    json.RawMessage `json:"changes"` // {"before": {...}, "after": {...}}，没有字段变化时为null
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// AuditLogFilter 审计日志查询条件
+type AuditLogFilter struct {
+	ActorID    int
+	ActorName  string
+	IP         string
+	Action     string
+	EntityType string
+	EntityID   int
+	StartDate  time.Time
+	EndDate    time.Time
+	Page       int
+	PageSize   int
+}
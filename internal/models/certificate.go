@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// Certificate 考试合格证书
+type Certificate struct {
+	ID         int       `json:"id"`
+	RecordID   int       `json:"record_id"`
+	UserID     int       `json:"user_id"`
+	ExamID     int       `json:"exam_id"`
+	Code       string    `json:"code"`
+	UserName   string    `json:"user_name"`
+	Department string    `json:"department"`
+	ExamTitle  string    `json:"exam_title"`
+	Score      float64   `json:"score"`
+	TotalScore float64   `json:"total_score"`
+	IssuedAt   time.Time `json:"issued_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"time"
+)
+
+// PracticeGoal 年度练习目标，练习题量达标后计入学分
+type PracticeGoal struct {
+	ID                int       `json:"id"`
+	Title             string    `json:"title"`
+	Year              int       `json:"year"`
+	RequiredQuestions int       `json:"required_questions"`
+	Credits           float64   `json:"credits"`
+	CreatedBy         int       `json:"created_by"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// CreditTarget 年度学分要求，JobTitle为空表示默认要求
+type CreditTarget struct {
+	ID              int       `json:"id"`
+	Year            int       `json:"year"`
+	JobTitle        string    `json:"job_title"`
+	RequiredCredits float64   `json:"required_credits"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// CreditLedgerEntry 学分台账记录
+type CreditLedgerEntry struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	Year        int       `json:"year"`
+	SourceType  string    `json:"source_type"`
+	SourceID    int       `json:"source_id"`
+	Credits     float64   `json:"credits"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CreditLedger 用户年度学分台账
+type CreditLedger struct {
+	UserID          int                 `json:"user_id"`
+	Year            int                 `json:"year"`
+	EarnedCredits   float64             `json:"earned_credits"`
+	RequiredCredits float64             `json:"required_credits"`
+	Remaining       float64             `json:"remaining"`
+	Entries         []CreditLedgerEntry `json:"entries"`
+}
+
+// CreditShortfall 学分未达标人员
+type CreditShortfall struct {
+	UserID          int     `json:"user_id"`
+	Name            string  `json:"name"`
+	Department      string  `json:"department"`
+	JobTitle        string  `json:"job_title"`
+	EarnedCredits   float64 `json:"earned_credits"`
+	RequiredCredits float64 `json:"required_credits"`
+	Remaining       float64 `json:"remaining"`
+}
+
+type PracticeGoalCreateRequest struct {
+	Title             string  `json:"title" binding:"required"`
+	Year              int     `json:"year" binding:"required"`
+	RequiredQuestions int     `json:"required_questions" binding:"required,min=1"`
+	Credits           float64 `json:"credits" binding:"required"`
+}
+
+type CreditTargetRequest struct {
+	Year            int     `json:"year" binding:"required"`
+	JobTitle        string  `json:"job_title" binding:"omitempty"`
+	RequiredCredits float64 `json:"required_credits" binding:"required"`
+}
+
+type ExamCreditsRequest struct {
+	CMECredits float64 `json:"cme_credits" binding:"min=0"`
+}
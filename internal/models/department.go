@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// 科室层级
+const (
+	DepartmentLevelHospital = "hospital"
+	DepartmentLevelDivision = "division"
+	DepartmentLevelWard     = "ward"
+)
+
+// Department 科室，ParentID为0表示顶级科室
+type Department struct {
+	ID        int          `json:"id"`
+	ParentID  int          `json:"parent_id"`
+	Name      string       `json:"name"`
+	Level     string       `json:"level"`
+	Path      string       `json:"path"`
+	SortOrder int          `json:"sort_order"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Children  []Department `json:"children,omitempty"`
+}
+
+// DepartmentStats 科室子树的考试统计
+type DepartmentStats struct {
+	DepartmentID int     `json:"department_id"`
+	Name         string  `json:"name"`
+	UserCount    int     `json:"user_count"`
+	RecordCount  int     `json:"record_count"`
+	PassedCount  int     `json:"passed_count"`
+	PassRate     float64 `json:"pass_rate"`
+	AvgScore     float64 `json:"avg_score"`
+}
+
+type DepartmentCreateRequest struct {
+	ParentID  int    `json:"parent_id" binding:"omitempty,min=0"`
+	Name      string `json:"name" binding:"required,max=100"`
+	Level     string `json:"level" binding:"required,oneof=hospital division ward"`
+	SortOrder int    `json:"sort_order" binding:"omitempty"`
+}
+
+type DepartmentUpdateRequest struct {
+	ParentID  *int    `json:"parent_id" binding:"omitempty,min=0"`
+	Name      *string `json:"name" binding:"omitempty,max=100"`
+	Level     *string `json:"level" binding:"omitempty,oneof=hospital division ward"`
+	SortOrder *int    `json:"sort_order" binding:"omitempty"`
+}
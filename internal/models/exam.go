@@ -14,6 +14,8 @@ type Exam struct {
 	StartTime   time.Time `json:"start_time"`
 	EndTime     time.Time `json:"end_time"`
 	Status      string    `json:"status"`
+	CMECredits  float64   `json:"cme_credits"`
+	DepartmentID int      `json:"department_id"` // 0表示全院试卷
 	CreatedBy   int       `json:"created_by"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
@@ -67,6 +69,9 @@ type ExamGenerateRequest struct {
 	EndTime     string `json:"end_time" binding:"required"`
 	QuestionCount int   `json:"question_count" binding:"required"`
 	Difficulty   string `json:"difficulty" binding:"omitempty"`
+	CMECredits   float64 `json:"cme_credits" binding:"omitempty,min=0"`
+	// DepartmentID 归属科室，站长不填表示全院试卷，其他管理角色不填时默认为所属科室
+	DepartmentID int     `json:"department_id" binding:"omitempty"`
 }
 
 type ExamAnswerRequest struct {
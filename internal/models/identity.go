@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// UserIdentity 账号绑定的外部身份，Issuer和Subject唯一确定认证服务中的一个账号
+type UserIdentity struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Provider  string    `json:"provider"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+	// LastLoginAt 最近一次使用该身份登录的时间，从未登录时为零值
+	LastLoginAt time.Time `json:"last_login_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+// 找回密码时接收验证码的方式
+const (
+	ResetChannelEmail = "email" // 发送到账号绑定的邮箱
+	ResetChannelSMS   = "sms"   // 发送到账号绑定的手机号
+)
+
+// PasswordResetCodeRequest 找回密码第一步：申请验证码。账号可以是用户名、手机号或身份证号
+type PasswordResetCodeRequest struct {
+	Account   string `json:"account" binding:"required,max=50"`
+	Channel   string `json:"channel" binding:"required,oneof=email sms"`
+	CaptchaID string `json:"captcha_id" binding:"required"`
+	Captcha   string `json:"captcha" binding:"required"`
+}
+
+// PasswordResetVerifyRequest 找回密码第二步：提交收到的验证码，通过后返回reset_token
+type PasswordResetVerifyRequest struct {
+	Account string `json:"account" binding:"required,max=50"`
+	Code    string `json:"code" binding:"required"`
+}
+
+// PasswordResetConfirmRequest 找回密码第三步：使用reset_token设置新密码
+type PasswordResetConfirmRequest struct {
+	ResetToken  string `json:"reset_token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+)
+
+// 系统内置角色
+const (
+	RoleAdmin    = "admin"    // 站长，拥有全部权限
+	RoleManager  = "manager"  // 管理员
+	RoleEmployee = "employee" // 员工/考生
+)
+
+// 权限编码
+const (
+	PermQuestionEdit     = "question.edit"
+	PermExamPublish      = "exam.publish"
+	PermGradeReview      = "grade.review"
+	PermUserManage       = "user.manage"
+	PermRoleAssign       = "role.assign"
+	PermCMEManage        = "cme.manage"
+	PermDepartmentView   = "department.view"
+	PermDepartmentManage = "department.manage"
+	PermSecurityAudit    = "security.audit"
+	PermRoleManage       = "role.manage"
+	PermSystemDebug      = "system.debug"
+	// PermPersonalInfoView 查看完整的手机号和身份证号，没有该权限时返回脱敏后的号码
+	PermPersonalInfoView = "personal_info.view"
+	// PermAuditView 查询和导出审计日志
+	PermAuditView = "audit.view"
+)
+
+// Permission 权限
+type Permission struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Role 角色
+type Role struct {
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	IsSystem    bool      `json:"is_system"`
+	Permissions []string  `json:"permissions"`
+	UserCount   int       `json:"user_count"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RoleCreateRequest 创建自定义角色请求
+type RoleCreateRequest struct {
+	Code        string   `json:"code" binding:"required"`
+	Name        string   `json:"name" binding:"required,max=50"`
+	Description string   `json:"description" binding:"omitempty,max=255"`
+	Permissions []string `json:"permissions"`
+}
+
+// RoleUpdateRequest 修改角色请求
+type RoleUpdateRequest struct {
+	Name        string   `json:"name" binding:"required,max=50"`
+	Description string   `json:"description" binding:"omitempty,max=255"`
+	Permissions []string `json:"permissions"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// PracticeRecord 模拟练习记录
+type PracticeRecord struct {
+	ID            int       `json:"id"`
+	UserID        int       `json:"user_id"`
+	QuestionCount int       `json:"question_count"`
+	CorrectCount  int       `json:"correct_count"`
+	Score         float64   `json:"score"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type PracticeSubmitRequest struct {
+	Answers []ExamAnswerRequest `json:"answers" binding:"required"`
+}
@@ -0,0 +1,95 @@
+package models
+
+import "time"
+
+// 考试中的违规事件
+const (
+	ProctorEventTabSwitch      = "tab_switch"      // 切换标签页或最小化
+	ProctorEventBlur           = "blur"            // 窗口失去焦点
+	ProctorEventCopy           = "copy"            // 复制
+	ProctorEventPaste          = "paste"           // 粘贴
+	ProctorEventFullscreenExit = "fullscreen_exit" // 退出全屏
+	ProctorEventMultiSession   = "multi_session"   // 同一考试记录在其他会话中作答，由服务器记录
+	// ProctorEventHeartbeat 考生端定时上报，不计入违规，只用于发现其他会话
+	ProctorEventHeartbeat = "heartbeat"
+)
+
+// 考试记录的监考状态，只升不降
+const (
+	ProctorStatusNormal         = "normal"
+	ProctorStatusWarned         = "warned"
+	ProctorStatusFlagged        = "flagged"
+	ProctorStatusForceSubmitted = "force_submitted"
+)
+
+// 上报违规事件后考生端需要执行的动作
+const (
+	ProctorActionNone   = "none"
+	ProctorActionWarn   = "warn"   // 提醒考生
+	ProctorActionSubmit = "submit" // 立即交卷
+)
+
+// ProctorPolicy 试卷的违规次数阈值，0表示不启用
+type ProctorPolicy struct {
+	ExamID          int `json:"exam_id"`
+	WarnThreshold   int `json:"warn_threshold"`
+	FlagThreshold   int `json:"flag_threshold"`
+	SubmitThreshold int `json:"submit_threshold"`
+}
+
+// ProctorPolicyRequest 设置试卷的违规次数阈值
+type ProctorPolicyRequest struct {
+	WarnThreshold   int `json:"warn_threshold" binding:"min=0"`
+	FlagThreshold   int `json:"flag_threshold" binding:"min=0"`
+	SubmitThreshold int `json:"submit_threshold" binding:"min=0"`
+}
+
+// ProctorEventRequest 考生端上报的事件
+type ProctorEventRequest struct {
+	Type   string `json:"type" binding:"required,oneof=tab_switch blur copy paste fullscreen_exit heartbeat"`
+	Detail string `json:"detail" binding:"omitempty,max=200"`
+}
+
+// ProctorEventResult 上报事件后考试记录的违规情况
+type ProctorEventResult struct {
+	ViolationCount int    `json:"violation_count"`
+	Status         string `json:"status"`
+	Action         string `json:"action"`
+	Message        string `json:"message,omitempty"`
+}
+
+// ProctorEvent 考试记录的违规事件
+type ProctorEvent struct {
+	ID        int64     `json:"id"`
+	RecordID  int       `json:"record_id"`
+	Type      string    `json:"type"`
+	Detail    string    `json:"detail"`
+	SessionID string    `json:"session_id"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FlaggedRecordFilter 可疑考试记录的查询条件
+type FlaggedRecordFilter struct {
+	ExamID       int
+	DepartmentID int    // 包含下级科室
+	Status       string // 为空时查询flagged和force_submitted
+	Page         int
+	PageSize     int
+}
+
+// FlaggedRecord 可疑考试记录
+type FlaggedRecord struct {
+	RecordID       int       `json:"record_id"`
+	ExamID         int       `json:"exam_id"`
+	ExamTitle      string    `json:"exam_title"`
+	UserID         int       `json:"user_id"`
+	Username       string    `json:"username"`
+	Name           string    `json:"name"`
+	Department     string    `json:"department"`
+	RecordStatus   string    `json:"record_status"`
+	TotalScore     float64   `json:"total_score"`
+	ViolationCount int       `json:"violation_count"`
+	ProctorStatus  string    `json:"proctor_status"`
+	FlaggedAt      time.Time `json:"flagged_at"`
+}
@@ -9,6 +9,7 @@ type QuestionBank struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Subject     string    `json:"subject"`
+	DepartmentID int      `json:"department_id"` // 0表示全院公共题库
 	CreatedBy   int       `json:"created_by"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
@@ -46,4 +47,6 @@ type QuestionBankCreateRequest struct {
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description" binding:"omitempty"`
 	Subject     string `json:"subject" binding:"required"`
+	// DepartmentID 归属科室，站长不填表示全院公共题库，其他管理角色不填时默认为所属科室
+	DepartmentID int   `json:"department_id" binding:"omitempty"`
 }
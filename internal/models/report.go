@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+// ScoreSheetFilter 成绩单导出筛选条件
+type ScoreSheetFilter struct {
+	ExamID       int
+	DepartmentID int    // 包含下级科室
+	Department   string // 科室名称，仅用于报表显示
+	StartDate    time.Time
+	EndDate      time.Time
+}
+
+// ScoreSheetRow 成绩单中的一行记录
+type ScoreSheetRow struct {
+	RecordID   int       `json:"record_id"`
+	UserID     int       `json:"user_id"`
+	Name       string    `json:"name"`
+	Department string    `json:"department"`
+	JobTitle   string    `json:"job_title"`
+	ExamID     int       `json:"exam_id"`
+	ExamTitle  string    `json:"exam_title"`
+	ExamTotal  float64   `json:"exam_total"`
+	Score      float64   `json:"score"`
+	Passed     bool      `json:"passed"`
+	Duration   int       `json:"duration"`
+	SubmitTime time.Time `json:"submit_time"`
+}
+
+// ExamSummary 单场考试成绩汇总
+type ExamSummary struct {
+	Exam         *Exam           `json:"exam"`
+	Participants int             `json:"participants"`
+	PassedCount  int             `json:"passed_count"`
+	PassRate     float64         `json:"pass_rate"`
+	AvgScore     float64         `json:"avg_score"`
+	MaxScore     float64         `json:"max_score"`
+	MinScore     float64         `json:"min_score"`
+	Rows         []ScoreSheetRow `json:"rows"`
+}
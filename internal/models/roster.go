@@ -0,0 +1,42 @@
+package models
+
+// 花名册导入行处理结果
+const (
+	RosterRowCreated   = "created"
+	RosterRowUpdated   = "updated"
+	RosterRowUnchanged = "unchanged"
+	RosterRowFailed    = "failed"
+)
+
+// RosterRow 花名册中的一行人员信息
+type RosterRow struct {
+	Line       int    `json:"line"` // 表格行号，从1开始，含表头
+	Username   string `json:"username"`
+	Name       string `json:"name"`
+	Gender     string `json:"gender"`
+	Phone      string `json:"phone"`
+	IDCard     string `json:"id_card"`
+	BirthDate  string `json:"birth_date,omitempty"` // 从身份证号中读取
+	Department string `json:"department"`
+	JobTitle   string `json:"job_title"`
+}
+
+// RosterRowResult 单行导入结果，新建账号时返回初始密码
+type RosterRowResult struct {
+	Line            int    `json:"line"`
+	Username        string `json:"username"`
+	Name            string `json:"name"`
+	Status          string `json:"status"`
+	InitialPassword string `json:"initial_password,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// RosterImportResult 花名册导入报告
+type RosterImportResult struct {
+	Total     int               `json:"total"`
+	Created   int               `json:"created"`
+	Updated   int               `json:"updated"`
+	Unchanged int               `json:"unchanged"`
+	Failed    int               `json:"failed"`
+	Rows      []RosterRowResult `json:"rows"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+)
+
+// 登录尝试结果
+const (
+	LoginResultSuccess        = "success"
+	LoginResultBadCredentials = "bad_credentials"
+	LoginResultCaptcha        = "captcha"
+	LoginResultDisabled       = "disabled"
+	LoginResultLocked         = "locked"
+	LoginResultThrottled      = "throttled"
+	LoginResultTwoFactor      = "two_factor" // 密码正确但两步验证码错误
+)
+
+// LoginAttempt 登录尝试记录
+type LoginAttempt struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Result    string    `json:"result"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginAttemptFilter 登录尝试记录查询条件
+type LoginAttemptFilter struct {
+	Username  string
+	IP        string
+	Result    string
+	StartDate time.Time
+	EndDate   time.Time
+	Page      int
+	PageSize  int
+}
+
+// LockedAccount 当前被锁定的账号
+type LockedAccount struct {
+	UserID      int       `json:"user_id"`
+	Username    string    `json:"username"`
+	Name        string    `json:"name"`
+	Department  string    `json:"department"`
+	LockedUntil time.Time `json:"locked_until"`
+}
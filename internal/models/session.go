@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// UserSession 登录会话
+type UserSession struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"user_id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current 是否为发起请求的会话
+	Current bool `json:"current"`
+}
+
+// RefreshTokenRequest 刷新令牌请求，未提供时从Cookie读取
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
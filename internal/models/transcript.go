@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+)
+
+// TranscriptEntry 成绩档案中的一次考试
+type TranscriptEntry struct {
+	RecordID   int       `json:"record_id"`
+	ExamID     int       `json:"exam_id"`
+	ExamTitle  string    `json:"exam_title"`
+	Subject    string    `json:"subject"`
+	ExamTotal  float64   `json:"exam_total"`
+	Score      float64   `json:"score"`
+	Passed     bool      `json:"passed"`
+	Attempt    int       `json:"attempt"`
+	IsMakeup   bool      `json:"is_makeup"`
+	Status     string    `json:"status"`
+	Duration   int       `json:"duration"`
+	StartTime  time.Time `json:"start_time"`
+	SubmitTime time.Time `json:"submit_time"`
+}
+
+// TranscriptYear 成绩档案的年度汇总
+type TranscriptYear struct {
+	Year              int               `json:"year"`
+	ExamCount         int               `json:"exam_count"`
+	PassedCount       int               `json:"passed_count"`
+	MakeupCount       int               `json:"makeup_count"`
+	AvgScore          float64           `json:"avg_score"`
+	PracticeCount     int               `json:"practice_count"`
+	PracticeQuestions int               `json:"practice_questions"`
+	PracticeCorrect   int               `json:"practice_correct"`
+	Entries           []TranscriptEntry `json:"entries"`
+}
+
+// Transcript 个人三基成绩档案
+type Transcript struct {
+	User  UserResponse     `json:"user"`
+	Years []TranscriptYear `json:"years"`
+}
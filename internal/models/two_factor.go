@@ -0,0 +1,28 @@
+package models
+
+// TwoFactorStatus 两步验证状态
+type TwoFactorStatus struct {
+	Enabled bool `json:"enabled"`
+	// Required 当前角色是否必须启用两步验证
+	Required bool `json:"required"`
+	// RecoveryCodesRemaining 剩余未使用的恢复码数量
+	RecoveryCodesRemaining int `json:"recovery_codes_remaining"`
+}
+
+// TwoFactorSetup 绑定两步验证时生成的密钥
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	// ProvisioningURI otpauth://地址，生成二维码后用验证器应用扫描
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// TwoFactorCodeRequest 提交两步验证码，也可以使用恢复码
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TwoFactorLoginRequest 登录第二步：提交密码验证后返回的two_factor_token和验证码
+type TwoFactorLoginRequest struct {
+	Token string `json:"token" binding:"required"`
+	Code  string `json:"code" binding:"required"`
+}
@@ -2,6 +2,15 @@ package models
 
 import (
 	"time"
+
+	"github.com/hangbin2008/sanjicms/pkg/idnumber"
+)
+
+// 账号的认证方式
+const (
+	AuthSourceLocal = "local" // 本地密码
+	AuthSourceLDAP  = "ldap"  // LDAP/Active Directory域账号
+	AuthSourceOIDC  = "oidc"  // OpenID Connect统一身份认证账号
 )
 
 type User struct {
@@ -14,12 +23,18 @@ type User struct {
 	Role         string    `json:"role"`
 	Phone        string    `json:"phone"`
 	IDCard       string    `json:"id_card"`
+	BirthDate    string    `json:"birth_date"` // 从身份证号中读取，格式为2006-01-02，未填写身份证号时为空
+	DepartmentID int       `json:"department_id"`
 	Department   string    `json:"department"`
 	JobTitle     string    `json:"job_title"`
 	Avatar       string    `json:"avatar"`
 	Status       int       `json:"status"`
+	AuthSource   string    `json:"auth_source"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+
+	MustChangePassword bool      `json:"must_change_password"`
+	PasswordChangedAt  time.Time `json:"password_changed_at"`
 }
 
 type UserRegisterRequest struct {
@@ -30,40 +45,131 @@ type UserRegisterRequest struct {
 }
 
 type UserUpdateRequest struct {
-	Name       string `json:"name" binding:"omitempty"`
-	Gender     string `json:"gender" binding:"omitempty"`
-	Email      string `json:"email" binding:"omitempty,email"`
-	Phone      string `json:"phone" binding:"omitempty"`
-	IDCard     string `json:"id_card" binding:"omitempty"`
-	Department string `json:"department" binding:"omitempty"`
-	JobTitle   string `json:"job_title" binding:"omitempty"`
-	Avatar     string `json:"avatar" binding:"omitempty"`
+	Name         string `json:"name" binding:"omitempty"`
+	Gender       string `json:"gender" binding:"omitempty"`
+	Email        string `json:"email" binding:"omitempty,email"`
+	Phone        string `json:"phone" binding:"omitempty"`
+	IDCard       string `json:"id_card" binding:"omitempty"`
+	DepartmentID int    `json:"department_id" binding:"omitempty"`
+	Department   string `json:"department" binding:"omitempty"`
+	JobTitle     string `json:"job_title" binding:"omitempty"`
+}
+
+// AvatarCrop 头像裁剪区域：以图片左上角为原点的正方形，单位为像素
+type AvatarCrop struct {
+	X    int
+	Y    int
+	Size int
 }
 
 type UserLoginRequest struct {
-	Username  string `json:"username" binding:"required,alphanum"`
-	Password  string `json:"password" binding:"required"`
-	CaptchaID string `json:"captcha_id" binding:"required"`
-	Captcha   string `json:"captcha" binding:"required"`
+	// 域账号可能含有点号和连字符，格式由对应的认证方式校验
+	Username string `json:"username" binding:"required,max=50"`
+	Password string `json:"password" binding:"required"`
+	// 账号或IP没有失败记录时不需要验证码，是否需要由登录风险决定
+	CaptchaID string `json:"captcha_id"`
+	Captcha   string `json:"captcha"`
 }
 
 type UserResponse struct {
-	ID         int       `json:"id"`
-	Username   string    `json:"username"`
-	Name       string    `json:"name"`
-	Gender     string    `json:"gender"`
-	Email      string    `json:"email"`
-	Role       string    `json:"role"`
-	Phone      string    `json:"phone"`
-	IDCard     string    `json:"id_card"`
-	Department string    `json:"department"`
-	JobTitle   string    `json:"job_title"`
-	Avatar     string    `json:"avatar"`
-	Status     int       `json:"status"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID           int       `json:"id"`
+	Username     string    `json:"username"`
+	Name         string    `json:"name"`
+	Gender       string    `json:"gender"`
+	Email        string    `json:"email"`
+	Role         string    `json:"role"`
+	Phone        string    `json:"phone"`
+	IDCard       string    `json:"id_card"`
+	BirthDate    string    `json:"birth_date"`
+	DepartmentID int       `json:"department_id"`
+	Department   string    `json:"department"`
+	JobTitle     string    `json:"job_title"`
+	Avatar       string    `json:"avatar"`
+	Status       int       `json:"status"`
+	AuthSource   string    `json:"auth_source"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// MaskPersonalInfo 脱敏手机号和身份证号
+func (u *User) MaskPersonalInfo() {
+	u.Phone = idnumber.MaskMobile(u.Phone)
+	u.IDCard = idnumber.MaskIDCard(u.IDCard)
+}
+
+// MaskPersonalInfo 脱敏手机号和身份证号
+func (r *UserResponse) MaskPersonalInfo() {
+	r.Phone = idnumber.MaskMobile(r.Phone)
+	r.IDCard = idnumber.MaskIDCard(r.IDCard)
+}
+
+// ExternalIdentity 外部认证方式验证通过后返回的目录用户信息
+type ExternalIdentity struct {
+	Username   string
+	Name       string
+	Department string
+	JobTitle   string
+	Role       string
+	// RoleFromGroups 为true时角色由目录组映射决定，每次登录同步；否则Role只在自动创建账号时使用
+	RoleFromGroups bool
 }
 
 type LoginResponse struct {
 	User  UserResponse `json:"user"`
 	Token string       `json:"token"`
+	// RefreshToken 访问令牌过期后用于续期，每次续期后更换
+	RefreshToken string `json:"refresh_token"`
+	// MustChangePassword 为true时Token为受限令牌，只能用于修改密码
+	MustChangePassword bool `json:"must_change_password"`
+	PasswordExpired    bool `json:"password_expired"`
+	// TwoFactorRequired 为true时密码已验证通过，需用TwoFactorToken和验证码调用 /api/login/2fa 完成登录
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	TwoFactorToken    string `json:"two_factor_token,omitempty"`
+	// TwoFactorSetupRequired 为true时Token为受限令牌，只能用于绑定两步验证
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required"`
+}
+
+// ChangePasswordRequest 修改密码请求
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// UserListFilter 管理员用户列表筛选条件
+type UserListFilter struct {
+	Keyword      string // 匹配用户名、姓名、手机号
+	DepartmentID int    // 包含下级科室
+	Role         string
+	Status       *int
+	Page         int
+	PageSize     int
+}
+
+// AdminUserCreateRequest 管理员代员工创建账号请求
+type AdminUserCreateRequest struct {
+	Username     string `json:"username" binding:"required,alphanum,min=3,max=20"`
+	Password     string `json:"password" binding:"required,min=6,max=20"`
+	Name         string `json:"name" binding:"required"`
+	Gender       string `json:"gender" binding:"omitempty,oneof=男 女"`
+	Email        string `json:"email" binding:"omitempty,email"`
+	Phone        string `json:"phone" binding:"omitempty"`
+	IDCard       string `json:"id_card" binding:"omitempty"`
+	DepartmentID int    `json:"department_id" binding:"omitempty"`
+	Department   string `json:"department" binding:"omitempty"`
+	JobTitle     string `json:"job_title" binding:"omitempty"`
+	Role         string `json:"role" binding:"omitempty"`
+}
+
+// UserStatusRequest 启用/禁用用户请求
+type UserStatusRequest struct {
+	Status *int `json:"status" binding:"required,oneof=0 1"`
+}
+
+// UserRoleRequest 修改用户角色请求
+type UserRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// ResetPasswordRequest 管理员重置用户密码请求
+type ResetPasswordRequest struct {
+	Password string `json:"password" binding:"required,min=6,max=20"`
 }
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+)
+
+// auditExportLimit 一次最多导出的审计日志条数
+const auditExportLimit = 50000
+
+// auditActionNames 审计操作的中文名称，用于导出
+var auditActionNames = map[string]string{
+	models.AuditUserRegister:        "注册账号",
+	models.AuditUserCreate:          "创建账号",
+	models.AuditUserUpdate:          "修改资料",
+	models.AuditUserStatus:          "启用/禁用账号",
+	models.AuditUserRole:            "修改角色",
+	models.AuditUserPasswordChange:  "修改密码",
+	models.AuditUserPasswordReset:   "重置密码",
+	models.AuditUserPasswordRecover: "找回密码",
+	models.AuditUserUnlock:          "解除锁定",
+	models.AuditUserTwoFactorReset:  "重置两步验证",
+	models.AuditUserSessionsRevoke:  "注销全部会话",
+	models.AuditRoleCreate:          "创建角色",
+	models.AuditRoleUpdate:          "修改角色权限",
+	models.AuditRoleDelete:          "删除角色",
+	models.AuditDepartmentCreate:    "创建科室",
+	models.AuditDepartmentUpdate:    "修改科室",
+	models.AuditDepartmentDelete:    "删除科室",
+	models.AuditQuestionBankCreate:  "创建题库",
+	models.AuditQuestionCreate:      "创建题目",
+	models.AuditExamPublish:         "发布试卷",
+	models.AuditExamProctoring:      "修改防作弊阈值",
+	models.AuditExamCredits:         "修改试卷学分",
+	models.AuditExamSubmit:          "交卷评分",
+	models.AuditExamForceSubmit:     "强制交卷",
+}
+
+// systemOperator 服务器命令行等没有登录用户的操作
+var systemOperator = Operator{Role: models.RoleAdmin, Username: "system"}
+
+// auditExecer 写入审计日志的数据库连接。在事务中传入*sql.Tx，审计日志与操作一起提交或回滚
+type auditExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// auditEvent 一次需要审计的操作。Before、After为操作前后的字段，新建时Before为nil
+type auditEvent struct {
+	Action     string
+	EntityType string
+	EntityID   int
+	Before     map[string]interface{}
+	After      map[string]interface{}
+}
+
+// auditChanges 审计日志中保存的变化内容
+type auditChanges struct {
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+}
+
+// recordAudit 写入审计日志，只保存发生变化的字段
+func recordAudit(exec auditExecer, op Operator, event auditEvent) error {
+	var changes interface{}
+	before, after := auditDiff(event.Before, event.After)
+	if len(before) > 0 || len(after) > 0 {
+		data, err := json.Marshal(auditChanges{Before: before, After: after})
+		if err != nil {
+			return err
+		}
+		changes = string(data)
+	}
+
+	_, err := exec.Exec(`
+		INSERT INTO audit_logs (actor_id, actor_name, ip, action, entity_type, entity_id, changes)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, nullableID(op.UserID), truncate(op.Username, 50), truncate(op.IP, 45), event.Action, event.EntityType,
+		event.EntityID, changes)
+	return err
+}
+
+// logAudit 操作完成后写入审计日志，失败时只记录日志，不影响已完成的操作
+func logAudit(op Operator, event auditEvent) {
+	if err := recordAudit(db.DB, op, event); err != nil {
+		log.Printf("记录审计日志失败（%s %s %d）: %v", event.Action, event.EntityType, event.EntityID, err)
+	}
+}
+
+// auditDiff 比较操作前后的字段，只保留发生变化的字段；没有操作前的字段时原样返回
+func auditDiff(before, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	if before == nil || after == nil {
+		return before, after
+	}
+	changedBefore := make(map[string]interface{})
+	changedAfter := make(map[string]interface{})
+	for key, value := range after {
+		old, ok := before[key]
+		if ok && fmt.Sprint(old) == fmt.Sprint(value) {
+			continue
+		}
+		if ok {
+			changedBefore[key] = old
+		}
+		changedAfter[key] = value
+	}
+	for key, old := range before {
+		if _, ok := after[key]; !ok {
+			changedBefore[key] = old
+		}
+	}
+	return changedBefore, changedAfter
+}
+
+// userAuditFields 审计日志中记录的用户字段，手机号和身份证号只记录脱敏后的号码
+func userAuditFields(user *models.User) map[string]interface{} {
+	masked := *user
+	masked.MaskPersonalInfo()
+	return map[string]interface{}{
+		"username":      masked.Username,
+		"name":          masked.Name,
+		"gender":        masked.Gender,
+		"email":         masked.Email,
+		"role":          masked.Role,
+		"phone":         masked.Phone,
+		"id_card":       masked.IDCard,
+		"birth_date":    masked.BirthDate,
+		"department_id": masked.DepartmentID,
+		"department":    masked.Department,
+		"job_title":     masked.JobTitle,
+		"status":        masked.Status,
+	}
+}
+
+// roleAuditFields 审计日志中记录的角色字段
+func roleAuditFields(name, description string, permissions []string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"description": description,
+		"permissions": permissions,
+	}
+}
+
+// departmentAuditFields 审计日志中记录的科室字段
+func departmentAuditFields(dept *models.Department) map[string]interface{} {
+	return map[string]interface{}{
+		"parent_id":  dept.ParentID,
+		"name":       dept.Name,
+		"level":      dept.Level,
+		"path":       dept.Path,
+		"sort_order": dept.SortOrder,
+	}
+}
+
+// AuditService 审计日志查询服务，审计日志由各服务在操作时写入
+type AuditService struct{}
+
+// NewAuditService 创建审计日志服务
+func NewAuditService() *AuditService {
+	return &AuditService{}
+}
+
+// auditWhere 根据查询条件生成WHERE子句
+func auditWhere(filter *models.AuditLogFilter) (string, []interface{}) {
+	where := " WHERE 1 = 1"
+	args := []interface{}{}
+	if filter.ActorID > 0 {
+		where += " AND actor_id = ?"
+		args = append(args, filter.ActorID)
+	}
+	if filter.ActorName != "" {
+		where += " AND actor_name = ?"
+		args = append(args, filter.ActorName)
+	}
+	if filter.IP != "" {
+		where += " AND ip = ?"
+		args = append(args, filter.IP)
+	}
+	if filter.Action != "" {
+		where += " AND action = ?"
+		args = append(args, filter.Action)
+	}
+	if filter.EntityType != "" {
+		where += " AND entity_type = ?"
+		args = append(args, filter.EntityType)
+	}
+	if filter.EntityID > 0 {
+		where += " AND entity_id = ?"
+		args = append(args, filter.EntityID)
+	}
+	if !filter.StartDate.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, filter.StartDate)
+	}
+	if !filter.EndDate.IsZero() {
+		// 结束日期包含当天
+		where += " AND created_at < ?"
+		args = append(args, filter.EndDate.AddDate(0, 0, 1))
+	}
+	return where, args
+}
+
+// ListAuditLogs 分页查询审计日志，按时间倒序
+func (s *AuditService) ListAuditLogs(filter *models.AuditLogFilter) ([]models.AuditLog, int, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 100 {
+		filter.PageSize = 20
+	}
+
+	where, args := auditWhere(filter)
+	var total int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM audit_logs"+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := (filter.Page - 1) * filter.PageSize
+	logs, err := s.queryAuditLogs(where+" ORDER BY id DESC LIMIT ? OFFSET ?", append(args, filter.PageSize, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
+
+// ExportAuditLogs 查询要导出的审计日志，按时间倒序，最多auditExportLimit条
+func (s *AuditService) ExportAuditLogs(filter *models.AuditLogFilter) ([]models.AuditLog, error) {
+	where, args := auditWhere(filter)
+	return s.queryAuditLogs(where+" ORDER BY id DESC LIMIT ?", append(args, auditExportLimit)...)
+}
+
+func (s *AuditService) queryAuditLogs(clause string, args ...interface{}) ([]models.AuditLog, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, COALESCE(actor_id, 0), actor_name, ip, action, entity_type, entity_id, changes, created_at
+		FROM audit_logs`+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []models.AuditLog{}
+	for rows.Next() {
+		var entry models.AuditLog
+		var changes []byte
+		err := rows.Scan(&entry.ID, &entry.ActorID, &entry.ActorName, &entry.IP, &entry.Action,
+			&entry.EntityType, &entry.EntityID, &changes, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) > 0 {
+			entry.Changes = json.RawMessage(changes)
+		}
+		logs = append(logs, entry)
+	}
+	return logs, rows.Err()
+}
+
+// WriteAuditLogsCSV 将审计日志写为CSV，带UTF-8 BOM以便Excel正确显示中文
+func (s *AuditService) WriteAuditLogsCSV(w io.Writer, logs []models.AuditLog) error {
+	if _, err := io.WriteString(w, "\xEF\xBB\xBF"); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"ID", "时间", "操作人ID", "操作人", "IP", "操作", "操作名称", "对象类型", "对象ID", "变化内容"})
+	if err != nil {
+		return err
+	}
+	for _, entry := range logs {
+		actorID := ""
+		if entry.ActorID > 0 {
+			actorID = fmt.Sprint(entry.ActorID)
+		}
+		err := writer.Write([]string{
+			fmt.Sprint(entry.ID),
+			entry.CreatedAt.Format(time.DateTime),
+			actorID,
+			csvSafe(entry.ActorName),
+			entry.IP,
+			entry.Action,
+			auditActionNames[entry.Action],
+			entry.EntityType,
+			fmt.Sprint(entry.EntityID),
+			csvSafe(string(entry.Changes)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvSafe 以=、+、-、@开头的内容在Excel中会被当作公式执行，前面加单引号
+func csvSafe(value string) string {
+	if value != "" && (value[0] == '=' || value[0] == '+' || value[0] == '-' || value[0] == '@') {
+		return "'" + value
+	}
+	return value
+}
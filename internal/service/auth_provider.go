@@ -0,0 +1,29 @@
+package service
+
+import "github.com/hangbin2008/sanjicms/internal/models"
+
+// AuthProvider 外部认证方式，例如LDAP/Active Directory。
+// 本地账号由users.password_hash验证；auth_source为其他值的账号交给同名的认证方式验证，
+// 本地不存在的账号依次交给允许自动创建账号的认证方式验证，通过后自动创建
+type AuthProvider interface {
+	// Name 认证方式名称，保存在users.auth_source中
+	Name() string
+	// Authenticate 验证用户名和密码，用户不存在或密码错误时返回ErrInvalidCredentials，
+	// 目录服务故障时返回*AuthUnavailableError
+	Authenticate(username, password string) (*models.ExternalIdentity, error)
+	// AutoProvision 是否在首次登录时自动创建账号
+	AutoProvision() bool
+}
+
+// AuthUnavailableError 外部认证服务不可用，Err为具体原因，只记录日志不返回给用户
+type AuthUnavailableError struct {
+	Err error
+}
+
+func (e *AuthUnavailableError) Error() string {
+	return "认证服务暂时不可用，请稍后再试"
+}
+
+func (e *AuthUnavailableError) Unwrap() error {
+	return e.Err
+}
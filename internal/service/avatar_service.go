@@ -0,0 +1,213 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	// 注册GIF、PNG和WebP解码器
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/imaging"
+	"github.com/hangbin2008/sanjicms/pkg/storage"
+)
+
+// AvatarError 上传的头像图片不符合要求
+type AvatarError struct {
+	Message string
+}
+
+func (e *AvatarError) Error() string {
+	return e.Message
+}
+
+// avatarSizes 头像的标准尺寸：第一个保存到users.avatar，用于个人中心；其余用于导航栏等小尺寸显示
+var avatarSizes = []int{256, 64}
+
+// avatarQuality 头像的JPEG压缩质量
+const avatarQuality = 85
+
+// avatarKeyPattern 上传头像的key：avatars/<用户ID>-<随机串>-<尺寸>.jpg
+var avatarKeyPattern = regexp.MustCompile(`^avatars/\d+-[0-9a-f]{16}-\d+\.jpg$`)
+
+// AvatarService 头像服务
+type AvatarService struct {
+	config  *config.AvatarConfig
+	storage storage.Storage
+}
+
+// NewAvatarService 创建头像服务，按配置选择文件存储
+func NewAvatarService(cfg *config.Config) *AvatarService {
+	return NewAvatarServiceWithStorage(cfg, storage.NewLocal(cfg.Storage.LocalDir, cfg.Storage.PublicURL))
+}
+
+// NewAvatarServiceWithStorage 使用指定的文件存储创建头像服务
+func NewAvatarServiceWithStorage(cfg *config.Config, store storage.Storage) *AvatarService {
+	return &AvatarService{config: &cfg.Avatar, storage: store}
+}
+
+// MaxSize 头像图片的大小上限（字节）
+func (s *AvatarService) MaxSize() int {
+	return s.config.MaxSize
+}
+
+// UploadAvatar 校验并处理上传的图片，保存为标准尺寸的头像，替换用户原来上传的头像。
+// crop为nil时裁剪图片中间的正方形区域。图片重新编码为JPEG，不保留EXIF等元数据
+func (s *AvatarService) UploadAvatar(userID int, data []byte, crop *models.AvatarCrop) (string, error) {
+	images, err := s.process(data, crop)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	keys := make([]string, 0, len(images))
+	for i, size := range avatarSizes {
+		key := fmt.Sprintf("avatars/%d-%s-%d.jpg", userID, token, size)
+		if err := s.storage.Put(key, bytes.NewReader(images[i])); err != nil {
+			s.deleteKeys(keys)
+			return "", err
+		}
+		keys = append(keys, key)
+	}
+
+	url := s.storage.URL(keys[0])
+	previous, err := s.replaceAvatar(userID, url)
+	if err != nil {
+		s.deleteKeys(keys)
+		return "", err
+	}
+	s.removeFiles(previous)
+	return url, nil
+}
+
+// ResetAvatar 恢复默认头像并删除上传的头像文件
+func (s *AvatarService) ResetAvatar(userID int) error {
+	previous, err := s.replaceAvatar(userID, nil)
+	if err != nil {
+		return err
+	}
+	s.removeFiles(previous)
+	return nil
+}
+
+// process 校验图片的格式、大小和尺寸，按EXIF方向摆正后裁剪，并编码为各个标准尺寸的JPEG
+func (s *AvatarService) process(data []byte, crop *models.AvatarCrop) ([][]byte, error) {
+	if len(data) == 0 {
+		return nil, &AvatarError{Message: "请选择头像图片"}
+	}
+	if len(data) > s.config.MaxSize {
+		return nil, &AvatarError{Message: fmt.Sprintf("头像图片不能超过%dMB", s.config.MaxSize>>20)}
+	}
+	// 按文件内容而不是扩展名判断格式
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+	default:
+		return nil, &AvatarError{Message: "头像只支持JPEG、PNG、GIF和WebP格式的图片"}
+	}
+
+	// 解码前先检查尺寸，防止体积很小但像素极多的图片耗尽内存
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, &AvatarError{Message: "无法识别头像图片，文件可能已损坏"}
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > s.config.MaxPixels {
+		return nil, &AvatarError{Message: "头像图片的分辨率过大"}
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &AvatarError{Message: "无法识别头像图片，文件可能已损坏"}
+	}
+	img = imaging.ApplyOrientation(img, imaging.Orientation(data))
+
+	// 裁剪区域按摆正后的图片坐标计算
+	bounds := img.Bounds()
+	rect := imaging.SquareCrop(bounds)
+	if crop != nil {
+		rect = image.Rect(crop.X, crop.Y, crop.X+crop.Size, crop.Y+crop.Size).Add(bounds.Min)
+		if crop.Size <= 0 || !rect.In(bounds) {
+			return nil, &AvatarError{Message: "裁剪区域超出图片范围"}
+		}
+	}
+
+	images := make([][]byte, 0, len(avatarSizes))
+	for _, size := range avatarSizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, imaging.Resize(img, rect, size), &jpeg.Options{Quality: avatarQuality}); err != nil {
+			return nil, err
+		}
+		images = append(images, buf.Bytes())
+	}
+	return images, nil
+}
+
+// replaceAvatar 更新用户头像，avatar为nil表示恢复默认头像，返回原来的头像地址
+func (s *AvatarService) replaceAvatar(userID int, avatar interface{}) (string, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow("SELECT COALESCE(avatar, '') FROM users WHERE id = ? FOR UPDATE", userID).Scan(&previous)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errors.New("用户不存在")
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE users SET avatar = ? WHERE id = ?", avatar, userID); err != nil {
+		return "", err
+	}
+	return previous, tx.Commit()
+}
+
+// removeFiles 删除上传的头像的全部尺寸，默认头像和其他地址不处理
+func (s *AvatarService) removeFiles(avatarURL string) {
+	key, ok := s.storage.Key(avatarURL)
+	if !ok || !avatarKeyPattern.MatchString(key) {
+		return
+	}
+	prefix := key[:strings.LastIndex(key, "-")+1]
+	keys := make([]string, 0, len(avatarSizes))
+	for _, size := range avatarSizes {
+		keys = append(keys, fmt.Sprintf("%s%d.jpg", prefix, size))
+	}
+	s.deleteKeys(keys)
+}
+
+// deleteKeys 删除文件，失败时只记录日志
+func (s *AvatarService) deleteKeys(keys []string) {
+	for _, key := range keys {
+		if err := s.storage.Delete(key); err != nil {
+			log.Printf("删除头像文件 %s 失败: %v", key, err)
+		}
+	}
+}
+
+// AvatarThumbnail 上传的头像返回导航栏等小尺寸显示用的地址，其他头像原样返回
+func AvatarThumbnail(avatarURL string) string {
+	large := fmt.Sprintf("-%d.jpg", avatarSizes[0])
+	small := fmt.Sprintf("-%d.jpg", avatarSizes[len(avatarSizes)-1])
+	i := strings.LastIndex(avatarURL, "/avatars/")
+	if i >= 0 && strings.HasSuffix(avatarURL, large) && avatarKeyPattern.MatchString(avatarURL[i+1:]) {
+		return strings.TrimSuffix(avatarURL, large) + small
+	}
+	return avatarURL
+}
@@ -1,96 +1,149 @@
 package service
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
-	"sync"
+	"log"
+	"strings"
+	"time"
 
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/redis"
 	"github.com/mojocn/base64Captcha"
 )
 
 // CaptchaService 验证码服务
 type CaptchaService struct {
-	store sync.Map // 用于存储验证码，key: captcha ID, value: captcha answer
+	config *config.CaptchaConfig
+	store  CaptchaStore
+	driver base64Captcha.Driver
 }
 
-// NewCaptchaService 创建验证码服务
-func NewCaptchaService() *CaptchaService {
-	return &CaptchaService{}
+// NewCaptchaService 创建验证码服务，按配置选择验证码存储
+func NewCaptchaService(cfg *config.Config) *CaptchaService {
+	var store CaptchaStore
+	switch cfg.Captcha.Store {
+	case "mysql":
+		store = NewMySQLCaptchaStore()
+	case "redis":
+		store = NewRedisCaptchaStore(redis.NewClient(redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		}))
+	default:
+		store = NewMemoryCaptchaStore(cfg.Captcha.MaxEntries)
+	}
+	return NewCaptchaServiceWithStore(cfg, store)
+}
+
+// NewCaptchaServiceWithStore 使用指定的存储创建验证码服务
+func NewCaptchaServiceWithStore(cfg *config.Config, store CaptchaStore) *CaptchaService {
+	return &CaptchaService{config: &cfg.Captcha, store: store, driver: newCaptchaDriver(&cfg.Captcha)}
+}
+
+// captchaFonts 图片验证码使用的字体，文泉驿微米黑笔画清晰且包含汉字
+var captchaFonts = []string{"wqy-microhei.ttc"}
+
+// newCaptchaDriver 按配置创建验证码生成器
+func newCaptchaDriver(cfg *config.CaptchaConfig) base64Captcha.Driver {
+	length := cfg.Length
+	if length <= 0 {
+		length = 4
+	}
+	// 干扰线：空心线和细线，既保证安全又清晰
+	lines := base64Captcha.OptionShowHollowLine | base64Captcha.OptionShowSlimeLine
+
+	switch cfg.Type {
+	case "string":
+		// 去掉了0/O、1/l等容易混淆的字符
+		return base64Captcha.NewDriverString(48, 120, 10, lines, length,
+			base64Captcha.TxtSimpleCharaters, nil, nil, captchaFonts)
+	case "math":
+		return base64Captcha.NewDriverMath(48, 120, 10, lines, nil, nil, captchaFonts)
+	case "chinese":
+		// 逗号分隔的字符表示从中随机选择单个汉字
+		source := strings.Join(strings.Split(base64Captcha.TxtChineseCharaters, ""), ",")
+		return base64Captcha.NewDriverChinese(48, 120, 10, lines, length, source, nil, nil, captchaFonts)
+	case "audio":
+		// 语音验证码用普通话朗读数字，供视力障碍的用户使用
+		return base64Captcha.NewDriverAudio(length, "zh")
+	default:
+		// NewDriverDigit的参数顺序是：height, width, length, maxSkew, dotNoiseCount
+		return base64Captcha.NewDriverDigit(
+			48,     // 高度（参数1：height）
+			120,    // 宽度（参数2：width）
+			length, // 字符数（参数3：length）
+			0.3,    // 干扰系数（参数4：maxSkew）- 适度干扰，既保证安全又清晰
+			30,     // 最大干扰点数（参数5：dotNoiseCount）- 适度干扰点
+		)
+	}
+}
+
+// Type 验证码类型，前端据此显示图片或播放语音
+func (s *CaptchaService) Type() string {
+	return s.config.Type
 }
 
 // GenerateCaptcha 生成验证码
 func (s *CaptchaService) GenerateCaptcha() (string, string, error) {
-	// 配置验证码生成器 - 修复参数顺序，NewDriverDigit的参数顺序是：height, width, length, maxSkew, dotNoiseCount
-	driver := base64Captcha.NewDriverDigit(
-		48,  // 高度（参数1：height）
-		120, // 宽度（参数2：width）
-		4,   // 字符数（参数3：length）
-		0.3, // 干扰系数（参数4：maxSkew）- 适度干扰，既保证安全又清晰
-		30,  // 最大干扰点数（参数5：dotNoiseCount）- 适度干扰点
-	)
-
-	// 创建验证码实例
-	captcha := base64Captcha.NewCaptcha(driver, s)
-
 	// 生成验证码
-	id, b64s, answer, err := captcha.Generate()
+	id, content, answer := s.driver.GenerateIdQuestionAnswer()
+	item, err := s.driver.DrawCaptcha(content)
 	if err != nil {
 		return "", "", fmt.Errorf("生成验证码失败: %w", err)
 	}
 
-	// 存储验证码答案
-	s.store.Store(id, answer)
+	// 存储验证码答案，字母验证码不区分大小写
+	if err := s.store.Save(id, strings.ToLower(answer), time.Duration(s.config.TTL)*time.Second); err != nil {
+		return "", "", fmt.Errorf("保存验证码失败: %w", err)
+	}
 
-	return id, b64s, nil
+	return id, item.EncodeB64string(), nil
 }
 
-// VerifyCaptcha 验证验证码
+// VerifyCaptcha 验证验证码：每次验证都计入尝试次数，达到上限后验证码作废；验证通过后立即删除，只能使用一次
 func (s *CaptchaService) VerifyCaptcha(id, answer string) bool {
+	answer = strings.ToLower(strings.TrimSpace(answer))
 	if id == "" || answer == "" {
 		return false
 	}
 
-	// 从存储中获取验证码答案
-	storedAnswer, exists := s.store.Load(id)
-	if !exists {
+	storedAnswer, attempts, err := s.store.Attempt(id)
+	if err != nil {
+		if !errors.Is(err, errCaptchaNotFound) {
+			log.Printf("读取验证码失败: %v", err)
+		}
 		return false
 	}
 
-	// 比较验证码
-	if storedAnswer != answer {
+	limited := s.config.MaxAttempts > 0
+	if limited && attempts > s.config.MaxAttempts {
+		s.delete(id)
 		return false
 	}
 
-	// 验证成功后删除验证码
-	s.store.Delete(id)
-
-	return true
-}
-
-// Get 实现base64Captcha.Store接口
-func (s *CaptchaService) Get(id string, clear bool) string {
-	value, exists := s.store.Load(id)
-	if !exists {
-		return ""
-	}
-
-	if clear {
-		s.store.Delete(id)
+	// 比较验证码
+	if subtle.ConstantTimeCompare([]byte(storedAnswer), []byte(answer)) != 1 {
+		if limited && attempts >= s.config.MaxAttempts {
+			s.delete(id)
+		}
+		return false
 	}
 
-	if v, ok := value.(string); ok {
-		return v
+	// 同一验证码被并发提交时只有删除成功的请求通过
+	deleted, err := s.store.Delete(id)
+	if err != nil {
+		log.Printf("删除验证码失败: %v", err)
+		return false
 	}
-
-	return ""
+	return deleted
 }
 
-// Set 实现base64Captcha.Store接口
-func (s *CaptchaService) Set(id string, value string) error {
-	s.store.Store(id, value)
-	return nil
-}
-
-// Verify 实现base64Captcha.Store接口
-func (s *CaptchaService) Verify(id, answer string, clear bool) bool {
-	return s.VerifyCaptcha(id, answer)
+// delete 作废验证码
+func (s *CaptchaService) delete(id string) {
+	if _, err := s.store.Delete(id); err != nil {
+		log.Printf("删除验证码失败: %v", err)
+	}
 }
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hangbin2008/sanjicms/pkg/config"
+)
+
+func newTestCaptchaService(captcha config.CaptchaConfig) (*CaptchaService, *MemoryCaptchaStore) {
+	store := NewMemoryCaptchaStore(0)
+	return NewCaptchaServiceWithStore(&config.Config{Captcha: captcha}, store), store
+}
+
+// storedAnswer 读取存储中的答案，不计入尝试次数
+func storedAnswer(t *testing.T, store *MemoryCaptchaStore, id string) string {
+	t.Helper()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	element, ok := store.entries[id]
+	if !ok {
+		t.Fatalf("captcha %q was not saved", id)
+	}
+	return element.Value.(*memoryCaptcha).answer
+}
+
+func TestGenerateCaptchaTypes(t *testing.T) {
+	cases := []struct {
+		typ    string
+		answer *regexp.Regexp
+	}{
+		{"digit", regexp.MustCompile(`^[0-9]{5}$`)},
+		{"", regexp.MustCompile(`^[0-9]{5}$`)},
+		{"string", regexp.MustCompile(`^[a-z0-9]{5}$`)},
+		{"math", regexp.MustCompile(`^-?[0-9]+$`)},
+		{"audio", regexp.MustCompile(`^[0-9]{5}$`)},
+	}
+	for _, c := range cases {
+		captcha, store := newTestCaptchaService(config.CaptchaConfig{Type: c.typ, Length: 5, TTL: 300})
+		id, b64s, err := captcha.GenerateCaptcha()
+		if err != nil {
+			t.Fatalf("%q: %v", c.typ, err)
+		}
+		if id == "" || b64s == "" {
+			t.Fatalf("%q: empty id or content", c.typ)
+		}
+		// 字母验证码保存小写答案，校验时不区分大小写
+		if answer := storedAnswer(t, store, id); !c.answer.MatchString(answer) {
+			t.Errorf("%q: stored answer %q", c.typ, answer)
+		}
+		if captcha.Type() != c.typ {
+			t.Errorf("Type() = %q, want %q", captcha.Type(), c.typ)
+		}
+	}
+
+	captcha, store := newTestCaptchaService(config.CaptchaConfig{Type: "chinese", Length: 3, TTL: 300})
+	id, _, err := captcha.GenerateCaptcha()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer := storedAnswer(t, store, id); utf8.RuneCountInString(answer) != 3 {
+		t.Errorf("chinese answer %q", answer)
+	}
+}
+
+func TestGenerateCaptchaTTL(t *testing.T) {
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	captcha, store := newTestCaptchaService(config.CaptchaConfig{Type: "digit", TTL: 120})
+	store.now = func() time.Time { return now }
+
+	id, _, err := captcha.GenerateCaptcha()
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := storedAnswer(t, store, id)
+	now = now.Add(2 * time.Minute)
+	if captcha.VerifyCaptcha(id, answer) {
+		t.Fatal("expired captcha was accepted")
+	}
+}
+
+func TestVerifyCaptchaSingleUse(t *testing.T) {
+	captcha, store := newTestCaptchaService(config.CaptchaConfig{MaxAttempts: 5})
+	store.Save("c1", "ab3d", time.Minute)
+
+	if captcha.VerifyCaptcha("", "ab3d") || captcha.VerifyCaptcha("c1", "  ") {
+		t.Fatal("empty id or answer was accepted")
+	}
+	if captcha.VerifyCaptcha("c1", "ab3e") {
+		t.Fatal("wrong answer was accepted")
+	}
+	// 错误答案未达到上限时验证码仍可使用；校验不区分大小写并忽略首尾空格
+	if !captcha.VerifyCaptcha("c1", " AB3D ") {
+		t.Fatal("correct answer was rejected")
+	}
+	if captcha.VerifyCaptcha("c1", "ab3d") {
+		t.Fatal("captcha was accepted twice")
+	}
+}
+
+func TestVerifyCaptchaAttemptLimit(t *testing.T) {
+	captcha, store := newTestCaptchaService(config.CaptchaConfig{MaxAttempts: 3})
+	store.Save("c1", "1234", time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if captcha.VerifyCaptcha("c1", "000"+strconv.Itoa(i)) {
+			t.Fatal("wrong answer was accepted")
+		}
+	}
+	// 第3次错误后验证码作废，正确答案也不再通过
+	if _, _, err := store.Attempt("c1"); !errors.Is(err, errCaptchaNotFound) {
+		t.Fatalf("captcha should be deleted after 3 failures, got %v", err)
+	}
+	if captcha.VerifyCaptcha("c1", "1234") {
+		t.Fatal("correct answer accepted after the attempt limit")
+	}
+}
+
+// 同一验证码被并发提交时只有一个请求通过
+func TestVerifyCaptchaConcurrent(t *testing.T) {
+	captcha, store := newTestCaptchaService(config.CaptchaConfig{MaxAttempts: 100})
+	store.Save("c1", "1234", time.Minute)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	passed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if captcha.VerifyCaptcha("c1", "1234") {
+				mu.Lock()
+				passed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if passed != 1 {
+		t.Fatalf("%d requests passed, want 1", passed)
+	}
+}
+
+// failingCaptchaStore 模拟存储不可用
+type failingCaptchaStore struct{ MemoryCaptchaStore }
+
+func (s *failingCaptchaStore) Attempt(string) (string, int, error) {
+	return "", 0, errors.New("connection refused")
+}
+
+func TestVerifyCaptchaStoreError(t *testing.T) {
+	captcha := NewCaptchaServiceWithStore(&config.Config{}, &failingCaptchaStore{})
+	if captcha.VerifyCaptcha("c1", "1234") {
+		t.Fatal("captcha accepted while the store is unavailable")
+	}
+}
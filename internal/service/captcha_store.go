@@ -0,0 +1,228 @@
+package service
+
+import (
+	"container/list"
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/pkg/redis"
+)
+
+// errCaptchaNotFound 验证码不存在、已过期或已使用
+var errCaptchaNotFound = errors.New("验证码不存在或已过期")
+
+// CaptchaStore 验证码存储。内存存储只能用于单实例部署，多个实例共享验证码时使用MySQL或Redis存储
+type CaptchaStore interface {
+	// Save 保存验证码答案，ttl后过期
+	Save(id, answer string, ttl time.Duration) error
+	// Attempt 将尝试次数加一并返回答案和加一后的次数，不存在或已过期时返回errCaptchaNotFound
+	Attempt(id string) (answer string, attempts int, err error)
+	// Delete 删除验证码，返回是否由本次调用删除，用于保证验证码只能使用一次
+	Delete(id string) (bool, error)
+}
+
+// memoryCaptcha 内存中的验证码
+type memoryCaptcha struct {
+	id        string
+	answer    string
+	attempts  int
+	expiresAt time.Time
+}
+
+// MemoryCaptchaStore 内存验证码存储：按生成顺序保存，过期的验证码在保存新验证码时清理，
+// 数量达到上限时淘汰最早生成的验证码
+type MemoryCaptchaStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // 按生成顺序排列，所有验证码有效期相同，因此最早生成的最先过期
+	now        func() time.Time
+}
+
+// NewMemoryCaptchaStore 创建内存验证码存储，maxEntries不大于0时不限制数量
+func NewMemoryCaptchaStore(maxEntries int) *MemoryCaptchaStore {
+	return &MemoryCaptchaStore{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+// Save 保存验证码答案
+func (s *MemoryCaptchaStore) Save(id, answer string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for front := s.order.Front(); front != nil; front = s.order.Front() {
+		if front.Value.(*memoryCaptcha).expiresAt.After(now) &&
+			(s.maxEntries <= 0 || s.order.Len() < s.maxEntries) {
+			break
+		}
+		s.remove(front)
+	}
+
+	if element, ok := s.entries[id]; ok {
+		s.remove(element)
+	}
+	s.entries[id] = s.order.PushBack(&memoryCaptcha{id: id, answer: answer, expiresAt: now.Add(ttl)})
+	return nil
+}
+
+// Attempt 将尝试次数加一并返回答案
+func (s *MemoryCaptchaStore) Attempt(id string) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[id]
+	if !ok {
+		return "", 0, errCaptchaNotFound
+	}
+	captcha := element.Value.(*memoryCaptcha)
+	if !captcha.expiresAt.After(s.now()) {
+		s.remove(element)
+		return "", 0, errCaptchaNotFound
+	}
+	captcha.attempts++
+	return captcha.answer, captcha.attempts, nil
+}
+
+// Delete 删除验证码
+func (s *MemoryCaptchaStore) Delete(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.entries[id]
+	if !ok {
+		return false, nil
+	}
+	s.remove(element)
+	return true, nil
+}
+
+func (s *MemoryCaptchaStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*memoryCaptcha).id)
+}
+
+// captchaPurgeInterval 数据库中过期验证码的清理间隔
+const captchaPurgeInterval = time.Minute
+
+// MySQLCaptchaStore 数据库验证码存储，多个实例共享
+type MySQLCaptchaStore struct {
+	mu        sync.Mutex
+	lastPurge time.Time
+	now       func() time.Time
+}
+
+// NewMySQLCaptchaStore 创建数据库验证码存储
+func NewMySQLCaptchaStore() *MySQLCaptchaStore {
+	return &MySQLCaptchaStore{now: time.Now}
+}
+
+// Save 保存验证码答案，每分钟最多清理一次过期的验证码
+func (s *MySQLCaptchaStore) Save(id, answer string, ttl time.Duration) error {
+	now := s.now()
+
+	s.mu.Lock()
+	purge := now.Sub(s.lastPurge) >= captchaPurgeInterval
+	if purge {
+		s.lastPurge = now
+	}
+	s.mu.Unlock()
+	if purge {
+		if _, err := db.DB.Exec("DELETE FROM captchas WHERE expires_at <= ?", now); err != nil {
+			return err
+		}
+	}
+
+	_, err := db.DB.Exec("INSERT INTO captchas (id, answer, expires_at) VALUES (?, ?, ?)", id, answer, now.Add(ttl))
+	return err
+}
+
+// Attempt 将尝试次数加一并返回答案
+func (s *MySQLCaptchaStore) Attempt(id string) (string, int, error) {
+	now := s.now()
+	result, err := db.DB.Exec("UPDATE captchas SET attempts = attempts + 1 WHERE id = ? AND expires_at > ?", id, now)
+	if err != nil {
+		return "", 0, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return "", 0, errCaptchaNotFound
+	}
+
+	var answer string
+	var attempts int
+	err = db.DB.QueryRow("SELECT answer, attempts FROM captchas WHERE id = ?", id).Scan(&answer, &attempts)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, errCaptchaNotFound
+	}
+	return answer, attempts, err
+}
+
+// Delete 删除验证码
+func (s *MySQLCaptchaStore) Delete(id string) (bool, error) {
+	result, err := db.DB.Exec("DELETE FROM captchas WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Redis中的验证码保存为哈希，answer为答案，attempts为尝试次数，由键的过期时间控制有效期
+const (
+	redisCaptchaPrefix = "captcha:"
+
+	redisCaptchaSave = `redis.call('HSET', KEYS[1], 'answer', ARGV[1], 'attempts', 0)
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1`
+
+	// 键不存在时不能执行HINCRBY，否则会创建一个没有过期时间的键
+	redisCaptchaAttempt = `if redis.call('EXISTS', KEYS[1]) == 0 then return false end
+local attempts = redis.call('HINCRBY', KEYS[1], 'attempts', 1)
+return {redis.call('HGET', KEYS[1], 'answer'), attempts}`
+)
+
+// RedisCaptchaStore Redis验证码存储，多个实例共享
+type RedisCaptchaStore struct {
+	client *redis.Client
+}
+
+// NewRedisCaptchaStore 创建Redis验证码存储
+func NewRedisCaptchaStore(client *redis.Client) *RedisCaptchaStore {
+	return &RedisCaptchaStore{client: client}
+}
+
+// Save 保存验证码答案
+func (s *RedisCaptchaStore) Save(id, answer string, ttl time.Duration) error {
+	_, err := s.client.Do("EVAL", redisCaptchaSave, "1", redisCaptchaPrefix+id,
+		answer, strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Attempt 将尝试次数加一并返回答案
+func (s *RedisCaptchaStore) Attempt(id string) (string, int, error) {
+	reply, err := s.client.Do("EVAL", redisCaptchaAttempt, "1", redisCaptchaPrefix+id)
+	if err != nil {
+		return "", 0, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return "", 0, errCaptchaNotFound
+	}
+	answer, _ := values[0].(string)
+	attempts, _ := values[1].(int64)
+	return answer, int(attempts), nil
+}
+
+// Delete 删除验证码
+func (s *RedisCaptchaStore) Delete(id string) (bool, error) {
+	n, err := s.client.Int("DEL", redisCaptchaPrefix+id)
+	return n > 0, err
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/pkg/redis"
+	"github.com/hangbin2008/sanjicms/pkg/redis/redistest"
+)
+
+func TestMemoryCaptchaStore(t *testing.T) {
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	store := NewMemoryCaptchaStore(0)
+	store.now = func() time.Time { return now }
+
+	if err := store.Save("c1", "abcd", 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for want := 1; want <= 2; want++ {
+		answer, attempts, err := store.Attempt("c1")
+		if err != nil || answer != "abcd" || attempts != want {
+			t.Fatalf("Attempt = %q %d %v", answer, attempts, err)
+		}
+	}
+
+	// 重新保存同一ID时答案和尝试次数都重置
+	store.Save("c1", "efgh", 5*time.Minute)
+	if answer, attempts, err := store.Attempt("c1"); err != nil || answer != "efgh" || attempts != 1 {
+		t.Fatalf("after replace: %q %d %v", answer, attempts, err)
+	}
+
+	// 只能删除一次
+	if deleted, err := store.Delete("c1"); err != nil || !deleted {
+		t.Fatalf("first Delete = %v %v", deleted, err)
+	}
+	if deleted, err := store.Delete("c1"); err != nil || deleted {
+		t.Fatalf("second Delete = %v %v", deleted, err)
+	}
+	if _, _, err := store.Attempt("c1"); !errors.Is(err, errCaptchaNotFound) {
+		t.Fatalf("Attempt after Delete: %v", err)
+	}
+
+	// 到达过期时间后不可用
+	store.Save("c2", "1234", 5*time.Minute)
+	now = now.Add(5 * time.Minute)
+	if _, _, err := store.Attempt("c2"); !errors.Is(err, errCaptchaNotFound) {
+		t.Fatalf("expired Attempt: %v", err)
+	}
+	if deleted, _ := store.Delete("c2"); deleted {
+		t.Error("expired captcha should have been removed")
+	}
+}
+
+func TestMemoryCaptchaStoreEviction(t *testing.T) {
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	store := NewMemoryCaptchaStore(3)
+	store.now = func() time.Time { return now }
+
+	for i := 1; i <= 4; i++ {
+		store.Save("c"+strconv.Itoa(i), "answer", 5*time.Minute)
+		now = now.Add(time.Minute)
+	}
+	// 达到上限时淘汰最早生成的验证码
+	if _, _, err := store.Attempt("c1"); !errors.Is(err, errCaptchaNotFound) {
+		t.Fatalf("oldest captcha should be evicted, got %v", err)
+	}
+	if _, _, err := store.Attempt("c4"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 保存新验证码时清理已过期的验证码：c2、c3分别在9:06、9:07过期
+	now = time.Date(2026, 10, 18, 9, 7, 0, 0, time.Local)
+	store.Save("c5", "answer", 5*time.Minute)
+	if store.order.Len() != 2 || len(store.entries) != 2 {
+		t.Fatalf("got %d entries, want c4 and c5", store.order.Len())
+	}
+}
+
+func TestMySQLCaptchaStore(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	store := NewMySQLCaptchaStore()
+	store.now = func() time.Time { return now }
+	purge := regexp.QuoteMeta("DELETE FROM captchas WHERE expires_at <= ?")
+	insert := regexp.QuoteMeta("INSERT INTO captchas (id, answer, expires_at) VALUES (?, ?, ?)")
+
+	// 第一次保存时清理过期验证码，一分钟内不再清理
+	mock.ExpectExec(purge).WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(insert).WithArgs("c1", "abcd", now.Add(5*time.Minute)).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.Save("c1", "abcd", 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(59 * time.Second)
+	mock.ExpectExec(insert).WithArgs("c2", "efgh", now.Add(5*time.Minute)).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.Save("c2", "efgh", 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Second)
+	mock.ExpectExec(purge).WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(insert).WithArgs("c3", "ijkl", now.Add(5*time.Minute)).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := store.Save("c3", "ijkl", 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	update := regexp.QuoteMeta("UPDATE captchas SET attempts = attempts + 1 WHERE id = ? AND expires_at > ?")
+	mock.ExpectExec(update).WithArgs("c1", now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT answer, attempts FROM captchas WHERE id = ?")).WithArgs("c1").
+		WillReturnRows(sqlmock.NewRows([]string{"answer", "attempts"}).AddRow("abcd", 1))
+	if answer, attempts, err := store.Attempt("c1"); err != nil || answer != "abcd" || attempts != 1 {
+		t.Fatalf("Attempt = %q %d %v", answer, attempts, err)
+	}
+
+	// 已过期或不存在的验证码不更新任何行
+	mock.ExpectExec(update).WithArgs("expired", now).WillReturnResult(sqlmock.NewResult(0, 0))
+	if _, _, err := store.Attempt("expired"); !errors.Is(err, errCaptchaNotFound) {
+		t.Fatalf("expired Attempt: %v", err)
+	}
+
+	// 并发校验时只有一次删除成功
+	del := regexp.QuoteMeta("DELETE FROM captchas WHERE id = ?")
+	mock.ExpectExec(del).WithArgs("c1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(del).WithArgs("c1").WillReturnResult(sqlmock.NewResult(0, 0))
+	if deleted, err := store.Delete("c1"); err != nil || !deleted {
+		t.Fatalf("first Delete = %v %v", deleted, err)
+	}
+	if deleted, err := store.Delete("c1"); err != nil || deleted {
+		t.Fatalf("second Delete = %v %v", deleted, err)
+	}
+}
+
+// newTestRedisCaptchaStore 启动进程内Redis服务器，注册与验证码Lua脚本逐行对应的实现
+func newTestRedisCaptchaStore(t *testing.T) (*RedisCaptchaStore, *redistest.Server) {
+	t.Helper()
+	server := redistest.NewServer()
+	t.Cleanup(server.Close)
+
+	server.Script(redisCaptchaSave, func(call func(...string) (interface{}, error), keys, argv []string) (interface{}, error) {
+		if _, err := call("HSET", keys[0], "answer", argv[0], "attempts", "0"); err != nil {
+			return nil, err
+		}
+		if _, err := call("PEXPIRE", keys[0], argv[1]); err != nil {
+			return nil, err
+		}
+		return int64(1), nil
+	})
+	server.Script(redisCaptchaAttempt, func(call func(...string) (interface{}, error), keys, argv []string) (interface{}, error) {
+		exists, err := call("EXISTS", keys[0])
+		if err != nil {
+			return nil, err
+		}
+		if exists == int64(0) {
+			return nil, nil
+		}
+		attempts, err := call("HINCRBY", keys[0], "attempts", "1")
+		if err != nil {
+			return nil, err
+		}
+		answer, err := call("HGET", keys[0], "answer")
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{answer, attempts}, nil
+	})
+
+	client := redis.NewClient(redis.Options{Addr: server.Addr, Timeout: 2 * time.Second})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisCaptchaStore(client), server
+}
+
+func TestRedisCaptchaStore(t *testing.T) {
+	store, server := newTestRedisCaptchaStore(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	server.SetNow(func() time.Time { return now })
+
+	if err := store.Save("c1", "abcd", 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok := server.TTL(0, "captcha:c1"); !ok || ttl != 5*time.Minute {
+		t.Fatalf("TTL = %v %v", ttl, ok)
+	}
+	for want := 1; want <= 2; want++ {
+		answer, attempts, err := store.Attempt("c1")
+		if err != nil || answer != "abcd" || attempts != want {
+			t.Fatalf("Attempt = %q %d %v", answer, attempts, err)
+		}
+	}
+
+	// 只能删除一次
+	if deleted, err := store.Delete("c1"); err != nil || !deleted {
+		t.Fatalf("first Delete = %v %v", deleted, err)
+	}
+	if deleted, err := store.Delete("c1"); err != nil || deleted {
+		t.Fatalf("second Delete = %v %v", deleted, err)
+	}
+	if _, _, err := store.Attempt("c1"); !errors.Is(err, errCaptchaNotFound) {
+		t.Fatalf("Attempt after Delete: %v", err)
+	}
+	// 对不存在的验证码尝试不会创建没有过期时间的键
+	if _, ok := server.TTL(0, "captcha:c1"); ok {
+		t.Fatal("Attempt recreated a deleted captcha")
+	}
+}
+
+func TestRedisCaptchaStoreExpiry(t *testing.T) {
+	store, server := newTestRedisCaptchaStore(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	server.SetNow(func() time.Time { return now })
+
+	if err := store.Save("c1", "abcd", 5*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	server.SetNow(func() time.Time { return now.Add(5*time.Minute - time.Millisecond) })
+	if _, _, err := store.Attempt("c1"); err != nil {
+		t.Fatalf("Attempt before expiry: %v", err)
+	}
+
+	server.SetNow(func() time.Time { return now.Add(5 * time.Minute) })
+	if _, _, err := store.Attempt("c1"); !errors.Is(err, errCaptchaNotFound) {
+		t.Fatalf("expired Attempt: %v", err)
+	}
+	if _, ok := server.TTL(0, "captcha:c1"); ok {
+		t.Fatal("Attempt recreated an expired captcha")
+	}
+	if deleted, err := store.Delete("c1"); err != nil || deleted {
+		t.Fatalf("Delete of expired captcha = %v %v", deleted, err)
+	}
+}
+
+func TestRedisCaptchaStoreUnavailable(t *testing.T) {
+	store, server := newTestRedisCaptchaStore(t)
+	server.Close()
+
+	if err := store.Save("c1", "abcd", time.Minute); err == nil {
+		t.Error("Save should fail when Redis is down")
+	}
+	// 连接失败不能当作验证码不存在处理
+	if _, _, err := store.Attempt("c1"); err == nil || errors.Is(err, errCaptchaNotFound) {
+		t.Errorf("Attempt = %v, want connection error", err)
+	}
+}
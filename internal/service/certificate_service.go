@@ -0,0 +1,204 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/pdf"
+)
+
+// ErrNotPassed 考试未及格，不能签发证书
+var ErrNotPassed = errors.New("考试未及格，不能签发证书")
+
+// CertificateService 合格证书服务
+type CertificateService struct {
+	config *config.Config
+}
+
+// NewCertificateService 创建合格证书服务
+func NewCertificateService(cfg *config.Config) *CertificateService {
+	return &CertificateService{
+		config: cfg,
+	}
+}
+
+const certificateColumns = `id, record_id, user_id, exam_id, code, user_name, COALESCE(department, ''),
+	exam_title, score, total_score, issued_at, created_at`
+
+// IssueForRecord 为已评分且及格的考试记录签发证书，已签发的直接返回原证书
+func (s *CertificateService) IssueForRecord(recordID int) (*models.Certificate, error) {
+	if cert, err := s.getCertificate("record_id = ?", recordID); err == nil {
+		return cert, nil
+	}
+
+	// 读取考试记录、考生和试卷信息作为证书快照
+	var cert models.Certificate
+	var status string
+	err := db.DB.QueryRow(`
+		SELECT r.id, r.user_id, r.exam_id, r.status, r.total_score, r.end_time,
+		       u.name, COALESCE(u.department, ''), e.title, e.total_score
+		FROM exam_records r
+		JOIN users u ON r.user_id = u.id
+		JOIN exams e ON r.exam_id = e.id
+		WHERE r.id = ?
+	`, recordID).Scan(
+		&cert.RecordID, &cert.UserID, &cert.ExamID, &status, &cert.Score, &cert.IssuedAt,
+		&cert.UserName, &cert.Department, &cert.ExamTitle, &cert.TotalScore,
+	)
+	if err != nil {
+		return nil, errors.New("考试记录不存在")
+	}
+
+	if status != "graded" {
+		return nil, errors.New("考试尚未评分")
+	}
+	if !IsPassed(cert.Score, cert.TotalScore) {
+		return nil, ErrNotPassed
+	}
+
+	// 证书日期取交卷时间，精确到秒，保证重复打印一致
+	cert.IssuedAt = cert.IssuedAt.Truncate(time.Second)
+
+	for i := 0; i < 3; i++ {
+		cert.Code, err = generateCertificateCode()
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = db.DB.Exec(`
+			INSERT INTO certificates (record_id, user_id, exam_id, code, user_name, department, exam_title, score, total_score, issued_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, cert.RecordID, cert.UserID, cert.ExamID, cert.Code, cert.UserName, cert.Department,
+			cert.ExamTitle, cert.Score, cert.TotalScore, cert.IssuedAt)
+		if err == nil {
+			break
+		}
+
+		// 并发签发时以先写入的证书为准
+		if existing, getErr := s.getCertificate("record_id = ?", recordID); getErr == nil {
+			return existing, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("签发证书失败: %w", err)
+	}
+
+	return s.getCertificate("record_id = ?", recordID)
+}
+
+// GetCertificateByID 根据ID获取证书
+func (s *CertificateService) GetCertificateByID(id int) (*models.Certificate, error) {
+	return s.getCertificate("id = ?", id)
+}
+
+// GetCertificateByCode 根据验证码获取证书
+func (s *CertificateService) GetCertificateByCode(code string) (*models.Certificate, error) {
+	return s.getCertificate("code = ?", strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// ListCertificates 获取用户的全部证书
+func (s *CertificateService) ListCertificates(userID int) ([]models.Certificate, error) {
+	rows, err := db.DB.Query(`SELECT `+certificateColumns+`
+		FROM certificates WHERE user_id = ?
+		ORDER BY issued_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var certs []models.Certificate
+	for rows.Next() {
+		var cert models.Certificate
+		err := rows.Scan(
+			&cert.ID, &cert.RecordID, &cert.UserID, &cert.ExamID, &cert.Code, &cert.UserName, &cert.Department,
+			&cert.ExamTitle, &cert.Score, &cert.TotalScore, &cert.IssuedAt, &cert.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
+
+// VerifyURL 获取证书的公开验证地址
+func (s *CertificateService) VerifyURL(code string) string {
+	return s.config.App.BaseURL + "/certificates/verify/" + code
+}
+
+// WriteCertificatePDF 将证书写为PDF，内容只取自证书快照，重复打印结果一致
+func (s *CertificateService) WriteCertificatePDF(w io.Writer, cert *models.Certificate) error {
+	doc := pdf.New()
+	doc.SetInfo(cert.ExamTitle+" 合格证书", s.config.App.Name, cert.IssuedAt)
+
+	page := doc.AddPage()
+	center := pdf.PageWidth / 2
+
+	page.Rect(40, 40, pdf.PageWidth-80, pdf.PageHeight-80, 2)
+	page.Rect(48, 48, pdf.PageWidth-96, pdf.PageHeight-96, 0.6)
+
+	page.TextCenter(center, 170, 36, "合 格 证 书")
+	page.TextCenter(center, 210, 12, "Certificate of Completion")
+
+	y := 300.0
+	name := cert.UserName
+	if cert.Department != "" {
+		name = cert.Department + " " + cert.UserName
+	}
+	page.TextCenter(center, y, 16, name+" 同志：")
+	y += 50
+	page.TextCenter(center, y, 16, fmt.Sprintf("于%s参加", cert.IssuedAt.Format("2006年01月02日")))
+	y += 36
+	page.TextCenter(center, y, 20, "《"+cert.ExamTitle+"》")
+	y += 40
+	page.TextCenter(center, y, 16, fmt.Sprintf("成绩 %.1f 分（满分 %.0f 分），考核合格，特发此证。", cert.Score, cert.TotalScore))
+
+	y = 620
+	page.Text(340, y, 14, "发证单位（盖章）")
+	y += 30
+	page.Text(340, y, 14, cert.IssuedAt.Format("2006年01月02日"))
+
+	y = 730
+	page.Text(80, y, 10.5, "证书编号："+cert.Code)
+	y += 18
+	page.Text(80, y, 10.5, "验证地址："+s.VerifyURL(cert.Code))
+
+	return doc.Write(w)
+}
+
+func (s *CertificateService) getCertificate(where string, arg interface{}) (*models.Certificate, error) {
+	var cert models.Certificate
+	err := db.DB.QueryRow(`SELECT `+certificateColumns+` FROM certificates WHERE `+where, arg).Scan(
+		&cert.ID, &cert.RecordID, &cert.UserID, &cert.ExamID, &cert.Code, &cert.UserName, &cert.Department,
+		&cert.ExamTitle, &cert.Score, &cert.TotalScore, &cert.IssuedAt, &cert.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+// generateCertificateCode 生成形如 ABCD-EFGH-JKLM 的证书验证码
+func generateCertificateCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	raw := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)[:12]
+	return raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12], nil
+}
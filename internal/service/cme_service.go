@@ -0,0 +1,350 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+)
+
+// 学分来源类型
+const (
+	CreditSourceExam         = "exam"
+	CreditSourcePracticeGoal = "practice_goal"
+)
+
+// CMEService 继续医学教育学分服务
+type CMEService struct{}
+
+// NewCMEService 创建继续医学教育学分服务
+func NewCMEService() *CMEService {
+	return &CMEService{}
+}
+
+// SetExamCredits 设置试卷的学分，修改记入审计日志
+func (s *CMEService) SetExamCredits(op Operator, examID int, credits float64) error {
+	if credits < 0 {
+		return errors.New("学分不能为负数")
+	}
+
+	var before float64
+	err := db.DB.QueryRow("SELECT cme_credits FROM exams WHERE id = ?", examID).Scan(&before)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("试卷不存在")
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.DB.Exec("UPDATE exams SET cme_credits = ? WHERE id = ?", credits, examID); err != nil {
+		return err
+	}
+
+	logAudit(op, auditEvent{
+		Action:     models.AuditExamCredits,
+		EntityType: models.AuditEntityExam,
+		EntityID:   examID,
+		Before:     map[string]interface{}{"cme_credits": before},
+		After:      map[string]interface{}{"cme_credits": credits},
+	})
+	return nil
+}
+
+// AccrueExamCredits 考试记录评分后，及格的按试卷学分计入台账，重复调用不会重复计分
+func (s *CMEService) AccrueExamCredits(recordID int) error {
+	var userID int
+	var status, title string
+	var score, totalScore, credits float64
+	var endTime sql.NullTime
+	err := db.DB.QueryRow(`
+		SELECT r.user_id, r.status, r.total_score, r.end_time, e.title, e.total_score, e.cme_credits
+		FROM exam_records r
+		JOIN exams e ON r.exam_id = e.id
+		WHERE r.id = ?
+	`, recordID).Scan(&userID, &status, &score, &endTime, &title, &totalScore, &credits)
+	if err != nil {
+		return err
+	}
+
+	if status != "graded" || credits <= 0 || !endTime.Valid || !IsPassed(score, totalScore) {
+		return nil
+	}
+
+	_, err = db.DB.Exec(`
+		INSERT IGNORE INTO cme_credit_ledger (user_id, year, source_type, source_id, credits, description)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, userID, endTime.Time.Year(), CreditSourceExam, recordID, credits, "考试合格："+title)
+	return err
+}
+
+// AccruePracticeGoals 检查用户当年的练习量，达到练习目标的计入学分。
+// 练习量按作答过的不同题目数统计，反复提交同一道题不能累积学分
+func (s *CMEService) AccruePracticeGoals(userID, year int) error {
+	var questions int
+	err := db.DB.QueryRow(`
+		SELECT COUNT(DISTINCT question_id) FROM practice_answers
+		WHERE user_id = ? AND YEAR(created_at) = ?
+	`, userID, year).Scan(&questions)
+	if err != nil {
+		return err
+	}
+
+	goals, err := s.ListPracticeGoals(year)
+	if err != nil {
+		return err
+	}
+
+	for _, goal := range goals {
+		if goal.Credits <= 0 || questions < goal.RequiredQuestions {
+			continue
+		}
+		_, err := db.DB.Exec(`
+			INSERT IGNORE INTO cme_credit_ledger (user_id, year, source_type, source_id, credits, description)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, userID, year, CreditSourcePracticeGoal, goal.ID, goal.Credits, "完成练习目标："+goal.Title)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreatePracticeGoal 创建练习目标
+func (s *CMEService) CreatePracticeGoal(req *models.PracticeGoalCreateRequest, createdBy int) (*models.PracticeGoal, error) {
+	if req.Credits < 0 {
+		return nil, errors.New("学分不能为负数")
+	}
+
+	result, err := db.DB.Exec(`
+		INSERT INTO practice_goals (title, year, required_questions, credits, created_by)
+		VALUES (?, ?, ?, ?, ?)
+	`, req.Title, req.Year, req.RequiredQuestions, req.Credits, createdBy)
+	if err != nil {
+		return nil, err
+	}
+
+	goalID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	var goal models.PracticeGoal
+	err = db.DB.QueryRow(`
+		SELECT id, title, year, required_questions, credits, created_by, created_at, updated_at
+		FROM practice_goals WHERE id = ?
+	`, goalID).Scan(
+		&goal.ID, &goal.Title, &goal.Year, &goal.RequiredQuestions, &goal.Credits,
+		&goal.CreatedBy, &goal.CreatedAt, &goal.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &goal, nil
+}
+
+// ListPracticeGoals 获取年度练习目标
+func (s *CMEService) ListPracticeGoals(year int) ([]models.PracticeGoal, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, title, year, required_questions, credits, created_by, created_at, updated_at
+		FROM practice_goals WHERE year = ?
+		ORDER BY required_questions
+	`, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var goals []models.PracticeGoal
+	for rows.Next() {
+		var goal models.PracticeGoal
+		err := rows.Scan(
+			&goal.ID, &goal.Title, &goal.Year, &goal.RequiredQuestions, &goal.Credits,
+			&goal.CreatedBy, &goal.CreatedAt, &goal.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		goals = append(goals, goal)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return goals, nil
+}
+
+// SetCreditTarget 设置年度学分要求，已存在的按年度和职称覆盖
+func (s *CMEService) SetCreditTarget(req *models.CreditTargetRequest) error {
+	if req.RequiredCredits < 0 {
+		return errors.New("学分不能为负数")
+	}
+
+	_, err := db.DB.Exec(`
+		INSERT INTO cme_credit_targets (year, job_title, required_credits)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE required_credits = VALUES(required_credits)
+	`, req.Year, req.JobTitle, req.RequiredCredits)
+	return err
+}
+
+// ListCreditTargets 获取年度学分要求
+func (s *CMEService) ListCreditTargets(year int) ([]models.CreditTarget, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, year, job_title, required_credits, created_at, updated_at
+		FROM cme_credit_targets WHERE year = ?
+		ORDER BY job_title
+	`, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []models.CreditTarget
+	for rows.Next() {
+		var target models.CreditTarget
+		err := rows.Scan(
+			&target.ID, &target.Year, &target.JobTitle, &target.RequiredCredits,
+			&target.CreatedAt, &target.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
+// GetLedger 获取用户年度学分台账
+func (s *CMEService) GetLedger(userID, year int) (*models.CreditLedger, error) {
+	var jobTitle string
+	err := db.DB.QueryRow("SELECT COALESCE(job_title, '') FROM users WHERE id = ?", userID).Scan(&jobTitle)
+	if err != nil {
+		return nil, errors.New("用户不存在")
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT id, user_id, year, source_type, source_id, credits, COALESCE(description, ''), created_at
+		FROM cme_credit_ledger
+		WHERE user_id = ? AND year = ?
+		ORDER BY created_at
+	`, userID, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ledger := &models.CreditLedger{
+		UserID:  userID,
+		Year:    year,
+		Entries: []models.CreditLedgerEntry{},
+	}
+	for rows.Next() {
+		var entry models.CreditLedgerEntry
+		err := rows.Scan(
+			&entry.ID, &entry.UserID, &entry.Year, &entry.SourceType, &entry.SourceID,
+			&entry.Credits, &entry.Description, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		ledger.EarnedCredits += entry.Credits
+		ledger.Entries = append(ledger.Entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	targets, err := s.targetsByJobTitle(year)
+	if err != nil {
+		return nil, err
+	}
+	ledger.RequiredCredits = requiredCredits(targets, jobTitle)
+	if ledger.RequiredCredits > ledger.EarnedCredits {
+		ledger.Remaining = ledger.RequiredCredits - ledger.EarnedCredits
+	}
+
+	return ledger, nil
+}
+
+// ListCreditShortfalls 获取年度学分未达标的在职人员，departmentID大于0时只统计该科室及其下级科室
+func (s *CMEService) ListCreditShortfalls(year int, departmentID int) ([]models.CreditShortfall, error) {
+	targets, err := s.targetsByJobTitle(year)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT u.id, u.name, COALESCE(u.department, ''), COALESCE(u.job_title, ''), COALESCE(SUM(l.credits), 0)
+		FROM users u
+		LEFT JOIN cme_credit_ledger l ON l.user_id = u.id AND l.year = ?
+		WHERE u.status = 1
+	`
+	args := []interface{}{year}
+	if departmentID > 0 {
+		subtree := SubtreeCondition("u.department_id", departmentID)
+		query += " AND " + subtree.SQL
+		args = append(args, subtree.Args...)
+	}
+	query += " GROUP BY u.id, u.name, u.department, u.job_title ORDER BY u.department, u.name"
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shortfalls := []models.CreditShortfall{}
+	for rows.Next() {
+		var item models.CreditShortfall
+		err := rows.Scan(&item.UserID, &item.Name, &item.Department, &item.JobTitle, &item.EarnedCredits)
+		if err != nil {
+			return nil, err
+		}
+
+		item.RequiredCredits = requiredCredits(targets, item.JobTitle)
+		if item.RequiredCredits <= 0 || item.EarnedCredits >= item.RequiredCredits {
+			continue
+		}
+		item.Remaining = item.RequiredCredits - item.EarnedCredits
+		shortfalls = append(shortfalls, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return shortfalls, nil
+}
+
+// targetsByJobTitle 获取年度学分要求，按职称索引
+func (s *CMEService) targetsByJobTitle(year int) (map[string]float64, error) {
+	targets, err := s.ListCreditTargets(year)
+	if err != nil {
+		return nil, fmt.Errorf("获取学分要求失败: %w", err)
+	}
+
+	byJobTitle := make(map[string]float64, len(targets))
+	for _, target := range targets {
+		byJobTitle[target.JobTitle] = target.RequiredCredits
+	}
+	return byJobTitle, nil
+}
+
+// requiredCredits 获取职称对应的学分要求，未单独配置的使用默认要求
+func requiredCredits(targets map[string]float64, jobTitle string) float64 {
+	if credits, ok := targets[jobTitle]; ok {
+		return credits
+	}
+	return targets[""]
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/internal/models"
+)
+
+func TestSetExamCreditsAudited(t *testing.T) {
+	mock := mockDB(t)
+	cme := NewCMEService()
+	op := Operator{UserID: 1, Username: "admin", IP: "10.0.0.1"}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT cme_credits FROM exams WHERE id = ?")).WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"cme_credits"}).AddRow(1.0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE exams SET cme_credits = ? WHERE id = ?")).WithArgs(2.5, 9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs")).
+		WithArgs(1, "admin", "10.0.0.1", models.AuditExamCredits, models.AuditEntityExam, 9,
+			`{"before":{"cme_credits":1},"after":{"cme_credits":2.5}}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := cme.SetExamCredits(op, 9, 2.5); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT cme_credits FROM exams WHERE id = ?")).WithArgs(404).
+		WillReturnRows(sqlmock.NewRows([]string{"cme_credits"}))
+	if err := cme.SetExamCredits(op, 404, 1); err == nil || err.Error() != "试卷不存在" {
+		t.Fatalf("got %v", err)
+	}
+	if err := cme.SetExamCredits(op, 9, -1); err == nil {
+		t.Fatal("学分为负数时应返回错误")
+	}
+}
+
+// 练习目标按作答过的不同题目数统计，反复提交同一道题不计入
+func TestAccruePracticeGoalsCountsDistinctQuestions(t *testing.T) {
+	mock := mockDB(t)
+	cme := NewCMEService()
+	now := time.Now()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(DISTINCT question_id) FROM practice_answers")).WithArgs(7, 2026).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(30))
+	mock.ExpectQuery(regexp.QuoteMeta("FROM practice_goals WHERE year = ?")).WithArgs(2026).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "year", "required_questions", "credits", "created_by", "created_at", "updated_at"}).
+			AddRow(1, "基础练习", 2026, 30, 1.0, 1, now, now).
+			AddRow(2, "进阶练习", 2026, 50, 2.0, 1, now, now))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT IGNORE INTO cme_credit_ledger")).
+		WithArgs(7, 2026, CreditSourcePracticeGoal, 1, 1.0, "完成练习目标：基础练习").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := cme.AccruePracticeGoals(7, 2026); err != nil {
+		t.Fatal(err)
+	}
+}
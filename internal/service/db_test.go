@@ -0,0 +1,43 @@
+package service
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/internal/db"
+)
+
+// mockDB 用sqlmock替换全局数据库连接，测试结束时检查预期的SQL是否全部执行
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := db.DB
+	db.DB = conn
+	t.Cleanup(func() {
+		db.DB = old
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+	return mock
+}
+
+// capturedArg 匹配任意参数并记录实际的值，用于取得服务内部生成的state、验证码等随机值
+type capturedArg struct {
+	value driver.Value
+}
+
+func (a *capturedArg) Match(v driver.Value) bool {
+	a.value = v
+	return true
+}
+
+func (a *capturedArg) String() string {
+	return fmt.Sprint(a.value)
+}
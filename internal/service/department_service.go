@@ -0,0 +1,498 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+)
+
+// departmentLevelRank 科室层级顺序，下级科室的层级必须低于上级科室
+var departmentLevelRank = map[string]int{
+	models.DepartmentLevelHospital: 1,
+	models.DepartmentLevelDivision: 2,
+	models.DepartmentLevelWard:     3,
+}
+
+// DepartmentService 科室服务
+type DepartmentService struct{}
+
+// NewDepartmentService 创建科室服务
+func NewDepartmentService() *DepartmentService {
+	return &DepartmentService{}
+}
+
+const departmentColumns = "id, COALESCE(parent_id, 0), name, level, path, sort_order, created_at, updated_at"
+
+// CreateDepartment 创建科室
+func (s *DepartmentService) CreateDepartment(op Operator, req *models.DepartmentCreateRequest) (*models.Department, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, errors.New("科室名称不能为空")
+	}
+
+	parentPath := "/"
+	if req.ParentID > 0 {
+		parent, err := s.GetDepartment(req.ParentID)
+		if err != nil {
+			return nil, errors.New("上级科室不存在")
+		}
+		if departmentLevelRank[req.Level] <= departmentLevelRank[parent.Level] {
+			return nil, errors.New("下级科室的层级必须低于上级科室")
+		}
+		parentPath = parent.Path
+	}
+
+	if err := s.checkNameAvailable(req.ParentID, name, 0); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO departments (parent_id, name, level, sort_order)
+		VALUES (?, ?, ?, ?)
+	`, nullableID(req.ParentID), name, req.Level, req.SortOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	departmentID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	path := fmt.Sprintf("%s%d/", parentPath, departmentID)
+	_, err = tx.Exec("UPDATE departments SET path = ? WHERE id = ?", path, departmentID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = recordAudit(tx, op, auditEvent{
+		Action:     models.AuditDepartmentCreate,
+		EntityType: models.AuditEntityDepartment,
+		EntityID:   int(departmentID),
+		After: departmentAuditFields(&models.Department{
+			ParentID: req.ParentID, Name: name, Level: req.Level, Path: path, SortOrder: req.SortOrder,
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetDepartment(int(departmentID))
+}
+
+// UpdateDepartment 更新科室，支持改名、调整层级和移动到其他上级科室
+func (s *DepartmentService) UpdateDepartment(op Operator, departmentID int, req *models.DepartmentUpdateRequest) (*models.Department, error) {
+	dept, err := s.GetDepartment(departmentID)
+	if err != nil {
+		return nil, errors.New("科室不存在")
+	}
+
+	name := dept.Name
+	if req.Name != nil {
+		name = strings.TrimSpace(*req.Name)
+		if name == "" {
+			return nil, errors.New("科室名称不能为空")
+		}
+	}
+	level := dept.Level
+	if req.Level != nil {
+		level = *req.Level
+	}
+	sortOrder := dept.SortOrder
+	if req.SortOrder != nil {
+		sortOrder = *req.SortOrder
+	}
+	parentID := dept.ParentID
+	if req.ParentID != nil {
+		parentID = *req.ParentID
+	}
+
+	// 校验上级科室：不能移动到自身或自身的下级科室
+	newPath := fmt.Sprintf("/%d/", dept.ID)
+	if parentID > 0 {
+		parent, err := s.GetDepartment(parentID)
+		if err != nil {
+			return nil, errors.New("上级科室不存在")
+		}
+		if strings.HasPrefix(parent.Path, dept.Path) {
+			return nil, errors.New("不能移动到自身或下级科室")
+		}
+		if departmentLevelRank[level] <= departmentLevelRank[parent.Level] {
+			return nil, errors.New("下级科室的层级必须低于上级科室")
+		}
+		newPath = fmt.Sprintf("%s%d/", parent.Path, dept.ID)
+	}
+
+	// 校验下级科室的层级
+	var childRank int
+	err = db.DB.QueryRow(`
+		SELECT COALESCE(MIN(CASE level WHEN 'hospital' THEN 1 WHEN 'division' THEN 2 ELSE 3 END), 0)
+		FROM departments WHERE parent_id = ?
+	`, dept.ID).Scan(&childRank)
+	if err != nil {
+		return nil, err
+	}
+	if childRank > 0 && childRank <= departmentLevelRank[level] {
+		return nil, errors.New("科室层级必须高于其下级科室")
+	}
+
+	if err := s.checkNameAvailable(parentID, name, dept.ID); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE departments SET parent_id = ?, name = ?, level = ?, sort_order = ?
+		WHERE id = ?
+	`, nullableID(parentID), name, level, sortOrder, dept.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 移动科室时同步更新整棵子树的路径
+	if newPath != dept.Path {
+		_, err = tx.Exec(`
+			UPDATE departments SET path = CONCAT(?, SUBSTRING(path, ?))
+			WHERE path LIKE ?
+		`, newPath, len(dept.Path)+1, dept.Path+"%")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 科室改名时同步更新用户的科室名称
+	if name != dept.Name {
+		_, err = tx.Exec("UPDATE users SET department = ? WHERE department_id = ?", name, dept.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = recordAudit(tx, op, auditEvent{
+		Action:     models.AuditDepartmentUpdate,
+		EntityType: models.AuditEntityDepartment,
+		EntityID:   dept.ID,
+		Before:     departmentAuditFields(dept),
+		After: departmentAuditFields(&models.Department{
+			ParentID: parentID, Name: name, Level: level, Path: newPath, SortOrder: sortOrder,
+		}),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetDepartment(dept.ID)
+}
+
+// DeleteDepartment 删除科室，存在下级科室或人员时不能删除
+func (s *DepartmentService) DeleteDepartment(op Operator, departmentID int) error {
+	dept, err := s.GetDepartment(departmentID)
+	if err != nil {
+		return errors.New("科室不存在")
+	}
+
+	var children, users int
+	err = db.DB.QueryRow("SELECT COUNT(*) FROM departments WHERE parent_id = ?", departmentID).Scan(&children)
+	if err != nil {
+		return err
+	}
+	if children > 0 {
+		return errors.New("请先删除下级科室")
+	}
+
+	err = db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE department_id = ?", departmentID).Scan(&users)
+	if err != nil {
+		return err
+	}
+	if users > 0 {
+		return errors.New("科室下仍有人员，不能删除")
+	}
+
+	var banks, exams int
+	err = db.DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM question_banks WHERE department_id = ?),
+		       (SELECT COUNT(*) FROM exams WHERE department_id = ?)
+	`, departmentID, departmentID).Scan(&banks, &exams)
+	if err != nil {
+		return err
+	}
+	if banks > 0 || exams > 0 {
+		return errors.New("科室下仍有题库或试卷，不能删除")
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM departments WHERE id = ?", departmentID); err != nil {
+		return err
+	}
+	err = recordAudit(tx, op, auditEvent{
+		Action:     models.AuditDepartmentDelete,
+		EntityType: models.AuditEntityDepartment,
+		EntityID:   departmentID,
+		Before:     departmentAuditFields(dept),
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetDepartment 根据ID获取科室
+func (s *DepartmentService) GetDepartment(departmentID int) (*models.Department, error) {
+	var dept models.Department
+	err := db.DB.QueryRow("SELECT "+departmentColumns+" FROM departments WHERE id = ?", departmentID).Scan(
+		&dept.ID, &dept.ParentID, &dept.Name, &dept.Level, &dept.Path, &dept.SortOrder,
+		&dept.CreatedAt, &dept.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dept, nil
+}
+
+// ListDepartments 获取全部科室，按路径排序
+func (s *DepartmentService) ListDepartments() ([]models.Department, error) {
+	rows, err := db.DB.Query("SELECT " + departmentColumns + " FROM departments ORDER BY sort_order, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var departments []models.Department
+	for rows.Next() {
+		var dept models.Department
+		err := rows.Scan(
+			&dept.ID, &dept.ParentID, &dept.Name, &dept.Level, &dept.Path, &dept.SortOrder,
+			&dept.CreatedAt, &dept.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		departments = append(departments, dept)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return departments, nil
+}
+
+// GetDepartmentTree 获取科室树
+func (s *DepartmentService) GetDepartmentTree() ([]models.Department, error) {
+	departments, err := s.ListDepartments()
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[int][]models.Department)
+	for _, dept := range departments {
+		children[dept.ParentID] = append(children[dept.ParentID], dept)
+	}
+
+	var build func(parentID int) []models.Department
+	build = func(parentID int) []models.Department {
+		nodes := children[parentID]
+		for i := range nodes {
+			nodes[i].Children = build(nodes[i].ID)
+		}
+		return nodes
+	}
+
+	tree := build(0)
+	if tree == nil {
+		tree = []models.Department{}
+	}
+	return tree, nil
+}
+
+// SubtreeIDs 获取科室及其全部下级科室的ID
+func (s *DepartmentService) SubtreeIDs(departmentID int) ([]int, error) {
+	dept, err := s.GetDepartment(departmentID)
+	if err != nil {
+		return nil, errors.New("科室不存在")
+	}
+
+	rows, err := db.DB.Query("SELECT id FROM departments WHERE path LIKE ?", dept.Path+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// ContainsDepartment 判断departmentID是否为rootID本身或其下级科室
+func (s *DepartmentService) ContainsDepartment(rootID, departmentID int) (bool, error) {
+	subtree := SubtreeCondition("id", rootID)
+	var count int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM departments WHERE id = ? AND "+subtree.SQL,
+		append([]interface{}{departmentID}, subtree.Args...)...).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ContainsUser 判断用户是否属于rootID对应科室或其下级科室
+func (s *DepartmentService) ContainsUser(rootID, userID int) (bool, error) {
+	subtree := SubtreeCondition("department_id", rootID)
+	var count int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE id = ? AND "+subtree.SQL,
+		append([]interface{}{userID}, subtree.Args...)...).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetDepartmentStats 获取科室子树的人员和考试统计
+func (s *DepartmentService) GetDepartmentStats(departmentID int) (*models.DepartmentStats, error) {
+	dept, err := s.GetDepartment(departmentID)
+	if err != nil {
+		return nil, errors.New("科室不存在")
+	}
+
+	stats := &models.DepartmentStats{
+		DepartmentID: dept.ID,
+		Name:         dept.Name,
+	}
+
+	subtree := SubtreeCondition("u.department_id", dept.ID)
+	err = db.DB.QueryRow(
+		"SELECT COUNT(*) FROM users u WHERE u.status = 1 AND "+subtree.SQL, subtree.Args...,
+	).Scan(&stats.UserCount)
+	if err != nil {
+		return nil, err
+	}
+
+	args := append([]interface{}{PassScoreRatio}, subtree.Args...)
+	err = db.DB.QueryRow(`
+		SELECT COUNT(*),
+		       COALESCE(SUM(CASE WHEN r.total_score >= e.total_score * ? THEN 1 ELSE 0 END), 0),
+		       COALESCE(AVG(r.total_score), 0)
+		FROM exam_records r
+		JOIN users u ON r.user_id = u.id
+		JOIN exams e ON r.exam_id = e.id
+		WHERE r.status = 'graded' AND `+subtree.SQL, args...,
+	).Scan(&stats.RecordCount, &stats.PassedCount, &stats.AvgScore)
+	if err != nil {
+		return nil, err
+	}
+
+	if stats.RecordCount > 0 {
+		stats.PassRate = float64(stats.PassedCount) / float64(stats.RecordCount) * 100
+	}
+
+	return stats, nil
+}
+
+// ResolveDepartment 根据科室ID或名称查找科室，名称去除首尾空格后精确匹配
+func (s *DepartmentService) ResolveDepartment(departmentID int, name string) (*models.Department, error) {
+	if departmentID > 0 {
+		dept, err := s.GetDepartment(departmentID)
+		if err != nil {
+			return nil, errors.New("科室不存在")
+		}
+		return dept, nil
+	}
+
+	name = strings.TrimSpace(name)
+	var id int
+	err := db.DB.QueryRow("SELECT id FROM departments WHERE name = ? ORDER BY path LIMIT 1", name).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("科室不存在: %s", name)
+		}
+		return nil, err
+	}
+
+	return s.GetDepartment(id)
+}
+
+// checkNameAvailable 检查同一上级科室下名称是否重复
+func (s *DepartmentService) checkNameAvailable(parentID int, name string, excludeID int) error {
+	var count int
+	var err error
+	if parentID > 0 {
+		err = db.DB.QueryRow("SELECT COUNT(*) FROM departments WHERE parent_id = ? AND name = ? AND id <> ?",
+			parentID, name, excludeID).Scan(&count)
+	} else {
+		err = db.DB.QueryRow("SELECT COUNT(*) FROM departments WHERE parent_id IS NULL AND name = ? AND id <> ?",
+			name, excludeID).Scan(&count)
+	}
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("同一上级科室下已存在同名科室")
+	}
+	return nil
+}
+
+// Condition SQL查询条件片段
+type Condition struct {
+	SQL  string
+	Args []interface{}
+}
+
+// SubtreeCondition 生成“column属于指定科室及其下级科室”的查询条件
+func SubtreeCondition(column string, departmentID int) Condition {
+	return Condition{
+		SQL: column + ` IN (
+			SELECT d.id FROM departments d
+			JOIN departments root ON d.path LIKE CONCAT(root.path, '%')
+			WHERE root.id = ?
+		)`,
+		Args: []interface{}{departmentID},
+	}
+}
+
+// nullableID 将0转换为NULL，用于可选的外键
+func nullableID(id int) interface{} {
+	if id <= 0 {
+		return nil
+	}
+	return id
+}
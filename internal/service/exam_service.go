@@ -1,7 +1,10 @@
 package service
 
 import (
+	"database/sql"
 	"errors"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/hangbin2008/sanjicms/internal/db"
@@ -20,8 +23,8 @@ func NewExamService(questionService *QuestionService) *ExamService {
 	}
 }
 
-// GenerateExam 生成试卷
-func (s *ExamService) GenerateExam(req *models.ExamGenerateRequest, createdBy int) (*models.Exam, error) {
+// GenerateExam 生成试卷，只从操作人数据范围内的题库抽题
+func (s *ExamService) GenerateExam(scope DataScope, req *models.ExamGenerateRequest, op Operator) (*models.Exam, error) {
 	// 解析时间
 	startTime, err := time.Parse("2006-01-02 15:04:05", req.StartTime)
 	if err != nil {
@@ -39,7 +42,7 @@ func (s *ExamService) GenerateExam(req *models.ExamGenerateRequest, createdBy in
 	}
 
 	// 获取随机题目
-	questions, err := s.questionService.GetRandomQuestions(req.Subject, req.Difficulty, req.QuestionCount)
+	questions, err := s.questionService.GetRandomQuestions(scope, req.Subject, req.Difficulty, req.QuestionCount)
 	if err != nil {
 		return nil, err
 	}
@@ -63,9 +66,9 @@ func (s *ExamService) GenerateExam(req *models.ExamGenerateRequest, createdBy in
 
 	// 插入试卷记录
 	result, err := tx.Exec(`
-		INSERT INTO exams (title, description, subject, total_score, duration, start_time, end_time, status, created_by)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, req.Title, req.Description, req.Subject, totalScore, req.Duration, startTime, endTime, "published", createdBy)
+		INSERT INTO exams (title, description, subject, total_score, duration, start_time, end_time, status, cme_credits, department_id, created_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, req.Title, req.Description, req.Subject, totalScore, req.Duration, startTime, endTime, "published", req.CMECredits, nullableID(req.DepartmentID), op.UserID)
 	if err != nil {
 		return nil, err
 	}
@@ -77,6 +80,7 @@ func (s *ExamService) GenerateExam(req *models.ExamGenerateRequest, createdBy in
 	}
 
 	// 插入试卷题目关联
+	questionIDs := make([]int, 0, len(questions))
 	for i, q := range questions {
 		_, err := tx.Exec(`
 			INSERT INTO exam_questions (exam_id, question_id, sequence)
@@ -85,6 +89,29 @@ func (s *ExamService) GenerateExam(req *models.ExamGenerateRequest, createdBy in
 		if err != nil {
 			return nil, err
 		}
+		questionIDs = append(questionIDs, q.ID)
+	}
+
+	// 审计日志与试卷一起提交，写入失败时不发布试卷
+	err = recordAudit(tx, op, auditEvent{
+		Action:     models.AuditExamPublish,
+		EntityType: models.AuditEntityExam,
+		EntityID:   int(examID),
+		After: map[string]interface{}{
+			"title":         req.Title,
+			"subject":       req.Subject,
+			"total_score":   totalScore,
+			"duration":      req.Duration,
+			"start_time":    req.StartTime,
+			"end_time":      req.EndTime,
+			"status":        "published",
+			"cme_credits":   req.CMECredits,
+			"department_id": req.DepartmentID,
+			"question_ids":  questionIDs,
+		},
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// 提交事务
@@ -95,11 +122,11 @@ func (s *ExamService) GenerateExam(req *models.ExamGenerateRequest, createdBy in
 	// 查询生成的试卷信息
 	var exam models.Exam
 	err = db.DB.QueryRow(`
-		SELECT id, title, description, subject, total_score, duration, start_time, end_time, status, created_by, created_at, updated_at
+		SELECT id, title, description, subject, total_score, duration, start_time, end_time, status, cme_credits, COALESCE(department_id, 0), created_by, created_at, updated_at
 		FROM exams WHERE id = ?
 	`, examID).Scan(
 		&exam.ID, &exam.Title, &exam.Description, &exam.Subject, &exam.TotalScore, &exam.Duration,
-		&exam.StartTime, &exam.EndTime, &exam.Status, &exam.CreatedBy, &exam.CreatedAt, &exam.UpdatedAt,
+		&exam.StartTime, &exam.EndTime, &exam.Status, &exam.CMECredits, &exam.DepartmentID, &exam.CreatedBy, &exam.CreatedAt, &exam.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -115,11 +142,11 @@ func (s *ExamService) GenerateExam(req *models.ExamGenerateRequest, createdBy in
 func (s *ExamService) GetExamByID(examID int) (*models.Exam, error) {
 	var exam models.Exam
 	err := db.DB.QueryRow(`
-		SELECT id, title, description, subject, total_score, duration, start_time, end_time, status, created_by, created_at, updated_at
+		SELECT id, title, description, subject, total_score, duration, start_time, end_time, status, cme_credits, COALESCE(department_id, 0), created_by, created_at, updated_at
 		FROM exams WHERE id = ?
 	`, examID).Scan(
 		&exam.ID, &exam.Title, &exam.Description, &exam.Subject, &exam.TotalScore, &exam.Duration,
-		&exam.StartTime, &exam.EndTime, &exam.Status, &exam.CreatedBy, &exam.CreatedAt, &exam.UpdatedAt,
+		&exam.StartTime, &exam.EndTime, &exam.Status, &exam.CMECredits, &exam.DepartmentID, &exam.CreatedBy, &exam.CreatedAt, &exam.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -160,8 +187,8 @@ func (s *ExamService) GetExamByID(examID int) (*models.Exam, error) {
 	return &exam, nil
 }
 
-// StartExam 开始考试
-func (s *ExamService) StartExam(examID, userID int) (*models.ExamRecord, error) {
+// StartExam 开始考试，sessionID为作答的会话，其他会话上报防作弊事件时记为多会话作答
+func (s *ExamService) StartExam(examID, userID int, sessionID string) (*models.ExamRecord, error) {
 	// 检查试卷是否存在
 	var exam models.Exam
 	err := db.DB.QueryRow("SELECT id, status, start_time, end_time FROM exams WHERE id = ?", examID).Scan(
@@ -200,9 +227,9 @@ func (s *ExamService) StartExam(examID, userID int) (*models.ExamRecord, error)
 
 	// 插入考试记录
 	result, err := db.DB.Exec(`
-		INSERT INTO exam_records (exam_id, user_id, start_time, status)
-		VALUES (?, ?, NOW(), 'ongoing')
-	`, examID, userID)
+		INSERT INTO exam_records (exam_id, user_id, start_time, status, session_id)
+		VALUES (?, ?, NOW(), 'ongoing', NULLIF(?, ''))
+	`, examID, userID, sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -230,39 +257,50 @@ func (s *ExamService) StartExam(examID, userID int) (*models.ExamRecord, error)
 }
 
 // SubmitExam 提交试卷
-func (s *ExamService) SubmitExam(req *models.ExamSubmitRequest) (*models.ExamRecord, error) {
-	// 查询考试记录
+func (s *ExamService) SubmitExam(req *models.ExamSubmitRequest, op Operator) (*models.ExamRecord, error) {
+	// 开始事务
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// 查询并锁定考试记录，与防作弊事件上报的强制交卷互斥
 	var record models.ExamRecord
-	err := db.DB.QueryRow(`
-		SELECT id, exam_id, user_id, start_time, status
+	var proctorStatus string
+	err = tx.QueryRow(`
+		SELECT id, exam_id, user_id, start_time, status, proctor_status
 		FROM exam_records WHERE id = ?
+		FOR UPDATE
 	`, req.RecordID).Scan(
-		&record.ID, &record.ExamID, &record.UserID, &record.StartTime, &record.Status,
+		&record.ID, &record.ExamID, &record.UserID, &record.StartTime, &record.Status, &proctorStatus,
 	)
-	if err != nil {
-		return nil, errors.New("考试记录不存在")
+	// 只能提交本人的考试记录
+	if err != nil || record.UserID != op.UserID {
+		err = errors.New("考试记录不存在")
+		return nil, err
+	}
+
+	// 违规次数达到阈值后服务器已结束考试，不再接受答案
+	if proctorStatus == models.ProctorStatusForceSubmitted {
+		err = errors.New("违规操作次数过多，考试已被强制交卷")
+		return nil, err
 	}
 
 	// 检查考试状态
 	if record.Status != "ongoing" {
-		return nil, errors.New("考试已提交或已结束")
+		err = errors.New("考试已提交或已结束")
+		return nil, err
 	}
 
 	// 计算考试时长
 	now := time.Now()
 	duration := int(now.Sub(record.StartTime).Seconds())
 
-	// 开始事务
-	tx, err := db.DB.Begin()
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
-
 	// 更新考试记录状态
 	_, err = tx.Exec(`
 		UPDATE exam_records
@@ -315,6 +353,24 @@ func (s *ExamService) SubmitExam(req *models.ExamSubmitRequest) (*models.ExamRec
 		return nil, err
 	}
 
+	// 审计日志与成绩一起提交，写入失败时不保存成绩
+	err = recordAudit(tx, op, auditEvent{
+		Action:     models.AuditExamSubmit,
+		EntityType: models.AuditEntityExamRecord,
+		EntityID:   req.RecordID,
+		Before:     map[string]interface{}{"status": record.Status},
+		After: map[string]interface{}{
+			"exam_id":     record.ExamID,
+			"user_id":     record.UserID,
+			"total_score": totalScore,
+			"status":      "graded",
+			"duration":    duration,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	// 提交事务
 	if err = tx.Commit(); err != nil {
 		return nil, err
@@ -400,11 +456,14 @@ func (s *ExamService) ListExamRecords(userID, page, pageSize int) ([]models.Exam
 		return nil, 0, err
 	}
 
-	// 获取考试记录列表
+	// 获取考试记录列表，同时带出试卷标题
 	rows, err := db.DB.Query(`
-		SELECT id, exam_id, user_id, start_time, end_time, duration, total_score, status, created_at, updated_at
-		FROM exam_records WHERE user_id = ?
-		ORDER BY start_time DESC LIMIT ? OFFSET ?
+		SELECT r.id, r.exam_id, r.user_id, r.start_time, r.end_time, COALESCE(r.duration, 0), r.total_score, r.status, r.created_at, r.updated_at,
+		       e.title, e.subject, e.total_score
+		FROM exam_records r
+		JOIN exams e ON r.exam_id = e.id
+		WHERE r.user_id = ?
+		ORDER BY r.start_time DESC LIMIT ? OFFSET ?
 	`, userID, pageSize, offset)
 	if err != nil {
 		return nil, 0, err
@@ -413,13 +472,22 @@ func (s *ExamService) ListExamRecords(userID, page, pageSize int) ([]models.Exam
 
 	for rows.Next() {
 		var record models.ExamRecord
+		var endTime sql.NullTime
+		exam := &models.Exam{}
 		err := rows.Scan(
-			&record.ID, &record.ExamID, &record.UserID, &record.StartTime, &record.EndTime,
+			&record.ID, &record.ExamID, &record.UserID, &record.StartTime, &endTime,
 			&record.Duration, &record.TotalScore, &record.Status, &record.CreatedAt, &record.UpdatedAt,
+			&exam.Title, &exam.Subject, &exam.TotalScore,
 		)
 		if err != nil {
 			return nil, 0, err
 		}
+		// 进行中的考试尚无交卷时间
+		if endTime.Valid {
+			record.EndTime = endTime.Time
+		}
+		exam.ID = record.ExamID
+		record.Exam = exam
 		records = append(records, record)
 	}
 
@@ -516,8 +584,8 @@ func (s *ExamService) GetExamStats(userID int) (map[string]interface{}, error) {
 	return stats, nil
 }
 
-// ListExams 获取试卷列表
-func (s *ExamService) ListExams(page, pageSize int) ([]models.Exam, int, error) {
+// ListExams 获取数据范围内已发布的试卷列表
+func (s *ExamService) ListExams(scope DataScope, page, pageSize int) ([]models.Exam, int, error) {
 	if page < 1 {
 		page = 1
 	}
@@ -529,18 +597,20 @@ func (s *ExamService) ListExams(page, pageSize int) ([]models.Exam, int, error)
 	var exams []models.Exam
 	var total int
 
+	cond := scope.ExamCondition("department_id")
+
 	// 获取总记录数
-	err := db.DB.QueryRow("SELECT COUNT(*) FROM exams WHERE status = 'published'").Scan(&total)
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM exams WHERE status = 'published' AND "+cond.SQL, cond.Args...).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	// 获取试卷列表
 	rows, err := db.DB.Query(`
-		SELECT id, title, description, subject, total_score, duration, start_time, end_time, status, created_by, created_at, updated_at
-		FROM exams WHERE status = 'published'
+		SELECT id, title, description, subject, total_score, duration, start_time, end_time, status, cme_credits, COALESCE(department_id, 0), created_by, created_at, updated_at
+		FROM exams WHERE status = 'published' AND `+cond.SQL+`
 		ORDER BY created_at DESC LIMIT ? OFFSET ?
-	`, pageSize, offset)
+	`, append(cond.Args, pageSize, offset)...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -550,7 +620,7 @@ func (s *ExamService) ListExams(page, pageSize int) ([]models.Exam, int, error)
 		var exam models.Exam
 		err := rows.Scan(
 			&exam.ID, &exam.Title, &exam.Description, &exam.Subject, &exam.TotalScore, &exam.Duration,
-			&exam.StartTime, &exam.EndTime, &exam.Status, &exam.CreatedBy, &exam.CreatedAt, &exam.UpdatedAt,
+			&exam.StartTime, &exam.EndTime, &exam.Status, &exam.CMECredits, &exam.DepartmentID, &exam.CreatedBy, &exam.CreatedAt, &exam.UpdatedAt,
 		)
 		if err != nil {
 			return nil, 0, err
@@ -564,3 +634,227 @@ func (s *ExamService) ListExams(page, pageSize int) ([]models.Exam, int, error)
 
 	return exams, total, nil
 }
+
+// ErrPracticeQuestion 练习中包含不存在、不在数据范围内或正在用于考试的题目
+var ErrPracticeQuestion = errors.New("题目不存在或不能用于练习")
+
+// SubmitPractice 提交练习答案并记录练习量。
+// 只能练习数据范围内题库的题目；尚未结束的考试使用的题目不能练习，否则可以借练习逐题核对考试答案
+func (s *ExamService) SubmitPractice(userID int, scope DataScope, req *models.PracticeSubmitRequest) (*models.PracticeRecord, error) {
+	if len(req.Answers) == 0 {
+		return nil, errors.New("练习答案不能为空")
+	}
+
+	ids := make([]interface{}, 0, len(req.Answers))
+	seen := make(map[int]bool, len(req.Answers))
+	for _, answer := range req.Answers {
+		if seen[answer.QuestionID] {
+			return nil, errors.New("练习答案中的题目重复")
+		}
+		seen[answer.QuestionID] = true
+		ids = append(ids, answer.QuestionID)
+	}
+
+	cond := scope.ExamCondition("b.department_id")
+	rows, err := db.DB.Query(`
+		SELECT q.id, q.answer, q.score FROM questions q
+		JOIN question_banks b ON b.id = q.bank_id
+		WHERE q.id IN (`+strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")+`) AND `+cond.SQL+`
+		  AND NOT EXISTS (
+			SELECT 1 FROM exam_questions eq JOIN exams e ON e.id = eq.exam_id
+			WHERE eq.question_id = q.id AND (e.end_time IS NULL OR e.end_time > NOW())
+		  )
+	`, append(ids, cond.Args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type answerKey struct {
+		answer string
+		score  float64
+	}
+	keys := make(map[int]answerKey, len(ids))
+	for rows.Next() {
+		var id int
+		var k answerKey
+		if err := rows.Scan(&id, &k.answer, &k.score); err != nil {
+			return nil, err
+		}
+		keys[id] = k
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// 有任何一道题不能练习时整份练习都不评分，不透露其余题目的对错
+	if len(keys) != len(ids) {
+		return nil, ErrPracticeQuestion
+	}
+
+	record := &models.PracticeRecord{
+		UserID:        userID,
+		QuestionCount: len(req.Answers),
+	}
+	correct := make(map[int]bool, len(req.Answers))
+	for _, answer := range req.Answers {
+		if k := keys[answer.QuestionID]; answer.UserAnswer == k.answer {
+			correct[answer.QuestionID] = true
+			record.CorrectCount++
+			record.Score += k.score
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO practice_records (user_id, question_count, correct_count, score)
+		VALUES (?, ?, ?, ?)
+	`, record.UserID, record.QuestionCount, record.CorrectCount, record.Score)
+	if err != nil {
+		return nil, err
+	}
+
+	recordID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	record.ID = int(recordID)
+
+	// 作答明细用于按不同题目数统计练习目标
+	for _, answer := range req.Answers {
+		_, err := tx.Exec(`
+			INSERT INTO practice_answers (record_id, user_id, question_id, is_correct)
+			VALUES (?, ?, ?, ?)
+		`, record.ID, userID, answer.QuestionID, correct[answer.QuestionID])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	record.CreatedAt = time.Now()
+
+	return record, nil
+}
+
+// GetTranscript 获取用户的三基成绩档案，按年度汇总考试、补考和练习情况
+func (s *ExamService) GetTranscript(userID int) ([]models.TranscriptYear, error) {
+	rows, err := db.DB.Query(`
+		SELECT r.id, r.exam_id, e.title, e.subject, e.total_score, r.total_score, r.status,
+		       COALESCE(r.duration, 0), r.start_time, r.end_time
+		FROM exam_records r
+		JOIN exams e ON r.exam_id = e.id
+		WHERE r.user_id = ?
+		ORDER BY r.start_time, r.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	years := make(map[int]*models.TranscriptYear)
+	yearOf := func(year int) *models.TranscriptYear {
+		if _, ok := years[year]; !ok {
+			years[year] = &models.TranscriptYear{Year: year}
+		}
+		return years[year]
+	}
+
+	// 同一试卷的第二次及以后的作答视为补考
+	attempts := make(map[int]int)
+	scoreSums := make(map[int]float64)
+	for rows.Next() {
+		var entry models.TranscriptEntry
+		var submitTime sql.NullTime
+		err := rows.Scan(
+			&entry.RecordID, &entry.ExamID, &entry.ExamTitle, &entry.Subject, &entry.ExamTotal,
+			&entry.Score, &entry.Status, &entry.Duration, &entry.StartTime, &submitTime,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if submitTime.Valid {
+			entry.SubmitTime = submitTime.Time
+		}
+
+		attempts[entry.ExamID]++
+		entry.Attempt = attempts[entry.ExamID]
+		entry.IsMakeup = entry.Attempt > 1
+
+		year := yearOf(entry.StartTime.Year())
+		if entry.Status == "graded" {
+			entry.Passed = IsPassed(entry.Score, entry.ExamTotal)
+			year.ExamCount++
+			scoreSums[year.Year] += entry.Score
+			if entry.Passed {
+				year.PassedCount++
+			}
+		}
+		if entry.IsMakeup {
+			year.MakeupCount++
+		}
+		year.Entries = append(year.Entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// 按年度统计练习量
+	practiceRows, err := db.DB.Query(`
+		SELECT YEAR(created_at), COUNT(*), COALESCE(SUM(question_count), 0), COALESCE(SUM(correct_count), 0)
+		FROM practice_records
+		WHERE user_id = ?
+		GROUP BY YEAR(created_at)
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer practiceRows.Close()
+
+	for practiceRows.Next() {
+		var yearNum, count, questions, correct int
+		if err := practiceRows.Scan(&yearNum, &count, &questions, &correct); err != nil {
+			return nil, err
+		}
+		year := yearOf(yearNum)
+		year.PracticeCount = count
+		year.PracticeQuestions = questions
+		year.PracticeCorrect = correct
+	}
+
+	if err = practiceRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// 按年度倒序输出
+	transcript := make([]models.TranscriptYear, 0, len(years))
+	for _, year := range years {
+		if year.ExamCount > 0 {
+			year.AvgScore = scoreSums[year.Year] / float64(year.ExamCount)
+		}
+		transcript = append(transcript, *year)
+	}
+	sort.Slice(transcript, func(i, j int) bool {
+		return transcript[i].Year > transcript[j].Year
+	})
+
+	return transcript, nil
+}
+
+// PassScoreRatio 及格分数占试卷总分的比例
+const PassScoreRatio = 0.6
+
+// IsPassed 判断考试成绩是否及格
+func IsPassed(score, totalScore float64) bool {
+	if totalScore <= 0 {
+		return false
+	}
+	return score >= totalScore*PassScoreRatio
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/internal/models"
+)
+
+var practiceQuestions = regexp.QuoteMeta("SELECT q.id, q.answer, q.score FROM questions q")
+
+func practiceRequest(answers ...models.ExamAnswerRequest) *models.PracticeSubmitRequest {
+	return &models.PracticeSubmitRequest{Answers: answers}
+}
+
+func TestSubmitPractice(t *testing.T) {
+	mock := mockDB(t)
+	exams := NewExamService(NewQuestionService())
+	scope := DataScope{DepartmentID: 2}
+
+	// 只查询数据范围内题库中、没有用于未结束考试的题目
+	mock.ExpectQuery(practiceQuestions+`.*`+regexp.QuoteMeta("e.end_time IS NULL OR e.end_time > NOW()")).
+		WithArgs(11, 12, 2, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "answer", "score"}).AddRow(11, "A", 2).AddRow(12, "B", 3))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO practice_records (user_id, question_count, correct_count, score)")).
+		WithArgs(7, 2, 1, 2.0).WillReturnResult(sqlmock.NewResult(5, 1))
+	insertAnswer := regexp.QuoteMeta("INSERT INTO practice_answers (record_id, user_id, question_id, is_correct)")
+	mock.ExpectExec(insertAnswer).WithArgs(5, 7, 11, true).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(insertAnswer).WithArgs(5, 7, 12, false).WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	record, err := exams.SubmitPractice(7, scope, practiceRequest(
+		models.ExamAnswerRequest{QuestionID: 11, UserAnswer: "A"},
+		models.ExamAnswerRequest{QuestionID: 12, UserAnswer: "C"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.ID != 5 || record.QuestionCount != 2 || record.CorrectCount != 1 || record.Score != 2 {
+		t.Fatalf("got %+v", record)
+	}
+}
+
+// 考试题目或范围外的题目不能练习，整份练习不评分，不透露其余题目的对错
+func TestSubmitPracticeRejectsExamQuestions(t *testing.T) {
+	mock := mockDB(t)
+	exams := NewExamService(NewQuestionService())
+
+	mock.ExpectQuery(practiceQuestions).WithArgs(11, 30, 2, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "answer", "score"}).AddRow(11, "A", 2))
+	_, err := exams.SubmitPractice(7, DataScope{DepartmentID: 2}, practiceRequest(
+		models.ExamAnswerRequest{QuestionID: 11, UserAnswer: "A"},
+		models.ExamAnswerRequest{QuestionID: 30, UserAnswer: "A"},
+	))
+	if !errors.Is(err, ErrPracticeQuestion) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+// 重复提交同一道题不能增加练习量
+func TestSubmitPracticeRejectsDuplicates(t *testing.T) {
+	mockDB(t)
+	exams := NewExamService(NewQuestionService())
+
+	_, err := exams.SubmitPractice(7, GlobalScope(), practiceRequest(
+		models.ExamAnswerRequest{QuestionID: 11, UserAnswer: "A"},
+		models.ExamAnswerRequest{QuestionID: 11, UserAnswer: "A"},
+	))
+	if err == nil {
+		t.Fatal("duplicate questions were accepted")
+	}
+	if _, err := exams.SubmitPractice(7, GlobalScope(), practiceRequest()); err == nil {
+		t.Fatal("empty practice was accepted")
+	}
+}
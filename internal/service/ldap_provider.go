@@ -0,0 +1,170 @@
+package service
+
+import (
+	"crypto/tls"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/ldap"
+)
+
+// ldapUsernamePattern 域账号的用户名格式，例如 zhangsan、zhang.san、zs-01
+var ldapUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,50}$`)
+
+// LDAPProvider LDAP/Active Directory认证：先用服务账号按用户名查找用户DN，再用用户的DN和密码绑定
+type LDAPProvider struct {
+	config *config.LDAPConfig
+}
+
+// NewLDAPProvider 创建LDAP认证方式
+func NewLDAPProvider(cfg *config.Config) *LDAPProvider {
+	return &LDAPProvider{config: &cfg.LDAP}
+}
+
+// Name 认证方式名称
+func (p *LDAPProvider) Name() string {
+	return models.AuthSourceLDAP
+}
+
+// AutoProvision 是否在首次登录时自动创建账号
+func (p *LDAPProvider) AutoProvision() bool {
+	return p.config.AutoProvision
+}
+
+// Authenticate 验证域账号的用户名和密码，通过后返回目录中的姓名、科室、职称和映射的角色
+func (p *LDAPProvider) Authenticate(username, password string) (*models.ExternalIdentity, error) {
+	// 空密码的绑定会被目录服务当作匿名绑定，必须拒绝
+	if !ldapUsernamePattern.MatchString(username) || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := p.connect()
+	if err != nil {
+		return nil, &AuthUnavailableError{Err: err}
+	}
+	defer conn.Close()
+
+	entry, err := p.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsResultCode(err, ldap.ResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, &AuthUnavailableError{Err: err}
+	}
+
+	return p.identity(username, entry), nil
+}
+
+// connect 连接目录服务器，配置了StartTLS时先升级为加密连接
+func (p *LDAPProvider) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: p.config.InsecureSkipVerify}
+	conn, err := ldap.Dial(p.config.URL, tlsConfig, time.Duration(p.config.Timeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if p.config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// findUser 用服务账号按用户名查找用户条目，找不到或匹配到多个条目时视为用户名错误
+func (p *LDAPProvider) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	if p.config.BindDN != "" {
+		if err := conn.Bind(p.config.BindDN, p.config.BindPassword); err != nil {
+			return nil, &AuthUnavailableError{Err: fmt.Errorf("服务账号绑定失败: %w", err)}
+		}
+	}
+
+	entries, err := conn.Search(&ldap.SearchRequest{
+		BaseDN: p.config.BaseDN,
+		Scope:  ldap.ScopeWholeSubtree,
+		Filter: strings.ReplaceAll(p.config.UserFilter, "%s", ldap.EscapeFilter(username)),
+		Attributes: []string{
+			p.config.NameAttribute,
+			p.config.DepartmentAttribute,
+			p.config.JobTitleAttribute,
+			p.config.GroupAttribute,
+		},
+		SizeLimit: 2,
+	})
+	if err != nil {
+		if ldap.IsResultCode(err, ldap.ResultSizeLimitExceeded) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, &AuthUnavailableError{Err: err}
+	}
+	if len(entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	return entries[0], nil
+}
+
+// identity 将目录条目的属性映射为用户信息
+func (p *LDAPProvider) identity(username string, entry *ldap.Entry) *models.ExternalIdentity {
+	identity := &models.ExternalIdentity{
+		Username:   username,
+		Name:       truncate(strings.TrimSpace(entry.Value(p.config.NameAttribute)), 50),
+		Department: strings.TrimSpace(entry.Value(p.config.DepartmentAttribute)),
+		JobTitle:   truncate(strings.TrimSpace(entry.Value(p.config.JobTitleAttribute)), 50),
+		Role:       p.config.DefaultRole,
+	}
+	if identity.Name == "" {
+		identity.Name = username
+	}
+
+	if len(p.config.GroupRoles) > 0 {
+		identity.RoleFromGroups = true
+		if role, ok := p.mapRole(entry.Values(p.config.GroupAttribute)); ok {
+			identity.Role = role
+		}
+	}
+	return identity
+}
+
+// mapRole 按配置顺序查找用户所属的第一个映射组
+func (p *LDAPProvider) mapRole(groups []string) (string, bool) {
+	for _, mapping := range p.config.GroupRoles {
+		for _, group := range groups {
+			if matchGroup(mapping.Group, group) {
+				return mapping.Role, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchGroup 比较组DN，配置中不含“=”时只比较组名（第一个RDN的值），均不区分大小写
+func matchGroup(configured, groupDN string) bool {
+	if strings.Contains(configured, "=") {
+		return strings.EqualFold(normalizeDN(configured), normalizeDN(groupDN))
+	}
+
+	rdn := strings.SplitN(groupDN, ",", 2)[0]
+	if i := strings.IndexByte(rdn, '='); i >= 0 {
+		rdn = rdn[i+1:]
+	}
+	return strings.EqualFold(strings.TrimSpace(rdn), configured)
+}
+
+// normalizeDN 去除DN各部分两侧的空格，例如“CN=考试管理员, OU=Groups”
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, part := range parts {
+		if kv := strings.SplitN(part, "=", 2); len(kv) == 2 {
+			parts[i] = strings.TrimSpace(kv[0]) + "=" + strings.TrimSpace(kv[1])
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/ldap/ldaptest"
+)
+
+const (
+	ldapServiceDN = "cn=exam,ou=services,dc=hospital,dc=local"
+	ldapUserDN    = "uid=zhangsan,ou=people,dc=hospital,dc=local"
+)
+
+func newLDAPTestProvider(t *testing.T) (*LDAPProvider, *ldaptest.Server) {
+	t.Helper()
+	server := ldaptest.NewServer(
+		ldaptest.Entry{DN: ldapServiceDN, Password: "service-pass"},
+		ldaptest.Entry{
+			DN:       ldapUserDN,
+			Password: "Secret#123",
+			Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"zhangsan"},
+				"cn":          {"张三"},
+				"department":  {"心内科"},
+				"title":       {"主治医师"},
+				"memberOf":    {"CN=考试管理员, OU=Groups,DC=hospital,DC=local"},
+			},
+		},
+		ldaptest.Entry{
+			DN:         "uid=lisi,ou=people,dc=hospital,dc=local",
+			Password:   "Other#456",
+			Attributes: map[string][]string{"objectClass": {"person"}, "uid": {"lisi"}},
+		},
+	)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{LDAP: config.LDAPConfig{
+		Enabled:             true,
+		URL:                 server.URL,
+		BindDN:              ldapServiceDN,
+		BindPassword:        "service-pass",
+		BaseDN:              "ou=people,dc=hospital,dc=local",
+		UserFilter:          "(&(objectClass=person)(uid=%s))",
+		Timeout:             2,
+		NameAttribute:       "cn",
+		DepartmentAttribute: "department",
+		JobTitleAttribute:   "title",
+		GroupAttribute:      "memberOf",
+		GroupRoles: []config.GroupRole{
+			{Group: "cn=考试管理员,ou=Groups,dc=hospital,dc=local", Role: models.RoleManager},
+		},
+		DefaultRole: models.RoleEmployee,
+	}}
+	return NewLDAPProvider(cfg), server
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	provider, server := newLDAPTestProvider(t)
+
+	identity, err := provider.Authenticate("zhangsan", "Secret#123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Username != "zhangsan" || identity.Name != "张三" || identity.Department != "心内科" || identity.JobTitle != "主治医师" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if !identity.RoleFromGroups || identity.Role != models.RoleManager {
+		t.Errorf("role = %q (from groups %v), want manager", identity.Role, identity.RoleFromGroups)
+	}
+
+	// 先用服务账号绑定查找用户，再用用户DN绑定
+	binds := server.Binds()
+	if len(binds) != 2 || binds[0].DN != ldapServiceDN || binds[1].DN != ldapUserDN {
+		t.Errorf("unexpected binds %+v", binds)
+	}
+	searches := server.Searches()
+	if len(searches) != 1 || searches[0].Filter != "(&(objectClass=person)(uid=zhangsan))" || searches[0].SizeLimit != 2 {
+		t.Errorf("unexpected searches %+v", searches)
+	}
+}
+
+func TestLDAPAuthenticateDefaultRole(t *testing.T) {
+	provider, _ := newLDAPTestProvider(t)
+
+	identity, err := provider.Authenticate("lisi", "Other#456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Role != models.RoleEmployee || identity.Name != "lisi" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+}
+
+func TestLDAPAuthenticateWrongPassword(t *testing.T) {
+	provider, _ := newLDAPTestProvider(t)
+
+	if _, err := provider.Authenticate("zhangsan", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := provider.Authenticate("nobody", "Secret#123"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("unknown user: got %v, want ErrInvalidCredentials", err)
+	}
+}
+
+// 空密码会被目录服务当作匿名绑定而成功，必须在连接之前拒绝
+func TestLDAPAuthenticateRejectsEmptyPassword(t *testing.T) {
+	provider, server := newLDAPTestProvider(t)
+
+	if _, err := provider.Authenticate("zhangsan", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials", err)
+	}
+	if server.Connections() != 0 {
+		t.Error("empty password reached the directory server")
+	}
+}
+
+// 含过滤条件特殊字符的用户名在查询前被拒绝
+func TestLDAPAuthenticateRejectsFilterCharacters(t *testing.T) {
+	provider, server := newLDAPTestProvider(t)
+
+	for _, username := range []string{"*", "zhangsan)(uid=*", "*)(|(objectClass=*", `zhang\san`} {
+		if _, err := provider.Authenticate(username, "Secret#123"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%q: got %v, want ErrInvalidCredentials", username, err)
+		}
+	}
+	if server.Connections() != 0 {
+		t.Error("invalid username reached the directory server")
+	}
+}
+
+func TestLDAPAuthenticateUnavailable(t *testing.T) {
+	provider, server := newLDAPTestProvider(t)
+
+	// 服务账号密码错误属于配置问题，不能当作用户密码错误
+	provider.config.BindPassword = "wrong"
+	_, err := provider.Authenticate("zhangsan", "Secret#123")
+	var unavailable *AuthUnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("service bind failure: got %v, want AuthUnavailableError", err)
+	}
+
+	server.Close()
+	provider.config.BindPassword = "service-pass"
+	if _, err := provider.Authenticate("zhangsan", "Secret#123"); !errors.As(err, &unavailable) {
+		t.Fatalf("server down: got %v, want AuthUnavailableError", err)
+	}
+}
@@ -0,0 +1,353 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/oidc"
+)
+
+// oidcStateTTL 跳转到认证服务后完成登录的时限
+const oidcStateTTL = 10 * time.Minute
+
+// oidcUsernamePattern 自动创建账号时用户名声明的格式，允许使用邮箱作为用户名
+var oidcUsernamePattern = regexp.MustCompile(`^[A-Za-z0-9._@-]{1,50}$`)
+
+// OIDC登录和绑定的错误
+var (
+	ErrOIDCState         = errors.New("登录已过期，请重新登录")
+	ErrOIDCNotLinked     = errors.New("该统一身份认证账号尚未绑定本系统账号，请先用账号密码登录后在个人中心绑定")
+	ErrOIDCUsernameTaken = errors.New("用户名已被其他账号使用，请先用该账号登录后在个人中心绑定")
+	ErrIdentityTaken     = errors.New("该统一身份认证账号已绑定其他用户")
+)
+
+// OIDCCallback 回调校验通过后得到的外部身份
+type OIDCCallback struct {
+	LinkUserID int // 绑定流程中发起绑定的用户，登录流程为0
+	Issuer     string
+	Subject    string
+	Identity   *models.ExternalIdentity
+}
+
+// OIDCService OpenID Connect统一身份认证：授权码+PKCE登录、外部身份绑定和声明到角色的映射
+type OIDCService struct {
+	config *config.OIDCConfig
+	users  *UserService
+	client *http.Client
+	now    func() time.Time
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// NewOIDCService 创建OIDC服务，认证服务的发现文档在第一次使用时读取
+func NewOIDCService(cfg *config.Config, users *UserService) *OIDCService {
+	return &OIDCService{
+		config: &cfg.OIDC,
+		users:  users,
+		client: &http.Client{Timeout: time.Duration(cfg.OIDC.Timeout) * time.Second},
+		now:    time.Now,
+	}
+}
+
+// Enabled 是否启用OIDC登录
+func (s *OIDCService) Enabled() bool {
+	return s.config.Enabled
+}
+
+// DisplayName 登录页按钮上显示的认证服务名称
+func (s *OIDCService) DisplayName() string {
+	return s.config.DisplayName
+}
+
+// Begin 保存state、nonce和PKCE的code_verifier，返回认证服务的授权地址和state。
+// userID大于0时为已登录用户绑定外部身份，否则为登录
+func (s *OIDCService) Begin(ctx context.Context, userID int) (string, string, error) {
+	if !s.config.Enabled {
+		return "", "", errors.New("未启用统一身份认证登录")
+	}
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return "", "", &AuthUnavailableError{Err: err}
+	}
+
+	state, err := oidc.GenerateState()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.GenerateState()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.GenerateVerifier()
+	if err != nil {
+		return "", "", err
+	}
+
+	now := s.now()
+	// 顺便清理过期未完成的登录状态
+	if _, err := db.DB.Exec("DELETE FROM oidc_login_states WHERE expires_at < ?", now); err != nil {
+		return "", "", err
+	}
+	_, err = db.DB.Exec(
+		"INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, user_id, expires_at) VALUES (?, ?, ?, ?, ?)",
+		hashToken(state), nonce, verifier, nullableID(userID), now.Add(oidcStateTTL),
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	return provider.AuthCodeURL(s.clientConfig(), state, nonce, oidc.Challenge(verifier)), state, nil
+}
+
+// Complete 处理回调：state只能使用一次，用授权码和code_verifier换取ID令牌，校验后将声明映射为用户信息
+func (s *OIDCService) Complete(ctx context.Context, state, code string) (*OIDCCallback, error) {
+	if state == "" || code == "" {
+		return nil, ErrOIDCState
+	}
+
+	stateHash := hashToken(state)
+	var nonce, verifier string
+	var linkUserID int
+	err := db.DB.QueryRow(
+		"SELECT nonce, code_verifier, COALESCE(user_id, 0) FROM oidc_login_states WHERE state_hash = ? AND expires_at > ?",
+		stateHash, s.now(),
+	).Scan(&nonce, &verifier, &linkUserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOIDCState
+		}
+		return nil, err
+	}
+
+	// 删除成功的请求才能继续，防止同一state被并发使用两次
+	result, err := db.DB.Exec("DELETE FROM oidc_login_states WHERE state_hash = ?", stateHash)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, ErrOIDCState
+	}
+
+	provider, err := s.discover(ctx)
+	if err != nil {
+		return nil, &AuthUnavailableError{Err: err}
+	}
+	cfg := s.clientConfig()
+	token, err := provider.Exchange(ctx, cfg, code, verifier)
+	if err != nil {
+		return nil, &AuthUnavailableError{Err: err}
+	}
+	claims, err := provider.VerifyIDToken(ctx, cfg, token.IDToken, nonce, s.now())
+	if err != nil {
+		return nil, &AuthUnavailableError{Err: err}
+	}
+
+	return &OIDCCallback{
+		LinkUserID: linkUserID,
+		Issuer:     claims.String("iss"),
+		Subject:    claims.String("sub"),
+		Identity:   s.identity(claims),
+	}, nil
+}
+
+// ResolveUser 查找外部身份绑定的账号，统一身份认证创建的账号同步声明中的信息；
+// 尚未绑定时按配置自动创建账号并绑定，用户名已被本地账号使用时不会自动绑定，防止冒用
+func (s *OIDCService) ResolveUser(cb *OIDCCallback) (int, error) {
+	var userID int
+	err := db.DB.QueryRow("SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?",
+		cb.Issuer, cb.Subject).Scan(&userID)
+	if err == nil {
+		if _, err := db.DB.Exec("UPDATE user_identities SET last_login_at = ? WHERE issuer = ? AND subject = ?",
+			s.now(), cb.Issuer, cb.Subject); err != nil {
+			return 0, err
+		}
+		user, err := s.users.GetUserByID(userID)
+		if err != nil {
+			return 0, err
+		}
+		if user.AuthSource == models.AuthSourceOIDC {
+			if err := s.users.syncExternalUser(user, cb.Identity); err != nil {
+				return 0, err
+			}
+		}
+		return userID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	if !s.config.AutoProvision {
+		return 0, ErrOIDCNotLinked
+	}
+	if !oidcUsernamePattern.MatchString(cb.Identity.Username) {
+		return 0, errors.New("统一身份认证账号缺少有效的用户名，请联系管理员")
+	}
+	var count int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", cb.Identity.Username).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, ErrOIDCUsernameTaken
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	userID, err = s.users.createExternalUser(tx, models.AuthSourceOIDC, cb.Identity)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO user_identities (user_id, provider, issuer, subject, last_login_at) VALUES (?, ?, ?, ?, ?)",
+		userID, models.AuthSourceOIDC, cb.Issuer, cb.Subject, s.now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
+
+// Link 将外部身份绑定到已登录的用户，重复绑定同一用户时直接返回
+func (s *OIDCService) Link(userID int, cb *OIDCCallback) error {
+	var owner int
+	err := db.DB.QueryRow("SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?",
+		cb.Issuer, cb.Subject).Scan(&owner)
+	if err == nil {
+		if owner == userID {
+			return nil
+		}
+		return ErrIdentityTaken
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	_, err = db.DB.Exec("INSERT INTO user_identities (user_id, provider, issuer, subject) VALUES (?, ?, ?, ?)",
+		userID, models.AuthSourceOIDC, cb.Issuer, cb.Subject)
+	return err
+}
+
+// ListIdentities 获取用户绑定的外部身份
+func (s *OIDCService) ListIdentities(userID int) ([]models.UserIdentity, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, user_id, provider, issuer, subject, created_at, last_login_at
+		FROM user_identities WHERE user_id = ? ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.UserIdentity{}
+	for rows.Next() {
+		var identity models.UserIdentity
+		var lastLogin sql.NullTime
+		if err := rows.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Issuer,
+			&identity.Subject, &identity.CreatedAt, &lastLogin); err != nil {
+			return nil, err
+		}
+		identity.LastLoginAt = lastLogin.Time
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// Unlink 解除外部身份绑定；统一身份认证创建的账号没有本地密码，不能解除最后一个绑定
+func (s *OIDCService) Unlink(userID, identityID int) error {
+	var authSource string
+	var count int
+	err := db.DB.QueryRow(`
+		SELECT u.auth_source, (SELECT COUNT(*) FROM user_identities WHERE user_id = u.id)
+		FROM users u WHERE u.id = ?
+	`, userID).Scan(&authSource, &count)
+	if err != nil {
+		return err
+	}
+	if authSource == models.AuthSourceOIDC && count <= 1 {
+		return errors.New("统一身份认证是该账号唯一的登录方式，不能解除绑定")
+	}
+
+	result, err := db.DB.Exec("DELETE FROM user_identities WHERE id = ? AND user_id = ?", identityID, userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return errors.New("绑定不存在")
+	}
+	return nil
+}
+
+// identity 将ID令牌的声明映射为用户信息
+func (s *OIDCService) identity(claims oidc.Claims) *models.ExternalIdentity {
+	identity := &models.ExternalIdentity{
+		Username: strings.TrimSpace(claims.String(s.config.UsernameClaim)),
+		Name:     truncate(strings.TrimSpace(claims.String(s.config.NameClaim)), 50),
+		Role:     s.config.DefaultRole,
+	}
+	if s.config.DepartmentClaim != "" {
+		identity.Department = strings.TrimSpace(claims.String(s.config.DepartmentClaim))
+	}
+	if s.config.JobTitleClaim != "" {
+		identity.JobTitle = truncate(strings.TrimSpace(claims.String(s.config.JobTitleClaim)), 50)
+	}
+	if identity.Name == "" {
+		identity.Name = identity.Username
+	}
+
+	if len(s.config.ClaimRoles) > 0 {
+		identity.RoleFromGroups = true
+		values := claims.Strings(s.config.RoleClaim)
+	match:
+		for _, mapping := range s.config.ClaimRoles {
+			for _, value := range values {
+				if strings.EqualFold(value, mapping.Group) {
+					identity.Role = mapping.Role
+					break match
+				}
+			}
+		}
+	}
+	return identity
+}
+
+// discover 读取并缓存认证服务的发现文档，失败时下次请求重试
+func (s *OIDCService) discover(ctx context.Context) (*oidc.Provider, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.provider != nil {
+		return s.provider, nil
+	}
+	provider, err := oidc.Discover(ctx, s.client, s.config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	s.provider = provider
+	return provider, nil
+}
+
+// clientConfig 本系统在认证服务中登记的客户端信息
+func (s *OIDCService) clientConfig() *oidc.Config {
+	return &oidc.Config{
+		ClientID:     s.config.ClientID,
+		ClientSecret: s.config.ClientSecret,
+		RedirectURL:  s.config.RedirectURL,
+		Scopes:       s.config.Scopes,
+	}
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/oidc"
+	"github.com/hangbin2008/sanjicms/pkg/oidc/oidctest"
+)
+
+const (
+	oidcSelectState = "SELECT nonce, code_verifier, COALESCE(user_id, 0) FROM oidc_login_states WHERE state_hash = ? AND expires_at > ?"
+	oidcDeleteState = "DELETE FROM oidc_login_states WHERE state_hash = ?"
+)
+
+// oidcLogin Begin保存的登录状态
+type oidcLogin struct {
+	authURL  string
+	state    string
+	nonce    string
+	verifier string
+}
+
+func newOIDCTestService(t *testing.T) (*OIDCService, *oidctest.Server, time.Time) {
+	t.Helper()
+	server := oidctest.NewServer("exam-system")
+	t.Cleanup(server.Close)
+	server.Claims["preferred_username"] = "zhangsan"
+	server.Claims["name"] = "张三"
+	server.Claims["department"] = "心内科"
+	server.Claims["roles"] = []string{"doctor", "Exam-Admin"}
+
+	cfg := &config.Config{OIDC: config.OIDCConfig{
+		Enabled:         true,
+		Issuer:          server.URL,
+		ClientID:        "exam-system",
+		RedirectURL:     "https://exam.example.com/auth/oidc/callback",
+		Timeout:         5,
+		UsernameClaim:   "preferred_username",
+		NameClaim:       "name",
+		DepartmentClaim: "department",
+		RoleClaim:       "roles",
+		ClaimRoles:      []config.GroupRole{{Group: "exam-admin", Role: models.RoleManager}},
+		DefaultRole:     models.RoleEmployee,
+	}}
+	s := NewOIDCService(cfg, nil)
+	now := time.Now().Truncate(time.Second)
+	s.now = func() time.Time { return now }
+	return s, server, now
+}
+
+// begin 调用Begin并取得写入数据库的nonce和code_verifier
+func begin(t *testing.T, s *OIDCService, mock sqlmock.Sqlmock, now time.Time, userID int) oidcLogin {
+	t.Helper()
+	var link interface{}
+	if userID > 0 {
+		link = userID
+	}
+	stateHash, nonce, verifier := &capturedArg{}, &capturedArg{}, &capturedArg{}
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM oidc_login_states WHERE expires_at < ?")).
+		WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, user_id, expires_at) VALUES (?, ?, ?, ?, ?)")).
+		WithArgs(stateHash, nonce, verifier, link, now.Add(oidcStateTTL)).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	authURL, state, err := s.Begin(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 数据库只保存state的哈希
+	if stateHash.String() != hashToken(state) {
+		t.Fatalf("stored state hash %s does not match state", stateHash)
+	}
+	return oidcLogin{authURL: authURL, state: state, nonce: nonce.String(), verifier: verifier.String()}
+}
+
+func expectState(mock sqlmock.Sqlmock, now time.Time, state, nonce, verifier string, userID int, deleted int64) {
+	mock.ExpectQuery(regexp.QuoteMeta(oidcSelectState)).WithArgs(hashToken(state), now).
+		WillReturnRows(sqlmock.NewRows([]string{"nonce", "code_verifier", "user_id"}).AddRow(nonce, verifier, userID))
+	mock.ExpectExec(regexp.QuoteMeta(oidcDeleteState)).WithArgs(hashToken(state)).
+		WillReturnResult(sqlmock.NewResult(0, deleted))
+}
+
+func TestOIDCLogin(t *testing.T) {
+	s, server, now := newOIDCTestService(t)
+	mock := mockDB(t)
+
+	login := begin(t, s, mock, now, 0)
+	q := mustQuery(t, login.authURL)
+	if q.Get("state") != login.state || q.Get("nonce") != login.nonce {
+		t.Errorf("authorization URL does not carry the stored state and nonce: %s", login.authURL)
+	}
+	if q.Get("code_challenge") != oidc.Challenge(login.verifier) || q.Get("code_challenge_method") != "S256" {
+		t.Errorf("authorization URL has no S256 challenge for the stored verifier: %s", login.authURL)
+	}
+
+	code, state, err := server.Authorize(login.authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectState(mock, now, state, login.nonce, login.verifier, 0, 1)
+
+	cb, err := s.Complete(context.Background(), state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb.LinkUserID != 0 || cb.Issuer != server.URL || cb.Subject != server.Subject {
+		t.Errorf("unexpected callback %+v", cb)
+	}
+	identity := cb.Identity
+	if identity.Username != "zhangsan" || identity.Name != "张三" || identity.Department != "心内科" {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if !identity.RoleFromGroups || identity.Role != models.RoleManager {
+		t.Errorf("role = %q, want manager", identity.Role)
+	}
+}
+
+func TestOIDCLinkKeepsUser(t *testing.T) {
+	s, server, now := newOIDCTestService(t)
+	mock := mockDB(t)
+
+	login := begin(t, s, mock, now, 7)
+	code, state, err := server.Authorize(login.authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectState(mock, now, state, login.nonce, login.verifier, 7, 1)
+
+	cb, err := s.Complete(context.Background(), state, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cb.LinkUserID != 7 {
+		t.Errorf("LinkUserID = %d, want 7", cb.LinkUserID)
+	}
+}
+
+// state只能使用一次：已使用或过期的state查不到，并发请求中删除失败的一方同样拒绝
+func TestOIDCStateSingleUse(t *testing.T) {
+	s, server, now := newOIDCTestService(t)
+	mock := mockDB(t)
+
+	login := begin(t, s, mock, now, 0)
+	code, state, err := server.Authorize(login.authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectState(mock, now, state, login.nonce, login.verifier, 0, 1)
+	if _, err := s.Complete(context.Background(), state, code); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(oidcSelectState)).WithArgs(hashToken(state), now).
+		WillReturnRows(sqlmock.NewRows([]string{"nonce", "code_verifier", "user_id"}))
+	if _, err := s.Complete(context.Background(), state, code); !errors.Is(err, ErrOIDCState) {
+		t.Fatalf("reused state: got %v, want ErrOIDCState", err)
+	}
+
+	expectState(mock, now, state, login.nonce, login.verifier, 0, 0)
+	if _, err := s.Complete(context.Background(), state, code); !errors.Is(err, ErrOIDCState) {
+		t.Fatalf("concurrently used state: got %v, want ErrOIDCState", err)
+	}
+
+	if _, err := s.Complete(context.Background(), "", code); !errors.Is(err, ErrOIDCState) {
+		t.Fatalf("empty state: got %v, want ErrOIDCState", err)
+	}
+}
+
+func TestOIDCCompleteRejectsInvalidToken(t *testing.T) {
+	cases := []struct {
+		name   string
+		mutate func(claims jwt.MapClaims, now time.Time)
+		nonce  string // 非空时替换数据库中保存的nonce
+	}{
+		{name: "nonce", nonce: "another-login"},
+		{name: "audience", mutate: func(c jwt.MapClaims, _ time.Time) { c["aud"] = "other-client" }},
+		{name: "azp", mutate: func(c jwt.MapClaims, _ time.Time) {
+			c["aud"] = []string{"exam-system", "other-client"}
+			c["azp"] = "other-client"
+		}},
+		{name: "expired", mutate: func(c jwt.MapClaims, now time.Time) { c["exp"] = now.Add(-5 * time.Minute).Unix() }},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, server, now := newOIDCTestService(t)
+			mock := mockDB(t)
+			if c.mutate != nil {
+				server.Mutate = func(claims jwt.MapClaims) { c.mutate(claims, now) }
+			}
+
+			login := begin(t, s, mock, now, 0)
+			code, state, err := server.Authorize(login.authURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nonce := login.nonce
+			if c.nonce != "" {
+				nonce = c.nonce
+			}
+			expectState(mock, now, state, nonce, login.verifier, 0, 1)
+
+			_, err = s.Complete(context.Background(), state, code)
+			var unavailable *AuthUnavailableError
+			if !errors.As(err, &unavailable) || !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Fatalf("got %v, want invalid id token", err)
+			}
+		})
+	}
+}
+
+// 授权码必须和同一次登录保存的code_verifier一起使用
+func TestOIDCCompleteRejectsWrongVerifier(t *testing.T) {
+	s, server, now := newOIDCTestService(t)
+	mock := mockDB(t)
+
+	first := begin(t, s, mock, now, 0)
+	second := begin(t, s, mock, now, 0)
+	code, state, err := server.Authorize(first.authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectState(mock, now, state, first.nonce, second.verifier, 0, 1)
+
+	_, err = s.Complete(context.Background(), state, code)
+	var unavailable *AuthUnavailableError
+	if !errors.As(err, &unavailable) || errors.Is(err, oidc.ErrInvalidIDToken) {
+		t.Fatalf("got %v, want token exchange failure", err)
+	}
+}
+
+func TestOIDCBeginDiscoveryFailure(t *testing.T) {
+	s, server, _ := newOIDCTestService(t)
+	server.Close()
+
+	_, _, err := s.Begin(context.Background(), 0)
+	var unavailable *AuthUnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("got %v, want AuthUnavailableError", err)
+	}
+
+	s.config.Enabled = false
+	if _, _, err := s.Begin(context.Background(), 0); err == nil {
+		t.Fatal("expected error when OIDC is disabled")
+	}
+}
+
+func mustQuery(t *testing.T, rawURL string) url.Values {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query()
+}
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/notify"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordResetError 找回密码的请求不符合要求或验证码、凭证无效
+type PasswordResetError struct {
+	Message string
+}
+
+func (e *PasswordResetError) Error() string {
+	return e.Message
+}
+
+// 找回密码失败时不区分账号不存在、验证码错误和已过期，防止探测账号
+var (
+	errResetCode  = &PasswordResetError{Message: "验证码错误或已过期，请重新获取"}
+	errResetToken = &PasswordResetError{Message: "找回密码已过期，请重新获取验证码"}
+)
+
+// ErrResetLimited 同一IP申请验证码过于频繁
+var ErrResetLimited = &PasswordResetError{Message: "请求过于频繁，请稍后再试"}
+
+// resetCodeDigits 验证码位数
+const resetCodeDigits = 6
+
+// PasswordResetService 自助找回密码：向账号绑定的邮箱或手机号发送验证码，验证通过后设置新密码
+type PasswordResetService struct {
+	config    *config.PasswordResetConfig
+	users     *UserService
+	email     notify.Notifier // 为nil表示不支持通过邮箱找回
+	sms       notify.Notifier // 为nil表示不支持通过手机号找回
+	signature string
+	now       func() time.Time
+}
+
+// NewPasswordResetService 创建找回密码服务，按配置选择邮件和短信的发送方式
+func NewPasswordResetService(cfg *config.Config, users *UserService) *PasswordResetService {
+	var email, sms notify.Notifier
+	n := &cfg.Notify
+	if n.Email == "smtp" {
+		email = notify.NewSMTP(notify.SMTPOptions{
+			Host:     n.SMTPHost,
+			Port:     n.SMTPPort,
+			Username: n.SMTPUsername,
+			Password: n.SMTPPassword,
+			From:     n.SMTPFrom,
+			Security: n.SMTPSecurity,
+			Timeout:  time.Duration(n.SMTPTimeout) * time.Second,
+		})
+	}
+	if n.SMS == "http" {
+		sms = notify.NewHTTPSMS(notify.HTTPSMSOptions{
+			URL:     n.SMSGatewayURL,
+			Token:   n.SMSGatewayToken,
+			Timeout: time.Duration(n.SMSGatewayTimeout) * time.Second,
+		})
+	}
+	return NewPasswordResetServiceWithNotifiers(cfg, users, email, sms)
+}
+
+// NewPasswordResetServiceWithNotifiers 使用指定的邮件和短信发送方式创建找回密码服务，为nil表示不支持该方式
+func NewPasswordResetServiceWithNotifiers(cfg *config.Config, users *UserService, email, sms notify.Notifier) *PasswordResetService {
+	return &PasswordResetService{
+		config:    &cfg.Reset,
+		users:     users,
+		email:     email,
+		sms:       sms,
+		signature: cfg.Notify.Signature,
+		now:       time.Now,
+	}
+}
+
+// Channels 可用的验证码接收方式，为空表示未开启自助找回密码
+func (s *PasswordResetService) Channels() []string {
+	var channels []string
+	if s.email != nil {
+		channels = append(channels, models.ResetChannelEmail)
+	}
+	if s.sms != nil {
+		channels = append(channels, models.ResetChannelSMS)
+	}
+	return channels
+}
+
+// RequestCode 向账号绑定的邮箱或手机号发送验证码。
+// 账号不存在、已禁用、为域账号、未绑定邮箱或手机号以及发送过于频繁时同样返回成功，不向请求方透露账号信息；
+// 只有同一IP请求过多时返回错误
+func (s *PasswordResetService) RequestCode(account, channel, ip string) error {
+	notifier := s.notifier(channel)
+	if notifier == nil {
+		return &PasswordResetError{Message: "不支持该找回方式"}
+	}
+
+	now := s.now()
+	var ipCount int
+	err := db.DB.QueryRow(
+		"SELECT COUNT(*) FROM password_resets WHERE ip = ? AND created_at > ?", ip, now.Add(-time.Hour),
+	).Scan(&ipCount)
+	if err != nil {
+		return err
+	}
+	if ipCount >= s.config.IPMaxPerHour {
+		return ErrResetLimited
+	}
+
+	user, err := s.findAccount(account)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	// 不发送验证码时也记录一行，用于按IP限制频率
+	var userID interface{}
+	destination := ""
+	if user != nil {
+		userID = user.ID
+		if user.Status != 0 && user.AuthSource == models.AuthSourceLocal {
+			destination = user.Email
+			if channel == models.ResetChannelSMS {
+				destination = user.Phone
+			}
+		}
+	}
+	if destination == "" {
+		return s.record(userID, channel, "", ip, now)
+	}
+	allowed, err := s.allowSend(user.ID, now)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return s.record(userID, channel, "", ip, now)
+	}
+
+	code, err := resetCode()
+	if err != nil {
+		return err
+	}
+	// 新验证码发出后，之前未使用的验证码作废
+	_, err = db.DB.Exec(
+		"UPDATE password_resets SET expires_at = ? WHERE user_id = ? AND verified_at IS NULL AND expires_at > ?",
+		now, user.ID, now,
+	)
+	if err != nil {
+		return err
+	}
+	if err := s.record(userID, channel, hashToken(code), ip, now); err != nil {
+		return err
+	}
+
+	minutes := s.config.CodeTTL / 60
+	if minutes < 1 {
+		minutes = 1
+	}
+	msg := notify.Message{
+		To:      destination,
+		Subject: s.signature + "找回密码验证码",
+		Body: fmt.Sprintf("【%s】您正在找回登录密码，验证码为%s，%d分钟内有效。如非本人操作，请忽略本消息并注意账号安全。",
+			s.signature, code, minutes),
+	}
+	// 发送失败不返回错误，与账号不存在时的响应保持一致
+	if err := notifier.Send(msg); err != nil {
+		log.Printf("发送找回密码验证码失败（用户ID %d，方式 %s）: %v", user.ID, channel, err)
+	}
+	return nil
+}
+
+// VerifyCode 校验验证码，通过后返回设置新密码用的一次性凭证。每个验证码最多尝试MaxAttempts次
+func (s *PasswordResetService) VerifyCode(account, code string) (string, error) {
+	user, err := s.findAccount(account)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errResetCode
+	}
+	if err != nil {
+		return "", err
+	}
+
+	now := s.now()
+	var id int
+	var codeHash string
+	err = db.DB.QueryRow(`
+		SELECT id, code_hash FROM password_resets
+		WHERE user_id = ? AND code_hash <> '' AND verified_at IS NULL AND expires_at > ?
+		ORDER BY id DESC LIMIT 1
+	`, user.ID, now).Scan(&id, &codeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errResetCode
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// 先计入尝试次数再比较，并发提交也不会超过上限
+	result, err := db.DB.Exec(
+		"UPDATE password_resets SET attempts = attempts + 1 WHERE id = ? AND attempts < ? AND verified_at IS NULL",
+		id, s.config.MaxAttempts,
+	)
+	if err != nil {
+		return "", err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return "", errResetCode
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(strings.TrimSpace(code))), []byte(codeHash)) != 1 {
+		return "", errResetCode
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	result, err = db.DB.Exec(`
+		UPDATE password_resets SET verified_at = ?, token_hash = ?, token_expires_at = ?
+		WHERE id = ? AND verified_at IS NULL
+	`, now, hashToken(token), now.Add(time.Duration(s.config.TokenTTL)*time.Second), id)
+	if err != nil {
+		return "", err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return "", errResetCode
+	}
+	return token, nil
+}
+
+// ResetPassword 使用验证码换取的凭证设置新密码。成功后凭证作废、解除账号锁定并注销全部会话，ip记入审计日志
+func (s *PasswordResetService) ResetPassword(token, newPassword, ip string) error {
+	now := s.now()
+	var resetID, userID int
+	err := db.DB.QueryRow(`
+		SELECT id, user_id FROM password_resets
+		WHERE token_hash = ? AND used_at IS NULL AND token_expires_at > ?
+	`, hashToken(token), now).Scan(&resetID, &userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errResetToken
+	}
+	if err != nil {
+		return err
+	}
+
+	var username, role, currentHash, authSource string
+	var status int
+	err = db.DB.QueryRow("SELECT username, role, password_hash, auth_source, status FROM users WHERE id = ?", userID).
+		Scan(&username, &role, &currentHash, &authSource, &status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errResetToken
+	}
+	if err != nil {
+		return err
+	}
+	if status == 0 || authSource != models.AuthSourceLocal {
+		return errResetToken
+	}
+
+	if err := s.users.ValidatePassword(newPassword); err != nil {
+		return &PasswordResetError{Message: err.Error()}
+	}
+	if err := s.users.checkPasswordReuse(userID, currentHash, newPassword); err != nil {
+		return &PasswordResetError{Message: err.Error()}
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	// 先占用凭证，同一凭证并发提交时只有一个生效
+	result, err := db.DB.Exec("UPDATE password_resets SET used_at = ? WHERE id = ? AND used_at IS NULL", now, resetID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return errResetToken
+	}
+
+	if err := s.users.setPassword(userID, currentHash, string(hashedPassword), false); err != nil {
+		return err
+	}
+	logAudit(Operator{UserID: userID, Username: username, Role: role, IP: ip}, auditEvent{
+		Action:     models.AuditUserPasswordRecover,
+		EntityType: models.AuditEntityUser,
+		EntityID:   userID,
+	})
+	// 本人已证明身份，解除因密码错误导致的锁定，并作废其他未使用的验证码和凭证
+	if _, err := db.DB.Exec("UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = ?", userID); err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(
+		"UPDATE password_resets SET expires_at = ?, token_expires_at = ? WHERE user_id = ? AND used_at IS NULL",
+		now, now, userID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = s.users.sessions.RevokeUserSessions(userID, "")
+	return err
+}
+
+// notifier 返回验证码接收方式对应的发送方式，未开启时返回nil
+func (s *PasswordResetService) notifier(channel string) notify.Notifier {
+	switch channel {
+	case models.ResetChannelEmail:
+		return s.email
+	case models.ResetChannelSMS:
+		return s.sms
+	}
+	return nil
+}
+
+// findAccount 按用户名查找账号，找不到时按手机号或身份证号的盲索引查找
+func (s *PasswordResetService) findAccount(account string) (*models.User, error) {
+	account = strings.TrimSpace(account)
+	user, _, err := s.users.findLoginUser("username", account)
+	if errors.Is(err, sql.ErrNoRows) {
+		if column, index := s.users.loginIndex(account); column != "" {
+			user, _, err = s.users.findLoginUser(column, index)
+		}
+	}
+	return user, err
+}
+
+// allowSend 检查同一账号的发送间隔和每小时发送数量
+func (s *PasswordResetService) allowSend(userID int, now time.Time) (bool, error) {
+	var count int
+	var last sql.NullTime
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*), MAX(created_at) FROM password_resets
+		WHERE user_id = ? AND code_hash <> '' AND created_at > ?
+	`, userID, now.Add(-time.Hour)).Scan(&count, &last)
+	if err != nil {
+		return false, err
+	}
+	if count >= s.config.MaxPerHour {
+		return false, nil
+	}
+	if last.Valid && now.Sub(last.Time) < time.Duration(s.config.ResendInterval)*time.Second {
+		return false, nil
+	}
+	return true, nil
+}
+
+// record 记录一次验证码申请，codeHash为空表示没有发送验证码
+func (s *PasswordResetService) record(userID interface{}, channel, codeHash, ip string, now time.Time) error {
+	_, err := db.DB.Exec(`
+		INSERT INTO password_resets (user_id, channel, code_hash, expires_at, ip, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, userID, channel, codeHash, now.Add(time.Duration(s.config.CodeTTL)*time.Second), ip, now)
+	return err
+}
+
+// resetCode 生成随机数字验证码
+func resetCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < resetCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", resetCodeDigits, n.Int64()), nil
+}
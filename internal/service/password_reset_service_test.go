@@ -0,0 +1,283 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/notify"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	resetIPCount     = regexp.QuoteMeta("SELECT COUNT(*) FROM password_resets WHERE ip = ? AND created_at > ?")
+	resetFindUser    = regexp.QuoteMeta("FROM users WHERE username = ?")
+	resetAllowSend   = regexp.QuoteMeta("SELECT COUNT(*), MAX(created_at) FROM password_resets")
+	resetRecord      = regexp.QuoteMeta("INSERT INTO password_resets (user_id, channel, code_hash, expires_at, ip, created_at)")
+	resetLatestCode  = regexp.QuoteMeta("SELECT id, code_hash FROM password_resets")
+	resetAttempt     = regexp.QuoteMeta("UPDATE password_resets SET attempts = attempts + 1 WHERE id = ? AND attempts < ? AND verified_at IS NULL")
+	resetVerified    = regexp.QuoteMeta("UPDATE password_resets SET verified_at = ?, token_hash = ?, token_expires_at = ?")
+	resetFindToken   = regexp.QuoteMeta("SELECT id, user_id FROM password_resets")
+	resetTargetUser  = regexp.QuoteMeta("SELECT username, role, password_hash, auth_source, status FROM users WHERE id = ?")
+	resetCodePattern = regexp.MustCompile(`验证码为(\d{6})`)
+)
+
+func newTestResetService(now time.Time) (*PasswordResetService, *notify.Memory, *notify.Memory) {
+	cfg := &config.Config{
+		Password: config.PasswordConfig{MinLength: 8, RequireLetter: true, RequireDigit: true},
+		Reset: config.PasswordResetConfig{
+			CodeTTL: 600, ResendInterval: 60, MaxPerHour: 3, IPMaxPerHour: 5, MaxAttempts: 3, TokenTTL: 600,
+		},
+		Notify: config.NotifyConfig{Signature: "市卫健委"},
+	}
+	email, sms := notify.NewMemory(), notify.NewMemory()
+	s := NewPasswordResetServiceWithNotifiers(cfg, NewUserService(cfg, nil), email, sms)
+	s.now = func() time.Time { return now }
+	return s, email, sms
+}
+
+// resetUserRows findLoginUser查询的一行结果
+func resetUserRows(authSource string, status int) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{
+		"id", "username", "password_hash", "name", "gender", "email", "role", "phone", "id_card", "birth_date",
+		"department_id", "department", "job_title", "avatar", "status", "created_at", "updated_at",
+		"must_change_password", "password_changed_at", "totp_enabled", "auth_source",
+	}).AddRow(
+		7, "zhangsan", "", "张三", "男", "zhangsan@example.com", models.RoleEmployee, "13800138000", "", "",
+		0, "", "", "", status, now, now, false, now, false, authSource,
+	)
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	s, email, _ := newTestResetService(now)
+	ip := "10.0.0.1"
+
+	// 申请验证码：旧验证码作废，新验证码只保存哈希
+	codeHash := &capturedArg{}
+	mock.ExpectQuery(resetIPCount).WithArgs(ip, now.Add(-time.Hour)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(resetFindUser).WithArgs("zhangsan").WillReturnRows(resetUserRows(models.AuthSourceLocal, 1))
+	mock.ExpectQuery(resetAllowSend).WithArgs(7, now.Add(-time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "last"}).AddRow(0, nil))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE password_resets SET expires_at = ? WHERE user_id = ? AND verified_at IS NULL AND expires_at > ?")).
+		WithArgs(now, 7, now).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(resetRecord).WithArgs(7, models.ResetChannelEmail, codeHash, now.Add(10*time.Minute), ip, now).
+		WillReturnResult(sqlmock.NewResult(11, 1))
+	if err := s.RequestCode(" zhangsan ", models.ResetChannelEmail, ip); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, ok := email.Last("zhangsan@example.com")
+	if !ok {
+		t.Fatal("no code was sent")
+	}
+	match := resetCodePattern.FindStringSubmatch(msg.Body)
+	if match == nil {
+		t.Fatalf("message has no code: %s", msg.Body)
+	}
+	code := match[1]
+	if codeHash.String() != hashToken(code) {
+		t.Fatal("stored code hash does not match the sent code")
+	}
+
+	// 校验验证码，换取设置新密码的凭证
+	tokenHash := &capturedArg{}
+	mock.ExpectQuery(resetFindUser).WithArgs("zhangsan").WillReturnRows(resetUserRows(models.AuthSourceLocal, 1))
+	mock.ExpectQuery(resetLatestCode).WithArgs(7, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "code_hash"}).AddRow(11, hashToken(code)))
+	mock.ExpectExec(resetAttempt).WithArgs(11, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(resetVerified).WithArgs(now, tokenHash, now.Add(10*time.Minute), 11).WillReturnResult(sqlmock.NewResult(0, 1))
+	token, err := s.VerifyCode("zhangsan", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokenHash.String() != hashToken(token) {
+		t.Fatal("stored token hash does not match the returned token")
+	}
+
+	// 设置新密码：凭证作废、解除锁定、注销会话并记录审计日志
+	oldHash, _ := bcrypt.GenerateFromPassword([]byte("OldPass2025"), bcrypt.MinCost)
+	mock.ExpectQuery(resetFindToken).WithArgs(hashToken(token), now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(11, 7))
+	mock.ExpectQuery(resetTargetUser).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "role", "password_hash", "auth_source", "status"}).
+			AddRow("zhangsan", models.RoleEmployee, string(oldHash), models.AuthSourceLocal, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE password_resets SET used_at = ? WHERE id = ? AND used_at IS NULL")).
+		WithArgs(now, 11).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET password_hash = ?, must_change_password = ?")).
+		WithArgs(sqlmock.AnyArg(), false, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs")).
+		WithArgs(7, "zhangsan", ip, models.AuditUserPasswordRecover, models.AuditEntityUser, 7, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = ?")).
+		WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE password_resets SET expires_at = ?, token_expires_at = ? WHERE user_id = ? AND used_at IS NULL")).
+		WithArgs(now, now, 7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_sessions SET revoked_at = ?")).
+		WithArgs(sqlmock.AnyArg(), 7, "").WillReturnResult(sqlmock.NewResult(0, 2))
+	if err := s.ResetPassword(token, "NewPass2026", ip); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 过期的验证码和凭证在查询条件中被排除，与错误的验证码返回同样的提示
+func TestPasswordResetExpired(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	s, _, _ := newTestResetService(now)
+
+	mock.ExpectQuery(resetFindUser).WithArgs("zhangsan").WillReturnRows(resetUserRows(models.AuthSourceLocal, 1))
+	mock.ExpectQuery(resetLatestCode).WithArgs(7, now).WillReturnRows(sqlmock.NewRows([]string{"id", "code_hash"}))
+	if _, err := s.VerifyCode("zhangsan", "123456"); err != errResetCode {
+		t.Fatalf("expired code: got %v, want errResetCode", err)
+	}
+
+	mock.ExpectQuery(resetFindToken).WithArgs(hashToken("expired-token"), now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}))
+	if err := s.ResetPassword("expired-token", "NewPass2026", "10.0.0.1"); err != errResetToken {
+		t.Fatalf("expired token: got %v, want errResetToken", err)
+	}
+}
+
+// 每个验证码最多尝试MaxAttempts次，达到上限后正确的验证码也不能使用
+func TestPasswordResetAttemptCap(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	s, _, _ := newTestResetService(now)
+	codeRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "code_hash"}).AddRow(11, hashToken("123456"))
+	}
+
+	mock.ExpectQuery(resetFindUser).WithArgs("zhangsan").WillReturnRows(resetUserRows(models.AuthSourceLocal, 1))
+	mock.ExpectQuery(resetLatestCode).WithArgs(7, now).WillReturnRows(codeRows())
+	mock.ExpectExec(resetAttempt).WithArgs(11, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	if _, err := s.VerifyCode("zhangsan", "654321"); err != errResetCode {
+		t.Fatalf("wrong code: got %v, want errResetCode", err)
+	}
+
+	mock.ExpectQuery(resetFindUser).WithArgs("zhangsan").WillReturnRows(resetUserRows(models.AuthSourceLocal, 1))
+	mock.ExpectQuery(resetLatestCode).WithArgs(7, now).WillReturnRows(codeRows())
+	mock.ExpectExec(resetAttempt).WithArgs(11, 3).WillReturnResult(sqlmock.NewResult(0, 0))
+	if _, err := s.VerifyCode("zhangsan", "123456"); err != errResetCode {
+		t.Fatalf("attempts exhausted: got %v, want errResetCode", err)
+	}
+}
+
+func TestPasswordResetIPLimit(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	s, email, _ := newTestResetService(now)
+
+	mock.ExpectQuery(resetIPCount).WithArgs("10.0.0.1", now.Add(-time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	if err := s.RequestCode("zhangsan", models.ResetChannelEmail, "10.0.0.1"); !errors.Is(err, ErrResetLimited) {
+		t.Fatalf("got %v, want ErrResetLimited", err)
+	}
+	if len(email.Messages()) != 0 {
+		t.Error("code sent despite the IP limit")
+	}
+}
+
+// 同一账号超过每小时上限或未到重发间隔时不发送验证码，但仍返回成功并记录申请
+func TestPasswordResetAccountLimit(t *testing.T) {
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	cases := []struct {
+		name  string
+		count int
+		last  interface{}
+	}{
+		{"hourly limit", 3, now.Add(-30 * time.Minute)},
+		{"resend interval", 1, now.Add(-30 * time.Second)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mock := mockDB(t)
+			s, _, sms := newTestResetService(now)
+
+			mock.ExpectQuery(resetIPCount).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			mock.ExpectQuery(resetFindUser).WithArgs("zhangsan").WillReturnRows(resetUserRows(models.AuthSourceLocal, 1))
+			mock.ExpectQuery(resetAllowSend).WithArgs(7, now.Add(-time.Hour)).
+				WillReturnRows(sqlmock.NewRows([]string{"count", "last"}).AddRow(c.count, c.last))
+			mock.ExpectExec(resetRecord).WithArgs(7, models.ResetChannelSMS, "", now.Add(10*time.Minute), "10.0.0.1", now).
+				WillReturnResult(sqlmock.NewResult(12, 1))
+			if err := s.RequestCode("zhangsan", models.ResetChannelSMS, "10.0.0.1"); err != nil {
+				t.Fatal(err)
+			}
+			if len(sms.Messages()) != 0 {
+				t.Error("code sent despite the account limit")
+			}
+		})
+	}
+}
+
+// 域账号、统一身份认证账号和已禁用的账号不能自助找回密码，响应与正常账号一致
+func TestPasswordResetRefusesNonLocalAccounts(t *testing.T) {
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	cases := []struct {
+		name       string
+		authSource string
+		status     int
+	}{
+		{"ldap", models.AuthSourceLDAP, 1},
+		{"oidc", models.AuthSourceOIDC, 1},
+		{"disabled", models.AuthSourceLocal, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mock := mockDB(t)
+			s, email, _ := newTestResetService(now)
+
+			mock.ExpectQuery(resetIPCount).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+			mock.ExpectQuery(resetFindUser).WithArgs("zhangsan").WillReturnRows(resetUserRows(c.authSource, c.status))
+			mock.ExpectExec(resetRecord).WithArgs(7, models.ResetChannelEmail, "", now.Add(10*time.Minute), "10.0.0.1", now).
+				WillReturnResult(sqlmock.NewResult(12, 1))
+			if err := s.RequestCode("zhangsan", models.ResetChannelEmail, "10.0.0.1"); err != nil {
+				t.Fatal(err)
+			}
+			if len(email.Messages()) != 0 {
+				t.Error("code sent to a non-local account")
+			}
+
+			// 验证码发出后账号改为外部认证，凭证同样不能使用
+			mock.ExpectQuery(resetFindToken).WithArgs(hashToken("token"), now).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(11, 7))
+			mock.ExpectQuery(resetTargetUser).WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"username", "role", "password_hash", "auth_source", "status"}).
+					AddRow("zhangsan", models.RoleEmployee, "", c.authSource, c.status))
+			if err := s.ResetPassword("token", "NewPass2026", "10.0.0.1"); err != errResetToken {
+				t.Fatalf("got %v, want errResetToken", err)
+			}
+		})
+	}
+}
+
+// 账号不存在时同样返回成功，不发送验证码
+func TestPasswordResetUnknownAccount(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	s, email, _ := newTestResetService(now)
+
+	mock.ExpectQuery(resetIPCount).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(resetFindUser).WithArgs("nobody").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectExec(resetRecord).WithArgs(nil, models.ResetChannelEmail, "", now.Add(10*time.Minute), "10.0.0.1", now).
+		WillReturnResult(sqlmock.NewResult(12, 1))
+	if err := s.RequestCode("nobody", models.ResetChannelEmail, "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(email.Messages()) != 0 {
+		t.Error("code sent for an unknown account")
+	}
+
+	mock.ExpectQuery(resetFindUser).WithArgs("nobody").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if _, err := s.VerifyCode("nobody", "123456"); err != errResetCode {
+		t.Fatalf("got %v, want errResetCode", err)
+	}
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+)
+
+// roleCodePattern 自定义角色编码：小写字母开头，只能包含小写字母、数字和下划线
+var roleCodePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,19}$`)
+
+// PermissionService 角色和权限服务
+type PermissionService struct{}
+
+// NewPermissionService 创建角色和权限服务
+func NewPermissionService() *PermissionService {
+	return &PermissionService{}
+}
+
+// ListPermissions 获取全部权限
+func (s *PermissionService) ListPermissions() ([]models.Permission, error) {
+	rows, err := db.DB.Query("SELECT code, name, description FROM permissions ORDER BY code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []models.Permission{}
+	for rows.Next() {
+		var permission models.Permission
+		if err := rows.Scan(&permission.Code, &permission.Name, &permission.Description); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, rows.Err()
+}
+
+// RolePermissions 获取角色拥有的权限编码，站长拥有全部权限
+func (s *PermissionService) RolePermissions(role string) ([]string, error) {
+	var rows *sql.Rows
+	var err error
+	if role == models.RoleAdmin {
+		rows, err = db.DB.Query("SELECT code FROM permissions ORDER BY code")
+	} else {
+		rows, err = db.DB.Query(
+			"SELECT permission_code FROM role_permissions WHERE role_code = ? ORDER BY permission_code", role,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []string{}
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, rows.Err()
+}
+
+// ListRoles 获取全部角色及其权限和人数
+func (s *PermissionService) ListRoles() ([]models.Role, error) {
+	rows, err := db.DB.Query(`
+		SELECT r.code, r.name, r.description, r.is_system, r.created_at, r.updated_at,
+		       (SELECT COUNT(*) FROM users u WHERE u.role = r.code)
+		FROM roles r
+		ORDER BY r.is_system DESC, r.created_at, r.code
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		var role models.Role
+		err := rows.Scan(&role.Code, &role.Name, &role.Description, &role.IsSystem,
+			&role.CreatedAt, &role.UpdatedAt, &role.UserCount)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range roles {
+		if roles[i].Permissions, err = s.RolePermissions(roles[i].Code); err != nil {
+			return nil, err
+		}
+	}
+
+	return roles, nil
+}
+
+// GetRole 获取角色详情
+func (s *PermissionService) GetRole(code string) (*models.Role, error) {
+	var role models.Role
+	err := db.DB.QueryRow(`
+		SELECT r.code, r.name, r.description, r.is_system, r.created_at, r.updated_at,
+		       (SELECT COUNT(*) FROM users u WHERE u.role = r.code)
+		FROM roles r WHERE r.code = ?
+	`, code).Scan(&role.Code, &role.Name, &role.Description, &role.IsSystem,
+		&role.CreatedAt, &role.UpdatedAt, &role.UserCount)
+	if err != nil {
+		return nil, errors.New("角色不存在")
+	}
+
+	if role.Permissions, err = s.RolePermissions(role.Code); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// CreateRole 创建自定义角色
+func (s *PermissionService) CreateRole(op Operator, req *models.RoleCreateRequest) (*models.Role, error) {
+	code := strings.TrimSpace(req.Code)
+	if !roleCodePattern.MatchString(code) {
+		return nil, errors.New("角色编码只能包含小写字母、数字和下划线，以字母开头，长度2-20位")
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, errors.New("角色名称不能为空")
+	}
+
+	var count int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM roles WHERE code = ? OR name = ?", code, name).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("角色编码或名称已存在")
+	}
+
+	permissions, err := s.normalizePermissions(req.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	description := strings.TrimSpace(req.Description)
+	_, err = tx.Exec("INSERT INTO roles (code, name, description) VALUES (?, ?, ?)", code, name, description)
+	if err != nil {
+		return nil, err
+	}
+	if err := setRolePermissions(tx, code, permissions); err != nil {
+		return nil, err
+	}
+	after := roleAuditFields(name, description, permissions)
+	after["code"] = code
+	err = recordAudit(tx, op, auditEvent{Action: models.AuditRoleCreate, EntityType: models.AuditEntityRole, After: after})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetRole(code)
+}
+
+// UpdateRole 修改角色名称、说明和权限，站长角色不能修改
+func (s *PermissionService) UpdateRole(op Operator, code string, req *models.RoleUpdateRequest) (*models.Role, error) {
+	if code == models.RoleAdmin {
+		return nil, errors.New("站长角色拥有全部权限，不能修改")
+	}
+	before, err := s.GetRole(code)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return nil, errors.New("角色名称不能为空")
+	}
+
+	var count int
+	err = db.DB.QueryRow("SELECT COUNT(*) FROM roles WHERE name = ? AND code <> ?", name, code).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("角色名称已存在")
+	}
+
+	permissions, err := s.normalizePermissions(req.Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	description := strings.TrimSpace(req.Description)
+	_, err = tx.Exec("UPDATE roles SET name = ?, description = ? WHERE code = ?", name, description, code)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM role_permissions WHERE role_code = ?", code); err != nil {
+		return nil, err
+	}
+	if err := setRolePermissions(tx, code, permissions); err != nil {
+		return nil, err
+	}
+	// 编码不会变化，只记录在after中，否则会被当作未变化的字段省略
+	after := roleAuditFields(name, description, permissions)
+	after["code"] = code
+	err = recordAudit(tx, op, auditEvent{
+		Action:     models.AuditRoleUpdate,
+		EntityType: models.AuditEntityRole,
+		Before:     roleAuditFields(before.Name, before.Description, before.Permissions),
+		After:      after,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.GetRole(code)
+}
+
+// DeleteRole 删除自定义角色，内置角色和仍有人员使用的角色不能删除
+func (s *PermissionService) DeleteRole(op Operator, code string) error {
+	role, err := s.GetRole(code)
+	if err != nil {
+		return err
+	}
+	if role.IsSystem {
+		return errors.New("内置角色不能删除")
+	}
+	if role.UserCount > 0 {
+		return errors.New("仍有人员使用该角色，不能删除")
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM roles WHERE code = ?", code); err != nil {
+		return err
+	}
+	before := roleAuditFields(role.Name, role.Description, role.Permissions)
+	before["code"] = code
+	err = recordAudit(tx, op, auditEvent{Action: models.AuditRoleDelete, EntityType: models.AuditEntityRole, Before: before})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RoleExists 判断角色是否存在
+func (s *PermissionService) RoleExists(code string) (bool, error) {
+	var count int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM roles WHERE code = ?", code).Scan(&count)
+	return count > 0, err
+}
+
+// normalizePermissions 去重并检查权限编码是否存在
+func (s *PermissionService) normalizePermissions(codes []string) ([]string, error) {
+	all, err := s.ListPermissions()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(all))
+	for _, permission := range all {
+		known[permission.Code] = true
+	}
+
+	seen := make(map[string]bool, len(codes))
+	result := []string{}
+	for _, code := range codes {
+		code = strings.TrimSpace(code)
+		if seen[code] {
+			continue
+		}
+		if !known[code] {
+			return nil, errors.New("权限不存在: " + code)
+		}
+		seen[code] = true
+		result = append(result, code)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// setRolePermissions 写入角色权限
+func setRolePermissions(tx *sql.Tx, role string, permissions []string) error {
+	for _, permission := range permissions {
+		_, err := tx.Exec("INSERT INTO role_permissions (role_code, permission_code) VALUES (?, ?)", role, permission)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/internal/models"
+)
+
+// changesContain 匹配包含指定片段的审计变化内容
+type changesContain string
+
+func (c changesContain) Match(v driver.Value) bool {
+	switch s := v.(type) {
+	case string:
+		return strings.Contains(s, string(c))
+	case []byte:
+		return strings.Contains(string(s), string(c))
+	}
+	return false
+}
+
+// 删除角色与审计日志在同一事务中提交，日志记录被删除角色的编码和权限
+func TestDeleteRoleRecordsAudit(t *testing.T) {
+	mock := mockDB(t)
+	permissions := NewPermissionService()
+	op := Operator{UserID: 1, Username: "admin", Role: models.RoleAdmin, IP: "10.0.0.1"}
+	now := time.Now()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM roles r WHERE r.code = ?")).WithArgs("trainer").
+		WillReturnRows(sqlmock.NewRows([]string{"code", "name", "description", "is_system",
+			"created_at", "updated_at", "user_count"}).
+			AddRow("trainer", "培训专员", "", false, now, now, 0))
+	mock.ExpectQuery(rolePermissions).WithArgs("trainer").
+		WillReturnRows(permissionRows(models.PermQuestionEdit))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM roles WHERE code = ?")).WithArgs("trainer").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs")).
+		WithArgs(1, "admin", "10.0.0.1", models.AuditRoleDelete, models.AuditEntityRole, 0,
+			changesContain(`"trainer"`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if err := permissions.DeleteRole(op, "trainer"); err != nil {
+		t.Fatalf("删除角色失败: %v", err)
+	}
+}
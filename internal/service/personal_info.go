@@ -0,0 +1,357 @@
+package service
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/idnumber"
+)
+
+// PersonalInfoError 手机号或身份证号校验失败
+type PersonalInfoError struct {
+	Message string
+}
+
+func (e *PersonalInfoError) Error() string {
+	return e.Message
+}
+
+// ErrInvalidPhone 手机号格式错误
+var ErrInvalidPhone = &PersonalInfoError{Message: "手机号格式不正确，请填写11位手机号"}
+
+// normalizePhone 校验手机号并去除空格、连字符和+86区号，未填写时返回空字符串
+func normalizePhone(phone string) (string, error) {
+	phone = idnumber.NormalizeMobile(phone)
+	if phone != "" && !idnumber.ValidMobile(phone) {
+		return "", ErrInvalidPhone
+	}
+	return phone, nil
+}
+
+// looksLikePhone 11位数字的用户名视为手机号
+func looksLikePhone(username string) bool {
+	return len(username) == 11 && strings.Trim(username, "0123456789") == ""
+}
+
+// looksLikeIDCard 17位数字加数字或X的用户名视为身份证号
+func looksLikeIDCard(username string) bool {
+	return len(username) == 18 && strings.Trim(username[:17], "0123456789") == "" &&
+		strings.Trim(username[17:], "0123456789xX") == ""
+}
+
+// ErrUsernameIsNumber 用户名不能使用手机号或身份证号，号码只加密保存在对应字段中
+var ErrUsernameIsNumber = &PersonalInfoError{Message: "用户名不能使用手机号或身份证号，请填写在手机号或身份证号中，可直接用号码登录"}
+
+// generateUsername 为以手机号或身份证号注册、导入的账号生成不含号码的随机用户名，这类账号通过盲索引用号码登录
+func generateUsername() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "u" + hex.EncodeToString(buf), nil
+}
+
+// maskLoginName 号码形式的登录名脱敏，用于登录记录
+func maskLoginName(username string) string {
+	switch {
+	case looksLikePhone(username):
+		return idnumber.MaskMobile(username)
+	case looksLikeIDCard(username):
+		return idnumber.MaskIDCard(username)
+	}
+	return username
+}
+
+// parseIDCard 校验18位身份证号，返回规范化后的号码及其中的性别和出生日期
+func parseIDCard(number string) (*idnumber.IDCard, error) {
+	card, err := idnumber.ParseIDCard(number, time.Now())
+	switch {
+	case err == nil:
+		return card, nil
+	case errors.Is(err, idnumber.ErrIDCardChecksum):
+		return nil, &PersonalInfoError{Message: "身份证号校验码错误，请核对后重新填写"}
+	case errors.Is(err, idnumber.ErrIDCardBirthdate):
+		return nil, &PersonalInfoError{Message: "身份证号中的出生日期无效"}
+	case errors.Is(err, idnumber.ErrIDCardRegion):
+		return nil, &PersonalInfoError{Message: "身份证号中的地区代码无效"}
+	default:
+		return nil, &PersonalInfoError{Message: "身份证号应为18位，最后一位可以是数字或X"}
+	}
+}
+
+// personalInfo 校验后的手机号和身份证号，字段为nil表示不修改。
+// 经sealPersonalInfo处理后phone和idCard为加密后的值
+type personalInfo struct {
+	phone         interface{}
+	idCard        interface{}
+	gender        interface{} // 填写了身份证号时以号码中的性别为准
+	birthDate     interface{} // 身份证号清空时为nil
+	phoneIndex    interface{} // 盲索引，号码清空时为nil
+	idCardIndex   interface{}
+	phoneChanged  bool
+	idCardChanged bool
+}
+
+// parsePersonalInfo 校验提交的手机号、身份证号和性别。
+// 页面回填的是脱敏后的号码，原样提交脱敏值表示不修改
+func parsePersonalInfo(phone, idCard, gender string) (*personalInfo, error) {
+	info := &personalInfo{}
+	if gender != "" {
+		info.gender = gender
+	}
+
+	if !idnumber.IsMasked(phone) {
+		normalized, err := normalizePhone(phone)
+		if err != nil {
+			return nil, err
+		}
+		info.phone = normalized
+		info.phoneChanged = true
+	}
+
+	if !idnumber.IsMasked(idCard) {
+		info.idCard = ""
+		info.idCardChanged = true
+		if strings.TrimSpace(idCard) != "" {
+			card, err := parseIDCard(idCard)
+			if err != nil {
+				return nil, err
+			}
+			info.idCard = card.Number
+			info.gender = card.Gender()
+			info.birthDate = card.Birthdate.Format("2006-01-02")
+		}
+	}
+	return info, nil
+}
+
+// 加密字段的名称，作为密文的附加数据和盲索引的输入，防止不同字段之间复制密文或比较索引
+const (
+	fieldPhone  = "users.phone"
+	fieldIDCard = "users.id_card"
+)
+
+// 手机号、身份证号已被其他账号使用
+var (
+	ErrPhoneTaken  = &PersonalInfoError{Message: "该手机号已被其他账号使用"}
+	ErrIDCardTaken = &PersonalInfoError{Message: "该身份证号已被其他账号使用"}
+)
+
+// seal 加密字段值并计算盲索引，空值不加密，盲索引为nil
+func (s *UserService) seal(field, plaintext string) (string, interface{}, error) {
+	if plaintext == "" {
+		return "", nil, nil
+	}
+	ciphertext, err := s.crypto.Encrypt(plaintext, field)
+	if err != nil {
+		return "", nil, err
+	}
+	return ciphertext, s.crypto.BlindIndex(plaintext, field), nil
+}
+
+// sealPersonalInfo 加密要修改的手机号和身份证号，并检查是否已被其他账号使用；userID为0表示新账号
+func (s *UserService) sealPersonalInfo(userID int, info *personalInfo) error {
+	if info.phoneChanged {
+		ciphertext, index, err := s.seal(fieldPhone, info.phone.(string))
+		if err != nil {
+			return err
+		}
+		info.phone, info.phoneIndex = ciphertext, index
+	}
+	if info.idCardChanged {
+		ciphertext, index, err := s.seal(fieldIDCard, info.idCard.(string))
+		if err != nil {
+			return err
+		}
+		info.idCard, info.idCardIndex = ciphertext, index
+	}
+	return s.checkPersonalInfoUnique(userID, info.phoneIndex, info.idCardIndex)
+}
+
+// checkPersonalInfoUnique 按盲索引检查手机号和身份证号是否已被其他账号使用，索引为nil时不检查
+func (s *UserService) checkPersonalInfoUnique(userID int, phoneIndex, idCardIndex interface{}) error {
+	checks := []struct {
+		column string
+		index  interface{}
+		err    error
+	}{
+		{"phone_hash", phoneIndex, ErrPhoneTaken},
+		{"id_card_hash", idCardIndex, ErrIDCardTaken},
+	}
+	for _, check := range checks {
+		if check.index == nil {
+			continue
+		}
+		var exists bool
+		err := db.DB.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE "+check.column+" = ? AND id <> ?)",
+			check.index, userID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return check.err
+		}
+	}
+	return nil
+}
+
+// openPersonalInfo 解密从数据库读取的手机号和身份证号
+func (s *UserService) openPersonalInfo(user *models.User) error {
+	phone, err := s.crypto.Decrypt(user.Phone, fieldPhone)
+	if err != nil {
+		return err
+	}
+	idCard, err := s.crypto.Decrypt(user.IDCard, fieldIDCard)
+	if err != nil {
+		return err
+	}
+	user.Phone, user.IDCard = phone, idCard
+	return nil
+}
+
+// loginIndex 用户名为手机号或身份证号时返回对应的盲索引列和索引值，用于手机号/身份证号登录
+func (s *UserService) loginIndex(username string) (string, string) {
+	switch {
+	case looksLikePhone(username):
+		if phone, err := normalizePhone(username); err == nil {
+			return "phone_hash", s.crypto.BlindIndex(phone, fieldPhone)
+		}
+	case looksLikeIDCard(username):
+		if card, err := parseIDCard(username); err == nil {
+			return "id_card_hash", s.crypto.BlindIndex(card.Number, fieldIDCard)
+		}
+	}
+	return "", ""
+}
+
+// findUserByNumber 按已规范化的手机号、身份证号的盲索引依次查找用户，都未找到时返回nil
+func (s *UserService) findUserByNumber(phone, idCard string) (*models.User, error) {
+	for _, number := range []string{phone, idCard} {
+		column, index := s.loginIndex(number)
+		if column == "" {
+			continue
+		}
+		user, _, err := s.findLoginUser(column, index)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		return user, err
+	}
+	return nil, nil
+}
+
+// personalInfoBatchSize 每批重新加密的账号数量
+const personalInfoBatchSize = 500
+
+// EncryptPersonalInfo 用当前密钥加密尚未加密或使用旧密钥加密的手机号和身份证号，并重新计算盲索引，
+// 用户名就是本人手机号或身份证号的账号改为随机用户名，返回更新的账号数量。每次启动时执行，密钥轮换或更换盲索引密钥后重启即可完成迁移
+func (s *UserService) EncryptPersonalInfo() (int, error) {
+	updated, lastID := 0, 0
+	for {
+		rows, err := db.DB.Query(`
+			SELECT id, username, COALESCE(phone, ''), COALESCE(id_card, ''), COALESCE(phone_hash, ''), COALESCE(id_card_hash, '')
+			FROM users WHERE id > ? ORDER BY id LIMIT ?
+		`, lastID, personalInfoBatchSize)
+		if err != nil {
+			return updated, err
+		}
+
+		type storedInfo struct {
+			id                      int
+			username                string
+			phone, idCard           string
+			phoneIndex, idCardIndex string
+		}
+		var batch []storedInfo
+		for rows.Next() {
+			var row storedInfo
+			if err := rows.Scan(&row.id, &row.username, &row.phone, &row.idCard, &row.phoneIndex, &row.idCardIndex); err != nil {
+				rows.Close()
+				return updated, err
+			}
+			batch = append(batch, row)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return updated, err
+		}
+		if len(batch) == 0 {
+			return updated, nil
+		}
+		lastID = batch[len(batch)-1].id
+
+		for _, row := range batch {
+			changed, err := s.rewritePersonalInfo(row.id, row.username, row.phone, row.idCard, row.phoneIndex, row.idCardIndex)
+			if err != nil {
+				// 单个账号失败（例如历史数据中的重复号码）不影响其他账号
+				log.Printf("加密用户 %d 的个人信息失败: %v", row.id, err)
+				continue
+			}
+			if changed {
+				updated++
+			}
+		}
+	}
+}
+
+// rewritePersonalInfo 需要时重新加密一个账号的手机号和身份证号、替换号码形式的用户名，返回是否有修改
+func (s *UserService) rewritePersonalInfo(userID int, username, phone, idCard, phoneIndex, idCardIndex string) (bool, error) {
+	fields := []struct {
+		name            string
+		stored, indexed string
+	}{
+		{fieldPhone, phone, phoneIndex},
+		{fieldIDCard, idCard, idCardIndex},
+	}
+	values := make([]interface{}, 0, 5)
+	changed := false
+	for _, field := range fields {
+		plaintext, err := s.crypto.Decrypt(field.stored, field.name)
+		if err != nil {
+			return false, err
+		}
+		// 早期以号码注册或导入的账号用户名是明文号码，号码已有盲索引，改为随机用户名后仍可用号码登录
+		if plaintext != "" && strings.EqualFold(username, plaintext) {
+			if username, err = generateUsername(); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+		value, index := field.stored, ""
+		if plaintext != "" {
+			index = s.crypto.BlindIndex(plaintext, field.name)
+		}
+		if s.crypto.NeedsRewrite(field.stored) {
+			if value, err = s.crypto.Encrypt(plaintext, field.name); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+		if index != field.indexed {
+			changed = true
+		}
+		values = append(values, value, nullableString(index))
+	}
+	if !changed {
+		return false, nil
+	}
+
+	_, err := db.DB.Exec("UPDATE users SET phone = ?, phone_hash = ?, id_card = ?, id_card_hash = ?, username = ? WHERE id = ?",
+		append(values, username, userID)...)
+	return err == nil, err
+}
+
+// nullableString 空字符串保存为NULL
+func nullableString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,377 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+)
+
+// ProctorError 违规事件或阈值设置不符合要求
+type ProctorError struct {
+	Message string
+}
+
+func (e *ProctorError) Error() string {
+	return e.Message
+}
+
+var errProctorRecord = &ProctorError{Message: "考试记录不存在"}
+
+// proctorStatusRank 监考状态的严重程度，状态只升不降
+var proctorStatusRank = map[string]int{
+	models.ProctorStatusNormal:         0,
+	models.ProctorStatusWarned:         1,
+	models.ProctorStatusFlagged:        2,
+	models.ProctorStatusForceSubmitted: 3,
+}
+
+// proctorEventNames 违规事件的中文名称，用于提醒考生
+var proctorEventNames = map[string]string{
+	models.ProctorEventTabSwitch:      "切换页面",
+	models.ProctorEventBlur:           "离开考试窗口",
+	models.ProctorEventCopy:           "复制",
+	models.ProctorEventPaste:          "粘贴",
+	models.ProctorEventFullscreenExit: "退出全屏",
+	models.ProctorEventMultiSession:   "在其他设备或浏览器作答",
+}
+
+// ProctoringService 在线考试防作弊服务：记录违规事件，按试卷阈值提醒、标记或强制交卷
+type ProctoringService struct{}
+
+// NewProctoringService 创建防作弊服务
+func NewProctoringService() *ProctoringService {
+	return &ProctoringService{}
+}
+
+// GetPolicy 获取试卷的违规次数阈值
+func (s *ProctoringService) GetPolicy(examID int) (*models.ProctorPolicy, error) {
+	policy := models.ProctorPolicy{ExamID: examID}
+	err := db.DB.QueryRow(`
+		SELECT proctor_warn_threshold, proctor_flag_threshold, proctor_submit_threshold
+		FROM exams WHERE id = ?
+	`, examID).Scan(&policy.WarnThreshold, &policy.FlagThreshold, &policy.SubmitThreshold)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, &ProctorError{Message: "试卷不存在"}
+		}
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// SetPolicy 设置试卷的违规次数阈值，启用的阈值必须按警告、标记、交卷的顺序不减
+func (s *ProctoringService) SetPolicy(op Operator, examID int, req *models.ProctorPolicyRequest) (*models.ProctorPolicy, error) {
+	thresholds := []int{req.WarnThreshold, req.FlagThreshold, req.SubmitThreshold}
+	last := 0
+	for _, threshold := range thresholds {
+		if threshold == 0 {
+			continue
+		}
+		if threshold < last {
+			return nil, &ProctorError{Message: "警告、标记、强制交卷的阈值必须依次不小于前一项"}
+		}
+		last = threshold
+	}
+
+	before, err := s.GetPolicy(examID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.DB.Exec(`
+		UPDATE exams SET proctor_warn_threshold = ?, proctor_flag_threshold = ?, proctor_submit_threshold = ?
+		WHERE id = ?
+	`, req.WarnThreshold, req.FlagThreshold, req.SubmitThreshold, examID)
+	if err != nil {
+		return nil, err
+	}
+
+	after := &models.ProctorPolicy{
+		ExamID:          examID,
+		WarnThreshold:   req.WarnThreshold,
+		FlagThreshold:   req.FlagThreshold,
+		SubmitThreshold: req.SubmitThreshold,
+	}
+	logAudit(op, auditEvent{
+		Action:     models.AuditExamProctoring,
+		EntityType: models.AuditEntityExam,
+		EntityID:   examID,
+		Before:     proctorPolicyFields(before),
+		After:      proctorPolicyFields(after),
+	})
+	return after, nil
+}
+
+func proctorPolicyFields(policy *models.ProctorPolicy) map[string]interface{} {
+	return map[string]interface{}{
+		"warn_threshold":   policy.WarnThreshold,
+		"flag_threshold":   policy.FlagThreshold,
+		"submit_threshold": policy.SubmitThreshold,
+	}
+}
+
+// ReportEvent 记录考生端上报的事件。sessionID为当前会话，与开始考试的会话不同时另记一次多会话作答；
+// 心跳不计入违规，只用于发现其他会话。违规次数达到强制交卷阈值时在同一事务中结束考试
+func (s *ProctoringService) ReportEvent(op Operator, sessionID string, recordID int, req *models.ProctorEventRequest) (*models.ProctorEventResult, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// 锁定考试记录，同一记录并发上报时依次计数
+	var userID, count int
+	var status, boundSession, proctorStatus string
+	var policy models.ProctorPolicy
+	err = tx.QueryRow(`
+		SELECT r.user_id, r.status, COALESCE(r.session_id, ''), r.violation_count, r.proctor_status,
+		       e.proctor_warn_threshold, e.proctor_flag_threshold, e.proctor_submit_threshold
+		FROM exam_records r
+		JOIN exams e ON r.exam_id = e.id
+		WHERE r.id = ?
+		FOR UPDATE
+	`, recordID).Scan(&userID, &status, &boundSession, &count, &proctorStatus,
+		&policy.WarnThreshold, &policy.FlagThreshold, &policy.SubmitThreshold)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = errProctorRecord
+		}
+		return nil, err
+	}
+	if userID != op.UserID {
+		err = errProctorRecord
+		return nil, err
+	}
+	if status != "ongoing" {
+		err = &ProctorError{Message: "考试已提交或已结束"}
+		return nil, err
+	}
+
+	events := []string{}
+	if req.Type != models.ProctorEventHeartbeat {
+		events = append(events, req.Type)
+	}
+
+	// 开始考试前未记录会话的考试记录，以第一次上报的会话为准
+	if boundSession == "" {
+		_, err = tx.Exec("UPDATE exam_records SET session_id = ? WHERE id = ?", sessionID, recordID)
+		if err != nil {
+			return nil, err
+		}
+	} else if sessionID != "" && sessionID != boundSession {
+		// 每个其他会话只记一次
+		var seen int
+		err = tx.QueryRow(`
+			SELECT COUNT(*) FROM proctoring_events WHERE record_id = ? AND event_type = ? AND session_id = ?
+		`, recordID, models.ProctorEventMultiSession, sessionID).Scan(&seen)
+		if err != nil {
+			return nil, err
+		}
+		if seen == 0 {
+			events = append(events, models.ProctorEventMultiSession)
+		}
+	}
+
+	for _, eventType := range events {
+		detail := truncate(req.Detail, 200)
+		if eventType == models.ProctorEventMultiSession {
+			detail = "开始考试的会话以外的会话上报了事件"
+		}
+		_, err = tx.Exec(`
+			INSERT INTO proctoring_events (record_id, event_type, detail, session_id, ip)
+			VALUES (?, ?, ?, ?, ?)
+		`, recordID, eventType, detail, sessionID, truncate(op.IP, 45))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newStatus := proctorStatus
+	if len(events) > 0 {
+		count += len(events)
+		newStatus = escalateProctorStatus(proctorStatus, count, &policy)
+		_, err = tx.Exec(`
+			UPDATE exam_records
+			SET violation_count = ?, proctor_status = ?,
+			    flagged_at = CASE WHEN flagged_at IS NULL AND ? IN ('flagged', 'force_submitted') THEN NOW() ELSE flagged_at END
+			WHERE id = ?
+		`, count, newStatus, newStatus, recordID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 达到强制交卷阈值时由服务器结束考试，之后考生端提交的答案一律拒绝，本次考试按未作答计0分
+	if newStatus == models.ProctorStatusForceSubmitted {
+		_, err = tx.Exec(`
+			UPDATE exam_records
+			SET end_time = NOW(), duration = TIMESTAMPDIFF(SECOND, start_time, NOW()), total_score = 0, status = 'graded'
+			WHERE id = ?
+		`, recordID)
+		if err != nil {
+			return nil, err
+		}
+		err = recordAudit(tx, op, auditEvent{
+			Action:     models.AuditExamForceSubmit,
+			EntityType: models.AuditEntityExamRecord,
+			EntityID:   recordID,
+			Before:     map[string]interface{}{"status": status, "proctor_status": proctorStatus},
+			After: map[string]interface{}{
+				"status":          "graded",
+				"proctor_status":  newStatus,
+				"total_score":     0,
+				"violation_count": count,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	result := &models.ProctorEventResult{ViolationCount: count, Status: newStatus, Action: models.ProctorActionNone}
+	switch {
+	case newStatus == models.ProctorStatusForceSubmitted:
+		result.Action = models.ProctorActionSubmit
+		result.Message = fmt.Sprintf("违规操作已达到%d次，考试已被强制交卷", policy.SubmitThreshold)
+	case len(events) > 0 && newStatus != models.ProctorStatusNormal:
+		result.Action = models.ProctorActionWarn
+		result.Message = fmt.Sprintf("检测到%s，已记录违规%d次", proctorEventNames[events[len(events)-1]], count)
+		if policy.SubmitThreshold > 0 {
+			result.Message += fmt.Sprintf("，达到%d次将强制交卷", policy.SubmitThreshold)
+		}
+	}
+	return result, nil
+}
+
+// escalateProctorStatus 根据违规次数和阈值确定监考状态，不会低于当前状态
+func escalateProctorStatus(current string, count int, policy *models.ProctorPolicy) string {
+	status := models.ProctorStatusNormal
+	switch {
+	case policy.SubmitThreshold > 0 && count >= policy.SubmitThreshold:
+		status = models.ProctorStatusForceSubmitted
+	case policy.FlagThreshold > 0 && count >= policy.FlagThreshold:
+		status = models.ProctorStatusFlagged
+	case policy.WarnThreshold > 0 && count >= policy.WarnThreshold:
+		status = models.ProctorStatusWarned
+	}
+	if proctorStatusRank[current] > proctorStatusRank[status] {
+		return current
+	}
+	return status
+}
+
+// RecordUserID 获取考试记录的考生ID，用于检查管理员能否查看该记录
+func (s *ProctoringService) RecordUserID(recordID int) (int, error) {
+	var userID int
+	err := db.DB.QueryRow("SELECT user_id FROM exam_records WHERE id = ?", recordID).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errProctorRecord
+	}
+	return userID, err
+}
+
+// ListEvents 获取考试记录的全部违规事件，按时间排序
+func (s *ProctoringService) ListEvents(recordID int) ([]models.ProctorEvent, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, record_id, event_type, detail, session_id, ip, created_at
+		FROM proctoring_events WHERE record_id = ?
+		ORDER BY id
+	`, recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.ProctorEvent{}
+	for rows.Next() {
+		var event models.ProctorEvent
+		err := rows.Scan(&event.ID, &event.RecordID, &event.Type, &event.Detail, &event.SessionID, &event.IP, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// ListFlaggedRecords 分页查询可疑考试记录，按标记时间倒序
+func (s *ProctoringService) ListFlaggedRecords(filter *models.FlaggedRecordFilter) ([]models.FlaggedRecord, int, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 100 {
+		filter.PageSize = 20
+	}
+
+	where := " WHERE 1 = 1"
+	args := []interface{}{}
+	if filter.Status != "" {
+		where += " AND r.proctor_status = ?"
+		args = append(args, filter.Status)
+	} else {
+		where += " AND r.proctor_status IN ('flagged', 'force_submitted')"
+	}
+	if filter.ExamID > 0 {
+		where += " AND r.exam_id = ?"
+		args = append(args, filter.ExamID)
+	}
+	if filter.DepartmentID > 0 {
+		subtree := SubtreeCondition("u.department_id", filter.DepartmentID)
+		where += " AND " + subtree.SQL
+		args = append(args, subtree.Args...)
+	}
+
+	from := `
+		FROM exam_records r
+		JOIN users u ON r.user_id = u.id
+		JOIN exams e ON r.exam_id = e.id`
+
+	var total int
+	err := db.DB.QueryRow("SELECT COUNT(*)"+from+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := (filter.Page - 1) * filter.PageSize
+	rows, err := db.DB.Query(`
+		SELECT r.id, e.id, e.title, u.id, u.username, u.name, COALESCE(u.department, ''),
+		       r.status, r.total_score, r.violation_count, r.proctor_status, r.flagged_at`+from+where+`
+		ORDER BY r.flagged_at IS NULL, r.flagged_at DESC, r.id DESC
+		LIMIT ? OFFSET ?
+	`, append(args, filter.PageSize, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	records := []models.FlaggedRecord{}
+	for rows.Next() {
+		var record models.FlaggedRecord
+		var flaggedAt sql.NullTime
+		err := rows.Scan(&record.RecordID, &record.ExamID, &record.ExamTitle, &record.UserID, &record.Username,
+			&record.Name, &record.Department, &record.RecordStatus, &record.TotalScore, &record.ViolationCount,
+			&record.ProctorStatus, &flaggedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		if flaggedAt.Valid {
+			record.FlaggedAt = flaggedAt.Time
+		}
+		records = append(records, record)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return records, total, nil
+}
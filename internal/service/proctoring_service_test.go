@@ -0,0 +1,85 @@
+package service
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/internal/models"
+)
+
+var proctorRecordQuery = regexp.QuoteMeta("FROM exam_records r")
+
+func proctorRecordRows(count int, proctorStatus string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"user_id", "status", "session_id", "violation_count", "proctor_status",
+		"warn", "flag", "submit"}).
+		AddRow(7, "ongoing", "s1", count, proctorStatus, 1, 2, 3)
+}
+
+func TestReportEventForceSubmitClosesRecord(t *testing.T) {
+	mock := mockDB(t)
+	proctor := NewProctoringService()
+	op := Operator{UserID: 7, Username: "zhangsan", IP: "10.0.0.1"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(proctorRecordQuery).WithArgs(3).WillReturnRows(proctorRecordRows(2, models.ProctorStatusFlagged))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO proctoring_events")).
+		WithArgs(3, models.ProctorEventTabSwitch, "", "s1", "10.0.0.1").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("SET violation_count = ?, proctor_status = ?")).
+		WithArgs(3, models.ProctorStatusForceSubmitted, models.ProctorStatusForceSubmitted, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("total_score = 0, status = 'graded'")).
+		WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs")).
+		WithArgs(7, "zhangsan", "10.0.0.1", models.AuditExamForceSubmit, models.AuditEntityExamRecord, 3, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	result, err := proctor.ReportEvent(op, "s1", 3, &models.ProctorEventRequest{Type: models.ProctorEventTabSwitch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Action != models.ProctorActionSubmit || result.Status != models.ProctorStatusForceSubmitted {
+		t.Fatalf("got %+v", result)
+	}
+}
+
+func TestReportEventBelowSubmitThreshold(t *testing.T) {
+	mock := mockDB(t)
+	proctor := NewProctoringService()
+	op := Operator{UserID: 7, IP: "10.0.0.1"}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(proctorRecordQuery).WithArgs(3).WillReturnRows(proctorRecordRows(1, models.ProctorStatusWarned))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO proctoring_events")).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("SET violation_count = ?, proctor_status = ?")).
+		WithArgs(2, models.ProctorStatusFlagged, models.ProctorStatusFlagged, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	result, err := proctor.ReportEvent(op, "s1", 3, &models.ProctorEventRequest{Type: models.ProctorEventBlur})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Action != models.ProctorActionWarn {
+		t.Fatalf("got %+v", result)
+	}
+}
+
+func TestSubmitExamRejectsForceSubmitted(t *testing.T) {
+	mock := mockDB(t)
+	exams := NewExamService(NewQuestionService())
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, exam_id, user_id, start_time, status, proctor_status")).WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "exam_id", "user_id", "start_time", "status", "proctor_status"}).
+			AddRow(3, 9, 7, time.Now(), "graded", models.ProctorStatusForceSubmitted))
+	mock.ExpectRollback()
+
+	req := &models.ExamSubmitRequest{RecordID: 3, Answers: []models.ExamAnswerRequest{{QuestionID: 1, UserAnswer: "A"}}}
+	_, err := exams.SubmitExam(req, Operator{UserID: 7})
+	if err == nil || err.Error() != "违规操作次数过多，考试已被强制交卷" {
+		t.Fatalf("强制交卷后提交答案应被拒绝, got %v", err)
+	}
+}
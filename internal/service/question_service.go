@@ -18,12 +18,12 @@ func NewQuestionService() *QuestionService {
 }
 
 // CreateQuestionBank 创建题库
-func (s *QuestionService) CreateQuestionBank(req *models.QuestionBankCreateRequest, createdBy int) (*models.QuestionBank, error) {
+func (s *QuestionService) CreateQuestionBank(req *models.QuestionBankCreateRequest, op Operator) (*models.QuestionBank, error) {
 	// 插入题库记录
 	result, err := db.DB.Exec(`
-		INSERT INTO question_banks (name, description, subject, created_by)
-		VALUES (?, ?, ?, ?)
-	`, req.Name, req.Description, req.Subject, createdBy)
+		INSERT INTO question_banks (name, description, subject, department_id, created_by)
+		VALUES (?, ?, ?, ?, ?)
+	`, req.Name, req.Description, req.Subject, nullableID(req.DepartmentID), op.UserID)
 	if err != nil {
 		return nil, err
 	}
@@ -33,14 +33,24 @@ func (s *QuestionService) CreateQuestionBank(req *models.QuestionBankCreateReque
 	if err != nil {
 		return nil, err
 	}
+	logAudit(op, auditEvent{
+		Action:     models.AuditQuestionBankCreate,
+		EntityType: models.AuditEntityQuestionBank,
+		EntityID:   int(bankID),
+		After: map[string]interface{}{
+			"name":          req.Name,
+			"subject":       req.Subject,
+			"department_id": req.DepartmentID,
+		},
+	})
 
 	// 查询插入的题库信息
 	var bank models.QuestionBank
 	err = db.DB.QueryRow(`
-		SELECT id, name, description, subject, created_by, created_at, updated_at
+		SELECT id, name, description, subject, COALESCE(department_id, 0), created_by, created_at, updated_at
 		FROM question_banks WHERE id = ?
 	`, bankID).Scan(
-		&bank.ID, &bank.Name, &bank.Description, &bank.Subject, &bank.CreatedBy,
+		&bank.ID, &bank.Name, &bank.Description, &bank.Subject, &bank.DepartmentID, &bank.CreatedBy,
 		&bank.CreatedAt, &bank.UpdatedAt,
 	)
 	if err != nil {
@@ -54,10 +64,10 @@ func (s *QuestionService) CreateQuestionBank(req *models.QuestionBankCreateReque
 func (s *QuestionService) GetQuestionBankByID(bankID int) (*models.QuestionBank, error) {
 	var bank models.QuestionBank
 	err := db.DB.QueryRow(`
-		SELECT id, name, description, subject, created_by, created_at, updated_at
+		SELECT id, name, description, subject, COALESCE(department_id, 0), created_by, created_at, updated_at
 		FROM question_banks WHERE id = ?
 	`, bankID).Scan(
-		&bank.ID, &bank.Name, &bank.Description, &bank.Subject, &bank.CreatedBy,
+		&bank.ID, &bank.Name, &bank.Description, &bank.Subject, &bank.DepartmentID, &bank.CreatedBy,
 		&bank.CreatedAt, &bank.UpdatedAt,
 	)
 	if err != nil {
@@ -67,8 +77,8 @@ func (s *QuestionService) GetQuestionBankByID(bankID int) (*models.QuestionBank,
 	return &bank, nil
 }
 
-// ListQuestionBanks 获取题库列表
-func (s *QuestionService) ListQuestionBanks(subject string, page, pageSize int) ([]models.QuestionBank, int, error) {
+// ListQuestionBanks 获取数据范围内的题库列表，包含全院公共题库
+func (s *QuestionService) ListQuestionBanks(scope DataScope, subject string, page, pageSize int) ([]models.QuestionBank, int, error) {
 	if page < 1 {
 		page = 1
 	}
@@ -80,34 +90,28 @@ func (s *QuestionService) ListQuestionBanks(subject string, page, pageSize int)
 	var banks []models.QuestionBank
 	var total int
 
-	// 获取总记录数
-	query := "SELECT COUNT(*) FROM question_banks"
-	args := []interface{}{}
+	// 构建查询条件
+	cond := scope.SharedCondition("department_id")
+	where := " WHERE " + cond.SQL
+	args := append([]interface{}{}, cond.Args...)
 
 	if subject != "" {
-		query += " WHERE subject = ?"
+		where += " AND subject = ?"
 		args = append(args, subject)
 	}
 
-	err := db.DB.QueryRow(query, args...).Scan(&total)
+	// 获取总记录数
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM question_banks"+where, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	// 获取题库列表
 	listQuery := `
-		SELECT id, name, description, subject, created_by, created_at, updated_at
+		SELECT id, name, description, subject, COALESCE(department_id, 0), created_by, created_at, updated_at
 		FROM question_banks
-	`
-	argsList := []interface{}{}
-
-	if subject != "" {
-		listQuery += " WHERE subject = ?"
-		argsList = append(argsList, subject)
-	}
-
-	listQuery += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
-	argsList = append(argsList, pageSize, offset)
+	` + where + " ORDER BY created_at DESC LIMIT ? OFFSET ?"
+	argsList := append(args, pageSize, offset)
 
 	rows, err := db.DB.Query(listQuery, argsList...)
 	if err != nil {
@@ -118,7 +122,7 @@ func (s *QuestionService) ListQuestionBanks(subject string, page, pageSize int)
 	for rows.Next() {
 		var bank models.QuestionBank
 		err := rows.Scan(
-			&bank.ID, &bank.Name, &bank.Description, &bank.Subject, &bank.CreatedBy,
+			&bank.ID, &bank.Name, &bank.Description, &bank.Subject, &bank.DepartmentID, &bank.CreatedBy,
 			&bank.CreatedAt, &bank.UpdatedAt,
 		)
 		if err != nil {
@@ -135,7 +139,7 @@ func (s *QuestionService) ListQuestionBanks(subject string, page, pageSize int)
 }
 
 // CreateQuestion 创建题目
-func (s *QuestionService) CreateQuestion(req *models.QuestionCreateRequest, createdBy int) (*models.Question, error) {
+func (s *QuestionService) CreateQuestion(req *models.QuestionCreateRequest, op Operator) (*models.Question, error) {
 	// 检查题库是否存在
 	var bankCount int
 	err := db.DB.QueryRow("SELECT COUNT(*) FROM question_banks WHERE id = ?", req.BankID).Scan(&bankCount)
@@ -150,7 +154,7 @@ func (s *QuestionService) CreateQuestion(req *models.QuestionCreateRequest, crea
 	result, err := db.DB.Exec(`
 		INSERT INTO questions (bank_id, type, content, options, answer, score, difficulty, analysis, created_by)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, req.BankID, req.Type, req.Content, req.Options, req.Answer, req.Score, req.Difficulty, req.Analysis, createdBy)
+	`, req.BankID, req.Type, req.Content, req.Options, req.Answer, req.Score, req.Difficulty, req.Analysis, op.UserID)
 	if err != nil {
 		return nil, err
 	}
@@ -160,6 +164,20 @@ func (s *QuestionService) CreateQuestion(req *models.QuestionCreateRequest, crea
 	if err != nil {
 		return nil, err
 	}
+	logAudit(op, auditEvent{
+		Action:     models.AuditQuestionCreate,
+		EntityType: models.AuditEntityQuestion,
+		EntityID:   int(questionID),
+		After: map[string]interface{}{
+			"bank_id":    req.BankID,
+			"type":       req.Type,
+			"content":    truncate(req.Content, 200),
+			"options":    req.Options,
+			"answer":     req.Answer,
+			"score":      req.Score,
+			"difficulty": req.Difficulty,
+		},
+	})
 
 	// 查询插入的题目信息
 	var question models.Question
@@ -246,8 +264,8 @@ func (s *QuestionService) ListQuestionsByBank(bankID int, page, pageSize int) ([
 	return questions, total, nil
 }
 
-// GetRandomQuestions 获取随机题目
-func (s *QuestionService) GetRandomQuestions(subject string, difficulty string, count int) ([]models.Question, error) {
+// GetRandomQuestions 从数据范围内的题库获取随机题目
+func (s *QuestionService) GetRandomQuestions(scope DataScope, subject string, difficulty string, count int) ([]models.Question, error) {
 	var questions []models.Question
 	var err error
 	var rows *sql.Rows
@@ -262,6 +280,13 @@ func (s *QuestionService) GetRandomQuestions(subject string, difficulty string,
 		args = append(args, subject)
 	}
 
+	// 只从数据范围内的题库和全院公共题库抽题
+	if !scope.Global {
+		cond := scope.SharedCondition("department_id")
+		query += " AND bank_id IN (SELECT id FROM question_banks WHERE " + cond.SQL + ")"
+		args = append(args, cond.Args...)
+	}
+
 	// 添加难度条件
 	if difficulty != "" {
 		query += " AND difficulty = ?"
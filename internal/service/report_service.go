@@ -0,0 +1,374 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/pdf"
+	"github.com/hangbin2008/sanjicms/pkg/xlsx"
+)
+
+// ReportService 报表导出服务
+type ReportService struct{}
+
+// NewReportService 创建报表导出服务
+func NewReportService() *ReportService {
+	return &ReportService{}
+}
+
+// ListScoreSheet 按筛选条件获取已交卷的成绩记录
+func (s *ReportService) ListScoreSheet(filter *models.ScoreSheetFilter) ([]models.ScoreSheetRow, error) {
+	query := `
+		SELECT r.id, u.id, u.name, COALESCE(u.department, ''), COALESCE(u.job_title, ''),
+		       e.id, e.title, e.total_score, r.total_score, COALESCE(r.duration, 0), r.end_time
+		FROM exam_records r
+		JOIN users u ON r.user_id = u.id
+		JOIN exams e ON r.exam_id = e.id
+		WHERE r.status IN ('submitted', 'graded')
+	`
+	args := []interface{}{}
+
+	if filter.ExamID > 0 {
+		query += " AND r.exam_id = ?"
+		args = append(args, filter.ExamID)
+	}
+	if filter.DepartmentID > 0 {
+		subtree := SubtreeCondition("u.department_id", filter.DepartmentID)
+		query += " AND " + subtree.SQL
+		args = append(args, subtree.Args...)
+	}
+	if !filter.StartDate.IsZero() {
+		query += " AND r.end_time >= ?"
+		args = append(args, filter.StartDate)
+	}
+	if !filter.EndDate.IsZero() {
+		// 结束日期包含当天
+		query += " AND r.end_time < ?"
+		args = append(args, filter.EndDate.AddDate(0, 0, 1))
+	}
+
+	query += " ORDER BY e.id, u.department, r.total_score DESC, r.id"
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sheet []models.ScoreSheetRow
+	for rows.Next() {
+		var row models.ScoreSheetRow
+		var submitTime sql.NullTime
+		err := rows.Scan(
+			&row.RecordID, &row.UserID, &row.Name, &row.Department, &row.JobTitle,
+			&row.ExamID, &row.ExamTitle, &row.ExamTotal, &row.Score, &row.Duration, &submitTime,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if submitTime.Valid {
+			row.SubmitTime = submitTime.Time
+		}
+		row.Passed = IsPassed(row.Score, row.ExamTotal)
+		sheet = append(sheet, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sheet, nil
+}
+
+// GetExamSummary 获取单场考试的成绩汇总
+func (s *ReportService) GetExamSummary(examID int, filter *models.ScoreSheetFilter) (*models.ExamSummary, error) {
+	var exam models.Exam
+	err := db.DB.QueryRow(`
+		SELECT id, title, description, subject, total_score, duration, start_time, end_time, status, created_by, created_at, updated_at
+		FROM exams WHERE id = ?
+	`, examID).Scan(
+		&exam.ID, &exam.Title, &exam.Description, &exam.Subject, &exam.TotalScore, &exam.Duration,
+		&exam.StartTime, &exam.EndTime, &exam.Status, &exam.CreatedBy, &exam.CreatedAt, &exam.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	examFilter := *filter
+	examFilter.ExamID = examID
+	rows, err := s.ListScoreSheet(&examFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &models.ExamSummary{
+		Exam:         &exam,
+		Participants: len(rows),
+		Rows:         rows,
+	}
+
+	var totalScore float64
+	for i, row := range rows {
+		totalScore += row.Score
+		if row.Passed {
+			summary.PassedCount++
+		}
+		if i == 0 || row.Score > summary.MaxScore {
+			summary.MaxScore = row.Score
+		}
+		if i == 0 || row.Score < summary.MinScore {
+			summary.MinScore = row.Score
+		}
+	}
+	if len(rows) > 0 {
+		summary.AvgScore = totalScore / float64(len(rows))
+		summary.PassRate = float64(summary.PassedCount) / float64(len(rows)) * 100
+	}
+
+	return summary, nil
+}
+
+// WriteScoreSheetXLSX 将成绩记录写为XLSX成绩单
+func (s *ReportService) WriteScoreSheetXLSX(w io.Writer, rows []models.ScoreSheetRow) error {
+	wb := xlsx.NewWorkbook()
+	sheet := wb.AddSheet("成绩单")
+	sheet.AddRow("序号", "考试", "姓名", "科室", "职称", "成绩", "是否及格", "用时", "交卷时间")
+
+	for i, row := range rows {
+		passed := "不及格"
+		if row.Passed {
+			passed = "及格"
+		}
+		submitTime := ""
+		if !row.SubmitTime.IsZero() {
+			submitTime = row.SubmitTime.Format("2006-01-02 15:04:05")
+		}
+		sheet.AddRow(i+1, row.ExamTitle, row.Name, row.Department, row.JobTitle,
+			row.Score, passed, formatDuration(row.Duration), submitTime)
+	}
+
+	return wb.Write(w)
+}
+
+// WriteExamSummaryPDF 将考试成绩汇总写为带签字栏的PDF
+func (s *ReportService) WriteExamSummaryPDF(w io.Writer, summary *models.ExamSummary, filter *models.ScoreSheetFilter, preparedBy string, now time.Time) error {
+	doc := pdf.New()
+	doc.SetInfo(summary.Exam.Title+" 成绩汇总表", preparedBy, now)
+
+	const (
+		left     = 50.0
+		right    = pdf.PageWidth - 50
+		rowGap   = 20.0
+		fontSize = 10.5
+		bottom   = pdf.PageHeight - 60
+	)
+	columns := []struct {
+		title string
+		x     float64
+	}{
+		{"序号", left}, {"姓名", left + 40}, {"科室", left + 120}, {"职称", left + 230},
+		{"成绩", left + 320}, {"是否及格", left + 370}, {"交卷时间", left + 430},
+	}
+
+	page := doc.AddPage()
+	page.TextCenter(pdf.PageWidth/2, 70, 18, summary.Exam.Title+" 成绩汇总表")
+
+	y := 105.0
+	page.Text(left, y, fontSize, fmt.Sprintf("科目：%s    试卷总分：%.1f    考试时长：%d分钟",
+		summary.Exam.Subject, summary.Exam.TotalScore, summary.Exam.Duration))
+	y += rowGap
+	scope := "全部科室"
+	if filter.Department != "" {
+		scope = filter.Department
+	}
+	if !filter.StartDate.IsZero() || !filter.EndDate.IsZero() {
+		scope += fmt.Sprintf("    交卷日期：%s 至 %s", formatDate(filter.StartDate), formatDate(filter.EndDate))
+	}
+	page.Text(left, y, fontSize, "统计范围："+scope)
+	y += rowGap
+	page.Text(left, y, fontSize, fmt.Sprintf("参考人数：%d    及格人数：%d    及格率：%.1f%%    平均分：%.1f    最高分：%.1f    最低分：%.1f",
+		summary.Participants, summary.PassedCount, summary.PassRate, summary.AvgScore, summary.MaxScore, summary.MinScore))
+	y += rowGap * 1.5
+
+	writeHeader := func() {
+		for _, col := range columns {
+			page.Text(col.x, y, fontSize, col.title)
+		}
+		page.Line(left, y+6, right, y+6, 0.8)
+		y += rowGap
+	}
+	writeHeader()
+
+	for i, row := range summary.Rows {
+		if y > bottom {
+			page = doc.AddPage()
+			y = 60
+			writeHeader()
+		}
+		passed := "不及格"
+		if row.Passed {
+			passed = "及格"
+		}
+		submitTime := ""
+		if !row.SubmitTime.IsZero() {
+			submitTime = row.SubmitTime.Format("2006-01-02 15:04")
+		}
+		values := []string{
+			fmt.Sprintf("%d", i+1), row.Name, row.Department, row.JobTitle,
+			fmt.Sprintf("%.1f", row.Score), passed, submitTime,
+		}
+		for j, col := range columns {
+			page.Text(col.x, y, fontSize, values[j])
+		}
+		y += rowGap
+	}
+
+	// 签字栏
+	if y > bottom-80 {
+		page = doc.AddPage()
+		y = 60
+	}
+	y += rowGap * 2
+	page.Line(left, y-rowGap, right, y-rowGap, 0.5)
+	page.Text(left, y, fontSize+1, "制表人："+preparedBy)
+	page.Text(left+260, y, fontSize+1, "制表日期："+now.Format("2006年01月02日"))
+	y += rowGap * 2
+	page.Text(left, y, fontSize+1, "审核人（签字）：")
+	page.Line(left+90, y+4, left+220, y+4, 0.5)
+	page.Text(left+260, y, fontSize+1, "科室负责人（签字）：")
+	page.Line(left+370, y+4, right, y+4, 0.5)
+	y += rowGap * 2
+	page.Text(left, y, fontSize+1, "日期：")
+	page.Line(left+35, y+4, left+220, y+4, 0.5)
+	page.Text(left+260, y, fontSize+1, "（盖章）")
+
+	return doc.Write(w)
+}
+
+// WriteTranscriptPDF 将个人成绩档案写为PDF，用于年度考核
+func (s *ReportService) WriteTranscriptPDF(w io.Writer, transcript *models.Transcript, now time.Time) error {
+	doc := pdf.New()
+	doc.SetInfo(transcript.User.Name+" 三基考核成绩档案", transcript.User.Name, now)
+
+	const (
+		left     = 50.0
+		right    = pdf.PageWidth - 50
+		rowGap   = 20.0
+		fontSize = 10.5
+		bottom   = pdf.PageHeight - 60
+	)
+	columns := []struct {
+		title string
+		x     float64
+	}{
+		{"考试", left}, {"科目", left + 180}, {"成绩", left + 260},
+		{"是否及格", left + 310}, {"类型", left + 370}, {"交卷时间", left + 410},
+	}
+
+	page := doc.AddPage()
+	page.TextCenter(pdf.PageWidth/2, 70, 18, "三基考核成绩档案")
+
+	y := 105.0
+	user := transcript.User
+	page.Text(left, y, fontSize, fmt.Sprintf("姓名：%s    账号：%s    科室：%s    职称：%s",
+		user.Name, user.Username, user.Department, user.JobTitle))
+	y += rowGap * 1.5
+
+	newPageIfNeeded := func(need float64) {
+		if y+need > bottom {
+			page = doc.AddPage()
+			y = 60
+		}
+	}
+
+	if len(transcript.Years) == 0 {
+		page.Text(left, y, fontSize, "暂无考试记录")
+		y += rowGap
+	}
+
+	for _, year := range transcript.Years {
+		newPageIfNeeded(rowGap * 4)
+		page.Text(left, y, fontSize+2, fmt.Sprintf("%d年度", year.Year))
+		y += rowGap
+		passRate := 0.0
+		if year.ExamCount > 0 {
+			passRate = float64(year.PassedCount) / float64(year.ExamCount) * 100
+		}
+		page.Text(left, y, fontSize, fmt.Sprintf("考试%d次    及格%d次    及格率%.1f%%    平均分%.1f    补考%d次    练习%d次（%d题，答对%d题）",
+			year.ExamCount, year.PassedCount, passRate, year.AvgScore, year.MakeupCount,
+			year.PracticeCount, year.PracticeQuestions, year.PracticeCorrect))
+		y += rowGap
+
+		if len(year.Entries) > 0 {
+			for _, col := range columns {
+				page.Text(col.x, y, fontSize, col.title)
+			}
+			page.Line(left, y+6, right, y+6, 0.8)
+			y += rowGap
+		}
+
+		for _, entry := range year.Entries {
+			newPageIfNeeded(rowGap)
+			result := "未评分"
+			if entry.Status == "graded" {
+				result = "不及格"
+				if entry.Passed {
+					result = "及格"
+				}
+			}
+			kind := "正考"
+			if entry.IsMakeup {
+				kind = "补考"
+			}
+			submitTime := ""
+			if !entry.SubmitTime.IsZero() {
+				submitTime = entry.SubmitTime.Format("2006-01-02 15:04")
+			}
+			values := []string{
+				entry.ExamTitle, entry.Subject, fmt.Sprintf("%.1f/%.0f", entry.Score, entry.ExamTotal),
+				result, kind, submitTime,
+			}
+			for j, col := range columns {
+				page.Text(col.x, y, fontSize, values[j])
+			}
+			y += rowGap
+		}
+		y += rowGap / 2
+	}
+
+	// 签字栏
+	newPageIfNeeded(rowGap * 5)
+	y += rowGap
+	page.Line(left, y-rowGap, right, y-rowGap, 0.5)
+	page.Text(left, y, fontSize+1, "本人签字：")
+	page.Line(left+60, y+4, left+220, y+4, 0.5)
+	page.Text(left+260, y, fontSize+1, "科室负责人签字：")
+	page.Line(left+350, y+4, right, y+4, 0.5)
+	y += rowGap * 2
+	page.Text(left, y, fontSize+1, "年度考核意见：")
+	page.Line(left+80, y+4, right, y+4, 0.5)
+	y += rowGap * 2
+	page.Text(left, y, fontSize+1, "打印日期："+now.Format("2006年01月02日"))
+
+	return doc.Write(w)
+}
+
+// formatDuration 将秒数格式化为“X分Y秒”
+func formatDuration(seconds int) string {
+	if seconds <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d分%d秒", seconds/60, seconds%60)
+}
+
+// formatDate 格式化日期，空日期显示为“不限”
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "不限"
+	}
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,432 @@
+package service
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/xlsx"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// MaxRosterRows 单次导入的最大人数
+const MaxRosterRows = 5000
+
+// rosterHeaders 花名册表头别名，支持中英文列名
+var rosterHeaders = map[string]string{
+	"用户名": "username", "账号": "username", "工号": "username", "username": "username",
+	"姓名": "name", "name": "name",
+	"性别": "gender", "gender": "gender",
+	"手机号": "phone", "手机": "phone", "联系电话": "phone", "phone": "phone",
+	"身份证号": "id_card", "身份证": "id_card", "id_card": "id_card",
+	"科室": "department", "部门": "department", "department": "department",
+	"职称": "job_title", "job_title": "job_title",
+}
+
+// RosterService 花名册批量导入服务
+type RosterService struct {
+	userService *UserService
+	departments *DepartmentService
+}
+
+// NewRosterService 创建花名册导入服务
+func NewRosterService(userService *UserService, departmentService *DepartmentService) *RosterService {
+	return &RosterService{
+		userService: userService,
+		departments: departmentService,
+	}
+}
+
+// ParseRoster 解析CSV或XLSX格式的花名册，第一行为表头
+func (s *RosterService) ParseRoster(filename string, data []byte) ([]models.RosterRow, error) {
+	var records [][]string
+	var err error
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		records, err = readCSV(data)
+	case ".xlsx":
+		records, err = xlsx.ReadRows(bytes.NewReader(data), int64(len(data)))
+	default:
+		return nil, errors.New("仅支持CSV或XLSX格式的花名册")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("花名册为空")
+	}
+
+	// 解析表头
+	columns := make(map[string]int)
+	for i, title := range records[0] {
+		if field, ok := rosterHeaders[strings.ToLower(strings.TrimSpace(title))]; ok {
+			if _, exists := columns[field]; !exists {
+				columns[field] = i
+			}
+		}
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, errors.New("花名册缺少“姓名”列")
+	}
+	_, hasUsername := columns["username"]
+	_, hasPhone := columns["phone"]
+	_, hasIDCard := columns["id_card"]
+	if !hasUsername && !hasPhone && !hasIDCard {
+		return nil, errors.New("花名册缺少“用户名”“手机号”或“身份证号”列")
+	}
+
+	cell := func(record []string, field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []models.RosterRow
+	for i, record := range records[1:] {
+		row := models.RosterRow{
+			Line:       i + 2,
+			Username:   cell(record, "username"),
+			Name:       cell(record, "name"),
+			Gender:     cell(record, "gender"),
+			Phone:      cell(record, "phone"),
+			IDCard:     strings.ToUpper(cell(record, "id_card")),
+			Department: cell(record, "department"),
+			JobTitle:   cell(record, "job_title"),
+		}
+		// 跳过空行
+		if row.Username == "" && row.Name == "" && row.Phone == "" && row.IDCard == "" {
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) > MaxRosterRows {
+		return nil, fmt.Errorf("单次最多导入%d人", MaxRosterRows)
+	}
+	return rows, nil
+}
+
+// readCSV 读取CSV文件，兼容Excel导出的UTF-8 BOM和GBK编码
+func readCSV(data []byte) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		decoded, err := simplifiedchinese.GBK.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, errors.New("无法识别CSV文件编码，请使用UTF-8或GBK编码")
+		}
+		data = decoded
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("CSV文件格式错误: %w", err)
+	}
+	return records, nil
+}
+
+// ImportRoster 按用户名（未填写时按手机号、身份证号）创建或更新人员，重复导入同一份花名册结果不变。
+// scopeDepartmentID大于0时只能导入该科室及其下级科室的人员。
+func (s *RosterService) ImportRoster(op Operator, scopeDepartmentID int, rows []models.RosterRow) (*models.RosterImportResult, error) {
+	result := &models.RosterImportResult{
+		Total: len(rows),
+		Rows:  make([]models.RosterRowResult, 0, len(rows)),
+	}
+
+	seen := make(map[string]int)
+	for _, row := range rows {
+		// 未填写用户名时按手机号、身份证号识别人员，号码不作为用户名
+		rowErr := normalizeRosterRow(&row)
+		key, field := rosterRowKey(&row)
+
+		item := models.RosterRowResult{Line: row.Line, Username: row.Username, Name: row.Name}
+		if rowErr != nil {
+			item.Status = models.RosterRowFailed
+			item.Error = rowErr.Error()
+		} else if line, ok := seen[key]; ok && key != "" {
+			item.Status = models.RosterRowFailed
+			item.Error = fmt.Sprintf("与第%d行%s重复", line, field)
+		} else {
+			seen[key] = row.Line
+			status, password, err := s.importRow(op, scopeDepartmentID, &row)
+			if err != nil {
+				item.Status = models.RosterRowFailed
+				item.Error = err.Error()
+			} else {
+				item.Status = status
+				item.Username = row.Username
+				item.InitialPassword = password
+			}
+		}
+
+		switch item.Status {
+		case models.RosterRowCreated:
+			result.Created++
+		case models.RosterRowUpdated:
+			result.Updated++
+		case models.RosterRowUnchanged:
+			result.Unchanged++
+		default:
+			result.Failed++
+		}
+		result.Rows = append(result.Rows, item)
+	}
+
+	return result, nil
+}
+
+// rosterRowKey 花名册中识别同一人员的字段：用户名，未填写时依次为手机号、身份证号
+func rosterRowKey(row *models.RosterRow) (string, string) {
+	switch {
+	case row.Username != "":
+		return row.Username, "用户名"
+	case row.Phone != "":
+		return "phone:" + row.Phone, "手机号"
+	case row.IDCard != "":
+		return "id_card:" + row.IDCard, "身份证号"
+	}
+	return "", ""
+}
+
+// normalizeRosterRow 校验并规范化手机号和身份证号，填写了身份证号时性别和出生日期以身份证号为准
+func normalizeRosterRow(row *models.RosterRow) error {
+	phone, err := normalizePhone(row.Phone)
+	if err != nil {
+		return err
+	}
+	row.Phone = phone
+
+	if row.IDCard != "" {
+		card, err := parseIDCard(row.IDCard)
+		if err != nil {
+			return err
+		}
+		row.IDCard = card.Number
+		row.Gender = card.Gender()
+		row.BirthDate = card.Birthdate.Format("2006-01-02")
+	}
+	return nil
+}
+
+// importRow 导入一行人员信息，返回处理结果和新账号的初始密码
+func (s *RosterService) importRow(op Operator, scopeDepartmentID int, row *models.RosterRow) (string, string, error) {
+	switch {
+	case row.Username == "" && row.Phone == "" && row.IDCard == "":
+		return "", "", errors.New("请填写用户名、手机号或身份证号")
+	case row.Username == "":
+	case !usernamePattern.MatchString(row.Username):
+		return "", "", errors.New("用户名只能包含字母和数字，长度3-20位")
+	case looksLikePhone(row.Username) || looksLikeIDCard(row.Username):
+		return "", "", ErrUsernameIsNumber
+	}
+	if err := s.userService.ValidateName(row.Name); err != nil {
+		return "", "", err
+	}
+	if row.Gender != "" && row.Gender != "男" && row.Gender != "女" {
+		return "", "", errors.New("性别只能为男或女")
+	}
+
+	var dept *models.Department
+	if row.Department != "" {
+		var err error
+		if dept, err = s.departments.ResolveDepartment(0, row.Department); err != nil {
+			return "", "", err
+		}
+		if scopeDepartmentID > 0 {
+			ok, err := s.departments.ContainsDepartment(scopeDepartmentID, dept.ID)
+			if err != nil {
+				return "", "", err
+			}
+			if !ok {
+				return "", "", errors.New("无权导入该科室的人员")
+			}
+		}
+	}
+
+	existing, err := s.findRowUser(row)
+	if err != nil {
+		return "", "", err
+	}
+	if existing == nil {
+		return s.createUser(op, scopeDepartmentID, row, dept)
+	}
+
+	if err := s.userService.canManage(op, existing); err != nil {
+		return "", "", err
+	}
+	if scopeDepartmentID > 0 {
+		ok, err := s.departments.ContainsUser(scopeDepartmentID, existing.ID)
+		if err != nil {
+			return "", "", err
+		}
+		if !ok {
+			return "", "", errors.New("无权管理该用户")
+		}
+	}
+
+	// 只更新花名册中填写了的字段
+	updated := *existing
+	updated.Name = row.Name
+	if row.Gender != "" {
+		updated.Gender = row.Gender
+	}
+	if row.Phone != "" {
+		updated.Phone = row.Phone
+	}
+	if row.IDCard != "" {
+		updated.IDCard = row.IDCard
+		updated.BirthDate = row.BirthDate
+	}
+	if dept != nil {
+		updated.DepartmentID = dept.ID
+		updated.Department = dept.Name
+	}
+	if row.JobTitle != "" {
+		updated.JobTitle = row.JobTitle
+	}
+	if updated == *existing {
+		return models.RosterRowUnchanged, "", nil
+	}
+
+	info, err := s.sealRow(existing.ID, updated.Phone, updated.IDCard)
+	if err != nil {
+		return "", "", err
+	}
+	_, err = db.DB.Exec(`
+		UPDATE users SET name = ?, gender = ?, phone = ?, phone_hash = ?, id_card = ?, id_card_hash = ?,
+		                 birth_date = NULLIF(?, ''), department_id = ?, department = ?, job_title = ?
+		WHERE id = ?
+	`, updated.Name, updated.Gender, info.phone, info.phoneIndex, info.idCard, info.idCardIndex, updated.BirthDate,
+		nullableID(updated.DepartmentID), updated.Department, updated.JobTitle, updated.ID)
+	if err != nil {
+		return "", "", err
+	}
+	logAudit(op, auditEvent{
+		Action:     models.AuditUserUpdate,
+		EntityType: models.AuditEntityUser,
+		EntityID:   existing.ID,
+		Before:     userAuditFields(existing),
+		After:      userAuditFields(&updated),
+	})
+	return models.RosterRowUpdated, "", nil
+}
+
+func (s *RosterService) createUser(op Operator, scopeDepartmentID int, row *models.RosterRow, dept *models.Department) (string, string, error) {
+	// 科室管理员导入时未填写科室的人员归入其所属科室
+	if dept == nil && scopeDepartmentID > 0 {
+		var err error
+		if dept, err = s.departments.GetDepartment(scopeDepartmentID); err != nil {
+			return "", "", errors.New("科室不存在")
+		}
+	}
+	departmentID, departmentName := 0, ""
+	if dept != nil {
+		departmentID, departmentName = dept.ID, dept.Name
+	}
+
+	gender := row.Gender
+	if gender == "" {
+		gender = "男"
+	}
+
+	info, err := s.sealRow(0, row.Phone, row.IDCard)
+	if err != nil {
+		return "", "", err
+	}
+
+	// 只填写了号码的人员生成随机用户名，之后用号码登录
+	if row.Username == "" {
+		if row.Username, err = generateUsername(); err != nil {
+			return "", "", err
+		}
+	}
+
+	password, err := s.userService.GenerateInitialPassword()
+	if err != nil {
+		return "", "", err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", err
+	}
+
+	result, err := db.DB.Exec(`
+		INSERT INTO users (username, password_hash, name, gender, role, phone, phone_hash, id_card, id_card_hash, birth_date,
+		                   department_id, department, job_title, must_change_password)
+		VALUES (?, ?, ?, ?, 'employee', ?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?, 1)
+	`, row.Username, string(hashedPassword), row.Name, gender, info.phone, info.phoneIndex, info.idCard, info.idCardIndex,
+		row.BirthDate,
+		nullableID(departmentID), departmentName, row.JobTitle)
+	if err != nil {
+		return "", "", err
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return "", "", err
+	}
+
+	logAudit(op, auditEvent{
+		Action:     models.AuditUserCreate,
+		EntityType: models.AuditEntityUser,
+		EntityID:   int(userID),
+		After: userAuditFields(&models.User{
+			Username: row.Username, Name: row.Name, Gender: gender, Role: models.RoleEmployee,
+			Phone: row.Phone, IDCard: row.IDCard, BirthDate: row.BirthDate,
+			DepartmentID: departmentID, Department: departmentName, JobTitle: row.JobTitle, Status: 1,
+		}),
+	})
+	return models.RosterRowCreated, password, nil
+}
+
+// sealRow 加密要保存的手机号和身份证号，并检查是否已被其他账号使用；userID为0表示新账号
+func (s *RosterService) sealRow(userID int, phone, idCard string) (*personalInfo, error) {
+	info := &personalInfo{phone: phone, idCard: idCard, phoneChanged: true, idCardChanged: true}
+	if err := s.userService.sealPersonalInfo(userID, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// findRowUser 按用户名查找花名册中的人员，未填写用户名时按手机号、身份证号的盲索引查找，
+// 找到时回填用户名，不存在时返回nil
+func (s *RosterService) findRowUser(row *models.RosterRow) (*models.User, error) {
+	if row.Username != "" {
+		return s.findUser(row.Username)
+	}
+	user, err := s.userService.findUserByNumber(row.Phone, row.IDCard)
+	if user != nil {
+		row.Username = user.Username
+	}
+	return user, err
+}
+
+// findUser 根据用户名查找用户，不存在时返回nil
+func (s *RosterService) findUser(username string) (*models.User, error) {
+	user, err := s.userService.GetUserByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return user, err
+}
+
+// WriteRosterTemplate 输出花名册XLSX模板
+func (s *RosterService) WriteRosterTemplate(w io.Writer) error {
+	wb := xlsx.NewWorkbook()
+	sheet := wb.AddSheet("花名册")
+	sheet.AddRow("用户名", "姓名", "性别", "手机号", "身份证号", "科室", "职称")
+	sheet.AddRow("", "张三", "男", "13800138000", "", "内科", "住院医师")
+	return wb.Write(w)
+}
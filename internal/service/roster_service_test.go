@@ -0,0 +1,238 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/xlsx"
+)
+
+var generatedUsername = regexp.MustCompile(`^u[0-9a-f]{10}$`)
+
+func newTestRosterService() *RosterService {
+	users := NewUserService(&config.Config{Password: config.PasswordConfig{MinLength: 8}}, nil)
+	return NewRosterService(users, NewDepartmentService())
+}
+
+func parseFixture(t *testing.T, s *RosterService, name string) []models.RosterRow {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := s.ParseRoster(name, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+// testdata/roster_utf8_bom.csv 为Excel另存的带BOM的UTF-8文件，表头使用“工号”“手机”“部门”等别名，含空行和重复人员
+func TestParseRosterUTF8BOM(t *testing.T) {
+	rows := parseFixture(t, newTestRosterService(), "roster_utf8_bom.csv")
+
+	want := []models.RosterRow{
+		{Line: 2, Username: "zhangsan", Name: "张三", Gender: "男", Phone: "13800138000", JobTitle: "主治医师"},
+		{Line: 3, Name: "李四", Phone: "13900139000"},
+		{Line: 4, Name: "王五", IDCard: "11010519491231002X"},
+		{Line: 6, Username: "zhangsan", Name: "张三丰"},
+		{Line: 7, Name: "赵六", Phone: "139-0013-9000"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %+v\nwant %+v", rows, want)
+	}
+}
+
+func TestParseRosterGBK(t *testing.T) {
+	rows := parseFixture(t, newTestRosterService(), "roster_gbk.csv")
+
+	want := []models.RosterRow{
+		{Line: 2, Username: "lisi", Name: "李四", Phone: "13700137000"},
+		{Line: 3, Username: "wangwu", Name: "王五"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %+v\nwant %+v", rows, want)
+	}
+}
+
+func TestParseRosterXLSX(t *testing.T) {
+	wb := xlsx.NewWorkbook()
+	sheet := wb.AddSheet("花名册")
+	// 英文表头不区分大小写，同一字段出现两列时使用第一列
+	sheet.AddRow("Username", " Name ", "姓名", "PHONE", "ID_Card", "Department", "职称")
+	sheet.AddRow("zhangsan", "张三", "忽略", 13800138000, "", "心内科", "主治医师")
+	var buf bytes.Buffer
+	if err := wb.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := newTestRosterService().ParseRoster("花名册.XLSX", buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.RosterRow{{
+		Line: 2, Username: "zhangsan", Name: "张三", Phone: "13800138000", Department: "心内科", JobTitle: "主治医师",
+	}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %+v\nwant %+v", rows, want)
+	}
+}
+
+func TestParseRosterRejects(t *testing.T) {
+	s := newTestRosterService()
+	cases := map[string]struct {
+		filename string
+		data     string
+	}{
+		"unsupported format": {"roster.xls", "用户名,姓名\n"},
+		"empty":              {"roster.csv", ""},
+		"missing name":       {"roster.csv", "用户名,手机号\nzhangsan,13800138000\n"},
+		"missing identifier": {"roster.csv", "姓名,科室\n张三,内科\n"},
+	}
+	for name, c := range cases {
+		if _, err := s.ParseRoster(c.filename, []byte(c.data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// loginUserRows findLoginUser查询的一行结果
+func loginUserRows(u models.User) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows([]string{
+		"id", "username", "password_hash", "name", "gender", "email", "role", "phone", "id_card", "birth_date",
+		"department_id", "department", "job_title", "avatar", "status", "created_at", "updated_at",
+		"must_change_password", "password_changed_at", "totp_enabled", "auth_source",
+	}).AddRow(
+		u.ID, u.Username, "", u.Name, u.Gender, "", models.RoleEmployee, u.Phone, u.IDCard, u.BirthDate,
+		0, "", u.JobTitle, "", 1, now, now, false, now, false, models.AuthSourceLocal,
+	)
+}
+
+// listUserRows GetUserByUsername、GetUserByID查询的一行结果，未指定角色时为员工
+func listUserRows(u models.User) *sqlmock.Rows {
+	now := time.Now()
+	if u.Role == "" {
+		u.Role = models.RoleEmployee
+	}
+	return sqlmock.NewRows([]string{
+		"id", "username", "name", "gender", "email", "role", "phone", "id_card", "birth_date",
+		"department_id", "department", "job_title", "avatar", "status", "auth_source", "created_at", "updated_at",
+	}).AddRow(
+		u.ID, u.Username, u.Name, u.Gender, "", u.Role, u.Phone, u.IDCard, u.BirthDate,
+		0, "", u.JobTitle, "", 1, models.AuthSourceLocal, now, now,
+	)
+}
+
+var (
+	rosterByUsername = regexp.QuoteMeta("FROM users WHERE username = ?")
+	rosterByPhone    = regexp.QuoteMeta("FROM users WHERE phone_hash = ?")
+	rosterByIDCard   = regexp.QuoteMeta("FROM users WHERE id_card_hash = ?")
+	rosterInsert     = regexp.QuoteMeta("INSERT INTO users (username, password_hash, name, gender, role, phone, phone_hash, id_card, id_card_hash, birth_date,")
+)
+
+func TestImportRosterCreatesAndRejectsDuplicates(t *testing.T) {
+	mock := mockDB(t)
+	s := newTestRosterService()
+	rows := parseFixture(t, s, "roster_utf8_bom.csv")
+	crypto := s.userService.crypto
+	phone1 := crypto.BlindIndex("13800138000", fieldPhone)
+	phone2 := crypto.BlindIndex("13900139000", fieldPhone)
+	card := crypto.BlindIndex("11010519491231002X", fieldIDCard)
+	noRows := sqlmock.NewRows([]string{"id"})
+	notTaken := func() *sqlmock.Rows { return sqlmock.NewRows([]string{"exists"}).AddRow(false) }
+
+	// 第2行：按用户名新建
+	mock.ExpectQuery(rosterByUsername).WithArgs("zhangsan").WillReturnRows(noRows)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM users WHERE phone_hash = ? AND id <> ?)")).
+		WithArgs(phone1, 0).WillReturnRows(notTaken())
+	mock.ExpectExec(rosterInsert).
+		WithArgs("zhangsan", sqlmock.AnyArg(), "张三", "男", "13800138000", phone1, "", nil, "", nil, "", "主治医师").
+		WillReturnResult(sqlmock.NewResult(21, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs")).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// 第3行：只有手机号，按盲索引查找，新建时生成用户名
+	generated := &capturedArg{}
+	mock.ExpectQuery(rosterByPhone).WithArgs(phone2).WillReturnRows(noRows)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM users WHERE phone_hash = ? AND id <> ?)")).
+		WithArgs(phone2, 0).WillReturnRows(notTaken())
+	mock.ExpectExec(rosterInsert).
+		WithArgs(generated, sqlmock.AnyArg(), "李四", "男", "13900139000", phone2, "", nil, "", nil, "", "").
+		WillReturnResult(sqlmock.NewResult(22, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs")).WillReturnResult(sqlmock.NewResult(2, 1))
+
+	// 第4行：只有身份证号，性别和出生日期以身份证号为准
+	mock.ExpectQuery(rosterByIDCard).WithArgs(card).WillReturnRows(noRows)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM users WHERE id_card_hash = ? AND id <> ?)")).
+		WithArgs(card, 0).WillReturnRows(notTaken())
+	mock.ExpectExec(rosterInsert).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "王五", "女", "", nil, "11010519491231002X", card, "1949-12-31", nil, "", "").
+		WillReturnResult(sqlmock.NewResult(23, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs")).WillReturnResult(sqlmock.NewResult(3, 1))
+
+	result, err := s.ImportRoster(Operator{UserID: 1, Username: "admin", Role: models.RoleAdmin}, 0, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 5 || result.Created != 3 || result.Failed != 2 || result.Updated != 0 || result.Unchanged != 0 {
+		t.Fatalf("unexpected summary %+v", result)
+	}
+
+	lisi := result.Rows[1]
+	if !generatedUsername.MatchString(lisi.Username) || lisi.Username != generated.String() {
+		t.Errorf("phone-only row got username %q, inserted %q", lisi.Username, generated)
+	}
+	for _, row := range result.Rows[:3] {
+		if row.Status != models.RosterRowCreated || row.InitialPassword == "" {
+			t.Errorf("line %d: %+v", row.Line, row)
+		}
+	}
+	// 重复行按用户名、规范化后的手机号识别
+	if row := result.Rows[3]; row.Status != models.RosterRowFailed || row.Error != "与第2行用户名重复" {
+		t.Errorf("line %d: %+v", row.Line, row)
+	}
+	if row := result.Rows[4]; row.Status != models.RosterRowFailed || row.Error != "与第3行手机号重复" {
+		t.Errorf("line %d: %+v", row.Line, row)
+	}
+}
+
+// 重复导入同一份花名册时已存在的人员信息没有变化，不写数据库
+func TestImportRosterReimportUnchanged(t *testing.T) {
+	mock := mockDB(t)
+	s := newTestRosterService()
+	rows := parseFixture(t, s, "roster_utf8_bom.csv")
+	crypto := s.userService.crypto
+
+	mock.ExpectQuery(rosterByUsername).WithArgs("zhangsan").WillReturnRows(listUserRows(models.User{
+		ID: 21, Username: "zhangsan", Name: "张三", Gender: "男", Phone: "13800138000", JobTitle: "主治医师",
+	}))
+	mock.ExpectQuery(rosterByPhone).WithArgs(crypto.BlindIndex("13900139000", fieldPhone)).WillReturnRows(loginUserRows(models.User{
+		ID: 22, Username: "u0a1b2c3d4e", Name: "李四", Gender: "男", Phone: "13900139000",
+	}))
+	mock.ExpectQuery(rosterByIDCard).WithArgs(crypto.BlindIndex("11010519491231002X", fieldIDCard)).WillReturnRows(loginUserRows(models.User{
+		ID: 23, Username: "u5f6e7d8c9b", Name: "王五", Gender: "女", IDCard: "11010519491231002X", BirthDate: "1949-12-31",
+	}))
+
+	result, err := s.ImportRoster(Operator{UserID: 1, Username: "admin", Role: models.RoleAdmin}, 0, rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Unchanged != 3 || result.Failed != 2 || result.Created != 0 || result.Updated != 0 {
+		t.Fatalf("unexpected summary %+v", result)
+	}
+	if got := result.Rows[1].Username; got != "u0a1b2c3d4e" {
+		t.Errorf("phone-only row should report the existing username, got %q", got)
+	}
+	for _, row := range result.Rows[:3] {
+		if row.Status != models.RosterRowUnchanged || row.InitialPassword != "" {
+			t.Errorf("line %d: %+v", row.Line, row)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+)
+
+// ErrOutOfScope 访问了数据范围以外的科室数据
+var ErrOutOfScope = errors.New("无权访问其他科室的数据")
+
+// DataScope 操作人可访问的数据范围。
+// 站长为全院范围；其他角色限制在所属科室及其下级科室，未分配科室时只能查看全院公共题库和试卷
+type DataScope struct {
+	Global       bool
+	DepartmentID int // 非全院范围的根科室，0表示未分配科室
+}
+
+// GlobalScope 全院数据范围
+func GlobalScope() DataScope {
+	return DataScope{Global: true}
+}
+
+// Condition 生成“column属于数据范围内科室”的查询条件
+func (sc DataScope) Condition(column string) Condition {
+	if sc.Global {
+		return Condition{SQL: "1=1"}
+	}
+	if sc.DepartmentID == 0 {
+		return Condition{SQL: "1=0"}
+	}
+	return SubtreeCondition(column, sc.DepartmentID)
+}
+
+// SharedCondition 在Condition的基础上包含column为NULL的全院公共数据，用于题库和试卷的查询
+func (sc DataScope) SharedCondition(column string) Condition {
+	if sc.Global {
+		return Condition{SQL: "1=1"}
+	}
+	cond := sc.Condition(column)
+	return Condition{SQL: "(" + column + " IS NULL OR " + cond.SQL + ")", Args: cond.Args}
+}
+
+// ExamCondition 在SharedCondition的基础上包含所属科室的上级科室的试卷，下级科室的人员可以查看和参加上级科室组织的考试
+func (sc DataScope) ExamCondition(column string) Condition {
+	cond := sc.SharedCondition(column)
+	if sc.Global || sc.DepartmentID == 0 {
+		return cond
+	}
+	return Condition{
+		SQL: "(" + cond.SQL + " OR " + column + ` IN (
+			SELECT d.id FROM departments d
+			JOIN departments own ON own.path LIKE CONCAT(d.path, '%')
+			WHERE own.id = ?
+		))`,
+		Args: append(append([]interface{}{}, cond.Args...), sc.DepartmentID),
+	}
+}
+
+// ScopeService 数据范围服务，按科室限制管理员能访问的题库、试卷、人员和成绩
+type ScopeService struct {
+	departments *DepartmentService
+}
+
+// NewScopeService 创建数据范围服务
+func NewScopeService(departments *DepartmentService) *ScopeService {
+	return &ScopeService{departments: departments}
+}
+
+// Resolve 解析操作人的数据范围：站长为全院，其他角色为所属科室子树
+func (s *ScopeService) Resolve(op Operator) (DataScope, error) {
+	if op.Role == models.RoleAdmin {
+		return GlobalScope(), nil
+	}
+
+	var departmentID int
+	err := db.DB.QueryRow("SELECT COALESCE(department_id, 0) FROM users WHERE id = ?", op.UserID).Scan(&departmentID)
+	if err != nil {
+		return DataScope{}, err
+	}
+	return DataScope{DepartmentID: departmentID}, nil
+}
+
+// RestrictDepartment 将科室筛选条件限制在数据范围内，未指定科室时默认为范围的根科室
+func (s *ScopeService) RestrictDepartment(sc DataScope, departmentID int) (int, error) {
+	if sc.Global {
+		return departmentID, nil
+	}
+	if sc.DepartmentID == 0 {
+		return 0, ErrOutOfScope
+	}
+	if departmentID == 0 {
+		return sc.DepartmentID, nil
+	}
+
+	ok, err := s.departments.ContainsDepartment(sc.DepartmentID, departmentID)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrOutOfScope
+	}
+	return departmentID, nil
+}
+
+// CanAccessUser 判断用户是否在数据范围内
+func (s *ScopeService) CanAccessUser(sc DataScope, userID int) (bool, error) {
+	if sc.Global {
+		return true, nil
+	}
+	if sc.DepartmentID == 0 {
+		return false, nil
+	}
+	return s.departments.ContainsUser(sc.DepartmentID, userID)
+}
+
+// OwnerDepartment 确定新建题库或试卷的归属科室。
+// 站长可以指定任意科室，不指定表示全院；其他角色只能选择范围内的科室，不指定时默认为所属科室
+func (s *ScopeService) OwnerDepartment(sc DataScope, departmentID int) (int, error) {
+	if !sc.Global {
+		return s.RestrictDepartment(sc, departmentID)
+	}
+	if departmentID > 0 {
+		if _, err := s.departments.GetDepartment(departmentID); err != nil {
+			return 0, errors.New("科室不存在")
+		}
+	}
+	return departmentID, nil
+}
+
+// CheckBank 检查题库是否在数据范围内；全院公共题库可以查看，但只有站长可以修改
+func (s *ScopeService) CheckBank(sc DataScope, bankID int, write bool) error {
+	return s.checkOwner(sc, "question_banks", bankID, write, "题库不存在")
+}
+
+// CheckExam 检查试卷是否在数据范围内；全院试卷和上级科室的试卷可以查看，但只有站长可以修改全院试卷
+func (s *ScopeService) CheckExam(sc DataScope, examID int, write bool) error {
+	err := s.checkOwner(sc, "exams", examID, write, "试卷不存在")
+	if write || !errors.Is(err, ErrOutOfScope) || sc.DepartmentID == 0 {
+		return err
+	}
+
+	// 下级科室的人员可以查看和参加上级科室的试卷
+	var departmentID int
+	if err := db.DB.QueryRow("SELECT COALESCE(department_id, 0) FROM exams WHERE id = ?", examID).Scan(&departmentID); err != nil {
+		return err
+	}
+	ok, err := s.departments.ContainsDepartment(departmentID, sc.DepartmentID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrOutOfScope
+	}
+	return nil
+}
+
+// checkOwner 根据记录的归属科室检查访问权限
+func (s *ScopeService) checkOwner(sc DataScope, table string, id int, write bool, notFound string) error {
+	var departmentID int
+	err := db.DB.QueryRow("SELECT COALESCE(department_id, 0) FROM "+table+" WHERE id = ?", id).Scan(&departmentID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New(notFound)
+		}
+		return err
+	}
+
+	if sc.Global {
+		return nil
+	}
+	if departmentID == 0 {
+		if write {
+			return ErrOutOfScope
+		}
+		return nil
+	}
+	if sc.DepartmentID == 0 {
+		return ErrOutOfScope
+	}
+
+	ok, err := s.departments.ContainsDepartment(sc.DepartmentID, departmentID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrOutOfScope
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/internal/models"
+)
+
+var (
+	ownerQuery   = regexp.QuoteMeta("SELECT COALESCE(department_id, 0) FROM exams WHERE id = ?")
+	bankQuery    = regexp.QuoteMeta("SELECT COALESCE(department_id, 0) FROM question_banks WHERE id = ?")
+	subtreeQuery = regexp.QuoteMeta("SELECT COUNT(*) FROM departments WHERE id = ?")
+	userQuery    = regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE id = ?")
+)
+
+func countRows(n int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"count"}).AddRow(n)
+}
+
+func departmentRows(id int) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"department_id"}).AddRow(id)
+}
+
+func TestResolveScope(t *testing.T) {
+	mock := mockDB(t)
+	scopes := NewScopeService(NewDepartmentService())
+
+	sc, err := scopes.Resolve(Operator{UserID: 1, Role: models.RoleAdmin})
+	if err != nil || !sc.Global {
+		t.Fatalf("站长应为全院范围, got %+v, %v", sc, err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COALESCE(department_id, 0) FROM users WHERE id = ?")).
+		WithArgs(7).WillReturnRows(departmentRows(2))
+	sc, err = scopes.Resolve(Operator{UserID: 7, Role: models.RoleManager})
+	if err != nil || sc.Global || sc.DepartmentID != 2 {
+		t.Fatalf("科室管理员应限制在所属科室, got %+v, %v", sc, err)
+	}
+}
+
+func TestCheckExamOtherDepartment(t *testing.T) {
+	mock := mockDB(t)
+	scopes := NewScopeService(NewDepartmentService())
+	manager := DataScope{DepartmentID: 2}
+
+	// 修改其他科室的试卷
+	mock.ExpectQuery(ownerQuery).WithArgs(9).WillReturnRows(departmentRows(5))
+	mock.ExpectQuery(subtreeQuery).WithArgs(5, 2).WillReturnRows(countRows(0))
+	if err := scopes.CheckExam(manager, 9, true); !errors.Is(err, ErrOutOfScope) {
+		t.Fatalf("修改其他科室的试卷应被拒绝, got %v", err)
+	}
+
+	// 查看其他科室（既不是下级也不是上级科室）的试卷
+	mock.ExpectQuery(ownerQuery).WithArgs(9).WillReturnRows(departmentRows(5))
+	mock.ExpectQuery(subtreeQuery).WithArgs(5, 2).WillReturnRows(countRows(0))
+	mock.ExpectQuery(ownerQuery).WithArgs(9).WillReturnRows(departmentRows(5))
+	mock.ExpectQuery(subtreeQuery).WithArgs(2, 5).WillReturnRows(countRows(0))
+	if err := scopes.CheckExam(manager, 9, false); !errors.Is(err, ErrOutOfScope) {
+		t.Fatalf("查看其他科室的试卷应被拒绝, got %v", err)
+	}
+}
+
+func TestCheckExamParentDepartmentReadOnly(t *testing.T) {
+	mock := mockDB(t)
+	scopes := NewScopeService(NewDepartmentService())
+	ward := DataScope{DepartmentID: 3}
+
+	// 病区人员可以查看上级科室的试卷
+	mock.ExpectQuery(ownerQuery).WithArgs(9).WillReturnRows(departmentRows(1))
+	mock.ExpectQuery(subtreeQuery).WithArgs(1, 3).WillReturnRows(countRows(0))
+	mock.ExpectQuery(ownerQuery).WithArgs(9).WillReturnRows(departmentRows(1))
+	mock.ExpectQuery(subtreeQuery).WithArgs(3, 1).WillReturnRows(countRows(1))
+	if err := scopes.CheckExam(ward, 9, false); err != nil {
+		t.Fatalf("应可查看上级科室的试卷, got %v", err)
+	}
+
+	// 但不能修改
+	mock.ExpectQuery(ownerQuery).WithArgs(9).WillReturnRows(departmentRows(1))
+	mock.ExpectQuery(subtreeQuery).WithArgs(1, 3).WillReturnRows(countRows(0))
+	if err := scopes.CheckExam(ward, 9, true); !errors.Is(err, ErrOutOfScope) {
+		t.Fatalf("修改上级科室的试卷应被拒绝, got %v", err)
+	}
+}
+
+func TestCheckSharedExamAndBank(t *testing.T) {
+	mock := mockDB(t)
+	scopes := NewScopeService(NewDepartmentService())
+	manager := DataScope{DepartmentID: 2}
+
+	mock.ExpectQuery(ownerQuery).WithArgs(4).WillReturnRows(departmentRows(0))
+	if err := scopes.CheckExam(manager, 4, false); err != nil {
+		t.Fatalf("全院试卷应可查看, got %v", err)
+	}
+	mock.ExpectQuery(bankQuery).WithArgs(4).WillReturnRows(departmentRows(0))
+	if err := scopes.CheckBank(manager, 4, true); !errors.Is(err, ErrOutOfScope) {
+		t.Fatalf("科室管理员修改全院题库应被拒绝, got %v", err)
+	}
+	mock.ExpectQuery(bankQuery).WithArgs(6).WillReturnRows(departmentRows(5))
+	mock.ExpectQuery(subtreeQuery).WithArgs(5, 2).WillReturnRows(countRows(0))
+	if err := scopes.CheckBank(manager, 6, false); !errors.Is(err, ErrOutOfScope) {
+		t.Fatalf("查看其他科室的题库应被拒绝, got %v", err)
+	}
+
+	// 站长不受限制，不查询数据库
+	mock.ExpectQuery(bankQuery).WithArgs(6).WillReturnRows(departmentRows(5))
+	if err := scopes.CheckBank(GlobalScope(), 6, true); err != nil {
+		t.Fatalf("站长应可修改任意题库, got %v", err)
+	}
+}
+
+func TestCheckExamNotFound(t *testing.T) {
+	mock := mockDB(t)
+	scopes := NewScopeService(NewDepartmentService())
+
+	mock.ExpectQuery(ownerQuery).WithArgs(404).WillReturnRows(sqlmock.NewRows([]string{"department_id"}))
+	err := scopes.CheckExam(DataScope{DepartmentID: 2}, 404, false)
+	if err == nil || errors.Is(err, ErrOutOfScope) || err.Error() != "试卷不存在" {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestUnassignedManager(t *testing.T) {
+	mock := mockDB(t)
+	scopes := NewScopeService(NewDepartmentService())
+	unassigned := DataScope{}
+
+	if _, err := scopes.RestrictDepartment(unassigned, 0); !errors.Is(err, ErrOutOfScope) {
+		t.Fatalf("未分配科室的管理员不能查询科室数据, got %v", err)
+	}
+	if ok, err := scopes.CanAccessUser(unassigned, 5); ok || err != nil {
+		t.Fatalf("未分配科室的管理员不能查看人员, got %v, %v", ok, err)
+	}
+	mock.ExpectQuery(ownerQuery).WithArgs(9).WillReturnRows(departmentRows(5))
+	if err := scopes.CheckExam(unassigned, 9, false); !errors.Is(err, ErrOutOfScope) {
+		t.Fatalf("未分配科室的管理员不能查看科室试卷, got %v", err)
+	}
+	if cond := unassigned.Condition("u.department_id"); cond.SQL != "1=0" {
+		t.Fatalf("got %q", cond.SQL)
+	}
+}
+
+func TestCrossDepartmentUserAndFilter(t *testing.T) {
+	mock := mockDB(t)
+	scopes := NewScopeService(NewDepartmentService())
+	manager := DataScope{DepartmentID: 2}
+
+	mock.ExpectQuery(userQuery).WithArgs(8, 2).WillReturnRows(countRows(0))
+	if ok, err := scopes.CanAccessUser(manager, 8); ok || err != nil {
+		t.Fatalf("不能查看其他科室的人员, got %v, %v", ok, err)
+	}
+	mock.ExpectQuery(userQuery).WithArgs(7, 2).WillReturnRows(countRows(1))
+	if ok, err := scopes.CanAccessUser(manager, 7); !ok || err != nil {
+		t.Fatalf("应可查看本科室的人员, got %v, %v", ok, err)
+	}
+
+	mock.ExpectQuery(subtreeQuery).WithArgs(5, 2).WillReturnRows(countRows(0))
+	if _, err := scopes.RestrictDepartment(manager, 5); !errors.Is(err, ErrOutOfScope) {
+		t.Fatalf("按其他科室筛选应被拒绝, got %v", err)
+	}
+	if id, err := scopes.RestrictDepartment(manager, 0); id != 2 || err != nil {
+		t.Fatalf("未指定科室时应默认为所属科室, got %d, %v", id, err)
+	}
+	if id, err := scopes.RestrictDepartment(GlobalScope(), 0); id != 0 || err != nil {
+		t.Fatalf("站长未指定科室时应查询全院, got %d, %v", id, err)
+	}
+}
+
+func TestExamCondition(t *testing.T) {
+	if cond := GlobalScope().ExamCondition("department_id"); cond.SQL != "1=1" {
+		t.Fatalf("got %q", cond.SQL)
+	}
+	if cond := (DataScope{}).ExamCondition("department_id"); !strings.Contains(cond.SQL, "department_id IS NULL") || len(cond.Args) != 0 {
+		t.Fatalf("未分配科室时只能查看全院试卷, got %q %v", cond.SQL, cond.Args)
+	}
+	cond := DataScope{DepartmentID: 2}.ExamCondition("department_id")
+	if len(cond.Args) != 2 || cond.Args[0] != 2 || cond.Args[1] != 2 {
+		t.Fatalf("got %v", cond.Args)
+	}
+	if !strings.Contains(cond.SQL, "department_id IS NULL") || !strings.Contains(cond.SQL, "own.path LIKE CONCAT(d.path, '%')") {
+		t.Fatalf("应包含全院试卷和上级科室的试卷, got %q", cond.SQL)
+	}
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+)
+
+// LoginBlockedError 登录被限制，需要等待RetryAfter后重试
+type LoginBlockedError struct {
+	Result     string // 记录到登录尝试中的结果：locked或throttled
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *LoginBlockedError) Error() string {
+	return e.Message
+}
+
+// SecurityService 登录安全服务：记录登录尝试，按账号和IP限制暴力破解
+type SecurityService struct {
+	config *config.LoginConfig
+	now    func() time.Time
+}
+
+// NewSecurityService 创建登录安全服务
+func NewSecurityService(cfg *config.Config) *SecurityService {
+	return &SecurityService{
+		config: &cfg.Login,
+		now:    time.Now,
+	}
+}
+
+// failureResults 计入失败次数的登录结果，被锁定或限流拒绝的请求不计入
+const failureResults = "('bad_credentials', 'captcha', 'two_factor')"
+
+// CheckLogin 登录前检查账号是否锁定、IP失败次数是否超限以及是否需要等待，返回*LoginBlockedError表示拒绝登录。
+// userID为ResolveLoginAccount找到的账号，用户名、手机号和身份证号登录都按同一账号统计；账号不存在时为0，只按IP检查
+func (s *SecurityService) CheckLogin(userID int, ip string) error {
+	now := s.now()
+
+	// 账号锁定
+	var lockedUntil sql.NullTime
+	err := db.DB.QueryRow("SELECT locked_until FROM users WHERE id = ?", userID).Scan(&lockedUntil)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if lockedUntil.Valid && lockedUntil.Time.After(now) {
+		wait := lockedUntil.Time.Sub(now)
+		return &LoginBlockedError{
+			Result:     models.LoginResultLocked,
+			Message:    fmt.Sprintf("账号已锁定，请%d分钟后重试或联系管理员解锁", int(math.Ceil(wait.Minutes()))),
+			RetryAfter: wait,
+		}
+	}
+
+	window := time.Duration(s.config.WindowMinutes) * time.Minute
+	since := now.Add(-window)
+
+	// 同一IP在统计窗口内的失败次数
+	ipFailures, ipLast, err := s.countFailures("ip = ?", ip, since)
+	if err != nil {
+		return err
+	}
+	if s.config.IPMaxFailures > 0 && ipFailures >= s.config.IPMaxFailures {
+		return &LoginBlockedError{
+			Result:     models.LoginResultThrottled,
+			Message:    "该IP登录失败次数过多，请稍后再试",
+			RetryAfter: ipLast.Add(window).Sub(now),
+		}
+	}
+
+	// 同一账号自上次登录成功以来的连续失败次数
+	userFailures, userLast, err := s.accountFailures(userID, since)
+	if err != nil {
+		return err
+	}
+
+	// 连续失败后逐次加倍等待时间
+	wait := s.delay(userFailures) - now.Sub(userLast)
+	if ipWait := s.delay(ipFailures) - now.Sub(ipLast); ipWait > wait {
+		wait = ipWait
+	}
+	if wait > 0 {
+		return &LoginBlockedError{
+			Result:     models.LoginResultThrottled,
+			Message:    fmt.Sprintf("登录过于频繁，请%d秒后重试", int(math.Ceil(wait.Seconds()))),
+			RetryAfter: wait,
+		}
+	}
+
+	return nil
+}
+
+// CaptchaRequired 登录是否需要验证码：账号自上次登录成功以来或IP在统计窗口内的失败次数达到阈值后需要，
+// userID为0时只按IP判断
+func (s *SecurityService) CaptchaRequired(userID int, ip string) (bool, error) {
+	if s.config.CaptchaAfterFailures <= 0 {
+		return true, nil
+	}
+
+	since := s.now().Add(-time.Duration(s.config.WindowMinutes) * time.Minute)
+	ipFailures, _, err := s.countFailures("ip = ?", ip, since)
+	if err != nil {
+		return true, err
+	}
+	if ipFailures >= s.config.CaptchaAfterFailures || userID == 0 {
+		return ipFailures >= s.config.CaptchaAfterFailures, nil
+	}
+
+	userFailures, _, err := s.accountFailures(userID, since)
+	if err != nil {
+		return true, err
+	}
+	return userFailures >= s.config.CaptchaAfterFailures, nil
+}
+
+// delay 计算连续失败n次后需要等待的时间：前两次不等待，之后从1秒开始逐次加倍
+func (s *SecurityService) delay(failures int) time.Duration {
+	if failures < 3 {
+		return 0
+	}
+	maxDelay := time.Duration(s.config.MaxDelay) * time.Second
+	d := time.Second << uint(failures-3)
+	if d > maxDelay || d <= 0 {
+		d = maxDelay
+	}
+	return d
+}
+
+// countFailures 统计since之后符合条件的失败次数和最近一次失败时间
+func (s *SecurityService) countFailures(cond string, arg interface{}, since time.Time) (int, time.Time, error) {
+	var count int
+	var last sql.NullTime
+	err := db.DB.QueryRow(
+		"SELECT COUNT(*), MAX(created_at) FROM login_attempts WHERE "+cond+
+			" AND result IN "+failureResults+" AND created_at >= ?", arg, since,
+	).Scan(&count, &last)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return count, last.Time, nil
+}
+
+// accountFailures 统计账号在since之后、最近一次登录成功以来的失败次数和最近一次失败时间，账号不存在时为0
+func (s *SecurityService) accountFailures(userID int, since time.Time) (int, time.Time, error) {
+	if userID == 0 {
+		return 0, time.Time{}, nil
+	}
+	lastSuccess, err := s.lastSuccess(userID, since)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return s.countFailures("user_id = ?", userID, lastSuccess)
+}
+
+// lastSuccess 获取账号在since之后最近一次登录成功的时间，没有时返回since
+func (s *SecurityService) lastSuccess(userID int, since time.Time) (time.Time, error) {
+	var last sql.NullTime
+	err := db.DB.QueryRow(
+		"SELECT MAX(created_at) FROM login_attempts WHERE user_id = ? AND result = 'success' AND created_at >= ?",
+		userID, since,
+	).Scan(&last)
+	if err != nil {
+		return since, err
+	}
+	if last.Valid {
+		return last.Time, nil
+	}
+	return since, nil
+}
+
+// RecordLogin 记录登录尝试；密码错误累计到账号失败次数，达到阈值后锁定账号，登录成功后清零。
+// userID为0表示账号不存在，只记录尝试；username为记录中显示的用户名，不应包含明文号码
+func (s *SecurityService) RecordLogin(userID int, username, ip, userAgent, result string) error {
+	now := s.now()
+	username = truncate(username, 50)
+	userAgent = truncate(userAgent, 255)
+
+	_, err := db.DB.Exec(`
+		INSERT INTO login_attempts (user_id, username, ip, user_agent, result, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, nullableID(userID), username, ip, userAgent, result, now)
+	if err != nil || userID == 0 {
+		return err
+	}
+
+	switch result {
+	case models.LoginResultSuccess:
+		_, err = db.DB.Exec("UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = ?", userID)
+	case models.LoginResultBadCredentials, models.LoginResultTwoFactor:
+		_, err = db.DB.Exec("UPDATE users SET failed_login_count = failed_login_count + 1 WHERE id = ?", userID)
+		if err == nil && s.config.MaxFailures > 0 {
+			_, err = db.DB.Exec(`
+				UPDATE users SET locked_until = ?, failed_login_count = 0
+				WHERE id = ? AND failed_login_count >= ?
+			`, now.Add(time.Duration(s.config.LockMinutes)*time.Minute), userID, s.config.MaxFailures)
+		}
+	}
+	return err
+}
+
+// ListLockedAccounts 获取当前被锁定的账号
+func (s *SecurityService) ListLockedAccounts() ([]models.LockedAccount, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, username, name, COALESCE(department, ''), locked_until
+		FROM users WHERE locked_until > ?
+		ORDER BY locked_until DESC
+	`, s.now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []models.LockedAccount{}
+	for rows.Next() {
+		var account models.LockedAccount
+		err := rows.Scan(&account.UserID, &account.Username, &account.Name, &account.Department, &account.LockedUntil)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	return accounts, rows.Err()
+}
+
+// ListLoginAttempts 分页查询登录尝试记录
+func (s *SecurityService) ListLoginAttempts(filter *models.LoginAttemptFilter) ([]models.LoginAttempt, int, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 100 {
+		filter.PageSize = 20
+	}
+
+	where := " WHERE 1 = 1"
+	args := []interface{}{}
+	if filter.Username != "" {
+		where += " AND username = ?"
+		args = append(args, filter.Username)
+	}
+	if filter.IP != "" {
+		where += " AND ip = ?"
+		args = append(args, filter.IP)
+	}
+	if filter.Result != "" {
+		where += " AND result = ?"
+		args = append(args, filter.Result)
+	}
+	if !filter.StartDate.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, filter.StartDate)
+	}
+	if !filter.EndDate.IsZero() {
+		// 结束日期包含当天
+		where += " AND created_at < ?"
+		args = append(args, filter.EndDate.AddDate(0, 0, 1))
+	}
+
+	var total int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM login_attempts"+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := (filter.Page - 1) * filter.PageSize
+	rows, err := db.DB.Query(
+		"SELECT id, username, ip, user_agent, result, created_at FROM login_attempts"+where+
+			" ORDER BY id DESC LIMIT ? OFFSET ?", append(args, filter.PageSize, offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	attempts := []models.LoginAttempt{}
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		err := rows.Scan(&attempt.ID, &attempt.Username, &attempt.IP, &attempt.UserAgent, &attempt.Result, &attempt.CreatedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return attempts, total, nil
+}
+
+// truncate 按字符截断字符串，避免超出字段长度
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
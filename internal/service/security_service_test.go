@@ -0,0 +1,153 @@
+package service
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+)
+
+func newTestSecurityService(now time.Time) *SecurityService {
+	cfg := &config.Config{Login: config.LoginConfig{
+		MaxFailures: 5, LockMinutes: 15, IPMaxFailures: 20, WindowMinutes: 30, MaxDelay: 60, CaptchaAfterFailures: 3,
+	}}
+	s := NewSecurityService(cfg)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestResolveLoginAccountByPhone(t *testing.T) {
+	mock := mockDB(t)
+	users := NewUserService(&config.Config{}, nil)
+	byUsername := regexp.QuoteMeta("SELECT id, username FROM users WHERE username = ?")
+
+	// 手机号登录按盲索引找到账号，锁定按账号统计
+	mock.ExpectQuery(byUsername).WithArgs("13800138000").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, username FROM users WHERE phone_hash = ?")).
+		WithArgs(users.crypto.BlindIndex("13800138000", fieldPhone)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(7, "u0a1b2c3d4e"))
+	id, username, err := users.ResolveLoginAccount("13800138000")
+	if err != nil || id != 7 || username != "u0a1b2c3d4e" {
+		t.Fatalf("got %d %q %v", id, username, err)
+	}
+
+	// 不存在的号码脱敏后写入登录记录
+	mock.ExpectQuery(byUsername).WithArgs("13900139000").WillReturnRows(sqlmock.NewRows([]string{"id", "username"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, username FROM users WHERE phone_hash = ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}))
+	id, username, err = users.ResolveLoginAccount("13900139000")
+	if err != nil || id != 0 || username == "13900139000" {
+		t.Fatalf("got %d %q %v", id, username, err)
+	}
+}
+
+func TestCheckLoginLockedByUserID(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	security := newTestSecurityService(now)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT locked_until FROM users WHERE id = ?")).WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(now.Add(10 * time.Minute)))
+	err := security.CheckLogin(7, "10.0.0.1")
+	var blocked *LoginBlockedError
+	if !errors.As(err, &blocked) || blocked.Result != models.LoginResultLocked {
+		t.Fatalf("已锁定的账号应拒绝登录, got %v", err)
+	}
+}
+
+func TestRecordLoginFailureLocksAccount(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	security := newTestSecurityService(now)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO login_attempts (user_id, username, ip, user_agent, result, created_at)")).
+		WithArgs(7, "u0a1b2c3d4e", "10.0.0.1", "test", models.LoginResultBadCredentials, now).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET failed_login_count = failed_login_count + 1 WHERE id = ?")).
+		WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET locked_until = ?, failed_login_count = 0")).
+		WithArgs(now.Add(15*time.Minute), 7, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := security.RecordLogin(7, "u0a1b2c3d4e", "10.0.0.1", "test", models.LoginResultBadCredentials); err != nil {
+		t.Fatal(err)
+	}
+
+	// 账号不存在时只记录尝试
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO login_attempts")).
+		WithArgs(nil, "139****9000", "10.0.0.1", "test", models.LoginResultBadCredentials, now).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	if err := security.RecordLogin(0, "139****9000", "10.0.0.1", "test", models.LoginResultBadCredentials); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateUsernameHasNoNumber(t *testing.T) {
+	username, err := generateUsername()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !usernamePattern.MatchString(username) || looksLikePhone(username) || looksLikeIDCard(username) {
+		t.Fatalf("got %q", username)
+	}
+}
+
+func TestCaptchaRequired(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local)
+	security := newTestSecurityService(now)
+	since := now.Add(-30 * time.Minute)
+	byIP := regexp.QuoteMeta("SELECT COUNT(*), MAX(created_at) FROM login_attempts WHERE ip = ?")
+	byUser := regexp.QuoteMeta("SELECT COUNT(*), MAX(created_at) FROM login_attempts WHERE user_id = ?")
+	lastSuccess := regexp.QuoteMeta("SELECT MAX(created_at) FROM login_attempts WHERE user_id = ? AND result = 'success'")
+	failures := func(n int) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"count", "last"}).AddRow(n, nil)
+	}
+
+	// 没有失败记录的IP和账号不需要验证码
+	mock.ExpectQuery(byIP).WithArgs("10.0.0.1", since).WillReturnRows(failures(0))
+	mock.ExpectQuery(lastSuccess).WithArgs(7, since).WillReturnRows(sqlmock.NewRows([]string{"last"}).AddRow(nil))
+	mock.ExpectQuery(byUser).WithArgs(7, since).WillReturnRows(failures(2))
+	if required, err := security.CaptchaRequired(7, "10.0.0.1"); err != nil || required {
+		t.Fatalf("clean login: %v %v", required, err)
+	}
+
+	// 账号失败次数从最近一次登录成功开始统计
+	success := now.Add(-5 * time.Minute)
+	mock.ExpectQuery(byIP).WithArgs("10.0.0.2", since).WillReturnRows(failures(1))
+	mock.ExpectQuery(lastSuccess).WithArgs(7, since).WillReturnRows(sqlmock.NewRows([]string{"last"}).AddRow(success))
+	mock.ExpectQuery(byUser).WithArgs(7, success).WillReturnRows(failures(3))
+	if required, err := security.CaptchaRequired(7, "10.0.0.2"); err != nil || !required {
+		t.Fatalf("account failures: %v %v", required, err)
+	}
+
+	// IP失败次数达到阈值时不再查询账号
+	mock.ExpectQuery(byIP).WithArgs("10.0.0.3", since).WillReturnRows(failures(3))
+	if required, err := security.CaptchaRequired(7, "10.0.0.3"); err != nil || !required {
+		t.Fatalf("ip failures: %v %v", required, err)
+	}
+
+	// 账号不存在时只按IP判断
+	mock.ExpectQuery(byIP).WithArgs("10.0.0.4", since).WillReturnRows(failures(2))
+	if required, err := security.CaptchaRequired(0, "10.0.0.4"); err != nil || required {
+		t.Fatalf("unknown account: %v %v", required, err)
+	}
+
+	// 查询失败时要求验证码
+	mock.ExpectQuery(byIP).WillReturnError(errors.New("connection refused"))
+	if required, err := security.CaptchaRequired(7, "10.0.0.5"); err == nil || !required {
+		t.Fatalf("db error: %v %v", required, err)
+	}
+}
+
+func TestCaptchaAlwaysRequired(t *testing.T) {
+	mockDB(t)
+	security := newTestSecurityService(time.Now())
+	security.config.CaptchaAfterFailures = 0
+
+	if required, err := security.CaptchaRequired(7, "10.0.0.1"); err != nil || !required {
+		t.Fatalf("got %v %v", required, err)
+	}
+}
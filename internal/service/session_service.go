@@ -0,0 +1,293 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/middleware"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+)
+
+// ErrSessionInvalid 会话不存在、已注销或已过期
+var ErrSessionInvalid = errors.New("登录已失效，请重新登录")
+
+// sessionTouchInterval 最近访问时间的更新间隔，避免每个请求都写库
+const sessionTouchInterval = time.Minute
+
+// SessionService 登录会话服务：签发访问令牌和刷新令牌，支持续期、注销和会话管理
+type SessionService struct {
+	config    *config.Config
+	jwtConfig *middleware.JWTConfig
+	now       func() time.Time
+}
+
+// NewSessionService 创建会话服务
+func NewSessionService(cfg *config.Config, jwt *middleware.JWTConfig) *SessionService {
+	return &SessionService{
+		config:    cfg,
+		jwtConfig: jwt,
+		now:       time.Now,
+	}
+}
+
+// CreateSession 为登录成功的用户创建会话，返回访问令牌和刷新令牌
+func (s *SessionService) CreateSession(userID int, username, role, scope, ip, userAgent string) (string, string, error) {
+	sessionID, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := s.now()
+	_, err = db.DB.Exec(`
+		INSERT INTO user_sessions (id, user_id, refresh_token_hash, scope, ip, user_agent, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, sessionID, userID, hashToken(secret), scope, truncate(ip, 45), truncate(userAgent, 255), now, now, s.refreshExpiry(now))
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := s.jwtConfig.GenerateSessionToken(userID, username, role, scope, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, sessionID + "." + secret, nil
+}
+
+// RefreshSession 用刷新令牌签发新的访问令牌并轮换刷新令牌；使用已轮换的旧令牌视为被盗用，注销整个会话
+func (s *SessionService) RefreshSession(refreshToken, ip, userAgent string) (string, string, error) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", ErrSessionInvalid
+	}
+
+	var userID, status int
+	var currentHash, previousHash, scope, username, role string
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	err := db.DB.QueryRow(`
+		SELECT s.user_id, s.refresh_token_hash, s.previous_token_hash, s.scope, s.expires_at, s.revoked_at,
+		       u.username, u.role, u.status
+		FROM user_sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.id = ?
+	`, sessionID).Scan(&userID, &currentHash, &previousHash, &scope, &expiresAt, &revokedAt, &username, &role, &status)
+	if err != nil {
+		return "", "", ErrSessionInvalid
+	}
+
+	now := s.now()
+	if revokedAt.Valid || !expiresAt.After(now) {
+		return "", "", ErrSessionInvalid
+	}
+
+	presented := hashToken(secret)
+	if previousHash != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(previousHash)) == 1 {
+		// 旧刷新令牌被再次使用，说明令牌已泄露
+		if err := s.RevokeSessionByID(sessionID); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrSessionInvalid
+	}
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(currentHash)) != 1 {
+		return "", "", ErrSessionInvalid
+	}
+	if status == 0 {
+		if err := s.RevokeSessionByID(sessionID); err != nil {
+			return "", "", err
+		}
+		return "", "", ErrUserDisabled
+	}
+
+	newSecret, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	// 条件更新防止同一刷新令牌被并发使用两次
+	result, err := db.DB.Exec(`
+		UPDATE user_sessions
+		SET previous_token_hash = refresh_token_hash, refresh_token_hash = ?,
+		    ip = ?, user_agent = ?, last_seen_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_token_hash = ? AND revoked_at IS NULL
+	`, hashToken(newSecret), truncate(ip, 45), truncate(userAgent, 255), now, s.refreshExpiry(now), sessionID, currentHash)
+	if err != nil {
+		return "", "", err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return "", "", ErrSessionInvalid
+	}
+
+	// 使用最新的角色签发访问令牌
+	accessToken, err := s.jwtConfig.GenerateSessionToken(userID, username, role, scope, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, sessionID + "." + newSecret, nil
+}
+
+// ReissueSession 修改密码或绑定两步验证后将会话改为新的受限范围（为空表示解除限制），
+// 轮换刷新令牌并注销该用户的其他会话
+func (s *SessionService) ReissueSession(sessionID string, userID int, scope string) (string, string, error) {
+	var username, role string
+	err := db.DB.QueryRow("SELECT username, role FROM users WHERE id = ?", userID).Scan(&username, &role)
+	if err != nil {
+		return "", "", err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := s.now()
+	result, err := db.DB.Exec(`
+		UPDATE user_sessions
+		SET scope = ?, previous_token_hash = refresh_token_hash, refresh_token_hash = ?, last_seen_at = ?, expires_at = ?
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL
+	`, scope, hashToken(secret), now, s.refreshExpiry(now), sessionID, userID)
+	if err != nil {
+		return "", "", err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return "", "", ErrSessionInvalid
+	}
+
+	if _, err := s.RevokeUserSessions(userID, sessionID); err != nil {
+		return "", "", err
+	}
+
+	accessToken, err := s.jwtConfig.GenerateSessionToken(userID, username, role, scope, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, sessionID + "." + secret, nil
+}
+
+// ValidateSession 检查会话是否属于该用户且未注销、未过期，并记录最近访问时间
+func (s *SessionService) ValidateSession(sessionID string, userID int) error {
+	var ownerID int
+	var lastSeenAt, expiresAt time.Time
+	var revokedAt sql.NullTime
+	err := db.DB.QueryRow(
+		"SELECT user_id, last_seen_at, expires_at, revoked_at FROM user_sessions WHERE id = ?", sessionID,
+	).Scan(&ownerID, &lastSeenAt, &expiresAt, &revokedAt)
+	if err != nil {
+		return ErrSessionInvalid
+	}
+
+	now := s.now()
+	if ownerID != userID || revokedAt.Valid || !expiresAt.After(now) {
+		return ErrSessionInvalid
+	}
+
+	if now.Sub(lastSeenAt) > sessionTouchInterval {
+		db.DB.Exec("UPDATE user_sessions SET last_seen_at = ? WHERE id = ?", now, sessionID)
+	}
+	return nil
+}
+
+// ListSessions 获取用户未注销、未过期的会话，currentID为发起请求的会话
+func (s *SessionService) ListSessions(userID int, currentID string) ([]models.UserSession, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, user_id, ip, user_agent, created_at, last_seen_at, expires_at
+		FROM user_sessions
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY last_seen_at DESC
+	`, userID, s.now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.UserSession{}
+	for rows.Next() {
+		var session models.UserSession
+		err := rows.Scan(&session.ID, &session.UserID, &session.IP, &session.UserAgent,
+			&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		session.Current = session.ID == currentID
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// RevokeSession 注销用户自己的某个会话
+func (s *SessionService) RevokeSession(userID int, sessionID string) error {
+	result, err := db.DB.Exec(
+		"UPDATE user_sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		s.now(), sessionID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return errors.New("会话不存在")
+	}
+	return nil
+}
+
+// RevokeSessionByID 注销会话
+func (s *SessionService) RevokeSessionByID(sessionID string) error {
+	_, err := db.DB.Exec("UPDATE user_sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", s.now(), sessionID)
+	return err
+}
+
+// RevokeRefreshToken 注销刷新令牌所属的会话，用于访问令牌已过期时登出
+func (s *SessionService) RevokeRefreshToken(refreshToken string) error {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok {
+		return ErrSessionInvalid
+	}
+	_, err := db.DB.Exec(
+		"UPDATE user_sessions SET revoked_at = ? WHERE id = ? AND refresh_token_hash = ? AND revoked_at IS NULL",
+		s.now(), sessionID, hashToken(secret),
+	)
+	return err
+}
+
+// RevokeUserSessions 注销用户的全部会话，exceptID不为空时保留该会话，返回注销的会话数
+func (s *SessionService) RevokeUserSessions(userID int, exceptID string) (int64, error) {
+	result, err := db.DB.Exec(
+		"UPDATE user_sessions SET revoked_at = ? WHERE user_id = ? AND id <> ? AND revoked_at IS NULL",
+		s.now(), userID, exceptID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// refreshExpiry 刷新令牌的过期时间，每次续期后顺延
+func (s *SessionService) refreshExpiry(now time.Time) time.Time {
+	return now.Add(time.Duration(s.config.JWT.RefreshExpiresIn) * time.Second)
+}
+
+// randomHex 生成n字节的随机数并编码为十六进制
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashToken 刷新令牌只保存SHA-256摘要
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,385 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/internal/db"
+	"github.com/hangbin2008/sanjicms/internal/middleware"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/fieldcrypt"
+	"github.com/hangbin2008/sanjicms/pkg/totp"
+)
+
+// ErrInvalidTwoFactorCode 两步验证码或恢复码错误
+var ErrInvalidTwoFactorCode = errors.New("两步验证码错误")
+
+// 两步验证参数
+const (
+	recoveryCodeCount = 10 // 每次生成的恢复码数量
+	totpSkew          = 1  // 允许前后各一个时间步（30秒）的时钟误差
+)
+
+// fieldTOTPSecret 两步验证密钥加密时的字段名称
+const fieldTOTPSecret = "users.totp_secret"
+
+// TwoFactorService 两步验证服务：TOTP绑定、校验、恢复码和重置
+type TwoFactorService struct {
+	config *config.TwoFactorConfig
+	crypto *fieldcrypt.Keyring // 加密保存TOTP密钥，与手机号、身份证号使用同一组密钥
+	now    func() time.Time
+}
+
+// NewTwoFactorService 创建两步验证服务
+func NewTwoFactorService(cfg *config.Config) *TwoFactorService {
+	crypto, err := fieldcrypt.NewKeyring(cfg.Crypto.Keys, cfg.Crypto.BlindIndexKey)
+	if err != nil {
+		// 密钥在加载配置时已经校验
+		panic(err)
+	}
+	return &TwoFactorService{
+		config: &cfg.TwoFactor,
+		crypto: crypto,
+		now:    time.Now,
+	}
+}
+
+// twoFactorState 用户的两步验证数据
+type twoFactorState struct {
+	username string
+	role     string
+	secret   string
+	enabled  bool
+}
+
+// Required 判断角色是否必须启用两步验证
+func (s *TwoFactorService) Required(role string) bool {
+	for _, r := range s.config.RequiredRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// GetStatus 获取用户的两步验证状态
+func (s *TwoFactorService) GetStatus(userID int) (*models.TwoFactorStatus, error) {
+	state, err := s.loadState(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &models.TwoFactorStatus{
+		Enabled:  state.enabled,
+		Required: s.Required(state.role),
+	}
+	if state.enabled {
+		err = db.DB.QueryRow("SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL",
+			userID).Scan(&status.RecoveryCodesRemaining)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// SetupScope 返回用户登录后的受限范围：必须启用两步验证但尚未绑定时为ScopeTwoFactorSetup，否则为空
+func (s *TwoFactorService) SetupScope(userID int) (string, error) {
+	state, err := s.loadState(userID)
+	if err != nil {
+		return "", err
+	}
+	if s.Required(state.role) && !state.enabled {
+		return middleware.ScopeTwoFactorSetup, nil
+	}
+	return "", nil
+}
+
+// BeginSetup 生成新的密钥，用户用验证器应用添加后调用Enable确认绑定
+func (s *TwoFactorService) BeginSetup(userID int) (*models.TwoFactorSetup, error) {
+	state, err := s.loadState(userID)
+	if err != nil {
+		return nil, err
+	}
+	if state.enabled {
+		return nil, errors.New("已启用两步验证，如需更换设备请先停用")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.crypto.Encrypt(secret, fieldTOTPSecret)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.DB.Exec("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?", sealed, userID); err != nil {
+		return nil, err
+	}
+
+	return &models.TwoFactorSetup{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(s.config.Issuer, state.username, secret),
+	}, nil
+}
+
+// Enable 用验证器应用生成的验证码确认绑定，成功后返回一组新的恢复码
+func (s *TwoFactorService) Enable(userID int, code string) ([]string, error) {
+	state, err := s.loadState(userID)
+	if err != nil {
+		return nil, err
+	}
+	if state.enabled {
+		return nil, errors.New("已启用两步验证")
+	}
+	if state.secret == "" {
+		return nil, errors.New("请先生成两步验证密钥")
+	}
+
+	step, ok := totp.Validate(state.secret, code, s.now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET totp_enabled = 1, totp_last_step = ? WHERE id = ? AND totp_enabled = 0",
+		step, userID)
+	if err != nil {
+		return nil, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, errors.New("已启用两步验证")
+	}
+
+	codes, err := s.replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable 用验证码或恢复码停用两步验证，必须启用的角色不能停用
+func (s *TwoFactorService) Disable(userID int, code string) error {
+	state, err := s.loadState(userID)
+	if err != nil {
+		return err
+	}
+	if !state.enabled {
+		return errors.New("未启用两步验证")
+	}
+	if s.Required(state.role) {
+		return errors.New("当前角色必须启用两步验证，不能停用")
+	}
+	if err := s.Verify(userID, code); err != nil {
+		return err
+	}
+	return s.Reset(userID)
+}
+
+// RegenerateRecoveryCodes 用验证器应用的验证码换取一组新的恢复码，旧恢复码全部作废
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	state, err := s.loadState(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !state.enabled {
+		return nil, errors.New("未启用两步验证")
+	}
+	if !s.verifyTOTP(userID, state.secret, code) {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := s.replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify 校验验证码或恢复码，验证码和恢复码都只能使用一次
+func (s *TwoFactorService) Verify(userID int, code string) error {
+	state, err := s.loadState(userID)
+	if err != nil {
+		return err
+	}
+	if !state.enabled {
+		return errors.New("未启用两步验证")
+	}
+
+	if s.verifyTOTP(userID, state.secret, code) {
+		return nil
+	}
+
+	// 不是有效的验证码时按恢复码校验
+	used, err := s.useRecoveryCode(userID, code)
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// Reset 清除用户的两步验证密钥和恢复码，用于停用或管理员重置
+func (s *TwoFactorService) Reset(userID int) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_secret = NULL, totp_enabled = 0, totp_last_step = 0 WHERE id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// verifyTOTP 校验验证码并记录时间步，同一时间步及更早的验证码不能再次使用
+func (s *TwoFactorService) verifyTOTP(userID int, secret, code string) bool {
+	step, ok := totp.Validate(secret, code, s.now(), totpSkew)
+	if !ok {
+		return false
+	}
+
+	result, err := db.DB.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0
+}
+
+// useRecoveryCode 使用一个恢复码
+func (s *TwoFactorService) useRecoveryCode(userID int, code string) (bool, error) {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false, nil
+	}
+
+	result, err := db.DB.Exec(
+		"UPDATE user_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL LIMIT 1",
+		s.now(), userID, hashToken(code),
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// replaceRecoveryCodes 作废旧恢复码并生成新的恢复码，只保存摘要
+func (s *TwoFactorService) replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := randomHex(5)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, hashToken(raw)); err != nil {
+			return nil, err
+		}
+		// 分成两组便于抄写，例如 3f9a1-0c2be
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, nil
+}
+
+// loadState 加载用户的两步验证数据
+func (s *TwoFactorService) loadState(userID int) (*twoFactorState, error) {
+	var state twoFactorState
+	err := db.DB.QueryRow(
+		"SELECT username, role, COALESCE(totp_secret, ''), totp_enabled FROM users WHERE id = ?", userID,
+	).Scan(&state.username, &state.role, &state.secret, &state.enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("用户不存在")
+		}
+		return nil, err
+	}
+	if state.secret, err = s.crypto.Decrypt(state.secret, fieldTOTPSecret); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// EncryptSecrets 用当前密钥加密尚未加密或使用旧密钥加密的两步验证密钥，返回更新的账号数量。
+// 与EncryptPersonalInfo一起在每次启动时执行
+func (s *TwoFactorService) EncryptSecrets() (int, error) {
+	rows, err := db.DB.Query("SELECT id, totp_secret FROM users WHERE totp_secret IS NOT NULL AND totp_secret <> ''")
+	if err != nil {
+		return 0, err
+	}
+	stored := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var secret string
+		if err := rows.Scan(&id, &secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if s.crypto.NeedsRewrite(secret) {
+			stored[id] = secret
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for id, secret := range stored {
+		plaintext, err := s.crypto.Decrypt(secret, fieldTOTPSecret)
+		if err != nil {
+			return updated, err
+		}
+		sealed, err := s.crypto.Encrypt(plaintext, fieldTOTPSecret)
+		if err != nil {
+			return updated, err
+		}
+		// 期间用户重新绑定过时不覆盖
+		result, err := db.DB.Exec("UPDATE users SET totp_secret = ? WHERE id = ? AND totp_secret = ?", sealed, id, secret)
+		if err != nil {
+			return updated, err
+		}
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+// normalizeRecoveryCode 恢复码忽略大小写、空格和连字符
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/fieldcrypt"
+	"github.com/hangbin2008/sanjicms/pkg/totp"
+)
+
+// RFC 6238 附录B的SHA1密钥，T=1111111111 时的验证码为 050471
+const (
+	testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	testTOTPCode   = "050471"
+)
+
+var (
+	stateQuery    = regexp.QuoteMeta("SELECT username, role, COALESCE(totp_secret, ''), totp_enabled FROM users WHERE id = ?")
+	lastStepQuery = regexp.QuoteMeta("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?")
+	recoveryQuery = regexp.QuoteMeta("UPDATE user_recovery_codes SET used_at = ?")
+)
+
+func testCryptoConfig() *config.Config {
+	return &config.Config{Crypto: config.CryptoConfig{
+		Keys:          []fieldcrypt.Key{{ID: "1", Secret: bytes.Repeat([]byte{1}, fieldcrypt.KeySize)}},
+		BlindIndexKey: bytes.Repeat([]byte{2}, fieldcrypt.KeySize),
+	}}
+}
+
+// newTestTwoFactorService 使用固定时钟的两步验证服务
+func newTestTwoFactorService(now time.Time) *TwoFactorService {
+	s := NewTwoFactorService(testCryptoConfig())
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func stateRows(s *TwoFactorService, secret string, enabled bool) *sqlmock.Rows {
+	sealed, _ := s.crypto.Encrypt(secret, fieldTOTPSecret)
+	return sqlmock.NewRows([]string{"username", "role", "totp_secret", "totp_enabled"}).
+		AddRow("zhangsan", "employee", sealed, enabled)
+}
+
+// sealedSecret 匹配加密保存的TOTP密钥
+type sealedSecret struct {
+	s         *TwoFactorService
+	plaintext *string
+}
+
+func (m sealedSecret) Match(v driver.Value) bool {
+	value, ok := v.(string)
+	if !ok || !fieldcrypt.IsEncrypted(value) {
+		return false
+	}
+	plaintext, err := m.s.crypto.Decrypt(value, fieldTOTPSecret)
+	*m.plaintext = plaintext
+	return err == nil && plaintext != ""
+}
+
+func TestBeginSetupSealsSecret(t *testing.T) {
+	mock := mockDB(t)
+	s := newTestTwoFactorService(time.Unix(1111111111, 0))
+
+	var stored string
+	mock.ExpectQuery(stateQuery).WithArgs(7).WillReturnRows(stateRows(s, "", false))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?")).
+		WithArgs(sealedSecret{s, &stored}, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	setup, err := s.BeginSetup(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != setup.Secret {
+		t.Fatalf("数据库中的密钥解密后应与返回给用户的一致, got %q want %q", stored, setup.Secret)
+	}
+	if !strings.Contains(setup.ProvisioningURI, "secret="+setup.Secret) {
+		t.Fatalf("got %s", setup.ProvisioningURI)
+	}
+}
+
+func TestEnableWithFixedClock(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Unix(1111111111, 0)
+	s := newTestTwoFactorService(now)
+
+	// 超出时钟误差的验证码
+	old, _ := totp.Code(testTOTPSecret, totp.Step(now)-2)
+	mock.ExpectQuery(stateQuery).WithArgs(7).WillReturnRows(stateRows(s, testTOTPSecret, false))
+	if _, err := s.Enable(7, old); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("got %v", err)
+	}
+
+	mock.ExpectQuery(stateQuery).WithArgs(7).WillReturnRows(stateRows(s, testTOTPSecret, false))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET totp_enabled = 1, totp_last_step = ?")).
+		WithArgs(totp.Step(now), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM user_recovery_codes WHERE user_id = ?")).WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for i := 0; i < recoveryCodeCount; i++ {
+		mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_recovery_codes")).WithArgs(7, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(int64(i+1), 1))
+	}
+	mock.ExpectCommit()
+
+	codes, err := s.Enable(7, testTOTPCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || !regexp.MustCompile(`^[0-9a-f]{5}-[0-9a-f]{5}$`).MatchString(codes[0]) {
+		t.Fatalf("got %v", codes)
+	}
+}
+
+func TestVerifyRejectsReplayedCode(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Unix(1111111111, 0)
+	s := newTestTwoFactorService(now)
+	step := totp.Step(now)
+
+	mock.ExpectQuery(stateQuery).WithArgs(7).WillReturnRows(stateRows(s, testTOTPSecret, true))
+	mock.ExpectExec(lastStepQuery).WithArgs(step, 7, step).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := s.Verify(7, testTOTPCode); err != nil {
+		t.Fatalf("第一次使用验证码应通过, got %v", err)
+	}
+
+	// 同一时间步的验证码已记录，再次提交时既不是有效的验证码也不是恢复码
+	mock.ExpectQuery(stateQuery).WithArgs(7).WillReturnRows(stateRows(s, testTOTPSecret, true))
+	mock.ExpectExec(lastStepQuery).WithArgs(step, 7, step).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(recoveryQuery).WithArgs(now, 7, hashToken(testTOTPCode)).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := s.Verify(7, testTOTPCode); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("重放的验证码应被拒绝, got %v", err)
+	}
+}
+
+func TestVerifyRecoveryCodeSingleUse(t *testing.T) {
+	mock := mockDB(t)
+	now := time.Unix(1111111111, 0)
+	s := newTestTwoFactorService(now)
+
+	// 恢复码忽略大小写、空格和连字符，只保存摘要
+	mock.ExpectQuery(stateQuery).WithArgs(7).WillReturnRows(stateRows(s, testTOTPSecret, true))
+	mock.ExpectExec(recoveryQuery).WithArgs(now, 7, hashToken("3f9a10c2be")).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := s.Verify(7, "3F9A1-0C2BE"); err != nil {
+		t.Fatalf("未使用的恢复码应通过, got %v", err)
+	}
+
+	mock.ExpectQuery(stateQuery).WithArgs(7).WillReturnRows(stateRows(s, testTOTPSecret, true))
+	mock.ExpectExec(recoveryQuery).WithArgs(now, 7, hashToken("3f9a10c2be")).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := s.Verify(7, "3f9a1-0c2be"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Fatalf("已使用的恢复码应被拒绝, got %v", err)
+	}
+
+	// 未启用两步验证时不校验
+	mock.ExpectQuery(stateQuery).WithArgs(7).WillReturnRows(stateRows(s, "", false))
+	if err := s.Verify(7, testTOTPCode); err == nil {
+		t.Fatal("未启用两步验证时应返回错误")
+	}
+}
+
+func TestEncryptSecretsRewritesPlaintext(t *testing.T) {
+	mock := mockDB(t)
+	s := newTestTwoFactorService(time.Unix(1111111111, 0))
+	sealed, _ := s.crypto.Encrypt(testTOTPSecret, fieldTOTPSecret)
+
+	var stored string
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, totp_secret FROM users")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "totp_secret"}).AddRow(7, testTOTPSecret).AddRow(8, sealed))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET totp_secret = ? WHERE id = ? AND totp_secret = ?")).
+		WithArgs(sealedSecret{s, &stored}, 7, testTOTPSecret).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	updated, err := s.EncryptSecrets()
+	if err != nil || updated != 1 {
+		t.Fatalf("got %d %v", updated, err)
+	}
+	if stored != testTOTPSecret {
+		t.Fatalf("got %q", stored)
+	}
+}
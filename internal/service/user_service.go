@@ -1,34 +1,78 @@
 package service
 
 import (
+	"crypto/rand"
+	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"math/big"
 	"regexp"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/hangbin2008/sanjicms/internal/db"
 	"github.com/hangbin2008/sanjicms/internal/middleware"
 	"github.com/hangbin2008/sanjicms/internal/models"
 	"github.com/hangbin2008/sanjicms/pkg/config"
+	"github.com/hangbin2008/sanjicms/pkg/fieldcrypt"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// usernamePattern 管理员创建或导入账号时的用户名格式，与注册时的校验规则一致
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9]{3,20}$`)
+
+// 登录失败原因，用于区分登录尝试记录的结果
+var (
+	ErrInvalidCredentials = errors.New("用户名或密码错误")
+	ErrUserDisabled       = errors.New("用户已被禁用")
+)
+
+// ErrExternalPassword 域账号等外部认证账号的密码不能在本系统修改
+var ErrExternalPassword = errors.New("域账号的密码请在医院统一身份认证系统中修改")
+
 // UserService 用户服务
 type UserService struct {
-	config    *config.Config
-	jwtConfig *middleware.JWTConfig
+	config      *config.Config
+	jwtConfig   *middleware.JWTConfig
+	departments *DepartmentService
+	sessions    *SessionService
+	permissions *PermissionService
+	twoFactor   *TwoFactorService
+	providers   []AuthProvider
+	crypto      *fieldcrypt.Keyring // 手机号、身份证号的加密和盲索引
 }
 
-// NewUserService 创建用户服务
+// NewUserService 创建用户服务，启用LDAP时注册LDAP认证方式
 func NewUserService(cfg *config.Config, jwt *middleware.JWTConfig) *UserService {
-	return &UserService{
-		config:    cfg,
-		jwtConfig: jwt,
+	crypto, err := fieldcrypt.NewKeyring(cfg.Crypto.Keys, cfg.Crypto.BlindIndexKey)
+	if err != nil {
+		// 密钥在加载配置时已经校验
+		panic(err)
+	}
+	s := &UserService{
+		config:      cfg,
+		jwtConfig:   jwt,
+		departments: NewDepartmentService(),
+		sessions:    NewSessionService(cfg, jwt),
+		permissions: NewPermissionService(),
+		twoFactor:   NewTwoFactorService(cfg),
+		crypto:      crypto,
 	}
+	if cfg.LDAP.Enabled {
+		s.RegisterAuthProvider(NewLDAPProvider(cfg))
+	}
+	return s
 }
 
+// RegisterAuthProvider 注册外部认证方式，自动创建账号时按注册顺序尝试
+func (s *UserService) RegisterAuthProvider(provider AuthProvider) {
+	s.providers = append(s.providers, provider)
+}
+
+// externalPasswordHash 外部认证账号的password_hash占位值，不是合法的bcrypt哈希，任何密码都不能通过校验
+const externalPasswordHash = "!external"
+
 // ValidatePassword 验证密码是否符合要求
 func (s *UserService) ValidatePassword(password string) error {
 	// 检查密码长度
@@ -92,13 +136,44 @@ func (s *UserService) ValidateName(name string) error {
 	return nil
 }
 
-// RegisterUser 用户注册 - 简化版：只需要账号、密码和验证码
-func (s *UserService) RegisterUser(req *models.UserRegisterRequest) (*models.User, error) {
+// RegisterUser 用户注册 - 简化版：只需要账号、密码和验证码，ip记入审计日志
+func (s *UserService) RegisterUser(req *models.UserRegisterRequest, ip string) (*models.User, error) {
 	// 验证密码
 	if err := s.ValidatePassword(req.Password); err != nil {
 		return nil, err
 	}
 
+	// 手机号或身份证号作为用户名时必须是有效号码
+	info := &personalInfo{}
+	switch {
+	case looksLikePhone(req.Username):
+		normalized, err := normalizePhone(req.Username)
+		if err != nil {
+			return nil, err
+		}
+		info.phone, info.phoneChanged = normalized, true
+	case looksLikeIDCard(req.Username):
+		card, err := parseIDCard(req.Username)
+		if err != nil {
+			return nil, err
+		}
+		info.idCard, info.idCardChanged = card.Number, true
+		info.gender = card.Gender()
+		info.birthDate = card.Birthdate.Format("2006-01-02")
+	}
+	if err := s.sealPersonalInfo(0, info); err != nil {
+		return nil, err
+	}
+
+	// 号码只加密保存，用户名随机生成，之后用号码登录
+	if info.phoneChanged || info.idCardChanged {
+		username, err := generateUsername()
+		if err != nil {
+			return nil, err
+		}
+		req.Username = username
+	}
+
 	// 检查用户名是否已存在
 	var count int
 	err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", req.Username).Scan(&count)
@@ -121,9 +196,10 @@ func (s *UserService) RegisterUser(req *models.UserRegisterRequest) (*models.Use
 
 	// 插入用户记录 - 简化版：只插入必要字段
 	result, err := db.DB.Exec(`
-		INSERT INTO users (username, password_hash, name, role)
-		VALUES (?, ?, ?, ?)
-	`, req.Username, string(hashedPassword), defaultName, defaultRole)
+		INSERT INTO users (username, password_hash, name, role, phone, phone_hash, id_card, id_card_hash, gender, birth_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, COALESCE(?, '男'), ?)
+	`, req.Username, string(hashedPassword), defaultName, defaultRole, info.phone, info.phoneIndex,
+		info.idCard, info.idCardIndex, info.gender, info.birthDate)
 	if err != nil {
 		return nil, err
 	}
@@ -137,151 +213,329 @@ func (s *UserService) RegisterUser(req *models.UserRegisterRequest) (*models.Use
 	// 查询插入的用户信息 - 使用COALESCE处理可能为NULL的字段
 	var user models.User
 	err = db.DB.QueryRow(`
-		SELECT id, username, name, role, 
-		       COALESCE(phone, ''), COALESCE(id_card, ''), 
+		SELECT id, username, name, COALESCE(gender, '男'), role, 
+		       COALESCE(phone, ''), COALESCE(id_card, ''), COALESCE(DATE_FORMAT(birth_date, '%Y-%m-%d'), ''), 
 		       COALESCE(department, ''), COALESCE(job_title, ''), 
 		       COALESCE(avatar, ''), status, created_at, updated_at
 		FROM users WHERE id = ?
 	`, userID).Scan(
-		&user.ID, &user.Username, &user.Name, &user.Role, &user.Phone, &user.IDCard,
+		&user.ID, &user.Username, &user.Name, &user.Gender, &user.Role, &user.Phone, &user.IDCard, &user.BirthDate,
 		&user.Department, &user.JobTitle, &user.Avatar, &user.Status, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.openPersonalInfo(&user); err != nil {
+		return nil, err
+	}
 
+	logAudit(Operator{UserID: user.ID, Username: user.Username, Role: user.Role, IP: ip}, auditEvent{
+		Action:     models.AuditUserRegister,
+		EntityType: models.AuditEntityUser,
+		EntityID:   user.ID,
+		After:      userAuditFields(&user),
+	})
 	return &user, nil
 }
 
-// LoginUser 用户登录
-func (s *UserService) LoginUser(req *models.UserLoginRequest) (*models.LoginResponse, error) {
-	// 查询用户 - 使用COALESCE处理可能为NULL的字段
-	var user models.User
-	query := `
-		SELECT id, username, password_hash, name, COALESCE(gender, '男'), COALESCE(email, ''), role, 
-		       COALESCE(phone, ''), COALESCE(id_card, ''), 
-		       COALESCE(department, ''), COALESCE(job_title, ''), 
-		       COALESCE(avatar, ''), status, created_at, updated_at
-		FROM users WHERE username = ?
-	`
-	err := db.DB.QueryRow(query, req.Username).Scan(
-		&user.ID, &user.Username, &user.PasswordHash, &user.Name, &user.Gender, &user.Email, &user.Role,
-		&user.Phone, &user.IDCard, &user.Department, &user.JobTitle, &user.Avatar, &user.Status,
-		&user.CreatedAt, &user.UpdatedAt,
-	)
+// ResolveLoginAccount 按用户名或手机号、身份证号的盲索引查找登录账号，返回账号ID和登录记录中使用的用户名，
+// 登录失败次数和锁定都按账号统计。账号不存在时ID为0，号码形式的登录名脱敏后返回，明文号码不写入登录记录
+func (s *UserService) ResolveLoginAccount(identifier string) (int, string, error) {
+	var userID int
+	var username string
+	err := db.DB.QueryRow("SELECT id, username FROM users WHERE username = ?", identifier).Scan(&userID, &username)
+	if errors.Is(err, sql.ErrNoRows) {
+		if column, index := s.loginIndex(identifier); column != "" {
+			err = db.DB.QueryRow("SELECT id, username FROM users WHERE "+column+" = ?", index).Scan(&userID, &username)
+		}
+	}
+	switch {
+	case err == nil:
+		return userID, username, nil
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, maskLoginName(identifier), nil
+	default:
+		return 0, "", err
+	}
+}
+
+// twoFactorChallengeTTL 密码验证通过后提交两步验证码的时限
+const twoFactorChallengeTTL = 5 * time.Minute
+
+// LoginUser 用户登录，ip和userAgent记录到新建的会话中。
+// 已启用两步验证的用户只返回两步验证凭证，需调用LoginTwoFactor完成登录
+func (s *UserService) LoginUser(req *models.UserLoginRequest, ip, userAgent string) (*models.LoginResponse, error) {
+	user, totpEnabled, err := s.findLoginUser("username", req.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		// 用户名不存在时按手机号或身份证号的盲索引查找
+		if column, index := s.loginIndex(req.Username); column != "" {
+			user, totpEnabled, err = s.findLoginUser(column, index)
+		}
+	}
+	switch {
+	case err == nil:
+		// 按账号的认证方式验证密码
+		if err := s.authenticate(user, req.Password); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		// 本地不存在的账号尝试外部认证，通过后自动创建
+		user, totpEnabled, err = s.provisionUser(req.Username, req.Password)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrInvalidCredentials
+	}
+
+	return s.finishLogin(user, totpEnabled, ip, userAgent)
+}
+
+// LoginExternalUser 外部认证（例如OIDC）已确认身份后登录，同样检查用户状态和两步验证
+func (s *UserService) LoginExternalUser(userID int, ip, userAgent string) (*models.LoginResponse, error) {
+	user, totpEnabled, err := s.findLoginUser("id", userID)
 	if err != nil {
-		// 用户名不存在，检查是否是admin用户，如果是则自动创建
-		if req.Username == "admin" {
-			// 修复：创建admin用户时使用固定的默认密码，不验证密码强度
-			log.Println("admin用户不存在，开始创建...")
+		return nil, ErrInvalidCredentials
+	}
+	return s.finishLogin(user, totpEnabled, ip, userAgent)
+}
 
-			// 固定使用符合要求的默认密码：Admin@123
-			defaultPassword := "Admin@123"
-			passwordToUse := req.Password
+// finishLogin 身份验证通过后检查用户状态；已启用两步验证时只返回两步验证凭证，否则创建会话
+func (s *UserService) finishLogin(user *models.User, totpEnabled bool, ip, userAgent string) (*models.LoginResponse, error) {
+	// 检查用户状态
+	if user.Status == 0 {
+		return nil, ErrUserDisabled
+	}
 
-			// 验证密码是否符合要求，如果不符合则使用默认密码
-			if err := s.ValidatePassword(passwordToUse); err != nil {
-				log.Printf("用户提供的密码不符合要求: %v，使用默认密码\n", err)
-				passwordToUse = defaultPassword
-			}
+	if totpEnabled {
+		challenge, err := s.jwtConfig.GenerateChallengeToken(user.ID, user.Username, user.Role,
+			middleware.ScopeTwoFactor, twoFactorChallengeTTL)
+		if err != nil {
+			return nil, err
+		}
+		return &models.LoginResponse{
+			User:              userResponse(user),
+			TwoFactorRequired: true,
+			TwoFactorToken:    challenge,
+		}, nil
+	}
 
-			// 哈希密码
-			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(passwordToUse), bcrypt.DefaultCost)
-			if err != nil {
-				return nil, fmt.Errorf("密码加密失败: %w", err)
-			}
+	return s.completeLogin(user, totpEnabled, ip, userAgent)
+}
 
-			log.Println("密码哈希成功，开始插入admin用户...")
+// LoginTwoFactor 登录第二步：校验两步验证码或恢复码后创建会话
+func (s *UserService) LoginTwoFactor(userID int, code, ip, userAgent string) (*models.LoginResponse, error) {
+	user, totpEnabled, err := s.findLoginUser("id", userID)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if user.Status == 0 {
+		return nil, ErrUserDisabled
+	}
+	if !totpEnabled {
+		return nil, errors.New("未启用两步验证，请重新登录")
+	}
 
-			// 插入admin用户 - 使用IGNORE避免唯一键冲突
-			result, err := db.DB.Exec(
-				`INSERT IGNORE INTO users (username, password_hash, name, role, status) VALUES (?, ?, ?, ?, ?)`,
-				"admin", string(hashedPassword), "系统管理员", "admin", 1,
-			)
-			if err != nil {
-				log.Printf("插入admin用户失败: %v\n", err)
-				return nil, fmt.Errorf("创建管理员用户失败: %w", err)
-			}
+	if err := s.twoFactor.Verify(user.ID, code); err != nil {
+		return nil, err
+	}
 
-			rowsAffected, _ := result.RowsAffected()
-			log.Printf("插入admin用户影响行数: %d\n", rowsAffected)
-
-			// 再次查询admin用户 - 使用带COALESCE的查询
-			log.Println("再次查询admin用户...")
-			adminQuery := `
-				SELECT id, username, password_hash, name, COALESCE(gender, '男'), COALESCE(email, ''), role, 
-				       COALESCE(phone, ''), COALESCE(id_card, ''), 
-				       COALESCE(department, ''), COALESCE(job_title, ''), 
-				       COALESCE(avatar, ''), status, created_at, updated_at
-				FROM users WHERE username = ?
-			`
-			err = db.DB.QueryRow(adminQuery, "admin").Scan(
-				&user.ID, &user.Username, &user.PasswordHash, &user.Name, &user.Gender, &user.Email, &user.Role,
-				&user.Phone, &user.IDCard, &user.Department, &user.JobTitle, &user.Avatar, &user.Status,
-				&user.CreatedAt, &user.UpdatedAt,
-			)
-			if err != nil {
-				log.Printf("查询admin用户失败: %v\n", err)
-				return nil, fmt.Errorf("查询新用户失败: %w", err)
-			}
+	return s.completeLogin(user, totpEnabled, ip, userAgent)
+}
 
-			log.Println("admin用户创建成功")
-		} else {
-			return nil, errors.New("用户名或密码错误")
+// authenticate 验证密码：本地账号比对密码哈希，外部账号交给对应的认证方式并同步目录中的信息
+func (s *UserService) authenticate(user *models.User, password string) error {
+	if user.AuthSource == models.AuthSourceLocal {
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+			return ErrInvalidCredentials
 		}
+		return nil
 	}
 
-	// 检查用户状态
-	if user.Status == 0 {
-		return nil, errors.New("用户已被禁用")
-	}
-
-	// 验证密码 - 增强版：确保admin用户可以使用默认密码登录
-	var passwordVerified bool
-
-	// 1. 尝试使用用户提供的密码验证
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
-	if err == nil {
-		passwordVerified = true
-	} else {
-		// 2. 如果是admin用户，尝试使用默认密码验证
-		if user.Username == "admin" {
-			defaultPassword := "Admin@123"
-			// 2.1 尝试直接验证默认密码
-			if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(defaultPassword)) == nil {
-				log.Println("使用默认密码验证admin用户成功")
-				passwordVerified = true
-			} else {
-				// 2.2 如果默认密码也不匹配，尝试重新设置admin密码为默认密码
-				log.Println("admin用户密码不匹配，尝试重新设置默认密码")
-				newHashedPassword, hashErr := bcrypt.GenerateFromPassword([]byte(defaultPassword), bcrypt.DefaultCost)
-				if hashErr == nil {
-					// 更新admin用户的密码
-					_, updateErr := db.DB.Exec(
-						"UPDATE users SET password_hash = ? WHERE username = ?",
-						string(newHashedPassword), "admin")
-					if updateErr == nil {
-						log.Println("admin用户密码已更新为默认密码")
-						passwordVerified = true
-					} else {
-						log.Printf("更新admin用户密码失败: %v\n", updateErr)
-					}
-				}
-			}
+	// 认证方式已停用时外部账号无法登录
+	provider := s.authProvider(user.AuthSource)
+	if provider == nil {
+		return ErrInvalidCredentials
+	}
+	identity, err := provider.Authenticate(user.Username, password)
+	if err != nil {
+		return err
+	}
+	return s.syncExternalUser(user, identity)
+}
+
+// authProvider 按名称查找已注册的认证方式
+func (s *UserService) authProvider(name string) AuthProvider {
+	for _, provider := range s.providers {
+		if provider.Name() == name {
+			return provider
+		}
+	}
+	return nil
+}
+
+// provisionUser 依次交给允许自动创建账号的认证方式验证，第一个验证通过的认证方式创建账号
+func (s *UserService) provisionUser(username, password string) (*models.User, bool, error) {
+	for _, provider := range s.providers {
+		if !provider.AutoProvision() {
+			continue
+		}
+		identity, err := provider.Authenticate(username, password)
+		if errors.Is(err, ErrInvalidCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, false, err
 		}
+
+		tx, err := db.DB.Begin()
+		if err != nil {
+			return nil, false, err
+		}
+		defer tx.Rollback()
+
+		userID, err := s.createExternalUser(tx, provider.Name(), identity)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, false, err
+		}
+		return s.findLoginUser("id", userID)
+	}
+	return nil, false, ErrInvalidCredentials
+}
+
+// createExternalUser 创建外部认证账号，科室按目录中的名称匹配科室表，匹配不到时不分配科室
+func (s *UserService) createExternalUser(tx *sql.Tx, source string, identity *models.ExternalIdentity) (int, error) {
+	var departmentID, departmentName interface{}
+	if dept := s.externalDepartment(identity.Department); dept != nil {
+		departmentID = dept.ID
+		departmentName = dept.Name
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO users (username, password_hash, name, role, department_id, department, job_title, auth_source)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, identity.Username, externalPasswordHash, identity.Name, s.externalRole(identity.Role),
+		departmentID, departmentName, identity.JobTitle, source)
+	if err != nil {
+		return 0, err
+	}
+
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(userID), nil
+}
+
+// syncExternalUser 登录时同步目录中的姓名、科室和职称；配置了组映射时同时同步角色。
+// 目录中的科室在科室表中不存在时保留原科室，便于管理员手动分配
+func (s *UserService) syncExternalUser(user *models.User, identity *models.ExternalIdentity) error {
+	user.Name = identity.Name
+	user.JobTitle = identity.JobTitle
+	if dept := s.externalDepartment(identity.Department); dept != nil {
+		user.DepartmentID = dept.ID
+		user.Department = dept.Name
+	}
+	if identity.RoleFromGroups {
+		user.Role = s.externalRole(identity.Role)
+	}
+
+	_, err := db.DB.Exec(`
+		UPDATE users SET name = ?, job_title = ?, department_id = ?, department = ?, role = ?
+		WHERE id = ?
+	`, user.Name, user.JobTitle, nullableID(user.DepartmentID), user.Department, user.Role, user.ID)
+	return err
+}
+
+// externalDepartment 按名称匹配科室表中的科室，匹配不到时返回nil
+func (s *UserService) externalDepartment(name string) *models.Department {
+	if strings.TrimSpace(name) == "" {
+		return nil
+	}
+	dept, err := s.departments.ResolveDepartment(0, name)
+	if err != nil {
+		return nil
+	}
+	return dept
+}
+
+// externalRole 目录映射的角色不存在时降级为员工，避免因配置错误无法登录
+func (s *UserService) externalRole(role string) string {
+	if role == "" {
+		return models.RoleEmployee
+	}
+	if ok, err := s.permissions.RoleExists(role); err != nil || !ok {
+		return models.RoleEmployee
+	}
+	return role
+}
+
+// findLoginUser 按用户名或ID查询登录所需的用户信息及是否启用两步验证
+func (s *UserService) findLoginUser(column string, value interface{}) (*models.User, bool, error) {
+	// 查询用户 - 使用COALESCE处理可能为NULL的字段
+	var user models.User
+	var totpEnabled bool
+	query := `
+		SELECT id, username, password_hash, name, COALESCE(gender, '男'), COALESCE(email, ''), role, 
+		       COALESCE(phone, ''), COALESCE(id_card, ''), COALESCE(DATE_FORMAT(birth_date, '%Y-%m-%d'), ''), COALESCE(department_id, 0),
+		       COALESCE(department, ''), COALESCE(job_title, ''), 
+		       COALESCE(avatar, ''), status, created_at, updated_at,
+		       must_change_password, COALESCE(password_changed_at, created_at), totp_enabled, auth_source
+		FROM users WHERE ` + column + ` = ?
+	`
+	err := db.DB.QueryRow(query, value).Scan(
+		&user.ID, &user.Username, &user.PasswordHash, &user.Name, &user.Gender, &user.Email, &user.Role,
+		&user.Phone, &user.IDCard, &user.BirthDate, &user.DepartmentID, &user.Department, &user.JobTitle, &user.Avatar,
+		&user.Status, &user.CreatedAt, &user.UpdatedAt, &user.MustChangePassword, &user.PasswordChangedAt, &totpEnabled,
+		&user.AuthSource,
+	)
+	if err != nil {
+		return nil, false, err
 	}
+	if err := s.openPersonalInfo(&user); err != nil {
+		return nil, false, err
+	}
+	return &user, totpEnabled, nil
+}
 
-	// 如果密码验证失败，返回错误
-	if !passwordVerified {
-		return nil, errors.New("用户名或密码错误")
+// completeLogin 身份验证通过后创建会话并生成登录响应
+func (s *UserService) completeLogin(user *models.User, totpEnabled bool, ip, userAgent string) (*models.LoginResponse, error) {
+	// 首次登录或密码过期时只签发修改密码用的受限令牌；
+	// 必须启用两步验证的角色尚未绑定时只签发绑定两步验证用的受限令牌。
+	// 外部认证账号的密码由目录服务管理，不检查密码有效期
+	passwordExpired := user.AuthSource == models.AuthSourceLocal && s.config.Password.ExpiryDays > 0 &&
+		time.Since(user.PasswordChangedAt) > time.Duration(s.config.Password.ExpiryDays)*24*time.Hour
+	scope := ""
+	if user.MustChangePassword || passwordExpired {
+		scope = middleware.ScopePasswordChange
+	} else if s.twoFactor.Required(user.Role) && !totpEnabled {
+		scope = middleware.ScopeTwoFactorSetup
 	}
 
-	// 生成JWT令牌
-	token, err := s.jwtConfig.GenerateToken(user.ID, user.Username, user.Role)
+	// 创建会话并生成访问令牌和刷新令牌
+	token, refreshToken, err := s.sessions.CreateSession(user.ID, user.Username, user.Role, scope, ip, userAgent)
 	if err != nil {
 		return nil, err
 	}
 
+	// 构建响应
+	response := &models.LoginResponse{
+		User:                   userResponse(user),
+		Token:                  token,
+		RefreshToken:           refreshToken,
+		MustChangePassword:     scope == middleware.ScopePasswordChange,
+		PasswordExpired:        passwordExpired,
+		TwoFactorSetupRequired: scope == middleware.ScopeTwoFactorSetup,
+	}
+
+	return response, nil
+}
+
+// userResponse 生成登录响应中的用户信息，手机号和身份证号已脱敏
+func userResponse(user *models.User) models.UserResponse {
 	// 设置默认头像（如果为空）
 	avatar := user.Avatar
 	if avatar == "" {
@@ -292,27 +546,27 @@ func (s *UserService) LoginUser(req *models.UserLoginRequest) (*models.LoginResp
 		}
 	}
 
-	// 构建响应
-	response := &models.LoginResponse{
-		User: models.UserResponse{
-			ID:         user.ID,
-			Username:   user.Username,
-			Name:       user.Name,
-			Gender:     user.Gender,
-			Email:      user.Email,
-			Role:       user.Role,
-			Phone:      user.Phone,
-			IDCard:     user.IDCard,
-			Department: user.Department,
-			JobTitle:   user.JobTitle,
-			Avatar:     avatar,
-			Status:     user.Status,
-			CreatedAt:  user.CreatedAt,
-		},
-		Token: token,
+	resp := models.UserResponse{
+		ID:           user.ID,
+		Username:     user.Username,
+		Name:         user.Name,
+		Gender:       user.Gender,
+		Email:        user.Email,
+		Role:         user.Role,
+		Phone:        user.Phone,
+		IDCard:       user.IDCard,
+		BirthDate:    user.BirthDate,
+		DepartmentID: user.DepartmentID,
+		Department:   user.Department,
+		JobTitle:     user.JobTitle,
+		Avatar:       avatar,
+		Status:       user.Status,
+		AuthSource:   user.AuthSource,
+		CreatedAt:    user.CreatedAt,
 	}
-
-	return response, nil
+	// 登录响应只用于显示当前用户，手机号和身份证号始终脱敏
+	resp.MaskPersonalInfo()
+	return resp
 }
 
 // GetUserByID 根据ID获取用户信息
@@ -320,18 +574,21 @@ func (s *UserService) GetUserByID(userID int) (*models.User, error) {
 	var user models.User
 	err := db.DB.QueryRow(`
 		SELECT id, username, name, COALESCE(gender, '男'), COALESCE(email, ''), role, 
-		       COALESCE(phone, ''), COALESCE(id_card, ''), 
+		       COALESCE(phone, ''), COALESCE(id_card, ''), COALESCE(DATE_FORMAT(birth_date, '%Y-%m-%d'), ''), COALESCE(department_id, 0),
 		       COALESCE(department, ''), COALESCE(job_title, ''), 
-		       COALESCE(avatar, ''), status, created_at, updated_at
+		       COALESCE(avatar, ''), status, auth_source, created_at, updated_at
 		FROM users WHERE id = ?
 	`, userID).Scan(
 		&user.ID, &user.Username, &user.Name, &user.Gender, &user.Email, &user.Role,
-		&user.Phone, &user.IDCard, &user.Department, &user.JobTitle, &user.Avatar,
-		&user.Status, &user.CreatedAt, &user.UpdatedAt,
+		&user.Phone, &user.IDCard, &user.BirthDate, &user.DepartmentID, &user.Department, &user.JobTitle, &user.Avatar,
+		&user.Status, &user.AuthSource, &user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.openPersonalInfo(&user); err != nil {
+		return nil, err
+	}
 
 	// 设置默认头像（如果为空）
 	if user.Avatar == "" {
@@ -345,8 +602,60 @@ func (s *UserService) GetUserByID(userID int) (*models.User, error) {
 	return &user, nil
 }
 
-// UpdateUser 更新用户信息
-func (s *UserService) UpdateUser(userID int, req *models.UserUpdateRequest) (*models.User, error) {
+// GetUserByUsername 根据用户名获取用户信息
+func (s *UserService) GetUserByUsername(username string) (*models.User, error) {
+	var user models.User
+	err := db.DB.QueryRow("SELECT "+userListColumns+" FROM users WHERE username = ?", username).Scan(
+		&user.ID, &user.Username, &user.Name, &user.Gender, &user.Email, &user.Role,
+		&user.Phone, &user.IDCard, &user.BirthDate, &user.DepartmentID, &user.Department, &user.JobTitle, &user.Avatar,
+		&user.Status, &user.AuthSource, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.openPersonalInfo(&user); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// UpdateUser 更新本人的用户信息，头像通过AvatarService上传。
+// 科室决定数据范围，本人不能修改，只能由管理员在管理范围内调整
+func (s *UserService) UpdateUser(op Operator, req *models.UserUpdateRequest) (*models.User, error) {
+	before, err := s.GetUserByID(op.UserID)
+	if err != nil {
+		return nil, errors.New("用户不存在")
+	}
+	self := *req
+	self.DepartmentID, self.Department = 0, ""
+	return s.updateUser(op, before, &self)
+}
+
+// updateUser 更新用户信息并记录审计日志，before为修改前的用户信息
+func (s *UserService) updateUser(op Operator, before *models.User, req *models.UserUpdateRequest) (*models.User, error) {
+	userID := before.ID
+
+	// 科室必须是科室表中已存在的科室，科室名称以科室表为准
+	var departmentID, departmentName interface{}
+	if req.DepartmentID > 0 || strings.TrimSpace(req.Department) != "" {
+		dept, err := s.departments.ResolveDepartment(req.DepartmentID, req.Department)
+		if err != nil {
+			return nil, err
+		}
+		departmentID = dept.ID
+		departmentName = dept.Name
+	}
+
+	// 校验手机号和身份证号，性别和出生日期以身份证号为准
+	info, err := parsePersonalInfo(req.Phone, req.IDCard, req.Gender)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sealPersonalInfo(userID, info); err != nil {
+		return nil, err
+	}
+
 	// 构建更新语句
 	updateSQL := `
 		UPDATE users SET
@@ -354,23 +663,32 @@ func (s *UserService) UpdateUser(userID int, req *models.UserUpdateRequest) (*mo
 		gender = COALESCE(?, gender),
 		email = COALESCE(?, email),
 		phone = COALESCE(?, phone),
+		phone_hash = IF(?, ?, phone_hash),
 		id_card = COALESCE(?, id_card),
+		id_card_hash = IF(?, ?, id_card_hash),
+		birth_date = IF(?, ?, birth_date),
+		department_id = COALESCE(?, department_id),
 		department = COALESCE(?, department),
-		job_title = COALESCE(?, job_title),
-		avatar = COALESCE(?, avatar)
+		job_title = COALESCE(?, job_title)
 		WHERE id = ?
 	`
 
 	// 执行更新
-	_, err := db.DB.Exec(updateSQL,
+	_, err = db.DB.Exec(updateSQL,
 		req.Name,
-		req.Gender,
+		info.gender,
 		req.Email,
-		req.Phone,
-		req.IDCard,
-		req.Department,
+		info.phone,
+		info.phoneChanged,
+		info.phoneIndex,
+		info.idCard,
+		info.idCardChanged,
+		info.idCardIndex,
+		info.idCardChanged,
+		info.birthDate,
+		departmentID,
+		departmentName,
 		req.JobTitle,
-		req.Avatar,
 		userID,
 	)
 	if err != nil {
@@ -378,22 +696,38 @@ func (s *UserService) UpdateUser(userID int, req *models.UserUpdateRequest) (*mo
 	}
 
 	// 获取更新后的用户信息
-	return s.GetUserByID(userID)
+	after, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	logAudit(op, auditEvent{
+		Action:     models.AuditUserUpdate,
+		EntityType: models.AuditEntityUser,
+		EntityID:   userID,
+		Before:     userAuditFields(before),
+		After:      userAuditFields(after),
+	})
+	return after, nil
 }
 
-// ChangePassword 修改用户密码
-func (s *UserService) ChangePassword(userID int, currentPassword, newPassword string) error {
-	// 验证新密码是否符合要求
-	if err := s.ValidatePassword(newPassword); err != nil {
-		return err
-	}
+// ChangePassword 修改本人的密码
+func (s *UserService) ChangePassword(op Operator, currentPassword, newPassword string) error {
+	userID := op.UserID
 
 	// 获取用户当前密码哈希
-	var currentHash string
-	err := db.DB.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&currentHash)
+	var currentHash, authSource string
+	err := db.DB.QueryRow("SELECT password_hash, auth_source FROM users WHERE id = ?", userID).Scan(&currentHash, &authSource)
 	if err != nil {
 		return err
 	}
+	if authSource != models.AuthSourceLocal {
+		return ErrExternalPassword
+	}
+
+	// 验证新密码是否符合要求
+	if err := s.ValidatePassword(newPassword); err != nil {
+		return err
+	}
 
 	// 验证当前密码是否正确
 	err = bcrypt.CompareHashAndPassword([]byte(currentHash), []byte(currentPassword))
@@ -401,6 +735,11 @@ func (s *UserService) ChangePassword(userID int, currentPassword, newPassword st
 		return errors.New("当前密码错误")
 	}
 
+	// 检查是否重复使用最近用过的密码
+	if err := s.checkPasswordReuse(userID, currentHash, newPassword); err != nil {
+		return err
+	}
+
 	// 加密新密码
 	newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
 	if err != nil {
@@ -408,10 +747,628 @@ func (s *UserService) ChangePassword(userID int, currentPassword, newPassword st
 	}
 
 	// 更新密码
-	_, err = db.DB.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(newHashedPassword), userID)
+	if err := s.setPassword(userID, currentHash, string(newHashedPassword), false); err != nil {
+		return err
+	}
+	logAudit(op, auditEvent{Action: models.AuditUserPasswordChange, EntityType: models.AuditEntityUser, EntityID: userID})
+	return nil
+}
+
+// checkPasswordReuse 检查新密码是否与当前密码或最近使用过的密码相同
+func (s *UserService) checkPasswordReuse(userID int, currentHash, newPassword string) error {
+	if bcrypt.CompareHashAndPassword([]byte(currentHash), []byte(newPassword)) == nil {
+		return errors.New("新密码不能与当前密码相同")
+	}
+
+	if s.config.Password.HistoryCount <= 0 {
+		return nil
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT password_hash FROM password_history
+		WHERE user_id = ? ORDER BY id DESC LIMIT ?
+	`, userID, s.config.Password.HistoryCount)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return err
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(newPassword)) == nil {
+			return fmt.Errorf("新密码不能与最近%d次使用过的密码相同", s.config.Password.HistoryCount)
+		}
+	}
+
+	return rows.Err()
+}
+
+// setPassword 更新密码哈希并记录历史密码，mustChange为true时用户下次登录必须修改密码
+func (s *UserService) setPassword(userID int, oldHash, newHash string, mustChange bool) error {
+	tx, err := db.DB.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE users SET password_hash = ?, must_change_password = ?, password_changed_at = NOW()
+		WHERE id = ?
+	`, newHash, mustChange, userID)
+	if err != nil {
+		return err
+	}
+
+	if oldHash != "" && s.config.Password.HistoryCount > 0 {
+		_, err = tx.Exec("INSERT INTO password_history (user_id, password_hash) VALUES (?, ?)", userID, oldHash)
+		if err != nil {
+			return err
+		}
 
+		// 只保留最近的历史密码
+		_, err = tx.Exec(`
+			DELETE FROM password_history WHERE user_id = ? AND id NOT IN (
+				SELECT id FROM (
+					SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?
+				) AS recent
+			)
+		`, userID, userID, s.config.Password.HistoryCount)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GenerateInitialPassword 生成符合密码策略的随机初始密码
+func (s *UserService) GenerateInitialPassword() (string, error) {
+	// 去掉容易混淆的字符，便于口头或纸面告知
+	const (
+		letters  = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKMNPQRSTUVWXYZ"
+		digits   = "23456789"
+		specials = "@#$%&*"
+	)
+
+	length := s.config.Password.MinLength
+	if length < 10 {
+		length = 10
+	}
+
+	pick := func(charset string) (byte, error) {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return 0, err
+		}
+		return charset[n.Int64()], nil
+	}
+
+	// 每类字符至少一个，其余从字母和数字中随机选取
+	sets := []string{letters, digits, specials}
+	password := make([]byte, 0, length)
+	for _, set := range sets {
+		c, err := pick(set)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	for len(password) < length {
+		c, err := pick(letters + digits)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+
+	// 打乱字符顺序
+	for i := len(password) - 1; i > 0; i-- {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		j := n.Int64()
+		password[i], password[j] = password[j], password[i]
+	}
+
+	return string(password), nil
+}
+
+// CreateFirstAdmin 创建第一个站长账号，系统中已有站长时拒绝执行。
+// 只能通过服务器上的命令行调用，密码由运维人员指定。
+func (s *UserService) CreateFirstAdmin(username, name, password string) error {
+	if !usernamePattern.MatchString(username) {
+		return errors.New("用户名只能包含字母和数字，长度3-20位")
+	}
+	if err := s.ValidatePassword(password); err != nil {
+		return err
+	}
+
+	var admins int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'admin'").Scan(&admins); err != nil {
+		return err
+	}
+	if admins > 0 {
+		return errors.New("系统中已存在站长账号，如需找回密码请使用 reset-admin-password 命令")
+	}
+
+	var count int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("用户名已存在")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.DB.Exec(`
+		INSERT INTO users (username, password_hash, name, role, status, must_change_password)
+		VALUES (?, ?, ?, 'admin', 1, 0)
+	`, username, string(hashedPassword), name)
+	if err != nil {
+		return err
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	logAudit(systemOperator, auditEvent{
+		Action:     models.AuditUserCreate,
+		EntityType: models.AuditEntityUser,
+		EntityID:   int(userID),
+		After:      map[string]interface{}{"username": username, "name": name, "role": models.RoleAdmin},
+	})
+	return nil
+}
+
+// RecoverAdminPassword 重置站长密码并重新启用、解锁账号，用于站长遗忘密码时在服务器上找回
+func (s *UserService) RecoverAdminPassword(username, password string) error {
+	if err := s.ValidatePassword(password); err != nil {
+		return err
+	}
+
+	var userID int
+	var role, currentHash string
+	err := db.DB.QueryRow("SELECT id, role, password_hash FROM users WHERE username = ?", username).
+		Scan(&userID, &role, &currentHash)
+	if err != nil {
+		return fmt.Errorf("用户 %s 不存在", username)
+	}
+	if role != "admin" {
+		return fmt.Errorf("用户 %s 不是站长账号", username)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if err := s.setPassword(userID, currentHash, string(hashedPassword), false); err != nil {
+		return err
+	}
+
+	_, err = db.DB.Exec("UPDATE users SET status = 1, failed_login_count = 0, locked_until = NULL WHERE id = ?", userID)
+	if err != nil {
+		return err
+	}
+	logAudit(systemOperator, auditEvent{Action: models.AuditUserPasswordReset, EntityType: models.AuditEntityUser, EntityID: userID})
+
+	_, err = s.sessions.RevokeUserSessions(userID, "")
+	return err
+}
+
+// Operator 执行操作的当前用户，Username和IP记入审计日志
+type Operator struct {
+	UserID      int
+	Username    string
+	Role        string
+	Permissions []string
+	IP          string
+}
+
+// canManage 判断操作者能否管理目标用户：只有站长能管理站长账号；
+// 站长以外的操作者不能管理权限超出自己的用户，否则可以重置其密码后以其身份登录提权
+func (s *UserService) canManage(op Operator, target *models.User) error {
+	if op.Role == models.RoleAdmin {
+		return nil
+	}
+	if target.Role == models.RoleAdmin {
+		return errors.New("无权管理站长账号")
+	}
+
+	granted, err := s.permissions.RolePermissions(target.Role)
+	if err != nil {
+		return err
+	}
+	if !op.hasAllPermissions(granted) {
+		return errors.New("无权管理权限超出自己的用户")
+	}
 	return nil
 }
+
+// hasPermission 判断操作者是否拥有指定权限
+func (o Operator) hasPermission(permission string) bool {
+	for _, p := range o.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAllPermissions 判断操作者是否拥有全部指定权限
+func (o Operator) hasAllPermissions(permissions []string) bool {
+	for _, permission := range permissions {
+		if !o.hasPermission(permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAssignableRole 检查操作者能否把用户设置为该角色：角色必须存在；
+// 站长以外的操作者需要分配角色权限，且不能分配站长角色或权限超出自己的角色
+func (s *UserService) checkAssignableRole(op Operator, role string) error {
+	exists, err := s.permissions.RoleExists(role)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return errors.New("角色不存在")
+	}
+	if op.Role == models.RoleAdmin {
+		return nil
+	}
+
+	if role == models.RoleAdmin {
+		return errors.New("无权将用户设置为站长")
+	}
+	if role != models.RoleEmployee && !op.hasPermission(models.PermRoleAssign) {
+		return errors.New("无权分配角色")
+	}
+
+	granted, err := s.permissions.RolePermissions(role)
+	if err != nil {
+		return err
+	}
+	if !op.hasAllPermissions(granted) {
+		return errors.New("不能分配权限超出自己的角色")
+	}
+	return nil
+}
+
+const userListColumns = `id, username, name, COALESCE(gender, '男'), COALESCE(email, ''), role,
+	COALESCE(phone, ''), COALESCE(id_card, ''), COALESCE(DATE_FORMAT(birth_date, '%Y-%m-%d'), ''), COALESCE(department_id, 0),
+	COALESCE(department, ''), COALESCE(job_title, ''),
+	COALESCE(avatar, ''), status, auth_source, created_at, updated_at`
+
+// ListUsers 分页获取用户列表，支持按关键字、科室（包含下级科室）、角色和状态筛选
+func (s *UserService) ListUsers(filter *models.UserListFilter) ([]models.User, int, error) {
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize < 1 || filter.PageSize > 100 {
+		filter.PageSize = 20
+	}
+
+	where := " WHERE 1 = 1"
+	args := []interface{}{}
+	if keyword := strings.TrimSpace(filter.Keyword); keyword != "" {
+		// 手机号和身份证号已加密，只能按完整号码的盲索引精确匹配
+		like := "%" + keyword + "%"
+		where += " AND (username LIKE ? OR name LIKE ?"
+		args = append(args, like, like)
+		if column, index := s.loginIndex(keyword); column != "" {
+			where += " OR " + column + " = ?"
+			args = append(args, index)
+		}
+		where += ")"
+	}
+	if filter.DepartmentID > 0 {
+		subtree := SubtreeCondition("department_id", filter.DepartmentID)
+		where += " AND " + subtree.SQL
+		args = append(args, subtree.Args...)
+	}
+	if filter.Role != "" {
+		where += " AND role = ?"
+		args = append(args, filter.Role)
+	}
+	if filter.Status != nil {
+		where += " AND status = ?"
+		args = append(args, *filter.Status)
+	}
+
+	// 获取总记录数
+	var total int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// 获取用户列表
+	offset := (filter.Page - 1) * filter.PageSize
+	rows, err := db.DB.Query("SELECT "+userListColumns+" FROM users"+where+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, filter.PageSize, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(
+			&user.ID, &user.Username, &user.Name, &user.Gender, &user.Email, &user.Role,
+			&user.Phone, &user.IDCard, &user.BirthDate, &user.DepartmentID, &user.Department, &user.JobTitle, &user.Avatar,
+			&user.Status, &user.AuthSource, &user.CreatedAt, &user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := s.openPersonalInfo(&user); err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// CreateUser 管理员代员工创建账号
+func (s *UserService) CreateUser(op Operator, req *models.AdminUserCreateRequest) (*models.User, error) {
+	role := req.Role
+	if role == "" {
+		role = models.RoleEmployee
+	}
+	if err := s.checkAssignableRole(op, role); err != nil {
+		return nil, err
+	}
+
+	if err := s.ValidatePassword(req.Password); err != nil {
+		return nil, err
+	}
+	if err := s.ValidateName(req.Name); err != nil {
+		return nil, err
+	}
+	if looksLikePhone(req.Username) || looksLikeIDCard(req.Username) {
+		return nil, ErrUsernameIsNumber
+	}
+
+	// 检查用户名是否已存在
+	var count int
+	err := db.DB.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", req.Username).Scan(&count)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, errors.New("用户名已存在")
+	}
+
+	var departmentID, departmentName interface{}
+	if req.DepartmentID > 0 || strings.TrimSpace(req.Department) != "" {
+		dept, err := s.departments.ResolveDepartment(req.DepartmentID, req.Department)
+		if err != nil {
+			return nil, err
+		}
+		departmentID = dept.ID
+		departmentName = dept.Name
+	}
+
+	info, err := parsePersonalInfo(req.Phone, req.IDCard, req.Gender)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sealPersonalInfo(0, info); err != nil {
+		return nil, err
+	}
+	gender := info.gender
+	if gender == nil {
+		gender = "男"
+	}
+
+	// 加密密码
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.DB.Exec(`
+		INSERT INTO users (username, password_hash, name, gender, email, role, phone, phone_hash, id_card, id_card_hash,
+		                   birth_date, department_id, department, job_title, must_change_password)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1)
+	`, req.Username, string(hashedPassword), req.Name, gender, req.Email, role, info.phone, info.phoneIndex,
+		info.idCard, info.idCardIndex, info.birthDate, departmentID, departmentName, req.JobTitle)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.GetUserByID(int(userID))
+	if err != nil {
+		return nil, err
+	}
+	logAudit(op, auditEvent{
+		Action:     models.AuditUserCreate,
+		EntityType: models.AuditEntityUser,
+		EntityID:   user.ID,
+		After:      userAuditFields(user),
+	})
+	return user, nil
+}
+
+// AdminUpdateUser 管理员编辑用户资料
+func (s *UserService) AdminUpdateUser(op Operator, userID int, req *models.UserUpdateRequest) (*models.User, error) {
+	target, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, errors.New("用户不存在")
+	}
+	if err := s.canManage(op, target); err != nil {
+		return nil, err
+	}
+
+	return s.updateUser(op, target, req)
+}
+
+// SetUserStatus 启用或禁用用户
+func (s *UserService) SetUserStatus(op Operator, userID, status int) error {
+	target, err := s.GetUserByID(userID)
+	if err != nil {
+		return errors.New("用户不存在")
+	}
+	if err := s.canManage(op, target); err != nil {
+		return err
+	}
+	if userID == op.UserID && status == 0 {
+		return errors.New("不能禁用自己的账号")
+	}
+
+	_, err = db.DB.Exec("UPDATE users SET status = ? WHERE id = ?", status, userID)
+	if err != nil {
+		return err
+	}
+	logAudit(op, auditEvent{
+		Action:     models.AuditUserStatus,
+		EntityType: models.AuditEntityUser,
+		EntityID:   userID,
+		Before:     map[string]interface{}{"status": target.Status},
+		After:      map[string]interface{}{"status": status},
+	})
+
+	if status == 0 {
+		_, err = s.sessions.RevokeUserSessions(userID, "")
+	}
+	return err
+}
+
+// UnlockUser 解除因登录失败过多导致的账号锁定
+func (s *UserService) UnlockUser(op Operator, userID int) error {
+	target, err := s.GetUserByID(userID)
+	if err != nil {
+		return errors.New("用户不存在")
+	}
+	if err := s.canManage(op, target); err != nil {
+		return err
+	}
+
+	_, err = db.DB.Exec("UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = ?", userID)
+	if err != nil {
+		return err
+	}
+	logAudit(op, auditEvent{Action: models.AuditUserUnlock, EntityType: models.AuditEntityUser, EntityID: userID})
+	return nil
+}
+
+// ResetTwoFactor 重置用户的两步验证，用于员工更换或丢失手机且恢复码也已用完的情况
+func (s *UserService) ResetTwoFactor(op Operator, userID int) error {
+	target, err := s.GetUserByID(userID)
+	if err != nil {
+		return errors.New("用户不存在")
+	}
+	if err := s.canManage(op, target); err != nil {
+		return err
+	}
+
+	if err := s.twoFactor.Reset(userID); err != nil {
+		return err
+	}
+	logAudit(op, auditEvent{Action: models.AuditUserTwoFactorReset, EntityType: models.AuditEntityUser, EntityID: userID})
+	return nil
+}
+
+// RevokeUserSessions 注销用户的全部会话，强制其重新登录
+func (s *UserService) RevokeUserSessions(op Operator, userID int) error {
+	target, err := s.GetUserByID(userID)
+	if err != nil {
+		return errors.New("用户不存在")
+	}
+	if err := s.canManage(op, target); err != nil {
+		return err
+	}
+
+	if _, err := s.sessions.RevokeUserSessions(userID, ""); err != nil {
+		return err
+	}
+	logAudit(op, auditEvent{Action: models.AuditUserSessionsRevoke, EntityType: models.AuditEntityUser, EntityID: userID})
+	return nil
+}
+
+// ResetPassword 管理员重置用户密码
+func (s *UserService) ResetPassword(op Operator, userID int, newPassword string) error {
+	target, err := s.GetUserByID(userID)
+	if err != nil {
+		return errors.New("用户不存在")
+	}
+	if err := s.canManage(op, target); err != nil {
+		return err
+	}
+	if target.AuthSource != models.AuthSourceLocal {
+		return ErrExternalPassword
+	}
+
+	if err := s.ValidatePassword(newPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	// 重置后的密码由管理员告知，用户下次登录必须修改
+	if err := s.setPassword(userID, "", string(hashedPassword), true); err != nil {
+		return err
+	}
+	logAudit(op, auditEvent{Action: models.AuditUserPasswordReset, EntityType: models.AuditEntityUser, EntityID: userID})
+
+	_, err = s.sessions.RevokeUserSessions(userID, "")
+	return err
+}
+
+// ChangeUserRole 修改用户角色，管理员不能将用户提升为站长
+func (s *UserService) ChangeUserRole(op Operator, userID int, role string) error {
+	target, err := s.GetUserByID(userID)
+	if err != nil {
+		return errors.New("用户不存在")
+	}
+	if err := s.canManage(op, target); err != nil {
+		return err
+	}
+	if err := s.checkAssignableRole(op, role); err != nil {
+		return err
+	}
+	if userID == op.UserID {
+		return errors.New("不能修改自己的角色")
+	}
+
+	_, err = db.DB.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return err
+	}
+	logAudit(op, auditEvent{
+		Action:     models.AuditUserRole,
+		EntityType: models.AuditEntityUser,
+		EntityID:   userID,
+		Before:     map[string]interface{}{"role": target.Role},
+		After:      map[string]interface{}{"role": role},
+	})
+
+	// 已签发的令牌携带旧角色，注销后需要重新登录
+	_, err = s.sessions.RevokeUserSessions(userID, "")
+	return err
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/hangbin2008/sanjicms/internal/models"
+	"github.com/hangbin2008/sanjicms/pkg/config"
+)
+
+var (
+	userByID        = regexp.QuoteMeta("FROM users WHERE id = ?")
+	rolePermissions = regexp.QuoteMeta("SELECT permission_code FROM role_permissions WHERE role_code = ?")
+)
+
+func permissionRows(codes ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"permission_code"})
+	for _, code := range codes {
+		rows.AddRow(code)
+	}
+	return rows
+}
+
+// 管理员不能管理权限超出自己的用户：重置密码后以其身份登录即可提权
+func TestManagerCannotManageMorePrivilegedUser(t *testing.T) {
+	mock := mockDB(t)
+	users := NewUserService(&config.Config{Password: config.PasswordConfig{MinLength: 8}}, nil)
+	op := Operator{
+		UserID: 2, Username: "manager", Role: models.RoleManager,
+		Permissions: []string{models.PermUserManage, models.PermQuestionEdit},
+	}
+	auditor := models.User{ID: 8, Username: "lisi", Name: "李四", Gender: "女", Role: "auditor"}
+	denied := func(err error) bool {
+		return err != nil && strings.Contains(err.Error(), "权限超出")
+	}
+	expectTarget := func() {
+		mock.ExpectQuery(userByID).WithArgs(8).WillReturnRows(listUserRows(auditor))
+		mock.ExpectQuery(rolePermissions).WithArgs("auditor").
+			WillReturnRows(permissionRows(models.PermSecurityAudit, models.PermUserManage))
+	}
+
+	expectTarget()
+	if err := users.ResetPassword(op, 8, "Passw0rd!2026"); !denied(err) {
+		t.Fatal("重置权限更高的用户的密码应被拒绝")
+	}
+	expectTarget()
+	if err := users.SetUserStatus(op, 8, 0); !denied(err) {
+		t.Fatal("禁用权限更高的用户应被拒绝")
+	}
+	expectTarget()
+	if _, err := users.AdminUpdateUser(op, 8, &models.UserUpdateRequest{Name: "李四"}); !denied(err) {
+		t.Fatal("编辑权限更高的用户应被拒绝")
+	}
+	expectTarget()
+	if err := users.RevokeUserSessions(op, 8); !denied(err) {
+		t.Fatal("注销权限更高的用户的会话应被拒绝")
+	}
+
+	// 权限不超出自己的用户可以管理
+	employee := models.User{ID: 9, Username: "wangwu", Name: "王五", Gender: "男"}
+	mock.ExpectQuery(userByID).WithArgs(9).WillReturnRows(listUserRows(employee))
+	mock.ExpectQuery(rolePermissions).WithArgs(models.RoleEmployee).WillReturnRows(permissionRows())
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = ?")).
+		WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs")).WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := users.UnlockUser(op, 9); err != nil {
+		t.Fatal(err)
+	}
+
+	// 站长可以管理任何用户，不查询角色权限
+	admin := Operator{UserID: 1, Username: "admin", Role: models.RoleAdmin}
+	mock.ExpectQuery(userByID).WithArgs(8).WillReturnRows(listUserRows(auditor))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET failed_login_count = 0")).
+		WithArgs(8).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_logs")).WillReturnResult(sqlmock.NewResult(2, 1))
+	if err := users.UnlockUser(admin, 8); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,24 @@
+-- 创建合格证书表
+-- 证书内容在签发时固化，重复打印时生成完全相同的文档
+CREATE TABLE IF NOT EXISTS certificates (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    record_id INT NOT NULL UNIQUE,
+    user_id INT NOT NULL,
+    exam_id INT NOT NULL,
+    code VARCHAR(32) NOT NULL UNIQUE,
+    user_name VARCHAR(50) NOT NULL,
+    department VARCHAR(100),
+    exam_title VARCHAR(100) NOT NULL,
+    score FLOAT NOT NULL,
+    total_score FLOAT NOT NULL,
+    issued_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_certificates_user (user_id),
+    FOREIGN KEY (record_id) REFERENCES exam_records(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (exam_id) REFERENCES exams(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 注释：
+-- code: 证书验证码，用于公开验证证书真伪
+-- user_name/department/exam_title/score/total_score: 签发时的快照
@@ -0,0 +1,46 @@
+-- 继续医学教育（CME）学分
+-- 试卷可配置学分，考试及格后计入学分
+ALTER TABLE exams ADD COLUMN cme_credits FLOAT NOT NULL DEFAULT 0;
+
+-- 创建练习目标表，年度练习题量达标后计入学分
+CREATE TABLE IF NOT EXISTS practice_goals (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    title VARCHAR(100) NOT NULL,
+    year INT NOT NULL,
+    required_questions INT NOT NULL,
+    credits FLOAT NOT NULL DEFAULT 0,
+    created_by INT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_practice_goals_year (year),
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 创建年度学分要求表，按职称配置，job_title为空表示默认要求
+CREATE TABLE IF NOT EXISTS cme_credit_targets (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    year INT NOT NULL,
+    job_title VARCHAR(50) NOT NULL DEFAULT '',
+    required_credits FLOAT NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_cme_credit_targets (year, job_title)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 创建学分台账表，同一来源只计一次学分
+CREATE TABLE IF NOT EXISTS cme_credit_ledger (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    year INT NOT NULL,
+    source_type VARCHAR(20) NOT NULL,
+    source_id INT NOT NULL,
+    credits FLOAT NOT NULL,
+    description VARCHAR(200),
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_cme_credit_ledger_source (user_id, source_type, source_id),
+    INDEX idx_cme_credit_ledger_user_year (user_id, year),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 注释：
+-- source_type: 学分来源：exam（考试记录，source_id为考试记录ID）、practice_goal（练习目标，source_id为目标ID）
@@ -0,0 +1,34 @@
+-- 创建科室表，层级：hospital（医院）→ division（科室/大科）→ ward（病区）
+-- path为物化路径，形如 /1/5/12/，用于查询子树
+CREATE TABLE IF NOT EXISTS departments (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    parent_id INT,
+    name VARCHAR(100) NOT NULL,
+    level VARCHAR(20) NOT NULL DEFAULT 'division',
+    path VARCHAR(255) NOT NULL DEFAULT '',
+    sort_order INT NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_departments_parent_name (parent_id, name),
+    INDEX idx_departments_path (path),
+    FOREIGN KEY (parent_id) REFERENCES departments(id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 用户关联科室，users.department保留为科室名称，随科室改名同步更新
+ALTER TABLE users ADD COLUMN department_id INT;
+ALTER TABLE users ADD CONSTRAINT fk_users_department FOREIGN KEY (department_id) REFERENCES departments(id) ON DELETE SET NULL;
+
+-- 将已有的自由文本科室迁移为顶级科室，去除首尾空格后合并同名科室。
+-- 只迁移尚未关联科室的用户，已关联科室的用户以科室表为准，不能按下级科室的名称生成顶级科室
+INSERT INTO departments (name, level)
+SELECT DISTINCT TRIM(u.department), 'division' FROM users u
+WHERE TRIM(COALESCE(u.department, '')) <> ''
+  AND u.department_id IS NULL
+  AND NOT EXISTS (SELECT 1 FROM departments d WHERE d.parent_id IS NULL AND d.name = TRIM(u.department));
+
+UPDATE departments SET path = CONCAT('/', id, '/') WHERE path = '';
+
+UPDATE users u
+JOIN departments d ON d.parent_id IS NULL AND d.name = TRIM(u.department)
+SET u.department_id = d.id, u.department = d.name
+WHERE u.department_id IS NULL;
@@ -0,0 +1,19 @@
+-- 密码策略：首次登录强制修改密码、密码有效期和历史密码
+-- must_change_password: 1-下次登录必须修改密码（管理员创建、导入或重置的账号）
+-- password_changed_at: 最近一次修改密码的时间，用于计算密码是否过期
+ALTER TABLE users ADD COLUMN must_change_password TINYINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN password_changed_at DATETIME DEFAULT CURRENT_TIMESTAMP;
+
+-- 仍在使用初始密码 Admin@123 的站长账号必须修改密码
+UPDATE users SET must_change_password = 1
+WHERE username = 'admin' AND password_hash = '$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy';
+
+-- 历史密码，用于防止重复使用最近用过的密码
+CREATE TABLE IF NOT EXISTS password_history (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_password_history_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,18 @@
+-- 登录尝试记录，用于按账号和IP限制暴力破解，并供安全审查查询
+-- result: success-成功，bad_credentials-用户名或密码错误，captcha-验证码错误，
+--         disabled-账号已禁用，locked-账号已锁定，throttled-请求过于频繁
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    username VARCHAR(50) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    result VARCHAR(20) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_login_attempts_username (username, created_at),
+    INDEX idx_login_attempts_ip (ip, created_at),
+    INDEX idx_login_attempts_created (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 账号连续登录失败次数和锁定截止时间
+ALTER TABLE users ADD COLUMN failed_login_count INT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until DATETIME NULL;
@@ -0,0 +1,17 @@
+-- 登录会话：访问令牌有效期较短，到期后用刷新令牌续期，刷新令牌每次使用后轮换
+-- refresh_token_hash 为当前刷新令牌的SHA-256，previous_token_hash 为上一个刷新令牌，用于发现被盗用的旧令牌
+CREATE TABLE IF NOT EXISTS user_sessions (
+    id CHAR(32) PRIMARY KEY,
+    user_id INT NOT NULL,
+    refresh_token_hash CHAR(64) NOT NULL,
+    previous_token_hash CHAR(64) NOT NULL DEFAULT '',
+    scope VARCHAR(32) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    expires_at DATETIME NOT NULL,
+    revoked_at DATETIME NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    INDEX idx_user_sessions_user (user_id, revoked_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
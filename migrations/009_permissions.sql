@@ -0,0 +1,52 @@
+-- 权限模型：角色拥有若干权限，接口按权限而不是角色名鉴权
+-- 角色：系统内置站长（admin）、管理员（manager）、员工（employee），可自定义科室考官、护士长等角色
+CREATE TABLE IF NOT EXISTS roles (
+    code VARCHAR(20) PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    is_system TINYINT NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_roles_name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 权限
+CREATE TABLE IF NOT EXISTS permissions (
+    code VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT ''
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 角色权限
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_code VARCHAR(20) NOT NULL,
+    permission_code VARCHAR(50) NOT NULL,
+    PRIMARY KEY (role_code, permission_code),
+    FOREIGN KEY (role_code) REFERENCES roles(code) ON DELETE CASCADE,
+    FOREIGN KEY (permission_code) REFERENCES permissions(code) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+INSERT IGNORE INTO roles (code, name, description, is_system) VALUES
+('admin', '站长', '拥有全部权限', 1),
+('manager', '管理员', '管理题库、考试、人员和成绩，只能管理所属科室', 1),
+('employee', '员工', '参加考试和练习', 1);
+
+INSERT IGNORE INTO permissions (code, name, description) VALUES
+('question.edit', '题库管理', '创建和编辑题库、题目'),
+('exam.publish', '组卷发布', '生成和发布试卷'),
+('grade.review', '成绩查看', '查看和导出成绩单、考试汇总、成绩档案和统计'),
+('user.manage', '人员管理', '创建、编辑、导入人员，启用禁用、重置密码、解锁和注销会话'),
+('role.assign', '分配角色', '修改人员的角色'),
+('cme.manage', '学分管理', '设置继续医学教育学分要求和练习目标，查看学分台账'),
+('department.view', '查看科室', '查看科室列表和科室树'),
+('department.manage', '维护科室', '创建、修改和删除科室'),
+('security.audit', '安全审查', '查看登录记录和被锁定的账号'),
+('role.manage', '角色管理', '自定义角色及其权限'),
+('system.debug', '系统调试', '访问健康检查和调试接口');
+
+-- 站长拥有全部权限，新增权限时无需补充。
+-- 管理员的权限只在尚未配置时预置，不能覆盖站长在角色管理中调整过的权限
+INSERT IGNORE INTO role_permissions (role_code, permission_code)
+SELECT 'manager', p.code FROM permissions p
+WHERE p.code IN ('question.edit', 'exam.publish', 'grade.review', 'user.manage', 'role.assign', 'cme.manage', 'department.view')
+  AND NOT EXISTS (SELECT 1 FROM role_permissions rp WHERE rp.role_code = 'manager');
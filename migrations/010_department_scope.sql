@@ -0,0 +1,6 @@
+-- 题库和试卷归属科室，NULL表示全院公共题库/试卷
+-- 站长可访问全部数据；其他管理角色只能访问所属科室及其下级科室的题库、试卷、人员和成绩，全院公共题库和试卷只读
+ALTER TABLE question_banks ADD COLUMN department_id INT NULL;
+ALTER TABLE question_banks ADD CONSTRAINT fk_question_banks_department FOREIGN KEY (department_id) REFERENCES departments(id);
+ALTER TABLE exams ADD COLUMN department_id INT NULL;
+ALTER TABLE exams ADD CONSTRAINT fk_exams_department FOREIGN KEY (department_id) REFERENCES departments(id);
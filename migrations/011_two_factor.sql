@@ -0,0 +1,16 @@
+-- 两步验证（TOTP）：totp_secret为Base32密钥，绑定确认前totp_enabled为0
+-- totp_last_step记录最近一次通过验证的时间步，同一验证码不能重复使用
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64) NULL;
+ALTER TABLE users ADD COLUMN totp_enabled TINYINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- 恢复码：手机丢失时代替验证码登录，每个只能使用一次，只保存SHA-256摘要
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at DATETIME NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_user_recovery_codes_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+-- 账号的认证方式：local-本地密码，ldap-LDAP/Active Directory域账号
+-- 域账号的密码由目录服务验证，password_hash保存一个不能通过校验的占位值
+ALTER TABLE users ADD COLUMN auth_source VARCHAR(20) NOT NULL DEFAULT 'local';
@@ -0,0 +1,25 @@
+-- 外部身份：OpenID Connect等统一身份认证账号与本系统账号的绑定关系，issuer+subject唯一确定一个外部账号
+CREATE TABLE IF NOT EXISTS user_identities (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    provider VARCHAR(20) NOT NULL,
+    issuer VARCHAR(191) NOT NULL,
+    subject VARCHAR(191) NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_login_at DATETIME NULL,
+    UNIQUE KEY uk_user_identities_subject (issuer, subject),
+    INDEX idx_user_identities_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- OIDC登录过程中的临时状态，跳转到认证服务前创建，回调时使用一次后删除
+-- state_hash为state参数的SHA-256摘要；user_id不为空时表示已登录用户绑定外部身份
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state_hash CHAR(64) PRIMARY KEY,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    user_id INT NULL,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_oidc_login_states_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
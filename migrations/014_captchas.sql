@@ -0,0 +1,9 @@
+-- 验证码：多个实例共享时使用数据库存储，attempts记录已尝试的次数，过期记录在生成新验证码时清理
+CREATE TABLE IF NOT EXISTS captchas (
+    id VARCHAR(64) PRIMARY KEY,
+    answer VARCHAR(32) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_captchas_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,6 @@
+-- 出生日期：填写身份证号时从号码中读取
+ALTER TABLE users ADD COLUMN birth_date DATE NULL;
+
+-- 手机号和身份证号默认脱敏显示，拥有该权限才能查看完整号码
+INSERT IGNORE INTO permissions (code, name, description) VALUES
+('personal_info.view', '查看个人信息', '查看完整的手机号和身份证号，未授权时显示脱敏后的号码');
@@ -0,0 +1,13 @@
+-- 手机号和身份证号加密保存，密文比明文长
+ALTER TABLE users MODIFY COLUMN phone VARCHAR(255) NULL;
+ALTER TABLE users MODIFY COLUMN id_card VARCHAR(255) NULL;
+
+-- 密文每次加密结果都不同，唯一约束改为建在盲索引上
+ALTER TABLE users DROP INDEX phone;
+ALTER TABLE users DROP INDEX id_card;
+
+-- 盲索引：手机号和身份证号的HMAC-SHA256，用于唯一性检查和手机号/身份证号登录，未填写时为NULL
+ALTER TABLE users ADD COLUMN phone_hash CHAR(64) NULL;
+ALTER TABLE users ADD COLUMN id_card_hash CHAR(64) NULL;
+ALTER TABLE users ADD UNIQUE INDEX uk_users_phone_hash (phone_hash);
+ALTER TABLE users ADD UNIQUE INDEX uk_users_id_card_hash (id_card_hash);
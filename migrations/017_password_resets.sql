@@ -0,0 +1,20 @@
+-- 自助找回密码：每次发送验证码记录一行，user_id为空表示账号不存在（只用于按IP限制频率）
+-- code_hash、token_hash为验证码和重置凭证的SHA-256摘要；验证码通过后签发重置凭证，设置新密码后标记used_at
+CREATE TABLE IF NOT EXISTS password_resets (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NULL,
+    channel VARCHAR(10) NOT NULL,
+    code_hash CHAR(64) NOT NULL DEFAULT '',
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at DATETIME NOT NULL,
+    verified_at DATETIME NULL,
+    token_hash CHAR(64) NULL,
+    token_expires_at DATETIME NULL,
+    used_at DATETIME NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_password_resets_token (token_hash),
+    INDEX idx_password_resets_user (user_id, created_at),
+    INDEX idx_password_resets_ip (ip, created_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
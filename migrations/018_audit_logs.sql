@@ -0,0 +1,21 @@
+-- 审计日志：记录人员、角色、密码、题库、试卷和成绩等关键操作，供医院考试质量检查和安全审计追溯
+-- actor_id为空表示服务器命令行等系统操作；actor_name保存操作时的用户名，账号改名后仍可追溯
+-- changes为JSON：before、after分别为修改前后发生变化的字段，新建时只有after
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    actor_id INT NULL,
+    actor_name VARCHAR(50) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    action VARCHAR(50) NOT NULL,
+    entity_type VARCHAR(30) NOT NULL,
+    entity_id INT NOT NULL DEFAULT 0,
+    changes JSON NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_audit_logs_created (created_at),
+    INDEX idx_audit_logs_actor (actor_id, created_at),
+    INDEX idx_audit_logs_entity (entity_type, entity_id, created_at),
+    INDEX idx_audit_logs_action (action, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+INSERT IGNORE INTO permissions (code, name, description) VALUES
+('audit.view', '审计日志', '查询和导出人员、角色、题库、试卷和成绩等操作的审计日志');
@@ -0,0 +1,24 @@
+-- 在线考试防作弊：考生端上报切屏、失焦、复制粘贴、退出全屏等事件，同一考试记录出现多个会话时由服务器记录
+-- 试卷的违规次数阈值，0表示不启用：达到警告阈值时提醒考生，达到标记阈值时列入可疑记录，达到交卷阈值时强制交卷
+ALTER TABLE exams ADD COLUMN proctor_warn_threshold INT NOT NULL DEFAULT 1;
+ALTER TABLE exams ADD COLUMN proctor_flag_threshold INT NOT NULL DEFAULT 3;
+ALTER TABLE exams ADD COLUMN proctor_submit_threshold INT NOT NULL DEFAULT 0;
+
+-- session_id为开始考试的会话；proctor_status为normal、warned、flagged或force_submitted，只升不降
+ALTER TABLE exam_records ADD COLUMN session_id VARCHAR(64) NULL;
+ALTER TABLE exam_records ADD COLUMN violation_count INT NOT NULL DEFAULT 0;
+ALTER TABLE exam_records ADD COLUMN proctor_status VARCHAR(20) NOT NULL DEFAULT 'normal';
+ALTER TABLE exam_records ADD COLUMN flagged_at DATETIME NULL;
+ALTER TABLE exam_records ADD INDEX idx_exam_records_proctor (proctor_status, flagged_at);
+
+CREATE TABLE IF NOT EXISTS proctoring_events (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    record_id INT NOT NULL,
+    event_type VARCHAR(30) NOT NULL,
+    detail VARCHAR(200) NOT NULL DEFAULT '',
+    session_id VARCHAR(64) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_proctoring_events_record (record_id, created_at),
+    FOREIGN KEY (record_id) REFERENCES exam_records(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
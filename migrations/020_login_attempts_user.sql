@@ -0,0 +1,6 @@
+-- 登录尝试关联到账号，用户名、手机号和身份证号登录都按账号统计失败次数和锁定
+ALTER TABLE login_attempts ADD COLUMN user_id INT NULL AFTER id;
+ALTER TABLE login_attempts ADD INDEX idx_login_attempts_user (user_id, created_at);
+
+-- 已有记录按用户名关联到账号
+UPDATE login_attempts la JOIN users u ON u.username = la.username SET la.user_id = u.id WHERE la.user_id IS NULL;
@@ -0,0 +1,4 @@
+-- 初始化脚本预置的 admin 账号使用公开的默认密码 Admin@123，任何人都可以登录后修改密码接管站长账号。
+-- 仍在使用默认密码时停用该账号，由运维人员在服务器上执行 server reset-admin-password 设置密码后重新启用
+UPDATE users SET status = 0
+WHERE username = 'admin' AND password_hash = '$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy';
@@ -0,0 +1,2 @@
+-- 两步验证密钥与手机号、身份证号一样加密保存，密文比明文长
+ALTER TABLE users MODIFY COLUMN totp_secret VARCHAR(255) NULL;
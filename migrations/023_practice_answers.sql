@@ -0,0 +1,14 @@
+-- 练习作答明细，练习目标按年度内作答过的不同题目数统计，重复练习同一道题不重复计入
+-- 此前的练习记录没有作答明细，不计入练习目标
+CREATE TABLE IF NOT EXISTS practice_answers (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    record_id INT NOT NULL,
+    user_id INT NOT NULL,
+    question_id INT NOT NULL,
+    is_correct TINYINT NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_practice_answers_user (user_id, created_at, question_id),
+    FOREIGN KEY (record_id) REFERENCES practice_records(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (question_id) REFERENCES questions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
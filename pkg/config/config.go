@@ -4,18 +4,33 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+
+	"github.com/hangbin2008/sanjicms/pkg/fieldcrypt"
 )
 
 type Config struct {
-	App      AppConfig
-	Server   ServerConfig
-	Database DatabaseConfig
-	JWT      JWTConfig
-	Password PasswordConfig
+	App       AppConfig
+	Server    ServerConfig
+	Database  DatabaseConfig
+	JWT       JWTConfig
+	Password  PasswordConfig
+	Login     LoginConfig
+	TwoFactor TwoFactorConfig
+	LDAP      LDAPConfig
+	OIDC      OIDCConfig
+	Captcha   CaptchaConfig
+	Redis     RedisConfig
+	Crypto    CryptoConfig
+	Storage   StorageConfig
+	Avatar    AvatarConfig
+	Reset     PasswordResetConfig
+	Notify    NotifyConfig
 }
 
 type AppConfig struct {
-	Name string
+	Name    string
+	BaseURL string
 }
 
 type ServerConfig struct {
@@ -23,6 +38,8 @@ type ServerConfig struct {
 	Host         string
 	ReadTimeout  int
 	WriteTimeout int
+	// TrustedProxies 可信反向代理地址，只有来自这些地址的X-Forwarded-For才会被采信
+	TrustedProxies []string
 }
 
 type DatabaseConfig struct {
@@ -35,8 +52,9 @@ type DatabaseConfig struct {
 }
 
 type JWTConfig struct {
-	Secret    string
-	ExpiresIn int
+	Secret           string
+	ExpiresIn        int // 访问令牌有效期（秒）
+	RefreshExpiresIn int // 刷新令牌有效期（秒），超过该时间未使用需要重新登录
 }
 
 type PasswordConfig struct {
@@ -44,6 +62,145 @@ type PasswordConfig struct {
 	RequireLetter  bool
 	RequireDigit   bool
 	RequireSpecial bool
+	ExpiryDays     int // 密码有效期（天），0表示永不过期
+	HistoryCount   int // 不能与最近几次使用过的密码相同
+}
+
+// LoginConfig 登录防暴力破解配置
+type LoginConfig struct {
+	MaxFailures   int // 同一账号连续失败多少次后锁定
+	LockMinutes   int // 账号锁定时长（分钟）
+	IPMaxFailures int // 同一IP在统计窗口内失败多少次后暂停登录
+	WindowMinutes int // 失败次数统计窗口（分钟）
+	MaxDelay      int // 连续失败后的最长等待时间（秒）
+	// CaptchaAfterFailures 账号或IP失败多少次后登录需要验证码，0表示始终需要
+	CaptchaAfterFailures int
+}
+
+// TwoFactorConfig 两步验证配置
+type TwoFactorConfig struct {
+	Issuer        string   // 验证器应用中显示的发行方名称
+	RequiredRoles []string // 必须启用两步验证的角色，未启用的用户登录后只能先完成绑定
+}
+
+// LDAPConfig LDAP/Active Directory域账号登录配置
+type LDAPConfig struct {
+	Enabled            bool
+	URL                string // 服务器地址，例如 ldap://dc.hospital.local:389 或 ldaps://dc.hospital.local:636
+	StartTLS           bool   // 使用ldap://地址时通过StartTLS加密连接
+	InsecureSkipVerify bool   // 跳过证书校验，仅用于测试环境
+	BindDN             string // 查询用户使用的服务账号，留空表示匿名查询
+	BindPassword       string
+	BaseDN             string // 查询用户的起始位置
+	UserFilter         string // 查询用户的过滤条件，%s替换为转义后的登录用户名
+	Timeout            int    // 连接和每次请求的超时时间（秒）
+	AutoProvision      bool   // 首次登录时自动创建账号
+
+	// 目录属性映射
+	NameAttribute       string
+	DepartmentAttribute string
+	JobTitleAttribute   string
+	GroupAttribute      string
+
+	GroupRoles  []GroupRole // 组与角色的对应关系，按顺序匹配第一个所属的组
+	DefaultRole string      // 不属于任何映射组时的角色
+}
+
+// OIDCConfig OpenID Connect统一身份认证登录配置
+type OIDCConfig struct {
+	Enabled       bool
+	DisplayName   string // 登录页按钮上显示的认证服务名称
+	Issuer        string // 认证服务地址，从 {Issuer}/.well-known/openid-configuration 读取端点
+	ClientID      string
+	ClientSecret  string   // 公共客户端留空，只使用PKCE
+	RedirectURL   string   // 回调地址，需在认证服务中登记
+	Scopes        []string // 请求的范围
+	Timeout       int      // 请求认证服务的超时时间（秒）
+	AutoProvision bool     // 首次登录时自动创建账号
+
+	// 声明映射
+	UsernameClaim   string
+	NameClaim       string
+	DepartmentClaim string
+	JobTitleClaim   string
+	RoleClaim       string
+
+	ClaimRoles  []GroupRole // 角色声明的值与角色的对应关系，按顺序匹配第一个
+	DefaultRole string      // 没有匹配的声明值时的角色
+}
+
+// CaptchaConfig 验证码配置
+type CaptchaConfig struct {
+	Type        string // 验证码类型：digit（数字）、string（字母数字）、math（算术）、chinese（汉字）、audio（语音）
+	Length      int    // 字符数，算术验证码不使用
+	Store       string // 验证码存储：memory（单实例）、mysql或redis（多实例共享）
+	TTL         int    // 有效期（秒）
+	MaxEntries  int    // 内存存储最多保存的验证码数量，超出时淘汰最早生成的
+	MaxAttempts int    // 同一验证码最多可以尝试的次数，超过后作废
+}
+
+// RedisConfig Redis连接配置，验证码使用redis存储时需要
+type RedisConfig struct {
+	Addr     string // host:port
+	Password string
+	DB       int
+}
+
+// CryptoConfig 手机号、身份证号等敏感字段的加密配置。未配置加密密钥时以明文保存
+type CryptoConfig struct {
+	// Keys 加密密钥，第一个用于加密，其余只用于解密轮换前写入的数据
+	Keys []fieldcrypt.Key
+	// BlindIndexKey 盲索引密钥，用于手机号、身份证号的唯一性检查和登录查询
+	BlindIndexKey []byte
+}
+
+// StorageConfig 上传文件的存储配置
+type StorageConfig struct {
+	Driver    string // 目前只支持local（本地磁盘）
+	LocalDir  string // 本地存储目录
+	PublicURL string // 本地存储目录的访问路径前缀
+}
+
+// AvatarConfig 头像上传配置
+type AvatarConfig struct {
+	MaxSize   int // 上传文件大小上限（字节）
+	MaxPixels int // 原图像素数上限，防止解码超大图片耗尽内存
+}
+
+// PasswordResetConfig 自助找回密码配置
+type PasswordResetConfig struct {
+	CodeTTL        int // 验证码有效期（秒）
+	ResendInterval int // 同一账号两次发送验证码的最小间隔（秒）
+	MaxPerHour     int // 同一账号每小时最多发送的验证码数量
+	IPMaxPerHour   int // 同一IP每小时最多申请验证码的次数
+	MaxAttempts    int // 同一验证码最多可以尝试的次数，超过后作废
+	TokenTTL       int // 验证通过后设置新密码的有效期（秒）
+}
+
+// NotifyConfig 邮件和短信通知配置
+type NotifyConfig struct {
+	Email     string // 邮件发送方式：off（不发送）或smtp
+	SMS       string // 短信发送方式：off（不发送）或http（短信网关）
+	Signature string // 通知内容中的系统名称
+
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string // 发件人，例如 考试系统 <exam@example.com>
+	SMTPSecurity string // tls（465端口）、starttls（587端口）或none
+	SMTPTimeout  int    // 超时时间（秒）
+
+	SMSGatewayURL     string
+	SMSGatewayToken   string
+	SMSGatewayTimeout int // 超时时间（秒）
+}
+
+// GroupRole 外部组与系统角色的对应关系。
+// LDAP中Group可以是组的完整DN或组名（CN）；OIDC中Group是角色声明中的值
+type GroupRole struct {
+	Group string
+	Role  string
 }
 
 func Load() (*Config, error) {
@@ -51,12 +208,15 @@ func Load() (*Config, error) {
 
 	// App config
 	config.App.Name = getEnv("APP_NAME", "jiceng-sanji-exam")
+	// 对外访问地址，用于证书验证链接等，例如 https://exam.example.com
+	config.App.BaseURL = strings.TrimRight(getEnv("APP_BASE_URL", ""), "/")
 
 	// Server config
 	config.Server.Port = getEnvAsInt("SERVER_PORT", 8080)
 	config.Server.Host = getEnv("SERVER_HOST", "0.0.0.0")
 	config.Server.ReadTimeout = getEnvAsInt("READ_TIMEOUT", 15)
 	config.Server.WriteTimeout = getEnvAsInt("WRITE_TIMEOUT", 15)
+	config.Server.TrustedProxies = getEnvAsList("TRUSTED_PROXIES")
 
 	// Database config
 	config.Database.Host = getEnv("DB_HOST", "localhost")
@@ -73,13 +233,166 @@ func Load() (*Config, error) {
 
 	// JWT config
 	config.JWT.Secret = getEnv("JWT_SECRET", "your-secret-key")
-	config.JWT.ExpiresIn = getEnvAsInt("JWT_EXPIRES_IN", 3600)
+	config.JWT.ExpiresIn = getEnvAsInt("JWT_EXPIRES_IN", 900)
+	config.JWT.RefreshExpiresIn = getEnvAsInt("JWT_REFRESH_EXPIRES_IN", 7*24*3600)
 
 	// Password config
 	config.Password.MinLength = getEnvAsInt("PASSWORD_MIN_LENGTH", 8)
 	config.Password.RequireLetter = getEnvAsBool("PASSWORD_REQUIRE_LETTER", true)
 	config.Password.RequireDigit = getEnvAsBool("PASSWORD_REQUIRE_DIGIT", true)
 	config.Password.RequireSpecial = getEnvAsBool("PASSWORD_REQUIRE_SPECIAL", true)
+	config.Password.ExpiryDays = getEnvAsInt("PASSWORD_EXPIRY_DAYS", 180)
+	config.Password.HistoryCount = getEnvAsInt("PASSWORD_HISTORY_COUNT", 5)
+
+	// Login config
+	config.Login.MaxFailures = getEnvAsInt("LOGIN_MAX_FAILURES", 5)
+	config.Login.LockMinutes = getEnvAsInt("LOGIN_LOCK_MINUTES", 15)
+	config.Login.IPMaxFailures = getEnvAsInt("LOGIN_IP_MAX_FAILURES", 20)
+	config.Login.WindowMinutes = getEnvAsInt("LOGIN_WINDOW_MINUTES", 15)
+	config.Login.MaxDelay = getEnvAsInt("LOGIN_MAX_DELAY", 30)
+	config.Login.CaptchaAfterFailures = getEnvAsInt("LOGIN_CAPTCHA_AFTER_FAILURES", 1)
+
+	// Two-factor config
+	config.TwoFactor.Issuer = getEnv("TOTP_ISSUER", "基层三基考试系统")
+	config.TwoFactor.RequiredRoles = getEnvAsList("TWO_FACTOR_REQUIRED_ROLES")
+
+	// LDAP config
+	config.LDAP.Enabled = getEnvAsBool("LDAP_ENABLED", false)
+	config.LDAP.URL = getEnv("LDAP_URL", "")
+	config.LDAP.StartTLS = getEnvAsBool("LDAP_START_TLS", false)
+	config.LDAP.InsecureSkipVerify = getEnvAsBool("LDAP_INSECURE_SKIP_VERIFY", false)
+	config.LDAP.BindDN = getEnv("LDAP_BIND_DN", "")
+	config.LDAP.BindPassword = getEnv("LDAP_BIND_PASSWORD", "")
+	config.LDAP.BaseDN = getEnv("LDAP_BASE_DN", "")
+	config.LDAP.UserFilter = getEnv("LDAP_USER_FILTER", "(&(objectClass=user)(sAMAccountName=%s))")
+	config.LDAP.Timeout = getEnvAsInt("LDAP_TIMEOUT", 10)
+	config.LDAP.AutoProvision = getEnvAsBool("LDAP_AUTO_PROVISION", true)
+	config.LDAP.NameAttribute = getEnv("LDAP_NAME_ATTRIBUTE", "displayName")
+	config.LDAP.DepartmentAttribute = getEnv("LDAP_DEPARTMENT_ATTRIBUTE", "department")
+	config.LDAP.JobTitleAttribute = getEnv("LDAP_JOB_TITLE_ATTRIBUTE", "title")
+	config.LDAP.GroupAttribute = getEnv("LDAP_GROUP_ATTRIBUTE", "memberOf")
+	config.LDAP.GroupRoles = parseGroupRoles(getEnv("LDAP_GROUP_ROLES", ""))
+	config.LDAP.DefaultRole = getEnv("LDAP_DEFAULT_ROLE", "employee")
+
+	// OIDC config
+	config.OIDC.Enabled = getEnvAsBool("OIDC_ENABLED", false)
+	config.OIDC.DisplayName = getEnv("OIDC_DISPLAY_NAME", "区域卫生平台账号")
+	config.OIDC.Issuer = strings.TrimRight(getEnv("OIDC_ISSUER", ""), "/")
+	config.OIDC.ClientID = getEnv("OIDC_CLIENT_ID", "")
+	config.OIDC.ClientSecret = getEnv("OIDC_CLIENT_SECRET", "")
+	config.OIDC.RedirectURL = getEnv("OIDC_REDIRECT_URL", "")
+	if config.OIDC.RedirectURL == "" {
+		config.OIDC.RedirectURL = config.App.BaseURL + "/api/auth/oidc/callback"
+	}
+	config.OIDC.Scopes = getEnvAsList("OIDC_SCOPES")
+	if len(config.OIDC.Scopes) == 0 {
+		config.OIDC.Scopes = []string{"openid", "profile", "email"}
+	}
+	config.OIDC.Timeout = getEnvAsInt("OIDC_TIMEOUT", 10)
+	config.OIDC.AutoProvision = getEnvAsBool("OIDC_AUTO_PROVISION", true)
+	config.OIDC.UsernameClaim = getEnv("OIDC_USERNAME_CLAIM", "preferred_username")
+	config.OIDC.NameClaim = getEnv("OIDC_NAME_CLAIM", "name")
+	config.OIDC.DepartmentClaim = getEnv("OIDC_DEPARTMENT_CLAIM", "")
+	config.OIDC.JobTitleClaim = getEnv("OIDC_JOB_TITLE_CLAIM", "")
+	config.OIDC.RoleClaim = getEnv("OIDC_ROLE_CLAIM", "roles")
+	config.OIDC.ClaimRoles = parseGroupRoles(getEnv("OIDC_CLAIM_ROLES", ""))
+	config.OIDC.DefaultRole = getEnv("OIDC_DEFAULT_ROLE", "employee")
+
+	// Captcha config
+	config.Captcha.Type = getEnv("CAPTCHA_TYPE", "digit")
+	config.Captcha.Length = getEnvAsInt("CAPTCHA_LENGTH", 4)
+	config.Captcha.Store = getEnv("CAPTCHA_STORE", "memory")
+	config.Captcha.TTL = getEnvAsInt("CAPTCHA_TTL", 300)
+	config.Captcha.MaxEntries = getEnvAsInt("CAPTCHA_MAX_ENTRIES", 10000)
+	config.Captcha.MaxAttempts = getEnvAsInt("CAPTCHA_MAX_ATTEMPTS", 3)
+
+	// Redis config
+	config.Redis.Addr = getEnv("REDIS_ADDR", "localhost:6379")
+	config.Redis.Password = getEnv("REDIS_PASSWORD", "")
+	config.Redis.DB = getEnvAsInt("REDIS_DB", 0)
+
+	// Storage config
+	config.Storage.Driver = getEnv("STORAGE_DRIVER", "local")
+	config.Storage.LocalDir = getEnv("STORAGE_LOCAL_DIR", "./uploads")
+	config.Storage.PublicURL = "/" + strings.Trim(getEnv("STORAGE_PUBLIC_URL", "/uploads"), "/")
+
+	// Avatar config
+	config.Avatar.MaxSize = getEnvAsInt("AVATAR_MAX_SIZE_MB", 2) << 20
+	config.Avatar.MaxPixels = getEnvAsInt("AVATAR_MAX_PIXELS", 40_000_000)
+
+	// Password reset config
+	config.Reset.CodeTTL = getEnvAsInt("PASSWORD_RESET_CODE_TTL", 600)
+	config.Reset.ResendInterval = getEnvAsInt("PASSWORD_RESET_RESEND_INTERVAL", 60)
+	config.Reset.MaxPerHour = getEnvAsInt("PASSWORD_RESET_MAX_PER_HOUR", 5)
+	config.Reset.IPMaxPerHour = getEnvAsInt("PASSWORD_RESET_IP_MAX_PER_HOUR", 20)
+	config.Reset.MaxAttempts = getEnvAsInt("PASSWORD_RESET_MAX_ATTEMPTS", 5)
+	config.Reset.TokenTTL = getEnvAsInt("PASSWORD_RESET_TOKEN_TTL", 600)
+
+	// Notify config
+	config.Notify.Email = getEnv("EMAIL_NOTIFIER", "off")
+	config.Notify.SMS = getEnv("SMS_NOTIFIER", "off")
+	config.Notify.Signature = getEnv("NOTIFY_SIGNATURE", "基层三基考试系统")
+	config.Notify.SMTPHost = getEnv("SMTP_HOST", "")
+	config.Notify.SMTPPort = getEnvAsInt("SMTP_PORT", 465)
+	config.Notify.SMTPUsername = getEnv("SMTP_USERNAME", "")
+	config.Notify.SMTPPassword = getEnv("SMTP_PASSWORD", "")
+	config.Notify.SMTPFrom = getEnv("SMTP_FROM", "")
+	config.Notify.SMTPSecurity = getEnv("SMTP_SECURITY", "tls")
+	config.Notify.SMTPTimeout = getEnvAsInt("SMTP_TIMEOUT", 10)
+	config.Notify.SMSGatewayURL = getEnv("SMS_GATEWAY_URL", "")
+	config.Notify.SMSGatewayToken = getEnv("SMS_GATEWAY_TOKEN", "")
+	config.Notify.SMSGatewayTimeout = getEnvAsInt("SMS_GATEWAY_TIMEOUT", 10)
+
+	// Field encryption config
+	keys, err := fieldcrypt.ParseKeys(getEnv("FIELD_ENCRYPTION_KEYS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("FIELD_ENCRYPTION_KEYS 配置错误: %w", err)
+	}
+	config.Crypto.Keys = keys
+	if indexKey := getEnv("FIELD_BLIND_INDEX_KEY", ""); indexKey != "" {
+		if config.Crypto.BlindIndexKey, err = fieldcrypt.ParseSecret(indexKey); err != nil {
+			return nil, fmt.Errorf("FIELD_BLIND_INDEX_KEY 配置错误: %w", err)
+		}
+	} else if len(keys) > 0 {
+		return nil, fmt.Errorf("配置了 FIELD_ENCRYPTION_KEYS 时必须同时配置 FIELD_BLIND_INDEX_KEY")
+	}
+
+	switch config.Captcha.Type {
+	case "digit", "string", "math", "chinese", "audio":
+	default:
+		return nil, fmt.Errorf("不支持的验证码类型: %s", config.Captcha.Type)
+	}
+	switch config.Captcha.Store {
+	case "memory", "mysql", "redis":
+	default:
+		return nil, fmt.Errorf("不支持的验证码存储: %s", config.Captcha.Store)
+	}
+	if config.Storage.Driver != "local" {
+		return nil, fmt.Errorf("不支持的文件存储: %s", config.Storage.Driver)
+	}
+	switch config.Notify.Email {
+	case "off":
+	case "smtp":
+		if config.Notify.SMTPHost == "" || config.Notify.SMTPFrom == "" {
+			return nil, fmt.Errorf("EMAIL_NOTIFIER=smtp 时必须配置 SMTP_HOST 和 SMTP_FROM")
+		}
+		switch config.Notify.SMTPSecurity {
+		case "tls", "starttls", "none":
+		default:
+			return nil, fmt.Errorf("不支持的SMTP加密方式: %s", config.Notify.SMTPSecurity)
+		}
+	default:
+		return nil, fmt.Errorf("不支持的邮件发送方式: %s", config.Notify.Email)
+	}
+	switch config.Notify.SMS {
+	case "off":
+	case "http":
+		if config.Notify.SMSGatewayURL == "" {
+			return nil, fmt.Errorf("SMS_NOTIFIER=http 时必须配置 SMS_GATEWAY_URL")
+		}
+	default:
+		return nil, fmt.Errorf("不支持的短信发送方式: %s", config.Notify.SMS)
+	}
 
 	return config, nil
 }
@@ -99,6 +412,35 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvAsList 读取逗号分隔的列表，未设置时返回nil
+func getEnvAsList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// parseGroupRoles 解析组与角色的对应关系，格式为“组:角色”，多项用分号分隔。
+// 组的DN中含有逗号，因此不能使用逗号分隔；角色编码不含冒号，按最后一个冒号拆分，
+// 因此组也可以是含冒号的OIDC声明值，例如 urn:hospital:exam-admin:admin
+func parseGroupRoles(value string) []GroupRole {
+	var mappings []GroupRole
+	for _, item := range strings.Split(value, ";") {
+		i := strings.LastIndex(item, ":")
+		if i < 0 {
+			continue
+		}
+		group, role := strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+		if group != "" && role != "" {
+			mappings = append(mappings, GroupRole{Group: group, Role: role})
+		}
+	}
+	return mappings
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	valueStr := getEnv(key, "")
 	if value, err := strconv.ParseBool(valueStr); err == nil {
@@ -0,0 +1,170 @@
+// Package fieldcrypt 对数据库中的敏感字段进行应用层加密（AES-256-GCM），并计算用于等值查询的盲索引（HMAC-SHA256）。
+// 密文格式为 enc:<密钥编号>:<base64(随机数+密文)>，密钥环中保留旧密钥用于解密，轮换后用新密钥重新加密。
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize 加密密钥和盲索引密钥的长度（字节）
+const KeySize = 32
+
+// prefix 密文前缀，没有该前缀的值视为加密前写入的明文
+const prefix = "enc:"
+
+// 解密失败的原因
+var (
+	ErrUnknownKey = errors.New("fieldcrypt: unknown key id")
+	ErrMalformed  = errors.New("fieldcrypt: malformed ciphertext")
+	ErrAuth       = errors.New("fieldcrypt: message authentication failed")
+)
+
+// Key 加密密钥
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseKeys 解析逗号分隔的“编号:base64密钥”列表，例如 2:AAAA...,1:BBBB...；第一个密钥为当前密钥
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok || id == "" || strings.ContainsAny(id, ": ") {
+			return nil, fmt.Errorf("fieldcrypt: key must be written as id:base64, got %q", item)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("fieldcrypt: duplicate key id %q", id)
+		}
+		secret, err := ParseSecret(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%w (key id %q)", err, id)
+		}
+		seen[id] = true
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	return keys, nil
+}
+
+// ParseSecret 解析base64编码的32字节密钥
+func ParseSecret(encoded string) ([]byte, error) {
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.New("fieldcrypt: key is not valid base64")
+	}
+	if len(secret) != KeySize {
+		return nil, fmt.Errorf("fieldcrypt: key must be %d bytes, got %d", KeySize, len(secret))
+	}
+	return secret, nil
+}
+
+// Keyring 密钥环，可以被多个goroutine同时使用。没有加密密钥时不加密，原样保存明文
+type Keyring struct {
+	current  string
+	aeads    map[string]cipher.AEAD
+	indexKey []byte
+}
+
+// NewKeyring 创建密钥环，keys中的第一个密钥用于加密，indexKey用于计算盲索引
+func NewKeyring(keys []Key, indexKey []byte) (*Keyring, error) {
+	k := &Keyring{aeads: make(map[string]cipher.AEAD, len(keys)), indexKey: indexKey}
+	for i, key := range keys {
+		if len(key.Secret) != KeySize {
+			return nil, fmt.Errorf("fieldcrypt: key %q must be %d bytes", key.ID, KeySize)
+		}
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.current = key.ID
+		}
+		k.aeads[key.ID] = aead
+	}
+	return k, nil
+}
+
+// Enabled 是否配置了加密密钥
+func (k *Keyring) Enabled() bool {
+	return k.current != ""
+}
+
+// Encrypt 使用当前密钥加密，field作为附加数据，防止把一个字段的密文复制到另一个字段后被解密；
+// 空值和未配置密钥时原样返回
+func (k *Keyring) Encrypt(plaintext, field string) (string, error) {
+	if plaintext == "" || !k.Enabled() {
+		return plaintext, nil
+	}
+	aead := k.aeads[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(field))
+	return prefix + k.current + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密Encrypt的结果，没有密文前缀的值视为明文原样返回
+func (k *Keyring) Decrypt(value, field string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(value[len(prefix):], ":")
+	if !ok {
+		return "", ErrMalformed
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(field))
+	if err != nil {
+		return "", ErrAuth
+	}
+	return string(plaintext), nil
+}
+
+// NeedsRewrite 判断值是否需要用当前密钥重新加密：未加密的明文或使用旧密钥加密的密文
+func (k *Keyring) NeedsRewrite(value string) bool {
+	if value == "" || !k.Enabled() {
+		return false
+	}
+	return !strings.HasPrefix(value, prefix+k.current+":")
+}
+
+// BlindIndex 计算盲索引：相同字段的相同值得到相同的索引，可以用于唯一约束和等值查询，
+// 但不能从索引还原出原值。盲索引密钥更换后需要重新计算全部索引
+func (k *Keyring) BlindIndex(value, field string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsEncrypted 判断值是否为密文
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
@@ -0,0 +1,145 @@
+// Package idnumber 校验和脱敏中国大陆手机号和18位居民身份证号（GB 11643-1999）。
+package idnumber
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// 身份证号校验失败的原因
+var (
+	ErrIDCardFormat    = errors.New("idnumber: id card must be 17 digits followed by a digit or X")
+	ErrIDCardRegion    = errors.New("idnumber: unknown region code")
+	ErrIDCardBirthdate = errors.New("idnumber: invalid birthdate")
+	ErrIDCardChecksum  = errors.New("idnumber: checksum mismatch")
+)
+
+// NormalizeMobile 去除手机号中的空格、连字符和+86/0086国际区号
+func NormalizeMobile(mobile string) string {
+	mobile = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(mobile))
+	for _, prefix := range []string{"+86", "0086"} {
+		if strings.HasPrefix(mobile, prefix) {
+			return mobile[len(prefix):]
+		}
+	}
+	return mobile
+}
+
+// ValidMobile 判断是否为11位手机号：1开头，第二位为3-9
+func ValidMobile(mobile string) bool {
+	if len(mobile) != 11 || mobile[0] != '1' || mobile[1] < '3' || mobile[1] > '9' {
+		return false
+	}
+	return allDigits(mobile)
+}
+
+// IDCard 解析后的身份证号
+type IDCard struct {
+	Number    string    // 规范化后的号码，末位X为大写
+	Region    string    // 6位行政区划代码
+	Birthdate time.Time // 出生日期
+	Male      bool      // 第17位为奇数表示男性
+}
+
+// Gender 性别：男或女
+func (c *IDCard) Gender() string {
+	if c.Male {
+		return "男"
+	}
+	return "女"
+}
+
+// provinces 行政区划代码的前两位（省级）
+var provinces = map[string]bool{
+	"11": true, "12": true, "13": true, "14": true, "15": true,
+	"21": true, "22": true, "23": true,
+	"31": true, "32": true, "33": true, "34": true, "35": true, "36": true, "37": true,
+	"41": true, "42": true, "43": true, "44": true, "45": true, "46": true,
+	"50": true, "51": true, "52": true, "53": true, "54": true,
+	"61": true, "62": true, "63": true, "64": true, "65": true,
+	"71": true, "81": true, "82": true, "83": true,
+}
+
+// checksumWeights 前17位的加权因子，checksumCodes 为加权和模11对应的校验码
+var (
+	checksumWeights = [17]int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	checksumCodes   = "10X98765432"
+)
+
+// ParseIDCard 校验18位身份证号的格式、地区、出生日期和校验码，now用于拒绝未来的出生日期
+func ParseIDCard(number string, now time.Time) (*IDCard, error) {
+	number = strings.ToUpper(strings.TrimSpace(number))
+	if len(number) != 18 || !allDigits(number[:17]) {
+		return nil, ErrIDCardFormat
+	}
+	last := number[17]
+	if (last < '0' || last > '9') && last != 'X' {
+		return nil, ErrIDCardFormat
+	}
+
+	if !provinces[number[:2]] {
+		return nil, ErrIDCardRegion
+	}
+
+	birthdate, err := time.ParseInLocation("20060102", number[6:14], time.Local)
+	if err != nil || birthdate.Year() < 1900 || birthdate.After(now) {
+		return nil, ErrIDCardBirthdate
+	}
+
+	sum := 0
+	for i, weight := range checksumWeights {
+		sum += int(number[i]-'0') * weight
+	}
+	if checksumCodes[sum%11] != last {
+		return nil, ErrIDCardChecksum
+	}
+
+	return &IDCard{
+		Number:    number,
+		Region:    number[:6],
+		Birthdate: birthdate,
+		Male:      (number[16]-'0')%2 == 1,
+	}, nil
+}
+
+// MaskMobile 手机号只保留前3位和后4位，例如 138****8000
+func MaskMobile(mobile string) string {
+	return mask(mobile, 3, 4)
+}
+
+// MaskIDCard 身份证号只保留前3位和后4位，例如 110***********1234
+func MaskIDCard(number string) string {
+	return mask(number, 3, 4)
+}
+
+// IsMasked 判断是否为脱敏后的值，前端原样提交脱敏值时表示不修改
+func IsMasked(value string) bool {
+	return strings.Contains(value, "*")
+}
+
+// mask 保留前head位和后tail位，其余替换为*；过短的值全部替换
+func mask(value string, head, tail int) string {
+	if value == "" {
+		return ""
+	}
+	runes := []rune(value)
+	if len(runes) <= head+tail {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:head]) + strings.Repeat("*", len(runes)-head-tail) + string(runes[len(runes)-tail:])
+}
+
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,240 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER标识字节（LDAP只用到标签号小于31的单字节标识）
+const (
+	tagBoolean     byte = 0x01
+	tagInteger     byte = 0x02
+	tagOctetString byte = 0x04
+	tagEnumerated  byte = 0x0a
+	tagSequence    byte = 0x30
+	tagSet         byte = 0x31
+
+	classContext byte = 0x80
+	constructed  byte = 0x20
+)
+
+// LDAP协议操作的标识字节（RFC 4511 4.2节起）
+const (
+	appBindRequest      byte = 0x60
+	appBindResponse     byte = 0x61
+	appUnbindRequest    byte = 0x42
+	appSearchRequest    byte = 0x63
+	appSearchEntry      byte = 0x64
+	appSearchDone       byte = 0x65
+	appSearchReference  byte = 0x73
+	appExtendedRequest  byte = 0x77
+	appExtendedResponse byte = 0x78
+)
+
+// 报文长度和嵌套层数上限，防止异常报文耗尽内存
+const (
+	maxPacketLength = 16 << 20
+	maxPacketDepth  = 64
+)
+
+// packet BER编码的数据元素，基本类型使用Value，结构化类型使用Children
+type packet struct {
+	Tag      byte
+	Value    []byte
+	Children []*packet
+}
+
+// Constructed 是否为结构化类型
+func (p *packet) Constructed() bool {
+	return p.Tag&constructed != 0
+}
+
+// String 按字符串读取内容
+func (p *packet) String() string {
+	return string(p.Value)
+}
+
+// Int 按整数读取内容（INTEGER和ENUMERATED）
+func (p *packet) Int() (int64, error) {
+	if len(p.Value) == 0 || len(p.Value) > 8 {
+		return 0, errors.New("ldap: invalid integer")
+	}
+	value := int64(int8(p.Value[0]))
+	for _, b := range p.Value[1:] {
+		value = value<<8 | int64(b)
+	}
+	return value, nil
+}
+
+// Child 返回第i个子元素，不存在时返回nil
+func (p *packet) Child(i int) *packet {
+	if i < 0 || i >= len(p.Children) {
+		return nil
+	}
+	return p.Children[i]
+}
+
+// Bytes 编码为BER字节
+func (p *packet) Bytes() []byte {
+	content := p.Value
+	if p.Constructed() {
+		content = nil
+		for _, child := range p.Children {
+			content = append(content, child.Bytes()...)
+		}
+	}
+
+	out := []byte{p.Tag}
+	out = append(out, encodeLength(len(content))...)
+	return append(out, content...)
+}
+
+// newString 创建字符串类型的基本元素
+func newString(tag byte, value string) *packet {
+	return &packet{Tag: tag, Value: []byte(value)}
+}
+
+// newInt 创建整数类型的基本元素
+func newInt(tag byte, value int64) *packet {
+	return &packet{Tag: tag, Value: encodeInt(value)}
+}
+
+// newBool 创建布尔类型的基本元素
+func newBool(value bool) *packet {
+	if value {
+		return &packet{Tag: tagBoolean, Value: []byte{0xff}}
+	}
+	return &packet{Tag: tagBoolean, Value: []byte{0x00}}
+}
+
+// newConstructed 创建结构化元素
+func newConstructed(tag byte, children ...*packet) *packet {
+	return &packet{Tag: tag | constructed, Children: children}
+}
+
+// readPacket 从r中读取一个完整的BER元素
+func readPacket(r *bufio.Reader) (*packet, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return parseContent(tag, content, 0)
+}
+
+// parseContent 解析元素内容，结构化类型递归解析子元素
+func parseContent(tag byte, content []byte, depth int) (*packet, error) {
+	if tag&0x1f == 0x1f {
+		return nil, errors.New("ldap: unsupported high tag number")
+	}
+	p := &packet{Tag: tag}
+	if tag&constructed == 0 {
+		p.Value = content
+		return p, nil
+	}
+	if depth >= maxPacketDepth {
+		return nil, errors.New("ldap: packet nested too deeply")
+	}
+
+	for len(content) > 0 {
+		childTag := content[0]
+		length, n, err := decodeLength(content[1:])
+		if err != nil {
+			return nil, err
+		}
+		start := 1 + n
+		if length > len(content)-start {
+			return nil, errors.New("ldap: truncated packet")
+		}
+		child, err := parseContent(childTag, content[start:start+length], depth+1)
+		if err != nil {
+			return nil, err
+		}
+		p.Children = append(p.Children, child)
+		content = content[start+length:]
+	}
+	return p, nil
+}
+
+// readLength 从流中读取长度，只支持确定长度形式
+func readLength(r *bufio.Reader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return int(first), nil
+	}
+
+	n := int(first & 0x7f)
+	if n == 0 || n > 4 {
+		return 0, fmt.Errorf("ldap: unsupported length form 0x%02x", first)
+	}
+	length := 0
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	if length > maxPacketLength {
+		return 0, errors.New("ldap: packet too large")
+	}
+	return length, nil
+}
+
+// decodeLength 从字节中解析长度，返回长度值和长度字段占用的字节数
+func decodeLength(data []byte) (int, int, error) {
+	if len(data) == 0 {
+		return 0, 0, errors.New("ldap: truncated packet")
+	}
+	first := data[0]
+	if first < 0x80 {
+		return int(first), 1, nil
+	}
+
+	n := int(first & 0x7f)
+	if n == 0 || n > 4 || len(data) < 1+n {
+		return 0, 0, fmt.Errorf("ldap: unsupported length form 0x%02x", first)
+	}
+	length := 0
+	for _, b := range data[1 : 1+n] {
+		length = length<<8 | int(b)
+	}
+	if length > maxPacketLength {
+		return 0, 0, errors.New("ldap: packet too large")
+	}
+	return length, 1 + n, nil
+}
+
+// encodeLength 编码长度，小于128使用短形式
+func encodeLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	var buf []byte
+	for v := length; v > 0; v >>= 8 {
+		buf = append([]byte{byte(v)}, buf...)
+	}
+	return append([]byte{0x80 | byte(len(buf))}, buf...)
+}
+
+// encodeInt 按二进制补码编码整数，使用最少的字节
+func encodeInt(value int64) []byte {
+	buf := []byte{byte(value)}
+	for value >= 0x80 || value < -0x80 {
+		value >>= 8
+		buf = append([]byte{byte(value)}, buf...)
+	}
+	return buf
+}
@@ -0,0 +1,82 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	long := strings.Repeat("x", 300) // 长度需要两个字节的长形式
+	original := newConstructed(tagSequence,
+		newInt(tagInteger, 7),
+		newConstructed(appBindRequest,
+			newInt(tagInteger, 3),
+			newString(tagOctetString, "uid=zhangsan,ou=people,dc=example,dc=com"),
+			newString(classContext, long),
+		),
+	)
+
+	decoded, err := readPacket(bufio.NewReader(bytes.NewReader(original.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Tag != tagSequence || len(decoded.Children) != 2 {
+		t.Fatalf("unexpected packet %+v", decoded)
+	}
+	if id, _ := decoded.Child(0).Int(); id != 7 {
+		t.Errorf("message id = %d, want 7", id)
+	}
+	bind := decoded.Child(1)
+	if bind.Tag != appBindRequest || bind.Child(1).String() != "uid=zhangsan,ou=people,dc=example,dc=com" {
+		t.Errorf("unexpected bind request %+v", bind)
+	}
+	if bind.Child(2).String() != long {
+		t.Errorf("long value was not preserved")
+	}
+	if decoded.Child(5) != nil {
+		t.Errorf("out of range child should be nil")
+	}
+}
+
+func TestEncodeInt(t *testing.T) {
+	for _, v := range []int64{0, 1, 127, 128, 255, 256, -1, -128, -129, 1 << 31} {
+		decoded, err := (&packet{Tag: tagInteger, Value: encodeInt(v)}).Int()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != v {
+			t.Errorf("encodeInt(%d) decoded as %d", v, decoded)
+		}
+	}
+	if got := encodeInt(128); !bytes.Equal(got, []byte{0x00, 0x80}) {
+		t.Errorf("encodeInt(128) = % x, want 00 80", got)
+	}
+}
+
+func TestReadPacketRejectsMalformed(t *testing.T) {
+	cases := map[string][]byte{
+		"truncated content":    {0x30, 0x05, 0x02, 0x01},
+		"truncated child":      {0x30, 0x03, 0x04, 0x05, 0x61},
+		"indefinite length":    {0x30, 0x80, 0x00, 0x00},
+		"oversized length":     {0x04, 0x84, 0x7f, 0xff, 0xff, 0xff},
+		"high tag number":      {0x1f, 0x01, 0x00},
+		"child length too big": {0x30, 0x04, 0x04, 0x85, 0x00, 0x00},
+	}
+	for name, data := range cases {
+		if _, err := readPacket(bufio.NewReader(bytes.NewReader(data))); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestReadPacketRejectsDeepNesting(t *testing.T) {
+	p := newString(tagOctetString, "x")
+	for i := 0; i <= maxPacketDepth; i++ {
+		p = newConstructed(tagSequence, p)
+	}
+	if _, err := readPacket(bufio.NewReader(bytes.NewReader(p.Bytes()))); err == nil {
+		t.Fatal("expected error for deeply nested packet")
+	}
+}
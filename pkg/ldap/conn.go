@@ -0,0 +1,327 @@
+// Package ldap 提供不依赖第三方库的最小化LDAP v3客户端，用于对接医院的LDAP/Active Directory目录。
+// 只实现登录认证需要的操作：简单绑定、查询、StartTLS和解除绑定，同一连接上的请求按顺序执行。
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LDAP结果码（RFC 4511 附录A），只列出调用方需要区分的几种
+const (
+	ResultSuccess            = 0
+	ResultSizeLimitExceeded  = 4
+	ResultInvalidCredentials = 49
+)
+
+// 查询范围
+const (
+	ScopeBaseObject   = 0
+	ScopeSingleLevel  = 1
+	ScopeWholeSubtree = 2
+)
+
+// startTLSOID StartTLS扩展操作的OID（RFC 4511 4.14节）
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// ErrEmptyPassword 密码为空。空密码的简单绑定会被服务器当作匿名绑定而“成功”，必须在客户端拒绝
+var ErrEmptyPassword = errors.New("ldap: empty password")
+
+// Error 服务器返回的非成功结果
+type Error struct {
+	ResultCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("ldap: result code %d", e.ResultCode)
+	}
+	return fmt.Sprintf("ldap: result code %d: %s", e.ResultCode, e.Message)
+}
+
+// IsResultCode 判断错误是否为指定结果码的服务器错误
+func IsResultCode(err error, code int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.ResultCode == code
+}
+
+// Entry 查询结果中的一个条目，属性名不区分大小写
+type Entry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values 返回属性的全部值
+func (e *Entry) Values(name string) []string {
+	return e.Attributes[strings.ToLower(name)]
+}
+
+// Value 返回属性的第一个值，不存在时返回空字符串
+func (e *Entry) Value(name string) string {
+	values := e.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// SearchRequest 查询请求
+type SearchRequest struct {
+	BaseDN     string
+	Scope      int
+	Filter     string
+	Attributes []string
+	SizeLimit  int // 最多返回的条目数，0表示由服务器决定
+}
+
+// Conn LDAP连接，不能并发使用
+type Conn struct {
+	host    string // 服务器主机名，StartTLS时用于校验证书
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	nextID  int64
+}
+
+// Dial 连接LDAP服务器，地址格式为 ldap://host:389 或 ldaps://host:636，
+// tlsConfig用于ldaps连接，timeout同时作为之后每次请求的超时时间
+func Dial(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Host
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch strings.ToLower(u.Scheme) {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, withServerName(tlsConfig, u.Hostname()))
+	default:
+		return nil, fmt.Errorf("ldap: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{host: u.Hostname(), conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// StartTLS 将明文连接升级为TLS连接，必须在绑定之前调用
+func (c *Conn) StartTLS(tlsConfig *tls.Config) error {
+	request := newConstructed(appExtendedRequest, newString(classContext, startTLSOID))
+	response, err := c.roundTrip(request, appExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := resultError(response); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.conn, withServerName(tlsConfig, c.host))
+	if c.timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Bind 使用DN和密码进行简单绑定，密码错误时返回结果码为ResultInvalidCredentials的错误
+func (c *Conn) Bind(dn, password string) error {
+	if password == "" {
+		return ErrEmptyPassword
+	}
+
+	request := newConstructed(appBindRequest,
+		newInt(tagInteger, 3),
+		newString(tagOctetString, dn),
+		newString(classContext, password), // simple [0]
+	)
+	response, err := c.roundTrip(request, appBindResponse)
+	if err != nil {
+		return err
+	}
+	return resultError(response)
+}
+
+// Search 执行查询，返回全部条目，忽略引用
+func (c *Conn) Search(req *SearchRequest) ([]*Entry, error) {
+	filter, err := compileFilter(req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := newConstructed(tagSequence)
+	for _, attr := range req.Attributes {
+		attributes.Children = append(attributes.Children, newString(tagOctetString, attr))
+	}
+	request := newConstructed(appSearchRequest,
+		newString(tagOctetString, req.BaseDN),
+		newInt(tagEnumerated, int64(req.Scope)),
+		newInt(tagEnumerated, 0), // derefAliases: neverDerefAliases
+		newInt(tagInteger, int64(req.SizeLimit)),
+		newInt(tagInteger, int64(c.timeout/time.Second)),
+		newBool(false),
+		filter,
+		attributes,
+	)
+
+	id, err := c.send(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*Entry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Tag {
+		case appSearchEntry:
+			entry, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case appSearchReference:
+			continue
+		case appSearchDone:
+			return entries, resultError(op)
+		default:
+			return nil, fmt.Errorf("ldap: unexpected response 0x%02x", op.Tag)
+		}
+	}
+}
+
+// Close 解除绑定并关闭连接
+func (c *Conn) Close() error {
+	c.send(&packet{Tag: appUnbindRequest})
+	return c.conn.Close()
+}
+
+// roundTrip 发送请求并读取对应的单个响应
+func (c *Conn) roundTrip(request *packet, responseTag byte) (*packet, error) {
+	id, err := c.send(request)
+	if err != nil {
+		return nil, err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if op.Tag != responseTag {
+		return nil, fmt.Errorf("ldap: unexpected response 0x%02x", op.Tag)
+	}
+	return op, nil
+}
+
+// send 发送一个LDAPMessage，返回消息ID
+func (c *Conn) send(op *packet) (int64, error) {
+	c.nextID++
+	message := newConstructed(tagSequence, newInt(tagInteger, c.nextID), op)
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if _, err := c.conn.Write(message.Bytes()); err != nil {
+		return 0, err
+	}
+	return c.nextID, nil
+}
+
+// receive 读取指定消息ID的响应，返回其中的协议操作
+func (c *Conn) receive(id int64) (*packet, error) {
+	for {
+		if c.timeout > 0 {
+			c.conn.SetDeadline(time.Now().Add(c.timeout))
+		}
+		message, err := readPacket(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if message.Tag != tagSequence || len(message.Children) < 2 {
+			return nil, errors.New("ldap: malformed message")
+		}
+		messageID, err := message.Child(0).Int()
+		if err != nil {
+			return nil, err
+		}
+
+		// 消息ID为0的是服务器主动发出的通知（例如即将断开连接）
+		if messageID == 0 {
+			if err := resultError(message.Child(1)); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("ldap: connection closed by server")
+		}
+		if messageID == id {
+			return message.Child(1), nil
+		}
+	}
+}
+
+// resultError 将LDAPResult转换为错误，成功时返回nil
+func resultError(op *packet) error {
+	if len(op.Children) < 3 {
+		return errors.New("ldap: malformed result")
+	}
+	code, err := op.Child(0).Int()
+	if err != nil {
+		return err
+	}
+	if code == ResultSuccess {
+		return nil
+	}
+	return &Error{ResultCode: int(code), Message: op.Child(2).String()}
+}
+
+// parseEntry 解析查询结果条目
+func parseEntry(op *packet) (*Entry, error) {
+	if len(op.Children) < 2 {
+		return nil, errors.New("ldap: malformed search entry")
+	}
+
+	entry := &Entry{DN: op.Child(0).String(), Attributes: map[string][]string{}}
+	for _, attr := range op.Child(1).Children {
+		if len(attr.Children) < 2 {
+			return nil, errors.New("ldap: malformed attribute")
+		}
+		name := strings.ToLower(attr.Child(0).String())
+		for _, value := range attr.Child(1).Children {
+			entry.Attributes[name] = append(entry.Attributes[name], value.String())
+		}
+	}
+	return entry, nil
+}
+
+// withServerName 未指定ServerName时使用连接的主机名校验证书
+func withServerName(tlsConfig *tls.Config, host string) *tls.Config {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	} else {
+		tlsConfig = tlsConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = host
+	}
+	return tlsConfig
+}
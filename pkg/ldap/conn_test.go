@@ -0,0 +1,183 @@
+package ldap
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/pkg/ldap/ldaptest"
+)
+
+const (
+	testBaseDN   = "ou=people,dc=example,dc=com"
+	testUserDN   = "uid=zhangsan,ou=people,dc=example,dc=com"
+	testPassword = "Secret#123"
+)
+
+func newTestServer(t *testing.T) *ldaptest.Server {
+	t.Helper()
+	server := ldaptest.NewServer(
+		ldaptest.Entry{
+			DN:       testUserDN,
+			Password: testPassword,
+			Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"zhangsan"},
+				"cn":          {"张三"},
+				"memberOf":    {"cn=doctors,ou=groups,dc=example,dc=com", "cn=teachers,ou=groups,dc=example,dc=com"},
+			},
+		},
+		ldaptest.Entry{
+			DN:         "uid=lisi,ou=people,dc=example,dc=com",
+			Password:   "Other#456",
+			Attributes: map[string][]string{"objectClass": {"person"}, "uid": {"lisi"}, "cn": {"李四"}},
+		},
+	)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dialTest(t *testing.T, server *ldaptest.Server) *Conn {
+	t.Helper()
+	conn, err := Dial(server.URL, nil, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestBind(t *testing.T) {
+	server := newTestServer(t)
+	conn := dialTest(t, server)
+
+	if err := conn.Bind(testUserDN, testPassword); err != nil {
+		t.Fatalf("bind with correct password: %v", err)
+	}
+
+	err := conn.Bind(testUserDN, "wrong")
+	if !IsResultCode(err, ResultInvalidCredentials) {
+		t.Fatalf("bind with wrong password: got %v, want result code 49", err)
+	}
+	var ldapErr *Error
+	if !errors.As(err, &ldapErr) || ldapErr.Message == "" {
+		t.Errorf("expected server diagnostic message, got %v", err)
+	}
+
+	if err := conn.Bind("uid=nobody,ou=people,dc=example,dc=com", testPassword); !IsResultCode(err, ResultInvalidCredentials) {
+		t.Errorf("bind with unknown DN: got %v", err)
+	}
+}
+
+// 服务器会把空密码的绑定当作匿名绑定返回成功，客户端必须在发送前拒绝
+func TestBindRejectsEmptyPassword(t *testing.T) {
+	server := newTestServer(t)
+	conn := dialTest(t, server)
+
+	if err := conn.Bind(testUserDN, ""); !errors.Is(err, ErrEmptyPassword) {
+		t.Fatalf("got %v, want ErrEmptyPassword", err)
+	}
+	if binds := server.Binds(); len(binds) != 0 {
+		t.Errorf("empty password bind reached the server: %+v", binds)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	server := newTestServer(t)
+	conn := dialTest(t, server)
+
+	entries, err := conn.Search(&SearchRequest{
+		BaseDN:     testBaseDN,
+		Scope:      ScopeWholeSubtree,
+		Filter:     "(&(objectClass=person)(uid=" + EscapeFilter("zhangsan") + "))",
+		Attributes: []string{"cn", "memberOf"},
+		SizeLimit:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].DN != testUserDN {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if got := entries[0].Value("CN"); got != "张三" {
+		t.Errorf("cn = %q", got)
+	}
+	if got := entries[0].Values("memberof"); len(got) != 2 {
+		t.Errorf("memberOf = %v", got)
+	}
+	if got := entries[0].Value("mail"); got != "" {
+		t.Errorf("missing attribute should be empty, got %q", got)
+	}
+
+	searches := server.Searches()
+	if len(searches) != 1 || searches[0].Filter != "(&(objectClass=person)(uid=zhangsan))" || searches[0].SizeLimit != 2 {
+		t.Errorf("unexpected search request %+v", searches)
+	}
+}
+
+// 注入过滤条件的用户名经转义后按字面值匹配，不会匹配到任何条目
+func TestSearchEscapedInjection(t *testing.T) {
+	server := newTestServer(t)
+	conn := dialTest(t, server)
+
+	input := "*)(|(uid=*"
+	entries, err := conn.Search(&SearchRequest{
+		BaseDN: testBaseDN,
+		Scope:  ScopeWholeSubtree,
+		Filter: "(&(objectClass=person)(uid=" + EscapeFilter(input) + "))",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("escaped filter matched %d entries", len(entries))
+	}
+	if got := server.Searches()[0].Filter; got != `(&(objectClass=person)(uid=\2a\29\28|\28uid=\2a))` {
+		t.Errorf("server received filter %s", got)
+	}
+}
+
+func TestSearchSizeLimit(t *testing.T) {
+	server := newTestServer(t)
+	conn := dialTest(t, server)
+
+	entries, err := conn.Search(&SearchRequest{
+		BaseDN:    testBaseDN,
+		Scope:     ScopeWholeSubtree,
+		Filter:    "(objectClass=person)",
+		SizeLimit: 1,
+	})
+	if !IsResultCode(err, ResultSizeLimitExceeded) {
+		t.Fatalf("got %v, want size limit exceeded", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d entries before the limit, want 1", len(entries))
+	}
+}
+
+func TestSearchInvalidFilter(t *testing.T) {
+	server := newTestServer(t)
+	conn := dialTest(t, server)
+
+	if _, err := conn.Search(&SearchRequest{BaseDN: testBaseDN, Filter: "(uid=zhangsan"}); !errors.Is(err, ErrInvalidFilter) {
+		t.Fatalf("got %v, want ErrInvalidFilter", err)
+	}
+	if len(server.Searches()) != 0 {
+		t.Error("invalid filter was sent to the server")
+	}
+}
+
+func TestStartTLSUnsupported(t *testing.T) {
+	server := newTestServer(t)
+	conn := dialTest(t, server)
+
+	if err := conn.StartTLS(nil); err == nil {
+		t.Fatal("expected StartTLS to fail when the server refuses it")
+	}
+}
+
+func TestDialRejectsUnsupportedScheme(t *testing.T) {
+	if _, err := Dial("http://127.0.0.1:389", nil, time.Second); err == nil {
+		t.Fatal("expected error for http scheme")
+	}
+}
@@ -0,0 +1,183 @@
+package ldap
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 过滤条件的标识字节（RFC 4511 4.5.1节）
+const (
+	filterAnd            byte = 0xa0
+	filterOr             byte = 0xa1
+	filterNot            byte = 0xa2
+	filterEquality       byte = 0xa3
+	filterSubstrings     byte = 0xa4
+	filterGreaterOrEqual byte = 0xa5
+	filterLessOrEqual    byte = 0xa6
+	filterPresent        byte = 0x87
+	filterApprox         byte = 0xa8
+)
+
+// ErrInvalidFilter 过滤条件格式错误
+var ErrInvalidFilter = errors.New("ldap: invalid filter")
+
+// EscapeFilter 转义过滤条件中的特殊字符（RFC 4515），拼接用户输入前必须转义
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// compileFilter 将字符串形式的过滤条件编码为BER，支持与、或、非、等于、存在、
+// 子串、大于等于、小于等于和近似匹配，不支持扩展匹配
+func compileFilter(filter string) (*packet, error) {
+	p, rest, err := parseFilter(strings.TrimSpace(filter), 0)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, ErrInvalidFilter
+	}
+	return p, nil
+}
+
+// parseFilter 解析一个带括号的过滤条件，返回剩余的字符串
+func parseFilter(s string, depth int) (*packet, string, error) {
+	if depth > maxPacketDepth || len(s) < 2 || s[0] != '(' {
+		return nil, "", ErrInvalidFilter
+	}
+
+	switch s[1] {
+	case '&', '|':
+		tag := filterAnd
+		if s[1] == '|' {
+			tag = filterOr
+		}
+		p := newConstructed(tag)
+		rest := s[2:]
+		for !strings.HasPrefix(rest, ")") {
+			child, next, err := parseFilter(rest, depth+1)
+			if err != nil {
+				return nil, "", err
+			}
+			p.Children = append(p.Children, child)
+			rest = next
+		}
+		return p, rest[1:], nil
+	case '!':
+		child, rest, err := parseFilter(s[2:], depth+1)
+		if err != nil {
+			return nil, "", err
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return nil, "", ErrInvalidFilter
+		}
+		return newConstructed(filterNot, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", ErrInvalidFilter
+	}
+	p, err := parseItem(s[1:end])
+	if err != nil {
+		return nil, "", err
+	}
+	return p, s[end+1:], nil
+}
+
+// parseItem 解析单个比较条件，例如 uid=zhangsan、cn=张*、mail=*
+func parseItem(item string) (*packet, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, ErrInvalidFilter
+	}
+
+	attr, value := item[:eq], item[eq+1:]
+	tag := filterEquality
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = filterGreaterOrEqual, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLessOrEqual, attr[:len(attr)-1]
+	case '~':
+		tag, attr = filterApprox, attr[:len(attr)-1]
+	}
+	if attr == "" || strings.ContainsAny(attr, "()*\\") {
+		return nil, ErrInvalidFilter
+	}
+
+	if tag == filterEquality && value == "*" {
+		return newString(filterPresent, attr), nil
+	}
+	if tag == filterEquality && strings.Contains(value, "*") {
+		return parseSubstrings(attr, value)
+	}
+
+	decoded, err := unescapeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return newConstructed(tag, newString(tagOctetString, attr), newString(tagOctetString, decoded)), nil
+}
+
+// parseSubstrings 解析含通配符的子串匹配
+func parseSubstrings(attr, value string) (*packet, error) {
+	parts := strings.Split(value, "*")
+	substrings := newConstructed(tagSequence)
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		decoded, err := unescapeValue(part)
+		if err != nil {
+			return nil, err
+		}
+		tag := classContext | 1 // any
+		if i == 0 {
+			tag = classContext // initial
+		} else if i == len(parts)-1 {
+			tag = classContext | 2 // final
+		}
+		substrings.Children = append(substrings.Children, newString(tag, decoded))
+	}
+	if len(substrings.Children) == 0 {
+		return nil, ErrInvalidFilter
+	}
+	return newConstructed(filterSubstrings, newString(tagOctetString, attr), substrings), nil
+}
+
+// unescapeValue 还原\XX形式的转义字符
+func unescapeValue(value string) (string, error) {
+	if !strings.Contains(value, "\\") {
+		return value, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			b.WriteByte(value[i])
+			continue
+		}
+		if i+3 > len(value) {
+			return "", ErrInvalidFilter
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", ErrInvalidFilter
+		}
+		b.Write(decoded)
+		i += 2
+	}
+	return b.String(), nil
+}
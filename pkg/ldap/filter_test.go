@@ -0,0 +1,93 @@
+package ldap
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEscapeFilter(t *testing.T) {
+	cases := map[string]string{
+		"zhangsan":           "zhangsan",
+		"*":                  `\2a`,
+		"admin)(uid=*":       `admin\29\28uid=\2a`,
+		`a\b`:                `a\5cb`,
+		"nul\x00":            `nul\00`,
+		"张三(外科)":             `张三\28外科\29`,
+		"*)(|(objectClass=*": `\2a\29\28|\28objectClass=\2a`,
+	}
+	for input, want := range cases {
+		if got := EscapeFilter(input); got != want {
+			t.Errorf("EscapeFilter(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+// 转义后的用户输入只能作为一个等于条件的值，不能改变过滤条件的结构
+func TestEscapedValueStaysLiteral(t *testing.T) {
+	for _, input := range []string{"*", "admin)(uid=*", "*)(|(objectClass=*", `a\b`, "x\x00y"} {
+		p, err := compileFilter("(&(objectClass=person)(uid=" + EscapeFilter(input) + "))")
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		if p.Tag != filterAnd || len(p.Children) != 2 {
+			t.Fatalf("%q: filter structure changed: %+v", input, p)
+		}
+		eq := p.Child(1)
+		if eq.Tag != filterEquality || eq.Child(0).String() != "uid" || eq.Child(1).String() != input {
+			t.Errorf("%q: got tag 0x%02x value %q", input, eq.Tag, eq.Child(1).String())
+		}
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	cases := []struct {
+		filter string
+		tag    byte
+	}{
+		{"(uid=zhangsan)", filterEquality},
+		{"(mail=*)", filterPresent},
+		{"(cn=张*)", filterSubstrings},
+		{"(cn=*三*)", filterSubstrings},
+		{"(uidNumber>=1000)", filterGreaterOrEqual},
+		{"(uidNumber<=1000)", filterLessOrEqual},
+		{"(cn~=zhang)", filterApprox},
+		{"(!(disabled=TRUE))", filterNot},
+		{"(|(uid=a)(uid=b))", filterOr},
+		{" (&(objectClass=person)(uid=a)) ", filterAnd},
+	}
+	for _, c := range cases {
+		p, err := compileFilter(c.filter)
+		if err != nil {
+			t.Errorf("%s: %v", c.filter, err)
+			continue
+		}
+		if p.Tag != c.tag {
+			t.Errorf("%s: tag 0x%02x, want 0x%02x", c.filter, p.Tag, c.tag)
+		}
+	}
+
+	p, _ := compileFilter("(cn=a*b*c)")
+	parts := p.Child(1).Children
+	if len(parts) != 3 || parts[0].Tag != classContext || parts[1].Tag != classContext|1 || parts[2].Tag != classContext|2 {
+		t.Errorf("unexpected substrings %+v", parts)
+	}
+}
+
+func TestCompileFilterRejectsInvalid(t *testing.T) {
+	for _, filter := range []string{
+		"",
+		"uid=zhangsan",
+		"(uid=zhangsan",
+		"(=zhangsan)",
+		"(uid=a)(uid=b)",
+		"(&(uid=a)",
+		`(uid=\zz)`,
+		`(uid=\2)`,
+		"(cn=**)",
+		"(u*d=a)",
+	} {
+		if _, err := compileFilter(filter); !errors.Is(err, ErrInvalidFilter) {
+			t.Errorf("%q: got %v, want ErrInvalidFilter", filter, err)
+		}
+	}
+}
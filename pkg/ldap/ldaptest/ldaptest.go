@@ -0,0 +1,519 @@
+// Package ldaptest 提供用于测试的进程内LDAP服务器，支持简单绑定、查询和解除绑定，
+// 行为与OpenLDAP、Active Directory一致：空密码的绑定按匿名绑定处理并返回成功。
+package ldaptest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// LDAP结果码
+const (
+	resultSuccess            = 0
+	resultProtocolError      = 2
+	resultSizeLimitExceeded  = 4
+	resultInvalidCredentials = 49
+	resultUnwillingToPerform = 53
+)
+
+// Entry 目录中的一个条目，Password为空表示不能用该条目绑定
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// Bind 服务器收到的一次绑定请求
+type Bind struct {
+	DN       string
+	Password string
+}
+
+// Search 服务器收到的一次查询请求，Filter为按RFC 4515重新编码的过滤条件
+type Search struct {
+	BaseDN     string
+	Scope      int
+	Filter     string
+	Attributes []string
+	SizeLimit  int
+}
+
+// Server 进程内LDAP服务器
+type Server struct {
+	URL string
+
+	listener net.Listener
+	entries  []Entry
+
+	mu       sync.Mutex
+	binds    []Bind
+	searches []Search
+	conns    int
+	wg       sync.WaitGroup
+}
+
+// NewServer 启动监听本机随机端口的LDAP服务器，使用完毕后调用Close
+func NewServer(entries ...Entry) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("ldaptest: failed to listen: %v", err))
+	}
+	s := &Server{
+		URL:      "ldap://" + listener.Addr().String(),
+		listener: listener,
+		entries:  entries,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Close 停止服务器
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// Binds 返回收到的全部绑定请求
+func (s *Server) Binds() []Bind {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Bind(nil), s.binds...)
+}
+
+// Searches 返回收到的全部查询请求
+func (s *Server) Searches() []Search {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Search(nil), s.searches...)
+}
+
+// Connections 返回接受的连接数
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+// handle 依次处理一个连接上的请求，收到解除绑定或连接断开时返回
+func (s *Server) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		message, err := readElement(reader)
+		if err != nil {
+			return
+		}
+		if message.tag != tagSequence || len(message.children) < 2 {
+			return
+		}
+		id := message.children[0].value
+		op := message.children[1]
+
+		var responses []*element
+		switch op.tag {
+		case appBindRequest:
+			responses = []*element{s.bind(op)}
+		case appSearchRequest:
+			responses = s.search(op)
+		case appUnbindRequest:
+			return
+		case appExtendedRequest:
+			// 不支持StartTLS等扩展操作
+			responses = []*element{result(appExtendedResponse, resultUnwillingToPerform, "unsupported extended operation")}
+		default:
+			return
+		}
+
+		for _, response := range responses {
+			out := constructed(tagSequence, &element{tag: tagInteger, value: id}, response)
+			if _, err := conn.Write(out.bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// bind 处理简单绑定
+func (s *Server) bind(op *element) *element {
+	if len(op.children) < 3 || op.children[2].tag != classContext {
+		return result(appBindResponse, resultProtocolError, "only simple bind is supported")
+	}
+	dn, password := string(op.children[1].value), string(op.children[2].value)
+
+	s.mu.Lock()
+	s.binds = append(s.binds, Bind{DN: dn, Password: password})
+	s.mu.Unlock()
+
+	// 空密码为匿名或未认证绑定，服务器返回成功（RFC 4513 5.1.2节）
+	if password == "" {
+		return result(appBindResponse, resultSuccess, "")
+	}
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password != "" && entry.Password == password {
+			return result(appBindResponse, resultSuccess, "")
+		}
+	}
+	return result(appBindResponse, resultInvalidCredentials, "invalid credentials")
+}
+
+// search 处理查询，返回条目和查询结束响应
+func (s *Server) search(op *element) []*element {
+	if len(op.children) < 8 {
+		return []*element{result(appSearchDone, resultProtocolError, "malformed search request")}
+	}
+	req := Search{
+		BaseDN:    string(op.children[0].value),
+		Scope:     int(intValue(op.children[1].value)),
+		SizeLimit: int(intValue(op.children[3].value)),
+	}
+	filter := op.children[6]
+	req.Filter = formatFilter(filter)
+	for _, attr := range op.children[7].children {
+		req.Attributes = append(req.Attributes, string(attr.value))
+	}
+
+	s.mu.Lock()
+	s.searches = append(s.searches, req)
+	s.mu.Unlock()
+
+	var responses []*element
+	for _, entry := range s.entries {
+		if !underBase(entry.DN, req.BaseDN) || !matchFilter(filter, &entry) {
+			continue
+		}
+		if req.SizeLimit > 0 && len(responses) == req.SizeLimit {
+			return append(responses, result(appSearchDone, resultSizeLimitExceeded, "size limit exceeded"))
+		}
+		responses = append(responses, searchEntry(&entry, req.Attributes))
+	}
+	return append(responses, result(appSearchDone, resultSuccess, ""))
+}
+
+// underBase 条目是否在查询的起始位置之下
+func underBase(dn, base string) bool {
+	dn, base = strings.ToLower(dn), strings.ToLower(base)
+	return base == "" || dn == base || strings.HasSuffix(dn, ","+base)
+}
+
+// searchEntry 编码查询结果条目，只返回请求的属性
+func searchEntry(entry *Entry, attributes []string) *element {
+	attrs := constructed(tagSequence)
+	for _, name := range attributes {
+		values := attributeValues(entry, name)
+		if len(values) == 0 {
+			continue
+		}
+		set := constructed(tagSet)
+		for _, value := range values {
+			set.children = append(set.children, primitive(tagOctetString, value))
+		}
+		attrs.children = append(attrs.children, constructed(tagSequence, primitive(tagOctetString, name), set))
+	}
+	return constructed(appSearchEntry, primitive(tagOctetString, entry.DN), attrs)
+}
+
+// attributeValues 属性名不区分大小写
+func attributeValues(entry *Entry, name string) []string {
+	for key, values := range entry.Attributes {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// matchFilter 判断条目是否符合BER编码的过滤条件，值的比较不区分大小写
+func matchFilter(f *element, entry *Entry) bool {
+	switch f.tag {
+	case filterAnd:
+		for _, child := range f.children {
+			if !matchFilter(child, entry) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, child := range f.children {
+			if matchFilter(child, entry) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return len(f.children) == 1 && !matchFilter(f.children[0], entry)
+	case filterPresent:
+		return len(attributeValues(entry, string(f.value))) > 0
+	case filterEquality, filterApprox:
+		if len(f.children) != 2 {
+			return false
+		}
+		for _, value := range attributeValues(entry, string(f.children[0].value)) {
+			if strings.EqualFold(value, string(f.children[1].value)) {
+				return true
+			}
+		}
+		return false
+	case filterSubstrings:
+		if len(f.children) != 2 {
+			return false
+		}
+		for _, value := range attributeValues(entry, string(f.children[0].value)) {
+			if matchSubstrings(strings.ToLower(value), f.children[1].children) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+func matchSubstrings(value string, parts []*element) bool {
+	for _, part := range parts {
+		sub := strings.ToLower(string(part.value))
+		switch part.tag {
+		case classContext: // initial
+			if !strings.HasPrefix(value, sub) {
+				return false
+			}
+			value = value[len(sub):]
+		case classContext | 1: // any
+			i := strings.Index(value, sub)
+			if i < 0 {
+				return false
+			}
+			value = value[i+len(sub):]
+		case classContext | 2: // final
+			if !strings.HasSuffix(value, sub) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// formatFilter 将BER编码的过滤条件还原为字符串，值中的特殊字符按RFC 4515转义
+func formatFilter(f *element) string {
+	switch f.tag {
+	case filterAnd, filterOr, filterNot:
+		op := map[byte]string{filterAnd: "&", filterOr: "|", filterNot: "!"}[f.tag]
+		var b strings.Builder
+		b.WriteString("(" + op)
+		for _, child := range f.children {
+			b.WriteString(formatFilter(child))
+		}
+		return b.String() + ")"
+	case filterPresent:
+		return "(" + string(f.value) + "=*)"
+	case filterEquality, filterGreaterOrEqual, filterLessOrEqual, filterApprox:
+		if len(f.children) != 2 {
+			return "(?)"
+		}
+		op := map[byte]string{filterEquality: "=", filterGreaterOrEqual: ">=", filterLessOrEqual: "<=", filterApprox: "~="}[f.tag]
+		return "(" + string(f.children[0].value) + op + escape(string(f.children[1].value)) + ")"
+	case filterSubstrings:
+		if len(f.children) != 2 {
+			return "(?)"
+		}
+		value := ""
+		for _, part := range f.children[1].children {
+			switch part.tag {
+			case classContext:
+				value += escape(string(part.value)) + "*"
+			case classContext | 1:
+				value += "*" + escape(string(part.value)) + "*"
+			case classContext | 2:
+				value += "*" + escape(string(part.value))
+			}
+		}
+		value = strings.ReplaceAll(value, "**", "*")
+		return "(" + string(f.children[0].value) + "=" + value + ")"
+	}
+	return "(?)"
+}
+
+func escape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// result 编码LDAPResult形式的响应
+func result(tag byte, code int, message string) *element {
+	return constructed(tag,
+		&element{tag: tagEnumerated, value: []byte{byte(code)}},
+		primitive(tagOctetString, ""),
+		primitive(tagOctetString, message),
+	)
+}
+
+// BER标识字节
+const (
+	tagInteger     byte = 0x02
+	tagOctetString byte = 0x04
+	tagEnumerated  byte = 0x0a
+	tagSequence    byte = 0x30
+	tagSet         byte = 0x31
+	classContext   byte = 0x80
+	isConstructed  byte = 0x20
+
+	appBindRequest      byte = 0x60
+	appBindResponse     byte = 0x61
+	appUnbindRequest    byte = 0x42
+	appSearchRequest    byte = 0x63
+	appSearchEntry      byte = 0x64
+	appSearchDone       byte = 0x65
+	appExtendedRequest  byte = 0x77
+	appExtendedResponse byte = 0x78
+
+	filterAnd            byte = 0xa0
+	filterOr             byte = 0xa1
+	filterNot            byte = 0xa2
+	filterEquality       byte = 0xa3
+	filterSubstrings     byte = 0xa4
+	filterGreaterOrEqual byte = 0xa5
+	filterLessOrEqual    byte = 0xa6
+	filterPresent        byte = 0x87
+	filterApprox         byte = 0xa8
+)
+
+// element BER编码的数据元素
+type element struct {
+	tag      byte
+	value    []byte
+	children []*element
+}
+
+func primitive(tag byte, value string) *element {
+	return &element{tag: tag, value: []byte(value)}
+}
+
+func constructed(tag byte, children ...*element) *element {
+	return &element{tag: tag | isConstructed, children: children}
+}
+
+func (e *element) bytes() []byte {
+	content := e.value
+	if e.tag&isConstructed != 0 {
+		content = nil
+		for _, child := range e.children {
+			content = append(content, child.bytes()...)
+		}
+	}
+	out := []byte{e.tag}
+	if len(content) < 0x80 {
+		out = append(out, byte(len(content)))
+	} else {
+		var length []byte
+		for v := len(content); v > 0; v >>= 8 {
+			length = append([]byte{byte(v)}, length...)
+		}
+		out = append(out, 0x80|byte(len(length)))
+		out = append(out, length...)
+	}
+	return append(out, content...)
+}
+
+func readElement(r *bufio.Reader) (*element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := int(first)
+	if first >= 0x80 {
+		length = 0
+		for i := 0; i < int(first&0x7f); i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return parseElement(tag, content)
+}
+
+func parseElement(tag byte, content []byte) (*element, error) {
+	e := &element{tag: tag}
+	if tag&isConstructed == 0 {
+		e.value = content
+		return e, nil
+	}
+	for len(content) > 0 {
+		if len(content) < 2 {
+			return nil, errors.New("ldaptest: truncated element")
+		}
+		childTag, first := content[0], content[1]
+		length, start := int(first), 2
+		if first >= 0x80 {
+			n := int(first & 0x7f)
+			if len(content) < 2+n {
+				return nil, errors.New("ldaptest: truncated element")
+			}
+			length = 0
+			for _, b := range content[2 : 2+n] {
+				length = length<<8 | int(b)
+			}
+			start += n
+		}
+		if length > len(content)-start {
+			return nil, errors.New("ldaptest: truncated element")
+		}
+		child, err := parseElement(childTag, content[start:start+length])
+		if err != nil {
+			return nil, err
+		}
+		e.children = append(e.children, child)
+		content = content[start+length:]
+	}
+	return e, nil
+}
+
+func intValue(value []byte) int64 {
+	if len(value) == 0 {
+		return 0
+	}
+	v := int64(int8(value[0]))
+	for _, b := range value[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v
+}
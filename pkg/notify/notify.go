@@ -0,0 +1,77 @@
+// Package notify 发送验证码等通知。邮件通过SMTP发送，短信通过短信网关发送；
+// Memory只把消息保存在内存中，用于测试和未接入邮件、短信服务的开发环境。
+package notify
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidRecipient 收件人为空或含有换行等非法字符
+var ErrInvalidRecipient = errors.New("notify: invalid recipient")
+
+// Message 一条通知
+type Message struct {
+	To      string // 邮箱地址或手机号
+	Subject string // 邮件主题，短信不使用
+	Body    string
+}
+
+// Notifier 通知发送方式。实现需要可以被多个goroutine同时使用
+type Notifier interface {
+	Send(msg Message) error
+}
+
+// validHeader 收件人和主题不能含有换行，防止注入邮件头
+func validHeader(value string) bool {
+	return !strings.ContainsAny(value, "\r\n")
+}
+
+// Memory 把消息保存在内存中的通知方式
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemory 创建内存通知方式
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Send 保存消息
+func (m *Memory) Send(msg Message) error {
+	if msg.To == "" || !validHeader(msg.To) {
+		return ErrInvalidRecipient
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages 按发送顺序返回已保存的消息
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Last 返回发送给指定收件人的最后一条消息
+func (m *Memory) Last(to string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+// Reset 清空已保存的消息
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPSMSOptions 短信网关配置
+type HTTPSMSOptions struct {
+	URL     string // 网关地址
+	Token   string // 以 Authorization: Bearer 请求头发送，为空时不发送
+	Timeout time.Duration
+}
+
+// HTTPSMS 通过HTTP短信网关发送短信：以JSON格式POST {"phone": "...", "content": "..."}，返回2xx表示成功。
+// 医院一般通过统一的短信平台或在内网部署转发服务对接运营商和云短信服务，
+// 需要直接对接云厂商接口时可以另外实现Notifier
+type HTTPSMS struct {
+	opts   HTTPSMSOptions
+	client *http.Client
+}
+
+// NewHTTPSMS 创建短信通知方式
+func NewHTTPSMS(opts HTTPSMSOptions) *HTTPSMS {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	return &HTTPSMS{opts: opts, client: &http.Client{Timeout: opts.Timeout}}
+}
+
+// Send 发送短信，Subject不使用
+func (s *HTTPSMS) Send(msg Message) error {
+	if msg.To == "" || !validHeader(msg.To) {
+		return ErrInvalidRecipient
+	}
+	payload, err := json.Marshal(map[string]string{"phone": msg.To, "content": msg.Body})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.opts.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.opts.Token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("notify: sms gateway returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTP连接的加密方式
+const (
+	SMTPSecurityTLS      = "tls"      // 连接后直接TLS握手，一般使用465端口
+	SMTPSecurityStartTLS = "starttls" // 明文连接后升级为TLS，一般使用587端口
+	SMTPSecurityNone     = "none"     // 不加密，只用于内网邮件中继
+)
+
+// SMTPOptions SMTP服务器配置
+type SMTPOptions struct {
+	Host     string
+	Port     int
+	Username string // 为空时不认证
+	Password string
+	From     string // 发件人，例如 考试系统 <exam@example.com>
+	Security string
+	Timeout  time.Duration
+}
+
+// SMTP 通过SMTP服务器发送邮件
+type SMTP struct {
+	opts SMTPOptions
+}
+
+// NewSMTP 创建SMTP邮件通知方式
+func NewSMTP(opts SMTPOptions) *SMTP {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	return &SMTP{opts: opts}
+}
+
+// Send 发送纯文本邮件
+func (s *SMTP) Send(msg Message) error {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil || !validHeader(msg.To) {
+		return ErrInvalidRecipient
+	}
+	from, err := mail.ParseAddress(s.opts.From)
+	if err != nil {
+		return fmt.Errorf("notify: invalid sender: %w", err)
+	}
+	if !validHeader(msg.Subject) {
+		return errors.New("notify: invalid subject")
+	}
+
+	client, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if s.opts.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.opts.Username, s.opts.Password, s.opts.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(buildMail(from, to, msg)); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// dial 按加密方式连接SMTP服务器
+func (s *SMTP) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.opts.Host, strconv.Itoa(s.opts.Port))
+	dialer := &net.Dialer{Timeout: s.opts.Timeout}
+	tlsConfig := &tls.Config{ServerName: s.opts.Host, MinVersion: tls.VersionTLS12}
+
+	var conn net.Conn
+	var err error
+	if s.opts.Security == SMTPSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 整个发送过程的超时时间
+	if err := conn.SetDeadline(time.Now().Add(s.opts.Timeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client, err := smtp.NewClient(conn, s.opts.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if s.opts.Security == SMTPSecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("notify: smtp server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// buildMail 生成邮件内容：中文主题按RFC 2047编码，正文为base64编码的UTF-8纯文本
+func buildMail(from, to *mail.Address, msg Message) []byte {
+	var b bytes.Buffer
+	header := func(name, value string) {
+		b.WriteString(name + ": " + value + "\r\n")
+	}
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", messageID(from.Address))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=UTF-8")
+	header("Content-Transfer-Encoding", "base64")
+	b.WriteString("\r\n")
+
+	body := base64.StdEncoding.EncodeToString([]byte(msg.Body))
+	for len(body) > 76 {
+		b.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	b.WriteString(body + "\r\n")
+	return b.Bytes()
+}
+
+// messageID 生成邮件ID，域名取发件人地址的域名
+func messageID(from string) string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	return "<" + hex.EncodeToString(buf) + "@" + domain + ">"
+}
@@ -0,0 +1,401 @@
+// Package oidc 实现OpenID Connect依赖方的授权码流程（RFC 6749、RFC 7636 PKCE、OpenID Connect Core 1.0），
+// 用于对接区域卫生信息平台等统一身份认证服务。ID令牌的签名校验使用golang-jwt，签名密钥从JWKS地址获取并缓存。
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// 校验ID令牌时允许的时钟误差
+const clockSkew = time.Minute
+
+// keyRefreshInterval 遇到未知密钥ID时重新获取JWKS的最短间隔，防止伪造的令牌频繁触发请求
+const keyRefreshInterval = time.Minute
+
+// maxResponseSize 发现文档、JWKS和令牌响应的长度上限
+const maxResponseSize = 1 << 20
+
+// signingMethods 接受的ID令牌签名算法，不接受none和HMAC
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// ErrInvalidIDToken ID令牌校验失败
+var ErrInvalidIDToken = errors.New("oidc: invalid id token")
+
+// Config 依赖方（本系统）在认证服务中注册的客户端信息
+type Config struct {
+	ClientID     string
+	ClientSecret string // 公共客户端留空，只使用PKCE
+	RedirectURL  string
+	Scopes       []string // 不含openid时自动添加
+}
+
+// Token 令牌端点返回的令牌
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// Claims ID令牌中的声明
+type Claims map[string]interface{}
+
+// String 读取字符串类型的声明，不存在或类型不符时返回空字符串
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings 读取字符串或字符串数组类型的声明，例如roles、groups
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Provider 认证服务（OpenID提供方），由发现文档初始化
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+// Discover 读取 {issuer}/.well-known/openid-configuration 发现文档，文档中的issuer必须与配置一致
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Provider, error) {
+	issuer = strings.TrimRight(issuer, "/")
+	p := &Provider{client: client}
+	if err := p.getJSON(ctx, issuer+"/.well-known/openid-configuration", p); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc: issuer mismatch, expected %q got %q", issuer, p.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+	return p, nil
+}
+
+// AuthCodeURL 生成跳转到认证服务的授权地址，codeChallenge由Challenge根据code_verifier计算
+func (p *Provider) AuthCodeURL(cfg *Config, state, nonce, codeChallenge string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", cfg.ClientID)
+	params.Set("redirect_uri", cfg.RedirectURL)
+	params.Set("scope", strings.Join(scopes(cfg.Scopes), " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + params.Encode()
+}
+
+// Exchange 用授权码和code_verifier换取令牌
+func (p *Provider) Exchange(ctx context.Context, cfg *Config, code, codeVerifier string) (*Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", cfg.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if cfg.ClientSecret == "" {
+		form.Set("client_id", cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if cfg.ClientSecret != "" {
+		// client_secret_basic：客户端ID和密钥先按表单编码（RFC 6749 2.3.1节）
+		req.SetBasicAuth(url.QueryEscape(cfg.ClientID), url.QueryEscape(cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.Unmarshal(body, &failure)
+		return nil, fmt.Errorf("oidc: token endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(failure.Error+" "+failure.Description))
+	}
+
+	var token Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+	return &token, nil
+}
+
+// VerifyIDToken 校验ID令牌的签名、签发方、受众、有效期和nonce，通过后返回声明
+func (p *Provider) VerifyIDToken(ctx context.Context, cfg *Config, rawIDToken, nonce string, now time.Time) (Claims, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods(signingMethods), jwt.WithoutClaimsValidation())
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	switch {
+	case !claims.VerifyIssuer(p.Issuer, true):
+		return nil, fmt.Errorf("%w: issuer mismatch", ErrInvalidIDToken)
+	case !claims.VerifyAudience(cfg.ClientID, true):
+		return nil, fmt.Errorf("%w: audience mismatch", ErrInvalidIDToken)
+	case !claims.VerifyExpiresAt(now.Add(-clockSkew).Unix(), true):
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidIDToken)
+	case !claims.VerifyNotBefore(now.Add(clockSkew).Unix(), false):
+		return nil, fmt.Errorf("%w: token not yet valid", ErrInvalidIDToken)
+	}
+
+	// 存在多个受众时授权方（azp）必须是本系统
+	if azp, ok := claims["azp"].(string); ok && azp != cfg.ClientID {
+		return nil, fmt.Errorf("%w: authorized party mismatch", ErrInvalidIDToken)
+	}
+	tokenNonce, _ := claims["nonce"].(string)
+	if subtle.ConstantTimeCompare([]byte(tokenNonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("%w: missing subject", ErrInvalidIDToken)
+	}
+
+	return Claims(claims), nil
+}
+
+// key 按密钥ID查找签名公钥，缓存中没有时重新获取JWKS（支持认证服务轮换密钥）
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	if !p.keysFetched.IsZero() && time.Since(p.keysFetched) < keyRefreshInterval {
+		return nil, fmt.Errorf("oidc: unknown key id %q", kid)
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	p.keysFetched = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown key id %q", kid)
+}
+
+// lookupKey 令牌未指定密钥ID时只在JWKS中恰好有一个密钥的情况下使用该密钥
+func (p *Provider) lookupKey(kid string) interface{} {
+	if kid != "" {
+		return p.keys[kid]
+	}
+	if len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// jsonWebKey JWKS中的一个密钥（RFC 7517），只使用签名用的RSA和EC密钥
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys 获取并解析JWKS
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("oidc: no usable signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey 将JWK转换为公钥
+func (k *jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("oidc: invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("oidc: unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("oidc: ec point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("oidc: unsupported key type %q", k.Kty)
+}
+
+// getJSON 请求地址并解析JSON响应
+func (p *Provider) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s returned %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+// GenerateVerifier 生成PKCE的code_verifier（32字节随机数，Base64URL编码后43个字符）
+func GenerateVerifier() (string, error) {
+	return randomString(32)
+}
+
+// GenerateState 生成state或nonce参数
+func GenerateState() (string, error) {
+	return randomString(32)
+}
+
+// Challenge 按S256方法计算code_challenge
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// decodeBigInt 解码Base64URL编码的大端整数
+func decodeBigInt(s string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil || len(buf) == 0 {
+		return nil, errors.New("oidc: invalid key parameter")
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
+
+// scopes 确保请求的范围包含openid
+func scopes(configured []string) []string {
+	for _, scope := range configured {
+		if scope == "openid" {
+			return configured
+		}
+	}
+	return append([]string{"openid"}, configured...)
+}
@@ -0,0 +1,264 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/hangbin2008/sanjicms/pkg/oidc/oidctest"
+)
+
+const testClientID = "exam-system"
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server, *Config) {
+	t.Helper()
+	server := oidctest.NewServer(testClientID)
+	t.Cleanup(server.Close)
+
+	provider, err := Discover(context.Background(), http.DefaultClient, server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{ClientID: testClientID, RedirectURL: "https://exam.example.com/auth/oidc/callback", Scopes: []string{"profile"}}
+	return provider, server, cfg
+}
+
+// login 走一遍授权码流程，返回令牌端点签发的ID令牌
+func login(t *testing.T, provider *Provider, server *oidctest.Server, cfg *Config, nonce string) string {
+	t.Helper()
+	verifier, err := GenerateVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := server.Authorize(provider.AuthCodeURL(cfg, "state-1", nonce, Challenge(verifier)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "state-1" {
+		t.Fatalf("state = %q", state)
+	}
+	token, err := provider.Exchange(context.Background(), cfg, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.IDToken
+}
+
+func TestDiscover(t *testing.T) {
+	provider, server, _ := newTestProvider(t)
+
+	if provider.Issuer != server.URL || provider.TokenEndpoint != server.URL+"/token" || provider.JWKSURI != server.URL+"/jwks" {
+		t.Errorf("unexpected provider %+v", provider)
+	}
+}
+
+func TestDiscoverRejectsIssuerMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"issuer":"https://evil.example.com","authorization_endpoint":"a","token_endpoint":"b","jwks_uri":"c"}`))
+	}))
+	defer server.Close()
+
+	if _, err := Discover(context.Background(), http.DefaultClient, server.URL); err == nil || !strings.Contains(err.Error(), "issuer mismatch") {
+		t.Fatalf("got %v, want issuer mismatch", err)
+	}
+}
+
+func TestDiscoverRejectsIncompleteDocument(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"issuer":"` + server.URL + `","authorization_endpoint":"a"}`))
+	}))
+	defer server.Close()
+
+	if _, err := Discover(context.Background(), http.DefaultClient, server.URL); err == nil {
+		t.Fatal("expected error for incomplete discovery document")
+	}
+}
+
+// RFC 7636 附录B的示例
+func TestChallenge(t *testing.T) {
+	if got := Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Challenge = %s", got)
+	}
+	verifier, _ := GenerateVerifier()
+	if len(verifier) != 43 {
+		t.Errorf("verifier length = %d, want 43", len(verifier))
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	provider, _, cfg := newTestProvider(t)
+
+	u, err := url.Parse(provider.AuthCodeURL(cfg, "s", "n", "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          cfg.RedirectURL,
+		"scope":                 "openid profile",
+		"state":                 "s",
+		"nonce":                 "n",
+		"code_challenge":        "c",
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if q.Get(name) != value {
+			t.Errorf("%s = %q, want %q", name, q.Get(name), value)
+		}
+	}
+}
+
+func TestExchangeAndVerify(t *testing.T) {
+	provider, server, cfg := newTestProvider(t)
+	server.Claims["name"] = "张三"
+	server.Claims["roles"] = []string{"exam-admin", "doctor"}
+
+	idToken := login(t, provider, server, cfg, "nonce-1")
+	claims, err := provider.VerifyIDToken(context.Background(), cfg, idToken, "nonce-1", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.String("sub") != server.Subject || claims.String("name") != "张三" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+	if roles := claims.Strings("roles"); len(roles) != 2 || roles[0] != "exam-admin" {
+		t.Errorf("roles = %v", roles)
+	}
+}
+
+func TestExchangeWithClientSecret(t *testing.T) {
+	provider, server, cfg := newTestProvider(t)
+	server.ClientSecret = "s3cret&+"
+	cfg.ClientSecret = "s3cret&+"
+
+	login(t, provider, server, cfg, "nonce-1")
+
+	cfg.ClientSecret = "wrong"
+	verifier, _ := GenerateVerifier()
+	code, _, err := server.Authorize(provider.AuthCodeURL(cfg, "s", "n", Challenge(verifier)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Exchange(context.Background(), cfg, code, verifier); err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Fatalf("got %v, want invalid_client", err)
+	}
+}
+
+// 令牌端点校验PKCE：code_verifier与授权请求的code_challenge不对应时拒绝
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	provider, server, cfg := newTestProvider(t)
+
+	verifier, _ := GenerateVerifier()
+	other, _ := GenerateVerifier()
+	code, _, err := server.Authorize(provider.AuthCodeURL(cfg, "s", "n", Challenge(verifier)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Exchange(context.Background(), cfg, code, other); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("got %v, want invalid_grant", err)
+	}
+}
+
+// 授权码只能使用一次
+func TestExchangeCodeSingleUse(t *testing.T) {
+	provider, server, cfg := newTestProvider(t)
+
+	verifier, _ := GenerateVerifier()
+	code, _, err := server.Authorize(provider.AuthCodeURL(cfg, "s", "n", Challenge(verifier)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Exchange(context.Background(), cfg, code, verifier); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Exchange(context.Background(), cfg, code, verifier); err == nil {
+		t.Fatal("expected authorization code replay to fail")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name   string
+		mutate func(claims jwt.MapClaims)
+		want   string
+	}{
+		{"audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }, "audience mismatch"},
+		{"audience list without client", func(c jwt.MapClaims) { c["aud"] = []string{"a", "b"} }, "audience mismatch"},
+		{"azp", func(c jwt.MapClaims) { c["aud"] = []string{testClientID, "other-client"}; c["azp"] = "other-client" }, "authorized party mismatch"},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = now.Add(-2 * time.Minute).Unix() }, "token expired"},
+		{"missing exp", func(c jwt.MapClaims) { delete(c, "exp") }, "token expired"},
+		{"not yet valid", func(c jwt.MapClaims) { c["nbf"] = now.Add(5 * time.Minute).Unix() }, "not yet valid"},
+		{"issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, "issuer mismatch"},
+		{"nonce", func(c jwt.MapClaims) { c["nonce"] = "other-nonce" }, "nonce mismatch"},
+		{"missing nonce", func(c jwt.MapClaims) { delete(c, "nonce") }, "nonce mismatch"},
+		{"missing subject", func(c jwt.MapClaims) { delete(c, "sub") }, "missing subject"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			provider, server, cfg := newTestProvider(t)
+			server.Mutate = c.mutate
+
+			idToken := login(t, provider, server, cfg, "nonce-1")
+			_, err := provider.VerifyIDToken(context.Background(), cfg, idToken, "nonce-1", now)
+			if !errors.Is(err, ErrInvalidIDToken) || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("got %v, want %s", err, c.want)
+			}
+		})
+	}
+}
+
+// 允许一分钟的时钟误差
+func TestVerifyIDTokenClockSkew(t *testing.T) {
+	provider, server, cfg := newTestProvider(t)
+	now := time.Now()
+	server.Mutate = func(c jwt.MapClaims) { c["exp"] = now.Add(-30 * time.Second).Unix() }
+
+	idToken := login(t, provider, server, cfg, "nonce-1")
+	if _, err := provider.VerifyIDToken(context.Background(), cfg, idToken, "nonce-1", now); err != nil {
+		t.Fatalf("token within clock skew rejected: %v", err)
+	}
+}
+
+func TestVerifyIDTokenRejectsUnsignedAndHMAC(t *testing.T) {
+	provider, server, cfg := newTestProvider(t)
+	claims := jwt.MapClaims{
+		"iss": server.URL, "aud": testClientID, "sub": "subject-1", "nonce": "n",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	hmac, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	for name, token := range map[string]string{"none": none, "HS256": hmac} {
+		if _, err := provider.VerifyIDToken(context.Background(), cfg, token, "n", time.Now()); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: got %v, want ErrInvalidIDToken", name, err)
+		}
+	}
+
+	// 正确签名的同一组声明可以通过
+	if _, err := provider.VerifyIDToken(context.Background(), cfg, server.Sign(claims), "n", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyIDTokenRejectsUnknownKey(t *testing.T) {
+	provider, server, cfg := newTestProvider(t)
+	other := oidctest.NewServer(testClientID)
+	defer other.Close()
+
+	// 另一个提供方的密钥ID相同但密钥不同，签名校验失败
+	claims := jwt.MapClaims{"iss": server.URL, "aud": testClientID, "sub": "s", "nonce": "n", "exp": time.Now().Add(time.Minute).Unix()}
+	if _, err := provider.VerifyIDToken(context.Background(), cfg, other.Sign(claims), "n", time.Now()); !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("got %v, want ErrInvalidIDToken", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Package pdf 提供不依赖第三方库的最小化PDF生成功能。
+// 中文使用PDF阅读器内置的Adobe CJK字体（STSong-Light），无需嵌入字体文件。
+// 相同的输入总是生成完全相同的字节，便于证书等文档重复打印时保持一致。
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// A4纸尺寸（单位：point）
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Document PDF文档
+type Document struct {
+	title   string
+	author  string
+	created time.Time
+	pages   []*Page
+}
+
+// Page PDF页面，坐标原点位于页面左上角，y轴向下
+type Page struct {
+	content bytes.Buffer
+}
+
+// New 创建PDF文档
+func New() *Document {
+	return &Document{}
+}
+
+// SetInfo 设置文档信息，created为空时不写入创建时间
+func (d *Document) SetInfo(title, author string, created time.Time) {
+	d.title = title
+	d.author = author
+	d.created = created
+}
+
+// AddPage 添加A4页面
+func (d *Document) AddPage() *Page {
+	page := &Page{}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// Text 在(x, y)处输出一行文字，y为文字基线到页面顶部的距离
+func (p *Page) Text(x, y, size float64, text string) {
+	fmt.Fprintf(&p.content, "BT /F1 %s Tf %s %s Td <%s> Tj ET\n",
+		num(size), num(x), num(PageHeight-y), encodeText(text))
+}
+
+// TextCenter 以x为中心输出一行文字
+func (p *Page) TextCenter(x, y, size float64, text string) {
+	p.Text(x-TextWidth(text, size)/2, y, size, text)
+}
+
+// Line 绘制直线
+func (p *Page) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s m %s %s l S\n",
+		num(width), num(x1), num(PageHeight-y1), num(x2), num(PageHeight-y2))
+}
+
+// Rect 绘制矩形边框
+func (p *Page) Rect(x, y, w, h, width float64) {
+	fmt.Fprintf(&p.content, "%s w %s %s %s %s re S\n",
+		num(width), num(x), num(PageHeight-y-h), num(w), num(h))
+}
+
+// TextWidth 估算文字宽度：中文等全角字符占1个字号宽度，ASCII字符占半个字号宽度
+func TextWidth(text string, size float64) float64 {
+	var width float64
+	for _, r := range text {
+		if r < 0x80 {
+			width += size / 2
+		} else {
+			width += size
+		}
+	}
+	return width
+}
+
+// Write 将文档以PDF格式写入w
+func (d *Document) Write(w io.Writer) error {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+
+	// 对象编号：1-Catalog，2-Pages，3-Type0字体，4-CID字体，5-字体描述，6-Info，之后依次为页面和内容流
+	newObj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	pageObjStart := 7
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageObjStart+i*2)
+	}
+
+	newObj("<< /Type /Catalog /Pages 2 0 R >>")
+	newObj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	newObj("<< /Type /Font /Subtype /Type0 /BaseFont /STSong-Light /Encoding /UniGB-UCS2-H /DescendantFonts [4 0 R] >>")
+	newObj("<< /Type /Font /Subtype /CIDFontType0 /BaseFont /STSong-Light " +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (GB1) /Supplement 2 >> " +
+		"/FontDescriptor 5 0 R /DW 1000 /W [1 95 500] >>")
+	newObj("<< /Type /FontDescriptor /FontName /STSong-Light /Flags 6 /FontBBox [-25 -254 1000 880] " +
+		"/ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>")
+
+	info := fmt.Sprintf("<< /Producer <%s> /Title <%s> /Author <%s>", infoText("sanjicms"), infoText(d.title), infoText(d.author))
+	if !d.created.IsZero() {
+		info += fmt.Sprintf(" /CreationDate (D:%s)", d.created.Format("20060102150405"))
+	}
+	newObj(info + " >>")
+
+	for i, page := range d.pages {
+		newObj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] "+
+			"/Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			num(PageWidth), num(PageHeight), pageObjStart+i*2+1))
+		newObj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.content.Len(), page.content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 6 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// encodeText 将文字编码为UCS-2大端序的十六进制字符串
+func encodeText(text string) string {
+	var b strings.Builder
+	for _, u := range utf16.Encode([]rune(text)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	return b.String()
+}
+
+// infoText 将文档信息编码为带BOM的UTF-16大端序十六进制字符串
+func infoText(text string) string {
+	return "FEFF" + encodeText(text)
+}
+
+func num(f float64) string {
+	s := fmt.Sprintf("%.2f", f)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
@@ -0,0 +1,216 @@
+// Package redis 提供不依赖第三方库的最小化Redis客户端（RESP2协议），用于在多个实例之间共享短期数据。
+// 只支持逐条发送命令，连接放在固定大小的连接池中复用，兼容Redis和实现了Redis协议的服务。
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBulkLength 单个回复字符串的最大长度，防止异常的服务端耗尽内存
+const maxBulkLength = 16 << 20
+
+// Error Redis服务端返回的错误回复，例如 "WRONGTYPE ..."；出现这种错误后连接仍可继续使用
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// Options 连接配置
+type Options struct {
+	Addr     string // host:port
+	Password string
+	DB       int
+	Timeout  time.Duration // 连接和每条命令的超时时间
+	PoolSize int           // 空闲连接的最大数量
+}
+
+// Client Redis客户端，可以被多个goroutine同时使用
+type Client struct {
+	opts Options
+	pool chan *conn
+}
+
+type conn struct {
+	netConn net.Conn
+	reader  *bufio.Reader
+}
+
+// NewClient 创建客户端，首次执行命令时才建立连接
+func NewClient(opts Options) *Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 10
+	}
+	return &Client{opts: opts, pool: make(chan *conn, opts.PoolSize)}
+}
+
+// Do 执行一条命令并返回回复：简单字符串和批量字符串为string，整数为int64，
+// 数组为[]interface{}，空回复为nil；服务端错误回复返回Error
+func (c *Client) Do(args ...string) (interface{}, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.do(c.opts.Timeout, args)
+	if err != nil {
+		var serverErr Error
+		if !errors.As(err, &serverErr) {
+			// 网络或协议错误后连接状态未知，不再放回连接池
+			cn.netConn.Close()
+			return nil, err
+		}
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Int 执行返回整数的命令
+func (c *Client) Int(args ...string) (int64, error) {
+	reply, err := c.Do(args...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis: unexpected reply %T", reply)
+	}
+	return n, nil
+}
+
+// Close 关闭连接池中的空闲连接
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.pool:
+			cn.netConn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// get 从连接池取出空闲连接，没有时新建连接
+func (c *Client) get() (*conn, error) {
+	select {
+	case cn := <-c.pool:
+		return cn, nil
+	default:
+	}
+
+	netConn, err := net.DialTimeout("tcp", c.opts.Addr, c.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{netConn: netConn, reader: bufio.NewReader(netConn)}
+	if c.opts.Password != "" {
+		if _, err := cn.do(c.opts.Timeout, []string{"AUTH", c.opts.Password}); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err := cn.do(c.opts.Timeout, []string{"SELECT", strconv.Itoa(c.opts.DB)}); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// put 将连接放回连接池，连接池已满时关闭
+func (c *Client) put(cn *conn) {
+	select {
+	case c.pool <- cn:
+	default:
+		cn.netConn.Close()
+	}
+}
+
+// do 发送命令并读取一条回复
+func (cn *conn) do(timeout time.Duration, args []string) (interface{}, error) {
+	if err := cn.netConn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if _, err := io.WriteString(cn.netConn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(cn.reader)
+}
+
+// readReply 读取一条RESP2回复
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("redis: malformed reply")
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, Error(payload)
+	case ':':
+		n, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			return nil, errors.New("redis: malformed integer reply")
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < -1 || n > maxBulkLength {
+			return nil, errors.New("redis: malformed bulk reply")
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < -1 || n > 1<<16 {
+			return nil, errors.New("redis: malformed array reply")
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			// 数组中元素的错误回复（例如EXEC的结果）作为值返回
+			value, err := readReply(r)
+			var serverErr Error
+			if err != nil && !errors.As(err, &serverErr) {
+				return nil, err
+			}
+			if err != nil {
+				value = serverErr
+			}
+			values[i] = value
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+	}
+}
@@ -0,0 +1,206 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hangbin2008/sanjicms/pkg/redis/redistest"
+)
+
+func TestReadReply(t *testing.T) {
+	cases := []struct {
+		input string
+		want  interface{}
+	}{
+		{"+OK\r\n", "OK"},
+		{":42\r\n", int64(42)},
+		{":-1\r\n", int64(-1)},
+		{"$5\r\nhello\r\n", "hello"},
+		{"$0\r\n\r\n", ""},
+		{"$7\r\na\r\nb\r\nc\r\n", "a\r\nb\r\nc"},
+		{"$-1\r\n", nil},
+		{"*-1\r\n", nil},
+		{"*0\r\n", []interface{}{}},
+		{"*3\r\n$6\r\n123456\r\n:2\r\n$-1\r\n", []interface{}{"123456", int64(2), nil}},
+		{"*2\r\n*1\r\n+a\r\n-ERR inner\r\n", []interface{}{[]interface{}{"a"}, Error("ERR inner")}},
+	}
+	for _, c := range cases {
+		got, err := readReply(bufio.NewReader(strings.NewReader(c.input)))
+		if err != nil {
+			t.Errorf("%q: %v", c.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %#v, want %#v", c.input, got, c.want)
+		}
+	}
+}
+
+func TestReadReplyErrors(t *testing.T) {
+	_, err := readReply(bufio.NewReader(strings.NewReader("-WRONGTYPE Operation against a key\r\n")))
+	var serverErr Error
+	if !errors.As(err, &serverErr) || !strings.HasPrefix(string(serverErr), "WRONGTYPE") {
+		t.Fatalf("got %v, want server error", err)
+	}
+
+	for _, input := range []string{
+		"+OK\n",
+		"\r\n",
+		":abc\r\n",
+		"$-2\r\n",
+		"$99999999999\r\n",
+		"$5\r\nab\r\n",
+		"*x\r\n",
+		"*2\r\n:1\r\n",
+		"?what\r\n",
+		"",
+	} {
+		_, err := readReply(bufio.NewReader(strings.NewReader(input)))
+		if err == nil || errors.As(err, &serverErr) {
+			t.Errorf("%q: got %v, want protocol error", input, err)
+		}
+	}
+}
+
+func newTestClient(t *testing.T, opts Options) (*Client, *redistest.Server) {
+	t.Helper()
+	server := redistest.NewServer()
+	t.Cleanup(server.Close)
+	opts.Addr = server.Addr
+	opts.Timeout = 2 * time.Second
+	client := NewClient(opts)
+	t.Cleanup(func() { client.Close() })
+	return client, server
+}
+
+func TestClientCommands(t *testing.T) {
+	client, server := newTestClient(t, Options{})
+
+	if reply, err := client.Do("SET", "greeting", "你好 world"); err != nil || reply != "OK" {
+		t.Fatalf("SET: %v %v", reply, err)
+	}
+	if reply, err := client.Do("GET", "greeting"); err != nil || reply != "你好 world" {
+		t.Fatalf("GET: %#v %v", reply, err)
+	}
+	if reply, err := client.Do("GET", "missing"); err != nil || reply != nil {
+		t.Fatalf("GET missing: %#v %v", reply, err)
+	}
+	for want := int64(1); want <= 3; want++ {
+		if n, err := client.Int("INCR", "counter"); err != nil || n != want {
+			t.Fatalf("INCR: %d %v", n, err)
+		}
+	}
+	if _, err := client.Int("GET", "greeting"); err == nil {
+		t.Error("Int on a string reply should fail")
+	}
+
+	// 服务端错误回复后连接仍然可用，放回连接池
+	_, err := client.Do("HGET", "greeting", "field")
+	var serverErr Error
+	if !errors.As(err, &serverErr) || !strings.HasPrefix(string(serverErr), "WRONGTYPE") {
+		t.Fatalf("HGET on string: got %v", err)
+	}
+	if n, err := client.Int("DEL", "greeting", "counter", "missing"); err != nil || n != 2 {
+		t.Fatalf("DEL: %d %v", n, err)
+	}
+	if server.Connections() != 1 {
+		t.Errorf("sequential commands used %d connections, want 1", server.Connections())
+	}
+}
+
+func TestClientAuthAndSelect(t *testing.T) {
+	client, server := newTestClient(t, Options{Password: "s3cret", DB: 2})
+	server.SetPassword("s3cret")
+
+	if _, err := client.Do("SET", "key", "db2"); err != nil {
+		t.Fatal(err)
+	}
+	commands := server.Commands()
+	if len(commands) < 2 || !reflect.DeepEqual(commands[0], []string{"AUTH", "s3cret"}) ||
+		!reflect.DeepEqual(commands[1], []string{"SELECT", "2"}) {
+		t.Errorf("unexpected handshake %q", commands)
+	}
+
+	// 另一个使用默认数据库的客户端看不到该键
+	other := NewClient(Options{Addr: server.Addr, Password: "s3cret", Timeout: time.Second})
+	defer other.Close()
+	if reply, err := other.Do("GET", "key"); err != nil || reply != nil {
+		t.Errorf("DB 0 GET: %#v %v", reply, err)
+	}
+
+	wrong := NewClient(Options{Addr: server.Addr, Password: "wrong", Timeout: time.Second})
+	defer wrong.Close()
+	var serverErr Error
+	if _, err := wrong.Do("PING"); !errors.As(err, &serverErr) || !strings.HasPrefix(string(serverErr), "WRONGPASS") {
+		t.Fatalf("wrong password: got %v", err)
+	}
+
+	anonymous := NewClient(Options{Addr: server.Addr, Timeout: time.Second})
+	defer anonymous.Close()
+	if _, err := anonymous.Do("GET", "key"); !errors.As(err, &serverErr) || !strings.HasPrefix(string(serverErr), "NOAUTH") {
+		t.Fatalf("missing password: got %v", err)
+	}
+}
+
+// 网络错误后连接不再放回连接池，下一条命令重新建立连接
+func TestClientDropsBrokenConnection(t *testing.T) {
+	client, server := newTestClient(t, Options{})
+
+	if _, err := client.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	server.CloseConnections()
+	if _, err := client.Do("PING"); err == nil {
+		t.Fatal("expected error on a connection closed by the server")
+	}
+	if reply, err := client.Do("PING"); err != nil || reply != "PONG" {
+		t.Fatalf("after reconnect: %v %v", reply, err)
+	}
+	if server.Connections() != 2 {
+		t.Errorf("got %d connections, want 2", server.Connections())
+	}
+}
+
+func TestClientConcurrent(t *testing.T) {
+	client, server := newTestClient(t, Options{PoolSize: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Int("INCR", "hits"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if reply, err := client.Do("GET", "hits"); err != nil || reply != "20" {
+		t.Fatalf("hits = %#v %v", reply, err)
+	}
+	// 连接池最多保留2个空闲连接，之后的命令复用它们
+	before := server.Connections()
+	for i := 0; i < 5; i++ {
+		client.Do("PING")
+	}
+	if server.Connections() != before {
+		t.Errorf("idle connections were not reused")
+	}
+}
+
+func TestClientDialFailure(t *testing.T) {
+	server := redistest.NewServer()
+	addr := server.Addr
+	server.Close()
+
+	client := NewClient(Options{Addr: addr, Timeout: time.Second})
+	if _, err := client.Do("PING"); err == nil {
+		t.Fatal("expected dial error")
+	}
+}
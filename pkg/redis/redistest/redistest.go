@@ -0,0 +1,495 @@
+// Package redistest 提供用于测试的进程内Redis服务器（RESP2协议），支持字符串、哈希和键过期等常用命令。
+// 服务器不执行Lua：EVAL按脚本内容查找测试注册的ScriptFunc，由其通过call逐条执行命令，与redis.call对应。
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status 简单字符串回复，例如 OK
+type Status string
+
+// ScriptFunc EVAL执行的脚本，返回nil对应Lua的false，[]interface{}对应table
+type ScriptFunc func(call func(args ...string) (interface{}, error), keys, argv []string) (interface{}, error)
+
+// entry 一个键的值，str和hash只有一个非nil
+type entry struct {
+	str       *string
+	hash      map[string]string
+	expiresAt time.Time
+}
+
+// Server 进程内Redis服务器
+type Server struct {
+	Addr string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	now      func() time.Time
+	password string
+	dbs      map[int]map[string]*entry
+	scripts  map[string]ScriptFunc
+	commands [][]string
+	conns    map[net.Conn]bool
+	accepted int
+}
+
+// NewServer 启动监听本机随机端口的服务器，使用完毕后调用Close
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("redistest: failed to listen: %v", err))
+	}
+	s := &Server{
+		Addr:     listener.Addr().String(),
+		listener: listener,
+		now:      time.Now,
+		dbs:      map[int]map[string]*entry{},
+		scripts:  map[string]ScriptFunc{},
+		conns:    map[net.Conn]bool{},
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// Close 停止服务器并断开全部连接
+func (s *Server) Close() {
+	s.listener.Close()
+	s.CloseConnections()
+	s.wg.Wait()
+}
+
+// CloseConnections 断开全部客户端连接，模拟服务器重启或网络中断
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// SetNow 设置服务器时钟，用于测试键过期
+func (s *Server) SetNow(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// SetPassword 设置密码，非空时新连接要求先执行AUTH
+func (s *Server) SetPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+// Script 注册EVAL脚本的实现
+func (s *Server) Script(script string, fn ScriptFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[script] = fn
+}
+
+// Commands 返回收到的全部命令
+func (s *Server) Commands() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.commands...)
+}
+
+// Connections 返回接受的连接数
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// TTL 返回键的剩余有效期，键不存在时返回false，没有过期时间时返回0
+func (s *Server) TTL(db int, key string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(db, key)
+	if e == nil {
+		return 0, false
+	}
+	if e.expiresAt.IsZero() {
+		return 0, true
+	}
+	return e.expiresAt.Sub(s.now()), true
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.accepted++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// session 连接的状态
+type session struct {
+	authed bool
+	db     int
+}
+
+func (s *Server) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	s.mu.Lock()
+	state := &session{authed: s.password == ""}
+	s.mu.Unlock()
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, args)
+		reply, err := s.dispatch(state, args)
+		s.mu.Unlock()
+
+		var out strings.Builder
+		if err != nil {
+			out.WriteString("-" + err.Error() + "\r\n")
+		} else {
+			writeReply(&out, reply)
+		}
+		if _, err := io.WriteString(conn, out.String()); err != nil {
+			return
+		}
+	}
+}
+
+// dispatch 执行连接级命令，其余命令交给exec，调用时已持有锁
+func (s *Server) dispatch(state *session, args []string) (interface{}, error) {
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "AUTH":
+		if len(args) != 2 {
+			return nil, errArgs(name)
+		}
+		if s.password == "" || args[1] != s.password {
+			return nil, errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+		}
+		state.authed = true
+		return Status("OK"), nil
+	}
+	if !state.authed {
+		return nil, errors.New("NOAUTH Authentication required.")
+	}
+
+	switch name {
+	case "SELECT":
+		if len(args) != 2 {
+			return nil, errArgs(name)
+		}
+		db, err := strconv.Atoi(args[1])
+		if err != nil || db < 0 || db > 15 {
+			return nil, errors.New("ERR DB index is out of range")
+		}
+		state.db = db
+		return Status("OK"), nil
+	case "EVAL":
+		return s.eval(state.db, args)
+	}
+	return s.exec(state.db, args)
+}
+
+// eval 执行注册的脚本：EVAL script numkeys key... arg...
+func (s *Server) eval(db int, args []string) (interface{}, error) {
+	if len(args) < 3 {
+		return nil, errArgs("EVAL")
+	}
+	fn, ok := s.scripts[args[1]]
+	if !ok {
+		return nil, errors.New("ERR script not registered in redistest")
+	}
+	numKeys, err := strconv.Atoi(args[2])
+	if err != nil || numKeys < 0 || numKeys > len(args)-3 {
+		return nil, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	keys, argv := args[3:3+numKeys], args[3+numKeys:]
+	call := func(cmd ...string) (interface{}, error) {
+		return s.exec(db, cmd)
+	}
+	return fn(call, keys, argv)
+}
+
+// exec 执行数据命令，调用时已持有锁
+func (s *Server) exec(db int, args []string) (interface{}, error) {
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "PING":
+		return Status("PONG"), nil
+	case "GET":
+		if len(args) != 2 {
+			return nil, errArgs(name)
+		}
+		e := s.lookup(db, args[1])
+		if e == nil {
+			return nil, nil
+		}
+		if e.str == nil {
+			return nil, errWrongType
+		}
+		return *e.str, nil
+	case "SET":
+		// SET key value [PX milliseconds]
+		if len(args) != 3 && !(len(args) == 5 && strings.EqualFold(args[3], "PX")) {
+			return nil, errors.New("ERR syntax error")
+		}
+		value := args[2]
+		e := &entry{str: &value}
+		if len(args) == 5 {
+			ms, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil || ms <= 0 {
+				return nil, errors.New("ERR invalid expire time in 'set' command")
+			}
+			e.expiresAt = s.now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		s.keyspace(db)[args[1]] = e
+		return Status("OK"), nil
+	case "INCR":
+		if len(args) != 2 {
+			return nil, errArgs(name)
+		}
+		e := s.lookup(db, args[1])
+		if e == nil {
+			zero := "0"
+			e = &entry{str: &zero}
+			s.keyspace(db)[args[1]] = e
+		}
+		if e.str == nil {
+			return nil, errWrongType
+		}
+		n, err := strconv.ParseInt(*e.str, 10, 64)
+		if err != nil {
+			return nil, errors.New("ERR value is not an integer or out of range")
+		}
+		n++
+		value := strconv.FormatInt(n, 10)
+		e.str = &value
+		return n, nil
+	case "DEL", "EXISTS":
+		if len(args) < 2 {
+			return nil, errArgs(name)
+		}
+		var n int64
+		for _, key := range args[1:] {
+			if s.lookup(db, key) != nil {
+				n++
+				if name == "DEL" {
+					delete(s.keyspace(db), key)
+				}
+			}
+		}
+		return n, nil
+	case "PEXPIRE":
+		if len(args) != 3 {
+			return nil, errArgs(name)
+		}
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return nil, errors.New("ERR value is not an integer or out of range")
+		}
+		e := s.lookup(db, args[1])
+		if e == nil {
+			return int64(0), nil
+		}
+		e.expiresAt = s.now().Add(time.Duration(ms) * time.Millisecond)
+		return int64(1), nil
+	case "PTTL":
+		if len(args) != 2 {
+			return nil, errArgs(name)
+		}
+		e := s.lookup(db, args[1])
+		switch {
+		case e == nil:
+			return int64(-2), nil
+		case e.expiresAt.IsZero():
+			return int64(-1), nil
+		}
+		return e.expiresAt.Sub(s.now()).Milliseconds(), nil
+	case "HSET":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return nil, errArgs(name)
+		}
+		e, err := s.hash(db, args[1], true)
+		if err != nil {
+			return nil, err
+		}
+		var added int64
+		for i := 2; i < len(args); i += 2 {
+			if _, ok := e.hash[args[i]]; !ok {
+				added++
+			}
+			e.hash[args[i]] = args[i+1]
+		}
+		return added, nil
+	case "HGET":
+		if len(args) != 3 {
+			return nil, errArgs(name)
+		}
+		e, err := s.hash(db, args[1], false)
+		if err != nil || e == nil {
+			return nil, err
+		}
+		value, ok := e.hash[args[2]]
+		if !ok {
+			return nil, nil
+		}
+		return value, nil
+	case "HINCRBY":
+		if len(args) != 4 {
+			return nil, errArgs(name)
+		}
+		delta, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			return nil, errors.New("ERR value is not an integer or out of range")
+		}
+		e, err := s.hash(db, args[1], true)
+		if err != nil {
+			return nil, err
+		}
+		n, _ := strconv.ParseInt(e.hash[args[2]], 10, 64)
+		n += delta
+		e.hash[args[2]] = strconv.FormatInt(n, 10)
+		return n, nil
+	}
+	return nil, fmt.Errorf("ERR unknown command '%s'", args[0])
+}
+
+func (s *Server) keyspace(db int) map[string]*entry {
+	if s.dbs[db] == nil {
+		s.dbs[db] = map[string]*entry{}
+	}
+	return s.dbs[db]
+}
+
+// lookup 查找键，已过期的键在访问时删除
+func (s *Server) lookup(db int, key string) *entry {
+	e, ok := s.keyspace(db)[key]
+	if !ok {
+		return nil
+	}
+	if !e.expiresAt.IsZero() && !s.now().Before(e.expiresAt) {
+		delete(s.keyspace(db), key)
+		return nil
+	}
+	return e
+}
+
+// hash 查找哈希类型的键，create为true时不存在则创建
+func (s *Server) hash(db int, key string, create bool) (*entry, error) {
+	e := s.lookup(db, key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		e = &entry{hash: map[string]string{}}
+		s.keyspace(db)[key] = e
+	}
+	if e.hash == nil {
+		return nil, errWrongType
+	}
+	return e, nil
+}
+
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+func errArgs(name string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+}
+
+// readCommand 读取客户端发送的命令（RESP数组形式）
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 2 || line[0] != '*' {
+		return nil, errors.New("redistest: expected array")
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n <= 0 {
+		return nil, errors.New("redistest: invalid array length")
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 2 || line[0] != '$' {
+			return nil, errors.New("redistest: expected bulk string")
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, errors.New("redistest: invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// writeReply 按RESP2编码回复
+func writeReply(b *strings.Builder, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		b.WriteString("$-1\r\n")
+	case Status:
+		b.WriteString("+" + string(v) + "\r\n")
+	case string:
+		b.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case int64:
+		b.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case int:
+		b.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case error:
+		b.WriteString("-" + v.Error() + "\r\n")
+	case []interface{}:
+		b.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(b, item)
+		}
+	default:
+		writeReply(b, fmt.Sprint(v))
+	}
+}
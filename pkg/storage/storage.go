@@ -0,0 +1,119 @@
+// Package storage 保存用户上传的文件。文件按key（例如 avatars/12-ab12cd.jpg）存取，
+// 通过URL对外访问；默认保存在本地磁盘，需要时可以实现Storage接口接入对象存储。
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidKey key包含非法字符或试图访问存储目录以外的文件
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// Storage 文件存储
+type Storage interface {
+	// Put 保存文件，已存在时覆盖
+	Put(key string, r io.Reader) error
+	// Delete 删除文件，文件不存在时不返回错误
+	Delete(key string) error
+	// URL 文件的访问地址
+	URL(key string) string
+	// Key 从访问地址还原key，不是本存储中的文件时返回false
+	Key(url string) (string, bool)
+}
+
+// Local 本地磁盘存储，dir中的文件由Web服务以baseURL为前缀提供访问
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocal 创建本地磁盘存储，例如 NewLocal("./uploads", "/uploads")
+func NewLocal(dir, baseURL string) *Local {
+	return &Local{dir: dir, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Put 先写入临时文件再重命名，避免读取到写了一半的文件
+func (s *Local) Put(key string, r io.Reader) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// Delete 删除文件
+func (s *Local) Delete(key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// URL 文件的访问地址
+func (s *Local) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+// Key 从访问地址还原key
+func (s *Local) Key(url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, s.baseURL+"/")
+	if !ok || !validKey(key) {
+		return "", false
+	}
+	return key, true
+}
+
+// path key对应的本地文件路径
+func (s *Local) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// validKey key只能是由字母、数字、点、下划线和连字符组成的相对路径，不能包含 . 和 .. 路径段
+func validKey(key string) bool {
+	if key == "" || path.Clean(key) != key || strings.HasPrefix(key, "/") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "." || segment == ".." || strings.HasPrefix(segment, ".") {
+			return false
+		}
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			r == '/' || r == '.' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
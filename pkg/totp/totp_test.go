@@ -0,0 +1,105 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret RFC 6238 附录B中SHA1测试向量的密钥 "12345678901234567890" 的Base32编码
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 附录B的SHA1测试向量，验证码为8位，这里取后6位
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	for _, v := range rfcVectors {
+		code, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != v.code {
+			t.Errorf("T=%d: got %s, want %s", v.unix, code, v.code)
+		}
+	}
+}
+
+func TestValidateFixedClock(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	got, ok := Validate(rfcSecret, "050471", now, 1)
+	if !ok || got != step {
+		t.Fatalf("当前时间步的验证码应通过, got %d %v", got, ok)
+	}
+
+	// 前后一个时间步的时钟误差
+	previous, _ := Code(rfcSecret, step-1)
+	if got, ok := Validate(rfcSecret, previous, now, 1); !ok || got != step-1 {
+		t.Fatalf("上一个时间步的验证码应通过, got %d %v", got, ok)
+	}
+	next, _ := Code(rfcSecret, step+1)
+	if got, ok := Validate(rfcSecret, " "+next+" ", now, 1); !ok || got != step+1 {
+		t.Fatalf("下一个时间步的验证码应通过, got %d %v", got, ok)
+	}
+
+	// 超出误差范围
+	old, _ := Code(rfcSecret, step-2)
+	if _, ok := Validate(rfcSecret, old, now, 1); ok {
+		t.Fatal("两个时间步之前的验证码应被拒绝")
+	}
+	if _, ok := Validate(rfcSecret, previous, now, 0); ok {
+		t.Fatal("skew为0时只接受当前时间步")
+	}
+
+	for _, code := range []string{"", "05047", "0504711", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now, 1); ok {
+			t.Fatalf("%q 应被拒绝", code)
+		}
+	}
+	if _, ok := Validate("not base32!", "050471", now, 1); ok {
+		t.Fatal("无效密钥应被拒绝")
+	}
+}
+
+func TestSecretEncoding(t *testing.T) {
+	// 验证器应用可能显示小写、带空格或填充的密钥
+	code, err := Code(strings.ToLower("GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ===="), Step(time.Unix(59, 0)))
+	if err != nil || code != "287082" {
+		t.Fatalf("got %s %v", code, err)
+	}
+
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Fatalf("160位密钥的Base32编码应为32个字符, got %d", len(secret))
+	}
+	if _, err := Code(secret, 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("三级医院考试", "zhang san", rfcSecret)
+	want := "otpauth://totp/%E4%B8%89%E7%BA%A7%E5%8C%BB%E9%99%A2%E8%80%83%E8%AF%95:zhang%20san?"
+	if !strings.HasPrefix(uri, want) {
+		t.Fatalf("got %s", uri)
+	}
+	for _, param := range []string{"secret=" + rfcSecret, "issuer=", "algorithm=SHA1", "digits=6", "period=30"} {
+		if !strings.Contains(uri, param) {
+			t.Errorf("缺少参数 %s: %s", param, uri)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testdata/roster.xlsx 模拟Excel保存的花名册：第一个工作表不是sheet1.xml，
+// 表头使用共享字符串，姓名为多段格式化文本，手机号保存为科学计数法，缺少空单元格和空行
+func TestReadRowsFixture(t *testing.T) {
+	data, err := os.ReadFile("testdata/roster.xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ReadRows(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"工号", "姓名", "联系电话", "身份证", "部门", "职称"},
+		{"zhangsan", "张三", "13800138000", "11010519491231002X", "", "主治医师"},
+		nil,
+		{"", "李四", "13900139000"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %q\nwant %q", rows, want)
+	}
+}
+
+func TestReadRowsRoundTrip(t *testing.T) {
+	wb := NewWorkbook()
+	sheet := wb.AddSheet("成绩")
+	sheet.AddRow("姓名", "成绩", "学分", "交卷时间")
+	sheet.AddRow("张三 <A&B>", 95, 1.5, time.Date(2026, 10, 18, 9, 30, 0, 0, time.Local))
+	sheet.AddRow(nil, int64(0), "  前后空格  ")
+
+	var buf bytes.Buffer
+	if err := wb.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ReadRows(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"姓名", "成绩", "学分", "交卷时间"},
+		{"张三 <A&B>", "95", "1.5", "2026-10-18 09:30:00"},
+		{"", "0", "  前后空格  "},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got %q\nwant %q", rows, want)
+	}
+}
+
+func TestReadRowsInvalid(t *testing.T) {
+	if _, err := ReadRows(strings.NewReader("name,phone"), 10); err == nil {
+		t.Error("expected error for non-zip data")
+	}
+
+	cases := map[string]map[string]string{
+		"missing workbook": {"xl/worksheets/sheet1.xml": `<worksheet/>`},
+		"missing sheet":    {"xl/workbook.xml": `<workbook/>`},
+		"bad shared string index": {
+			"xl/workbook.xml":          `<workbook/>`,
+			"xl/sharedStrings.xml":     `<sst><si><t>a</t></si></sst>`,
+			"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row r="1"><c r="A1" t="s"><v>3</v></c></row></sheetData></worksheet>`,
+		},
+	}
+	for name, files := range cases {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for path, content := range files {
+			w, _ := zw.Create(path)
+			w.Write([]byte(content))
+		}
+		zw.Close()
+		if _, err := ReadRows(bytes.NewReader(buf.Bytes()), int64(buf.Len())); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	for ref, want := range map[string]int{"A1": 0, "Z9": 25, "AA10": 26, "AZ1": 51, "BA2": 52} {
+		if got, ok := columnIndex(ref); !ok || got != want {
+			t.Errorf("columnIndex(%s) = %d, want %d", ref, got, want)
+		}
+		if name := ColumnName(want); name != strings.TrimRight(ref, "0123456789") {
+			t.Errorf("ColumnName(%d) = %s", want, name)
+		}
+	}
+	if _, ok := columnIndex("12"); ok {
+		t.Error("reference without column letters should be rejected")
+	}
+}
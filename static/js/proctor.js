@@ -0,0 +1,112 @@
+// 在线考试防作弊：监听切屏、失焦、复制粘贴和退出全屏，上报到 /api/records/:id/events
+// 用法：
+//   const proctor = Proctor.start(recordID, {
+//       onWarn: message => alert(message),
+//       onSubmit: message => { alert(message); location.href = '/records'; }
+//   });
+//   交卷后调用 proctor.stop()。达到强制交卷阈值时服务器已结束考试并拒绝之后提交的答案，onSubmit只需离开考试页面
+(function(window, document) {
+    'use strict';
+
+    // 心跳间隔，用于发现同一考试记录在其他设备或浏览器中作答
+    const HEARTBEAT_INTERVAL = 30000;
+    // 切换标签页会同时触发失焦，短时间内的重复事件只上报一次
+    const DEBOUNCE = 1000;
+
+    function start(recordID, options) {
+        options = options || {};
+        let stopped = false;
+        let lastEvent = 0;
+        let submitting = false;
+
+        async function report(type, detail) {
+            if (stopped) {
+                return;
+            }
+            const post = () => fetch('/api/records/' + recordID + '/events', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                    'Authorization': 'Bearer ' + localStorage.getItem('token')
+                },
+                body: JSON.stringify({ type: type, detail: detail || '' })
+            });
+            try {
+                let response = await post();
+                // 访问令牌过期时用刷新令牌Cookie续期后重试
+                if (response.status === 401) {
+                    const refresh = await fetch('/api/auth/refresh', { method: 'POST' });
+                    if (!refresh.ok) {
+                        return;
+                    }
+                    localStorage.setItem('token', (await refresh.json()).data.token);
+                    response = await post();
+                }
+                if (!response.ok) {
+                    return;
+                }
+                const result = (await response.json()).data;
+                if (result.action === 'submit' && !submitting) {
+                    // 服务器已结束考试，不再上报
+                    submitting = true;
+                    stopped = true;
+                    if (options.onSubmit) {
+                        options.onSubmit(result.message, result);
+                    }
+                } else if (result.action === 'warn' && options.onWarn) {
+                    options.onWarn(result.message, result);
+                }
+            } catch (error) {
+                console.error('上报防作弊事件失败:', error);
+            }
+        }
+
+        function violation(type, detail) {
+            const now = Date.now();
+            if (now - lastEvent < DEBOUNCE) {
+                return;
+            }
+            lastEvent = now;
+            report(type, detail);
+        }
+
+        const handlers = {
+            visibilitychange: () => {
+                if (document.visibilityState === 'hidden') {
+                    violation('tab_switch');
+                }
+            },
+            blur: () => violation('blur'),
+            copy: () => violation('copy'),
+            paste: () => violation('paste'),
+            fullscreenchange: () => {
+                if (!document.fullscreenElement) {
+                    violation('fullscreen_exit');
+                }
+            }
+        };
+
+        document.addEventListener('visibilitychange', handlers.visibilitychange);
+        window.addEventListener('blur', handlers.blur);
+        document.addEventListener('copy', handlers.copy);
+        document.addEventListener('paste', handlers.paste);
+        document.addEventListener('fullscreenchange', handlers.fullscreenchange);
+
+        report('heartbeat');
+        const timer = setInterval(() => report('heartbeat'), HEARTBEAT_INTERVAL);
+
+        return {
+            stop: function() {
+                stopped = true;
+                clearInterval(timer);
+                document.removeEventListener('visibilitychange', handlers.visibilitychange);
+                window.removeEventListener('blur', handlers.blur);
+                document.removeEventListener('copy', handlers.copy);
+                document.removeEventListener('paste', handlers.paste);
+                document.removeEventListener('fullscreenchange', handlers.fullscreenchange);
+            }
+        };
+    }
+
+    window.Proctor = { start: start };
+})(window, document);